@@ -1,5 +1,6 @@
 // Package config handles all server configuration.
-// CLI flags take precedence; environment variables are used as fallback.
+// CLI flags take precedence; environment variables are used as fallback,
+// then an optional TOML config file.
 package config
 
 import (
@@ -9,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 )
 
@@ -16,8 +18,15 @@ import (
 type Config struct {
 	// Port is the TCP port the HTTP server listens on.
 	Port int
-	// Dirs is the ordered list of root directories to serve.
-	Dirs []string
+	// Roots is the ordered list of root directories to serve, with their
+	// per-root settings.
+	Roots []Root
+	// Exclude lists gitignore-style patterns of paths that are not served
+	// from any root, in addition to each root's own Exclude and the
+	// .gileignore files found in the served directories.
+	Exclude []string
+	// ConfigFile is the path of the config file, empty when none is used.
+	ConfigFile string
 	// Theme is the Chroma syntax-highlighting theme name.
 	Theme string
 	// Title is the branding name shown in the UI and page titles.
@@ -25,9 +34,26 @@ type Config struct {
 	// FaviconPath is an optional path to a custom favicon file.
 	// When empty the server returns a minimal default favicon.
 	FaviconPath string
-	// BandwidthLimit is the total server-wide upload cap in bytes per second.
-	// 0 means unlimited.
-	BandwidthLimit float64
+	// Bandwidth is the total server-wide upload cap, which may follow a
+	// schedule by time of day. Its zero value means unlimited.
+	Bandwidth BandwidthSchedule
+	// BandwidthPerIP caps the upload rate of any single client IP in bytes
+	// per second, applied on top of its fair share of Bandwidth.
+	// 0 means no per-IP cap.
+	BandwidthPerIP float64
+	// BandwidthPerConn caps the upload rate of any single transfer in bytes
+	// per second. 0 means no per-connection cap.
+	BandwidthPerConn float64
+	// BandwidthWeights weights the fair split of Bandwidth by transfer
+	// class, keyed "route:<name>", "root:<name>", "user:<name>" or
+	// "group:<name>". Unlisted classes weigh 1.
+	BandwidthWeights map[string]float64
+	// IngressBandwidth, IngressPerIP and IngressPerConn cap request bodies
+	// (uploads) in bytes per second: server-wide, per client IP and per
+	// request. They are independent of the Bandwidth caps. 0 means no cap.
+	IngressBandwidth float64
+	IngressPerIP     float64
+	IngressPerConn   float64
 	// DefaultTheme is the UI colour scheme served to clients that have not
 	// expressed a preference yet.  Accepted values: "dark", "light".
 	DefaultTheme string
@@ -44,6 +70,27 @@ type Config struct {
 	// rendered as rich documents. When false they fall back to syntax
 	// highlighting (if PreviewText is enabled) or the binary info-card.
 	PreviewDocs bool
+	// PreviewPDF controls whether PDF documents are embedded in the browser's
+	// built-in viewer. When false they fall back to the binary info-card.
+	PreviewPDF bool
+	// PreviewFonts controls whether font files are shown as type specimens.
+	// When false they fall back to the binary info-card.
+	PreviewFonts bool
+	// DailyQuota and MonthlyQuota cap the bytes one client may download per
+	// calendar day and month. 0 disables the quota.
+	DailyQuota   int64
+	MonthlyQuota int64
+	// RateLimitHeavy and RateLimitLight are the request budgets of one client
+	// IP for the expensive routes (ZIP, previews, search index, diffs) and for
+	// everything else. Their zero values mean unlimited.
+	RateLimitHeavy RequestRate
+	RateLimitLight RequestRate
+	// MaxTransfers caps the simultaneous downloads of one client IP.
+	// 0 means no cap.
+	MaxTransfers int
+	// AdminPassword protects the /admin/ endpoints with HTTP Basic
+	// authentication (user "admin"). When empty the endpoints are disabled.
+	AdminPassword string
 	// TrustedProxy is an optional IP address or CIDR range of a trusted
 	// reverse proxy (e.g. "127.0.0.1" or "10.0.0.0/8"). When set, the server
 	// reads the real client IP from the X-Real-IP or X-Forwarded-For header
@@ -51,6 +98,19 @@ type Config struct {
 	// are not affected — their RemoteAddr is used directly. Leave empty when
 	// GileBrowser is accessed directly without a reverse proxy.
 	TrustedProxy string
+	// ShutdownTimeout is how long a shutdown waits for transfers in progress
+	// to finish before cutting them off. 0 waits for as long as they last.
+	ShutdownTimeout time.Duration
+}
+
+// fileSettings is the config file read by the latest Load, nil when none is
+// used.
+var fileSettings *configFile
+
+// getenv returns the environment variable key, falling back to the config
+// file's value for the same setting.
+func getenv(key string) string {
+	return fileSettings.getenv(key)
 }
 
 // dirList is a custom flag.Value that can be set multiple times.
@@ -66,26 +126,62 @@ func (d *dirList) Set(value string) error {
 }
 
 // Load parses flags and environment variables, returning a validated Config.
+// It may be called again to reload the configuration: the flags and the
+// environment are those the process started with, so in practice a reload
+// picks up changes to the config file.
 func Load() (*Config, error) {
+	// A fresh flag set each call lets Load run again on a reload.
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	var dirs dirList
-	portFlag           := flag.Int("port", 0, "HTTP port to listen on (env: GILE_PORT, default: 7887)")
-	titleFlag          := flag.String("title", "", "Site branding title (env: GILE_TITLE, default: GileBrowser)")
-	faviconFlag        := flag.String("favicon", "", "Path to a custom favicon file (env: GILE_FAVICON)")
-	bandwidthFlag      := flag.String("bandwidth", "", "Total upload bandwidth cap, e.g. 10mbps, 500kbps, 1gbps (env: GILE_BANDWIDTH, default: unlimited)")
-	defaultThemeFlag   := flag.String("theme", "", "UI theme: dark or light (env: GILE_DEFAULT_THEME, default: dark)")
-	statsDirFlag       := flag.String("stats-dir", "", "Directory in which gile.json is stored (env: GILE_STATS_DIR, default: current working directory)")
-	previewImagesFlag  := flag.String("preview-images", "", "Enable inline image previews: true or false (env: GILE_PREVIEW_IMAGES, default: true)")
-	previewTextFlag    := flag.String("preview-text", "", "Enable syntax-highlighted text previews: true or false (env: GILE_PREVIEW_TEXT, default: true)")
-	previewDocsFlag    := flag.String("preview-docs", "", "Enable rendered document previews (Markdown, Org, HTML): true or false (env: GILE_PREVIEW_DOCS, default: true)")
-	trustedProxyFlag   := flag.String("trusted-proxy", "", "IP or CIDR of a trusted reverse proxy for X-Forwarded-For (env: GILE_TRUSTED_PROXY)")
-	flag.Var(&dirs, "dir", "Root directory to serve (repeatable; env: GILE_DIRS, colon-separated)")
-	flag.Parse()
+	configFlag         := flags.String("config", "", "Path to a TOML config file; flags and environment variables override it (env: GILE_CONFIG)")
+	portFlag           := flags.Int("port", 0, "HTTP port to listen on (env: GILE_PORT, default: 7887)")
+	titleFlag          := flags.String("title", "", "Site branding title (env: GILE_TITLE, default: GileBrowser)")
+	faviconFlag        := flags.String("favicon", "", "Path to a custom favicon file (env: GILE_FAVICON)")
+	bandwidthFlag      := flags.String("bandwidth", "", "Total upload bandwidth cap, e.g. 10mbps, or a schedule such as \"mon-fri 08:00-18:00 20mbps; unlimited\" (env: GILE_BANDWIDTH, default: unlimited)")
+	bandwidthIPFlag    := flags.String("bandwidth-per-ip", "", "Upload bandwidth cap for any one client IP, e.g. 20mbps (env: GILE_BANDWIDTH_PER_IP, default: unlimited)")
+	bandwidthWtFlag    := flags.String("bandwidth-weights", "", "Weights for the fair bandwidth split, e.g. root:releases=4,route:zip=0.5,group:staff=3 (env: GILE_BANDWIDTH_WEIGHTS)")
+	bandwidthConnFlag  := flags.String("bandwidth-per-conn", "", "Upload bandwidth cap for any one transfer, e.g. 5mbps (env: GILE_BANDWIDTH_PER_CONN, default: unlimited)")
+	ingressFlag        := flags.String("ingress-bandwidth", "", "Total cap on incoming request bodies, e.g. 10mbps (env: GILE_INGRESS_BANDWIDTH, default: unlimited)")
+	ingressIPFlag      := flags.String("ingress-bandwidth-per-ip", "", "Cap on incoming request bodies from any one client IP (env: GILE_INGRESS_BANDWIDTH_PER_IP, default: unlimited)")
+	ingressConnFlag    := flags.String("ingress-bandwidth-per-conn", "", "Cap on any one incoming request body (env: GILE_INGRESS_BANDWIDTH_PER_CONN, default: unlimited)")
+	defaultThemeFlag   := flags.String("theme", "", "UI theme: dark or light (env: GILE_DEFAULT_THEME, default: dark)")
+	statsDirFlag       := flags.String("stats-dir", "", "Directory in which gile.json is stored (env: GILE_STATS_DIR, default: current working directory)")
+	previewImagesFlag  := flags.String("preview-images", "", "Enable inline image previews: true or false (env: GILE_PREVIEW_IMAGES, default: true)")
+	previewTextFlag    := flags.String("preview-text", "", "Enable syntax-highlighted text previews: true or false (env: GILE_PREVIEW_TEXT, default: true)")
+	previewDocsFlag    := flags.String("preview-docs", "", "Enable rendered document previews (Markdown, Org, reStructuredText, AsciiDoc, HTML, notebooks): true or false (env: GILE_PREVIEW_DOCS, default: true)")
+	previewPDFFlag     := flags.String("preview-pdf", "", "Enable embedded PDF previews: true or false (env: GILE_PREVIEW_PDF, default: true)")
+	previewFontsFlag   := flags.String("preview-fonts", "", "Enable font specimen previews: true or false (env: GILE_PREVIEW_FONTS, default: true)")
+	quotaDailyFlag     := flags.String("quota-daily", "", "Bytes one client may download per day, e.g. 10GB (env: GILE_QUOTA_DAILY, default: unlimited)")
+	quotaMonthlyFlag   := flags.String("quota-monthly", "", "Bytes one client may download per month, e.g. 200GB (env: GILE_QUOTA_MONTHLY, default: unlimited)")
+	rateHeavyFlag      := flags.String("rate-limit-heavy", "", "Requests one client IP may make to ZIP, preview, search and diff routes, e.g. 30/min (env: GILE_RATE_LIMIT_HEAVY, default: unlimited)")
+	rateLightFlag      := flags.String("rate-limit-light", "", "Requests one client IP may make to all other routes, e.g. 600/min (env: GILE_RATE_LIMIT_LIGHT, default: unlimited)")
+	maxTransfersFlag   := flags.Int("max-transfers-per-ip", 0, "Simultaneous downloads allowed per client IP (env: GILE_MAX_TRANSFERS_PER_IP, default: unlimited)")
+	adminPasswordFlag  := flags.String("admin-password", "", "Password for the /admin/ endpoints, user \"admin\" (env: GILE_ADMIN_PASSWORD, default: admin endpoints disabled)")
+	trustedProxyFlag   := flags.String("trusted-proxy", "", "IP or CIDR of a trusted reverse proxy for X-Forwarded-For (env: GILE_TRUSTED_PROXY)")
+	excludeFlag        := flags.String("exclude", "", "Comma-separated gitignore-style patterns of paths not served from any root, e.g. .git/,*.swp,.env (env: GILE_EXCLUDE)")
+	shutdownFlag       := flags.String("shutdown-timeout", "", "How long to wait for transfers to finish on shutdown, e.g. 30s or 10m; 0 waits for as long as they last (env: GILE_SHUTDOWN_TIMEOUT, default: 30s)")
+	flags.Var(&dirs, "dir", "Root directory to serve, optionally as name=path (repeatable; env: GILE_DIRS, colon-separated)")
+	flags.Parse(os.Args[1:])
+
+	// --- config ---
+	configPath := *configFlag
+	if configPath == "" {
+		configPath = os.Getenv("GILE_CONFIG")
+	}
+	fileSettings = nil
+	if configPath != "" {
+		f, err := loadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("config file %q: %w", configPath, err)
+		}
+		fileSettings = f
+	}
 
 	// --- port ---
 	port := *portFlag
 	if port == 0 {
 		// fall back to env
-		if v := os.Getenv("GILE_PORT"); v != "" {
+		if v := getenv("GILE_PORT"); v != "" {
 			p, err := strconv.Atoi(v)
 			if err != nil || p < 1 || p > 65535 {
 				return nil, fmt.Errorf("invalid GILE_PORT value %q", v)
@@ -99,7 +195,7 @@ func Load() (*Config, error) {
 	// --- dirs ---
 	if len(dirs) == 0 {
 		// fall back to env
-		if v := os.Getenv("GILE_DIRS"); v != "" {
+		if v := getenv("GILE_DIRS"); v != "" {
 			for _, d := range strings.Split(v, ":") {
 				d = strings.TrimSpace(d)
 				if d != "" {
@@ -110,16 +206,28 @@ func Load() (*Config, error) {
 	}
 
 	// Remaining positional arguments are also treated as directories
-	for _, arg := range flag.Args() {
+	for _, arg := range flags.Args() {
 		dirs = append(dirs, arg)
 	}
 
 
 
+	// --- exclude ---
+	excludeRaw := *excludeFlag
+	if excludeRaw == "" {
+		excludeRaw = getenv("GILE_EXCLUDE")
+	}
+	var exclude []string
+	for _, p := range strings.Split(excludeRaw, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			exclude = append(exclude, p)
+		}
+	}
+
 	// --- title ---
 	title := *titleFlag
 	if title == "" {
-		if v := os.Getenv("GILE_TITLE"); v != "" {
+		if v := getenv("GILE_TITLE"); v != "" {
 			title = v
 		} else {
 			title = "GileBrowser"
@@ -129,7 +237,7 @@ func Load() (*Config, error) {
 	// --- favicon ---
 	favicon := *faviconFlag
 	if favicon == "" {
-		favicon = os.Getenv("GILE_FAVICON")
+		favicon = getenv("GILE_FAVICON")
 	}
 	if favicon != "" {
 		info, err := os.Stat(favicon)
@@ -141,14 +249,19 @@ func Load() (*Config, error) {
 		}
 	}
 
-	if len(dirs) == 0 {
-		return nil, fmt.Errorf("at least one root directory must be specified via -dir flag, GILE_DIRS env var, or positional argument")
+	// --- roots ---
+	roots, err := resolveRoots(dirs, fileSettings)
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("at least one root directory must be specified via -dir flag, GILE_DIRS env var, positional argument, or [[root]] in the config file")
 	}
 
 	// --- theme ---
 	defaultTheme := *defaultThemeFlag
 	if defaultTheme == "" {
-		if v := os.Getenv("GILE_DEFAULT_THEME"); v != "" {
+		if v := getenv("GILE_DEFAULT_THEME"); v != "" {
 			defaultTheme = v
 		} else {
 			defaultTheme = "dark"
@@ -166,34 +279,70 @@ func Load() (*Config, error) {
 	}
 
 	// Validate that all supplied directories exist
-	for _, d := range dirs {
-		info, err := os.Stat(d)
+	for _, r := range roots {
+		info, err := os.Stat(r.Path)
 		if err != nil {
-			return nil, fmt.Errorf("directory %q: %w", d, err)
+			return nil, fmt.Errorf("directory %q: %w", r.Path, err)
 		}
 		if !info.IsDir() {
-			return nil, fmt.Errorf("%q is not a directory", d)
+			return nil, fmt.Errorf("%q is not a directory", r.Path)
 		}
 	}
 
 	// --- bandwidth ---
 	bwRaw := *bandwidthFlag
 	if bwRaw == "" {
-		bwRaw = os.Getenv("GILE_BANDWIDTH")
+		bwRaw = getenv("GILE_BANDWIDTH")
 	}
-	var bandwidthBps float64
-	if bwRaw != "" {
-		bps, err := parseBandwidth(bwRaw)
-		if err != nil {
-			return nil, fmt.Errorf("invalid bandwidth %q: %w", bwRaw, err)
-		}
-		bandwidthBps = bps
+	bandwidth, err := parseBandwidthSchedule(bwRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bandwidth %q: %w", bwRaw, err)
+	}
+
+	// --- bandwidth-per-ip ---
+	bandwidthPerIP, err := bandwidthOption(*bandwidthIPFlag, "GILE_BANDWIDTH_PER_IP", "bandwidth-per-ip")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- bandwidth-per-conn ---
+	bandwidthPerConn, err := bandwidthOption(*bandwidthConnFlag, "GILE_BANDWIDTH_PER_CONN", "bandwidth-per-conn")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- bandwidth-weights ---
+	wtRaw := *bandwidthWtFlag
+	if wtRaw == "" {
+		wtRaw = getenv("GILE_BANDWIDTH_WEIGHTS")
+	}
+	bandwidthWeights, err := parseWeights(wtRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bandwidth-weights %q: %w", wtRaw, err)
+	}
+
+	// --- ingress-bandwidth ---
+	ingressBandwidth, err := bandwidthOption(*ingressFlag, "GILE_INGRESS_BANDWIDTH", "ingress-bandwidth")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- ingress-bandwidth-per-ip ---
+	ingressPerIP, err := bandwidthOption(*ingressIPFlag, "GILE_INGRESS_BANDWIDTH_PER_IP", "ingress-bandwidth-per-ip")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- ingress-bandwidth-per-conn ---
+	ingressPerConn, err := bandwidthOption(*ingressConnFlag, "GILE_INGRESS_BANDWIDTH_PER_CONN", "ingress-bandwidth-per-conn")
+	if err != nil {
+		return nil, err
 	}
 
 	// --- stats-dir ---
 	statsDir := *statsDirFlag
 	if statsDir == "" {
-		if v := os.Getenv("GILE_STATS_DIR"); v != "" {
+		if v := getenv("GILE_STATS_DIR"); v != "" {
 			statsDir = v
 		} else {
 			cwd, err := os.Getwd()
@@ -213,10 +362,61 @@ func Load() (*Config, error) {
 	// --- preview-docs ---
 	previewDocs := parseBoolFlag(*previewDocsFlag, "GILE_PREVIEW_DOCS", true)
 
+	// --- preview-pdf ---
+	previewPDF := parseBoolFlag(*previewPDFFlag, "GILE_PREVIEW_PDF", true)
+
+	// --- preview-fonts ---
+	previewFonts := parseBoolFlag(*previewFontsFlag, "GILE_PREVIEW_FONTS", true)
+
+	// --- quota-daily ---
+	dailyQuota, err := sizeOption(*quotaDailyFlag, "GILE_QUOTA_DAILY", "quota-daily")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- quota-monthly ---
+	monthlyQuota, err := sizeOption(*quotaMonthlyFlag, "GILE_QUOTA_MONTHLY", "quota-monthly")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- rate-limit-heavy ---
+	rateHeavy, err := rateOption(*rateHeavyFlag, "GILE_RATE_LIMIT_HEAVY", "rate-limit-heavy")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- rate-limit-light ---
+	rateLight, err := rateOption(*rateLightFlag, "GILE_RATE_LIMIT_LIGHT", "rate-limit-light")
+	if err != nil {
+		return nil, err
+	}
+
+	// --- max-transfers-per-ip ---
+	maxTransfers := *maxTransfersFlag
+	if maxTransfers == 0 {
+		if v := getenv("GILE_MAX_TRANSFERS_PER_IP"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid GILE_MAX_TRANSFERS_PER_IP value %q", v)
+			}
+			maxTransfers = n
+		}
+	}
+	if maxTransfers < 0 {
+		return nil, fmt.Errorf("invalid max-transfers-per-ip %d: must not be negative", maxTransfers)
+	}
+
+	// --- admin-password ---
+	adminPassword := *adminPasswordFlag
+	if adminPassword == "" {
+		adminPassword = getenv("GILE_ADMIN_PASSWORD")
+	}
+
 	// --- trusted-proxy ---
 	trustedProxy := *trustedProxyFlag
 	if trustedProxy == "" {
-		trustedProxy = os.Getenv("GILE_TRUSTED_PROXY")
+		trustedProxy = getenv("GILE_TRUSTED_PROXY")
 	}
 	if trustedProxy != "" {
 		if err := validateProxy(trustedProxy); err != nil {
@@ -224,19 +424,50 @@ func Load() (*Config, error) {
 		}
 	}
 
+	// --- shutdown-timeout ---
+	shutdownRaw := *shutdownFlag
+	if shutdownRaw == "" {
+		shutdownRaw = getenv("GILE_SHUTDOWN_TIMEOUT")
+	}
+	shutdownTimeout := 30 * time.Second
+	if shutdownRaw != "" {
+		d, err := time.ParseDuration(shutdownRaw)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid shutdown-timeout %q: use a duration such as 30s or 10m", shutdownRaw)
+		}
+		shutdownTimeout = d
+	}
+
 	return &Config{
-		Port:           port,
-		Dirs:           []string(dirs),
-		Theme:          theme,
-		Title:          title,
-		FaviconPath:    favicon,
-		BandwidthLimit: bandwidthBps,
-		DefaultTheme:   defaultTheme,
-		StatsDir:       statsDir,
-		PreviewImages:  previewImages,
-		PreviewText:    previewText,
-		PreviewDocs:    previewDocs,
-		TrustedProxy:   trustedProxy,
+		Port:             port,
+		Roots:            roots,
+		Exclude:          exclude,
+		ConfigFile:       configPath,
+		Theme:            theme,
+		Title:            title,
+		FaviconPath:      favicon,
+		Bandwidth:        bandwidth,
+		BandwidthPerIP:   bandwidthPerIP,
+		BandwidthPerConn: bandwidthPerConn,
+		BandwidthWeights: bandwidthWeights,
+		IngressBandwidth: ingressBandwidth,
+		IngressPerIP:     ingressPerIP,
+		IngressPerConn:   ingressPerConn,
+		DefaultTheme:     defaultTheme,
+		StatsDir:         statsDir,
+		PreviewImages:    previewImages,
+		PreviewText:      previewText,
+		PreviewDocs:      previewDocs,
+		PreviewPDF:       previewPDF,
+		PreviewFonts:     previewFonts,
+		DailyQuota:       dailyQuota,
+		MonthlyQuota:     monthlyQuota,
+		RateLimitHeavy:   rateHeavy,
+		RateLimitLight:   rateLight,
+		MaxTransfers:     maxTransfers,
+		AdminPassword:    adminPassword,
+		TrustedProxy:     trustedProxy,
+		ShutdownTimeout:  shutdownTimeout,
 	}, nil
 }
 
@@ -263,7 +494,7 @@ func parseBoolFlag(flagVal, envKey string, defaultVal bool) bool {
 			return b
 		}
 	}
-	if v := os.Getenv(envKey); v != "" {
+	if v := getenv(envKey); v != "" {
 		if b, ok := parseBoolString(v); ok {
 			return b
 		}
@@ -282,6 +513,168 @@ func parseBoolString(s string) (bool, bool) {
 	return false, false
 }
 
+// bandwidthOption resolves a bandwidth option from its CLI flag value, falling
+// back to the environment variable envKey. An unset option is 0 (unlimited).
+func bandwidthOption(flagVal, envKey, name string) (float64, error) {
+	raw := flagVal
+	if raw == "" {
+		raw = getenv(envKey)
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	bps, err := parseBandwidth(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	return bps, nil
+}
+
+// sizeOption resolves a byte-size option from its CLI flag value, falling
+// back to the environment variable envKey. An unset option is 0.
+func sizeOption(flagVal, envKey, name string) (int64, error) {
+	raw := flagVal
+	if raw == "" {
+		raw = getenv(envKey)
+	}
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := parseSize(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	return n, nil
+}
+
+// RequestRate is a request budget: Count requests per Per. Its zero value
+// means unlimited.
+type RequestRate struct {
+	Count int
+	Per   time.Duration
+}
+
+// String formats the budget as it is written, e.g. "30/min".
+func (r RequestRate) String() string {
+	if r.Count == 0 {
+		return "unlimited"
+	}
+	unit := map[time.Duration]string{time.Second: "s", time.Minute: "min", time.Hour: "h", 24 * time.Hour: "day"}[r.Per]
+	return fmt.Sprintf("%d/%s", r.Count, unit)
+}
+
+// rateOption resolves a request-rate option from its CLI flag value, falling
+// back to the environment variable envKey. An unset option is unlimited.
+func rateOption(flagVal, envKey, name string) (RequestRate, error) {
+	raw := flagVal
+	if raw == "" {
+		raw = getenv(envKey)
+	}
+	if raw == "" {
+		return RequestRate{}, nil
+	}
+	r, err := parseRequestRate(raw)
+	if err != nil {
+		return RequestRate{}, fmt.Errorf("invalid %s %q: %w", name, raw, err)
+	}
+	return r, nil
+}
+
+// parseRequestRate parses a request budget of the form COUNT/UNIT, where UNIT
+// is s, min, h or day (case-insensitive), or "unlimited". A bare count is
+// per minute.
+//
+// Examples: "30/min", "5/s", "1000/h", "unlimited"
+func parseRequestRate(s string) (RequestRate, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "unlimited" {
+		return RequestRate{}, nil
+	}
+	countStr, unit, hasUnit := strings.Cut(s, "/")
+	count, err := strconv.Atoi(strings.TrimSpace(countStr))
+	if err != nil || count < 0 {
+		return RequestRate{}, fmt.Errorf("invalid count %q", countStr)
+	}
+	per := time.Minute
+	if hasUnit {
+		periods := map[string]time.Duration{
+			"s": time.Second, "sec": time.Second, "second": time.Second,
+			"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+			"h": time.Hour, "hour": time.Hour,
+			"d": 24 * time.Hour, "day": 24 * time.Hour,
+		}
+		var ok bool
+		if per, ok = periods[strings.TrimSpace(unit)]; !ok {
+			return RequestRate{}, fmt.Errorf("unknown unit %q (use s, min, h or day)", unit)
+		}
+	}
+	return RequestRate{Count: count, Per: per}, nil
+}
+
+// parseSize converts a human-readable size to bytes. Accepted units
+// (case-insensitive): B, KB, MB, GB, TB (powers of 1000, as sizes are shown
+// in the UI) and KiB, MiB, GiB, TiB (powers of 1024). A bare number is bytes.
+//
+// Examples: "10GB", "500 MB", "1.5TiB", "1048576"
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] == '.' || (s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+	if i == 0 {
+		return 0, fmt.Errorf("no numeric value found")
+	}
+	val, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil || val < 0 {
+		return 0, fmt.Errorf("invalid number %q", s[:i])
+	}
+	mult := map[string]float64{
+		"": 1, "b": 1,
+		"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+		"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+	}
+	unit := strings.ToLower(strings.TrimFunc(s[i:], unicode.IsSpace))
+	m, ok := mult[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q (accepted: B, KB, MB, GB, TB, KiB, MiB, GiB, TiB)", unit)
+	}
+	return int64(val * m), nil
+}
+
+// parseWeights parses a comma-separated list of class=weight pairs such as
+// "root:releases=4,route:zip=0.5". Classes are route:, root:, user: or
+// group: followed by a name.
+func parseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		class, val, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want class=weight", item)
+		}
+		class = strings.TrimSpace(class)
+		kind, name, _ := strings.Cut(class, ":")
+		switch kind {
+		case "route", "root", "user", "group":
+		default:
+			return nil, fmt.Errorf("%q: class must start with route:, root:, user: or group:", item)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%q: missing name after %s:", item, kind)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("%q: weight must be a positive number", item)
+		}
+		weights[class] = w
+	}
+	return weights, nil
+}
+
 // parseBandwidth converts a human-readable bandwidth string to bytes per
 // second. Accepted units (case-insensitive): bps, kbps, mbps, gbps.
 // A bare number is treated as bytes per second.
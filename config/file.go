@@ -0,0 +1,363 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Root is one served directory together with its per-root settings.
+type Root struct {
+	// Path is the directory on disk.
+	Path string
+	// Name is the URL name of the root, e.g. "media" for /media/.
+	Name string
+	// DisplayName and Description are shown for the root on the front page.
+	DisplayName string
+	Description string
+	// Hidden lists gitignore-style patterns (e.g. "*.tmp", ".git/") of files
+	// and directories left out of listings, search and ZIP archives. They
+	// can still be fetched by direct link.
+	Hidden []string
+	// Exclude lists gitignore-style patterns of files and directories that
+	// are not served at all.
+	Exclude []string
+	// Preview overrides the global preview toggles for this root. A nil
+	// field keeps the global setting.
+	Preview RootPreview
+	// BandwidthWeight is the root's weight in the fair bandwidth split,
+	// 0 meaning the default of 1.
+	BandwidthWeight float64
+	// Writable marks the root as open to write operations. GileBrowser has
+	// none yet, so it is recorded but has no effect.
+	Writable bool
+	// Allow restricts the root to the listed clients: IP addresses, CIDR
+	// ranges, "user:<name>" and "group:<name>" (from a trusted proxy's
+	// Remote-User and Remote-Groups headers). Empty means everyone.
+	Allow []string
+}
+
+// RootPreview holds per-root overrides of the preview toggles.
+type RootPreview struct {
+	Images, Text, Docs, PDF, Fonts *bool
+}
+
+// fileRoot is a [[root]] table in the config file.
+type fileRoot struct {
+	Path            string   `toml:"path"`
+	Name            string   `toml:"name"`
+	DisplayName     string   `toml:"display-name"`
+	Description     string   `toml:"description"`
+	Hidden          []string `toml:"hidden"`
+	Exclude         []string `toml:"exclude"`
+	PreviewImages   *bool    `toml:"preview-images"`
+	PreviewText     *bool    `toml:"preview-text"`
+	PreviewDocs     *bool    `toml:"preview-docs"`
+	PreviewPDF      *bool    `toml:"preview-pdf"`
+	PreviewFonts    *bool    `toml:"preview-fonts"`
+	BandwidthWeight float64  `toml:"bandwidth-weight"`
+	Writable        bool     `toml:"writable"`
+	Allow           []string `toml:"allow"`
+}
+
+// fileKeys maps the top-level keys of the config file to the environment
+// variables they stand in for. Keys are the flag names.
+var fileKeys = map[string]string{
+	"port":                       "GILE_PORT",
+	"title":                      "GILE_TITLE",
+	"favicon":                    "GILE_FAVICON",
+	"theme":                      "GILE_DEFAULT_THEME",
+	"stats-dir":                  "GILE_STATS_DIR",
+	"bandwidth":                  "GILE_BANDWIDTH",
+	"bandwidth-per-ip":           "GILE_BANDWIDTH_PER_IP",
+	"bandwidth-per-conn":         "GILE_BANDWIDTH_PER_CONN",
+	"bandwidth-weights":          "GILE_BANDWIDTH_WEIGHTS",
+	"ingress-bandwidth":          "GILE_INGRESS_BANDWIDTH",
+	"ingress-bandwidth-per-ip":   "GILE_INGRESS_BANDWIDTH_PER_IP",
+	"ingress-bandwidth-per-conn": "GILE_INGRESS_BANDWIDTH_PER_CONN",
+	"preview-images":             "GILE_PREVIEW_IMAGES",
+	"preview-text":               "GILE_PREVIEW_TEXT",
+	"preview-docs":               "GILE_PREVIEW_DOCS",
+	"preview-pdf":                "GILE_PREVIEW_PDF",
+	"preview-fonts":              "GILE_PREVIEW_FONTS",
+	"quota-daily":                "GILE_QUOTA_DAILY",
+	"quota-monthly":              "GILE_QUOTA_MONTHLY",
+	"rate-limit-heavy":           "GILE_RATE_LIMIT_HEAVY",
+	"rate-limit-light":           "GILE_RATE_LIMIT_LIGHT",
+	"max-transfers-per-ip":       "GILE_MAX_TRANSFERS_PER_IP",
+	"admin-password":             "GILE_ADMIN_PASSWORD",
+	"trusted-proxy":              "GILE_TRUSTED_PROXY",
+	"exclude":                    "GILE_EXCLUDE",
+	"shutdown-timeout":           "GILE_SHUTDOWN_TIMEOUT",
+}
+
+// configFile is a parsed config file: the top-level settings, keyed by the
+// environment variable they stand in for, and the [[root]] tables.
+type configFile struct {
+	values map[string]string
+	roots  []Root
+}
+
+// getenv returns the environment variable key, falling back to the config
+// file's value for the same setting. It is used in place of os.Getenv so that
+// flags override the environment, which overrides the file.
+func (f *configFile) getenv(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	if f == nil {
+		return ""
+	}
+	return f.values[key]
+}
+
+// loadFile reads a TOML config file. Top-level keys are the flag names;
+// [[root]] tables describe the served directories:
+//
+//	title = "MyFiles"
+//	bandwidth = "100mbps"
+//
+//	[[root]]
+//	path = "/srv/media"
+//	display-name = "Media library"
+//	hidden = [".*"]
+//	allow = ["10.0.0.0/8", "group:staff"]
+func loadFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	f := &configFile{values: make(map[string]string)}
+	for key, v := range raw {
+		if key == "root" {
+			continue
+		}
+		env, ok := fileKeys[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		s, err := fileValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		f.values[env] = s
+	}
+
+	var tables struct {
+		Root []fileRoot `toml:"root"`
+	}
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&tables); err != nil {
+		return nil, err
+	}
+	// Check the root tables' keys separately: the decoder cannot be strict
+	// about them without also rejecting every top-level setting.
+	if list, ok := raw["root"].([]any); ok {
+		for i, t := range list {
+			if err := checkRootKeys(t); err != nil {
+				return nil, fmt.Errorf("root %d: %w", i+1, err)
+			}
+		}
+	}
+	for i, fr := range tables.Root {
+		if fr.Path == "" {
+			return nil, fmt.Errorf("root %d: path is required", i+1)
+		}
+		f.roots = append(f.roots, Root{
+			Path:        fr.Path,
+			Name:        fr.Name,
+			DisplayName: fr.DisplayName,
+			Description: fr.Description,
+			Hidden:      fr.Hidden,
+			Exclude:     fr.Exclude,
+			Preview: RootPreview{
+				Images: fr.PreviewImages,
+				Text:   fr.PreviewText,
+				Docs:   fr.PreviewDocs,
+				PDF:    fr.PreviewPDF,
+				Fonts:  fr.PreviewFonts,
+			},
+			BandwidthWeight: fr.BandwidthWeight,
+			Writable:        fr.Writable,
+			Allow:           fr.Allow,
+		})
+	}
+	return f, nil
+}
+
+// rootKeys are the keys a [[root]] table may contain.
+var rootKeys = map[string]bool{
+	"path": true, "name": true, "display-name": true, "description": true,
+	"hidden": true, "exclude": true, "preview-images": true, "preview-text": true,
+	"preview-docs": true, "preview-pdf": true, "preview-fonts": true,
+	"bandwidth-weight": true, "writable": true, "allow": true,
+}
+
+// checkRootKeys rejects unknown keys in a [[root]] table.
+func checkRootKeys(table any) error {
+	m, ok := table.(map[string]any)
+	if !ok {
+		return fmt.Errorf("not a table")
+	}
+	for key := range m {
+		if !rootKeys[key] {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
+// fileValue converts a top-level config file value to the string form the
+// matching flag accepts. bandwidth-weights may be written as a table, and
+// exclude as an array.
+func fileValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		pairs := make([]string, 0, len(v))
+		for class, w := range v {
+			s, err := fileValue(w)
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, class+"="+s)
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// splitDirSpec splits a directory given as "name=path" into its parts. A
+// spec whose part before the first "=" is empty or looks like a path is all
+// path, with no name.
+func splitDirSpec(spec string) (name, dir string) {
+	name, dir, ok := strings.Cut(spec, "=")
+	if !ok || name == "" || strings.ContainsAny(name, "/\\") {
+		return "", spec
+	}
+	return name, dir
+}
+
+// reservedNames are the first path segments the server's own routes use. A
+// root with one of these names would be hidden behind the route.
+var reservedNames = map[string]bool{
+	"admin": true, "api": true, "diff": true, "download": true, "favicon.ico": true,
+	"highlight.css": true, "preview": true, "static": true, "view": true, "zip": true,
+}
+
+// resolveRoots merges the directories given by flags, the environment or
+// positional arguments with the config file's roots. When any directories are
+// given that way they replace the file's list, but a directory that the file
+// also describes keeps its settings. A directory given as "name=path" takes
+// that name; otherwise names default to rootName of the path.
+func resolveRoots(dirs []string, file *configFile) ([]Root, error) {
+	var fileRoots []Root
+	if file != nil {
+		fileRoots = file.roots
+	}
+
+	roots := fileRoots
+	if len(dirs) > 0 {
+		roots = make([]Root, 0, len(dirs))
+		for _, spec := range dirs {
+			name, d := splitDirSpec(spec)
+			if d == "" {
+				return nil, fmt.Errorf("directory %q: missing path", spec)
+			}
+			r := Root{Path: d}
+			for _, fr := range fileRoots {
+				if filepath.Clean(fr.Path) == filepath.Clean(d) {
+					r = fr
+					break
+				}
+			}
+			if name != "" {
+				r.Name = name
+			}
+			roots = append(roots, r)
+		}
+	}
+
+	seen := make(map[string]string, len(roots))
+	for i := range roots {
+		r := &roots[i]
+		if r.Name == "" {
+			r.Name = rootName(r.Path)
+		}
+		if r.Name == "" || strings.ContainsAny(r.Name, "/\\") || r.Name == "." || r.Name == ".." {
+			return nil, fmt.Errorf("root %q: invalid name %q", r.Path, r.Name)
+		}
+		if reservedNames[r.Name] {
+			return nil, fmt.Errorf("root %q: the name %q is used by the server's own pages; give the root another with --dir name=path or name in its [[root]] table", r.Path, r.Name)
+		}
+		if prev, dup := seen[r.Name]; dup {
+			return nil, fmt.Errorf("roots %q and %q are both named %q; give one of them a distinct name with --dir name=path or name in its [[root]] table", prev, r.Path, r.Name)
+		}
+		seen[r.Name] = r.Path
+		if r.BandwidthWeight < 0 {
+			return nil, fmt.Errorf("root %q: bandwidth-weight must not be negative", r.Name)
+		}
+		for _, rule := range r.Allow {
+			if err := validateAllow(rule); err != nil {
+				return nil, fmt.Errorf("root %q: allow %q: %w", r.Name, rule, err)
+			}
+		}
+	}
+	return roots, nil
+}
+
+// validateAllow checks one entry of a root's allow list.
+func validateAllow(rule string) error {
+	if name, ok := strings.CutPrefix(rule, "user:"); ok {
+		if name == "" {
+			return fmt.Errorf("missing user name")
+		}
+		return nil
+	}
+	if name, ok := strings.CutPrefix(rule, "group:"); ok {
+		if name == "" {
+			return fmt.Errorf("missing group name")
+		}
+		return nil
+	}
+	return validateProxy(rule)
+}
+
+// rootName derives a URL-safe root name from a filesystem directory path.
+// It uses the base name of the path, lowercased, with spaces replaced by
+// hyphens.
+func rootName(dir string) string {
+	base := filepath.Base(filepath.Clean(dir))
+	base = strings.ToLower(base)
+	base = strings.ReplaceAll(base, " ", "-")
+	return base
+}
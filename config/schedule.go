@@ -0,0 +1,255 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BandwidthSchedule is a server-wide bandwidth cap that can change with the
+// day of the week and the time of day, in the server's local time zone.
+//
+// It is written as rules separated by semicolons. Each rule is an optional
+// set of days, an optional time range and a rate; a rule with neither days
+// nor times sets the cap outside every other rule:
+//
+//	mon-fri 08:00-18:00 20mbps; unlimited
+//	sat,sun 5mbps; 22:00-06:00 unlimited; 50mbps
+//
+// The first rule covering a moment wins. A time range whose end is not after
+// its start runs past midnight into the next day. A plain rate such as
+// "10mbps" is a schedule with a single, constant cap.
+type BandwidthSchedule struct {
+	Rules []BandwidthRule
+	// Default applies when no rule covers a moment, in bytes per second.
+	// 0 means unlimited.
+	Default float64
+	// Spec is the schedule as it was written, for display.
+	Spec string
+}
+
+// BandwidthRule is one time window of a BandwidthSchedule.
+type BandwidthRule struct {
+	Days       [7]bool // indexed by time.Weekday
+	Start, End int     // minutes after midnight; the window is [Start, End)
+	Limit      float64 // bytes per second, 0 = unlimited
+}
+
+// minutesPerDay is the End of a rule that runs to midnight.
+const minutesPerDay = 24 * 60
+
+// Scheduled reports whether the cap changes over time.
+func (s BandwidthSchedule) Scheduled() bool {
+	return len(s.Rules) > 0
+}
+
+// Enabled reports whether the schedule ever caps bandwidth.
+func (s BandwidthSchedule) Enabled() bool {
+	if s.Default > 0 {
+		return true
+	}
+	for _, r := range s.Rules {
+		if r.Limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the schedule as it was written.
+func (s BandwidthSchedule) String() string {
+	return s.Spec
+}
+
+// Limit returns the cap in effect at t, in bytes per second (0 = unlimited).
+func (s BandwidthSchedule) Limit(t time.Time) float64 {
+	t = t.Local()
+	day, minute := t.Weekday(), t.Hour()*60+t.Minute()
+	for _, r := range s.Rules {
+		if r.covers(day, minute) {
+			return r.Limit
+		}
+	}
+	return s.Default
+}
+
+// covers reports whether the rule applies at minute of day.
+func (r BandwidthRule) covers(day time.Weekday, minute int) bool {
+	if r.Start < r.End {
+		return r.Days[day] && minute >= r.Start && minute < r.End
+	}
+	// Overnight: the evening part belongs to the listed day, the early
+	// morning part to the day after it.
+	return (r.Days[day] && minute >= r.Start) || (r.Days[(day+6)%7] && minute < r.End)
+}
+
+// Next returns when the cap next changes after t and the cap from then on.
+// It returns the zero time when the cap never changes.
+func (s BandwidthSchedule) Next(t time.Time) (time.Time, float64) {
+	if !s.Scheduled() {
+		return time.Time{}, s.Default
+	}
+	t = t.Local()
+	current := s.Limit(t)
+
+	// The cap can only change where some rule starts or ends. Collect those
+	// boundaries for the coming week and a day, in order.
+	var bounds []time.Time
+	y, m, d := t.Date()
+	for off := 0; off <= 8; off++ {
+		for _, r := range s.Rules {
+			for _, minute := range []int{r.Start, r.End} {
+				b := time.Date(y, m, d+off, minute/60, minute%60, 0, 0, time.Local)
+				if b.After(t) {
+					bounds = append(bounds, b)
+				}
+			}
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Before(bounds[j]) })
+	for _, b := range bounds {
+		if limit := s.Limit(b); limit != current {
+			return b, limit
+		}
+	}
+	return time.Time{}, current
+}
+
+// parseBandwidthSchedule parses a --bandwidth value; see BandwidthSchedule
+// for the syntax.
+func parseBandwidthSchedule(spec string) (BandwidthSchedule, error) {
+	s := BandwidthSchedule{Spec: strings.TrimSpace(spec)}
+	haveDefault := false
+	for _, part := range strings.Split(spec, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		var r BandwidthRule
+		hasDays, hasTime := false, false
+		for len(fields) > 1 {
+			if !hasDays && !hasTime {
+				if days, ok := parseDays(fields[0]); ok {
+					r.Days, hasDays = days, true
+					fields = fields[1:]
+					continue
+				}
+			}
+			if !hasTime && strings.Contains(fields[0], ":") {
+				start, end, err := parseTimeRange(fields[0])
+				if err != nil {
+					return s, err
+				}
+				r.Start, r.End, hasTime = start, end, true
+				fields = fields[1:]
+				continue
+			}
+			break
+		}
+		rate := strings.Join(fields, " ")
+		if strings.Contains(rate, ":") {
+			return s, fmt.Errorf("rule %q has no rate", strings.TrimSpace(part))
+		}
+		limit, err := parseRate(rate)
+		if err != nil {
+			return s, fmt.Errorf("rate %q: %w", rate, err)
+		}
+		r.Limit = limit
+
+		if !hasDays && !hasTime {
+			if haveDefault {
+				return s, fmt.Errorf("more than one rule without days or times")
+			}
+			s.Default, haveDefault = limit, true
+			continue
+		}
+		if !hasDays {
+			r.Days = [7]bool{true, true, true, true, true, true, true}
+		}
+		if !hasTime {
+			r.Start, r.End = 0, minutesPerDay
+		}
+		s.Rules = append(s.Rules, r)
+	}
+	return s, nil
+}
+
+// parseRate parses a rate for a schedule rule: a bandwidth or "unlimited".
+func parseRate(s string) (float64, error) {
+	if strings.EqualFold(strings.TrimSpace(s), "unlimited") {
+		return 0, nil
+	}
+	return parseBandwidth(s)
+}
+
+// weekdays maps three-letter day names to time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDays parses a day list such as "mon-fri", "sat,sun" or "daily".
+// It reports false when s is not a day list at all.
+func parseDays(s string) ([7]bool, bool) {
+	var days [7]bool
+	s = strings.ToLower(s)
+	if s == "daily" {
+		return [7]bool{true, true, true, true, true, true, true}, true
+	}
+	for _, item := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return days, false
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return days, false
+			}
+		}
+		// Ranges may wrap around the week, e.g. fri-mon.
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, true
+}
+
+// parseTimeRange parses "HH:MM-HH:MM" into minutes after midnight. An end of
+// 24:00 means midnight at the end of the day.
+func parseTimeRange(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time range %q (want HH:MM-HH:MM)", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == minutesPerDay || start == end {
+		return 0, 0, fmt.Errorf("invalid time range %q", s)
+	}
+	return start, end, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || len(m) != 2 || hour < 0 || minute < 0 || minute > 59 ||
+		hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return hour*60 + minute, nil
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gileserver/models"
+)
+
+// adminUser is the user name expected by AdminAuth.
+const adminUser = "admin"
+
+// AdminAuth protects h with HTTP Basic authentication for the user "admin"
+// and the given password. Credentials are compared in constant time, and
+// failed attempts are logged with the client IP.
+func AdminAuth(password string, h http.Handler) http.Handler {
+	want := sha256.Sum256([]byte(adminUser + ":" + password))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		// Hashing both sides first makes the comparison independent of the
+		// lengths involved.
+		got := sha256.Sum256([]byte(user + ":" + pass))
+		if !ok || subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+			if ok {
+				log.Printf("admin: rejected credentials  ip=%s  path=%s", clientIP(r), r.URL.Path)
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="GileBrowser admin", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// BandwidthStatusHandler serves /admin/bandwidth: the bandwidth limits in
+// force, the next scheduled change and the current allocation, as JSON.
+func BandwidthStatusHandler(bm *BandwidthManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bm.Status())
+	}
+}
+
+// AdminPageHandler serves /admin/transfers, the live transfer dashboard. The
+// page polls TransfersHandler and acts through AbortHandler and
+// ThrottleHandler.
+func AdminPageHandler(siteName, defaultTheme string, tmpl interface {
+	ExecuteAdmin(http.ResponseWriter, *models.AdminPage) error
+}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := &models.AdminPage{
+			Title:        "Transfers",
+			SiteName:     siteName,
+			DefaultTheme: defaultTheme,
+		}
+		if err := tmpl.ExecuteAdmin(w, data); err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// TransfersHandler serves /admin/api/transfers: every transfer in progress
+// and the throttles in force, as JSON.
+func TransfersHandler(bm *BandwidthManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(bm.Transfers())
+	}
+}
+
+// adminAction checks that r is a POST sent by the dashboard. Browsers attach
+// Basic credentials to cross-site requests too, so a custom header, which a
+// cross-site form cannot set, is required as well.
+func adminAction(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if r.Header.Get("X-Gile-Admin") == "" {
+		http.Error(w, "Missing X-Gile-Admin header", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// AbortHandler serves POST /admin/api/abort?id=N, which cuts off a transfer.
+func AbortHandler(bm *BandwidthManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAction(w, r) {
+			return
+		}
+		id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid transfer id", http.StatusBadRequest)
+			return
+		}
+		if !bm.Abort(id) {
+			http.Error(w, "No such transfer", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// maxThrottle is the longest an admin throttle may last.
+const maxThrottle = 7 * 24 * time.Hour
+
+// ThrottleHandler serves POST /admin/api/throttle?ip=A&rate=R&minutes=M,
+// which caps client IP A at R bytes per second for M minutes. A rate of 0
+// lifts the IP's throttle.
+func ThrottleHandler(bm *BandwidthManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAction(w, r) {
+			return
+		}
+		ip := net.ParseIP(r.FormValue("ip"))
+		if ip == nil {
+			http.Error(w, "Invalid IP address", http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.ParseFloat(r.FormValue("rate"), 64)
+		if err != nil || limit < 0 || math.IsInf(limit, 0) || math.IsNaN(limit) {
+			http.Error(w, "Invalid rate", http.StatusBadRequest)
+			return
+		}
+		var d time.Duration
+		if limit > 0 {
+			minutes, err := strconv.ParseFloat(r.FormValue("minutes"), 64)
+			d = time.Duration(minutes * float64(time.Minute))
+			if err != nil || d <= 0 || d > maxThrottle {
+				http.Error(w, "Invalid duration", http.StatusBadRequest)
+				return
+			}
+		}
+		bm.Throttle(ip.String(), limit, d)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ReloadHandler serves POST /admin/api/reload, which reloads the
+// configuration as SIGHUP does. A configuration that does not load is
+// reported with 422 Unprocessable Entity, and the running one is kept.
+func ReloadHandler(reload func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAction(w, r) {
+			return
+		}
+		log.Printf("admin: reload  ip=%s", clientIP(r))
+		if err := reload(); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,618 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"gileserver/models"
+)
+
+// Archive browsing lets a supported archive file be navigated like a
+// read-only directory. A URL such as /root/bundle.zip/docs/readme.md names
+// the member docs/readme.md inside /root/bundle.zip; nothing is ever
+// extracted to disk.
+//
+// Member names come from untrusted archive headers, so every name is
+// sanitised once when the archive is indexed (see sanitizeMemberName) and
+// members are only ever located through that index — never by joining a
+// member name onto a filesystem path.
+
+const (
+	archiveZip    = "zip"
+	archiveTar    = "tar"
+	archiveTarGz  = "tar.gz"
+	archiveTarZst = "tar.zst"
+
+	// maxArchiveMembers bounds the index built for a single archive so that a
+	// pathological archive cannot exhaust memory. Members past the limit are
+	// not listed.
+	maxArchiveMembers = 200000
+
+	// maxCachedArchives bounds the number of archive indexes held in memory.
+	maxCachedArchives = 64
+)
+
+// errMemberNotFound is returned when a member path does not exist in the
+// archive index.
+var errMemberNotFound = errors.New("archive member not found")
+
+// archiveKind returns the archive format for a file name, or "" when the name
+// does not carry a supported archive extension.
+func archiveKind(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz
+	case strings.HasSuffix(lower, ".tar.zst"), strings.HasSuffix(lower, ".tar.zstd"), strings.HasSuffix(lower, ".tzst"):
+		return archiveTarZst
+	}
+	return ""
+}
+
+// archiveRef identifies a location inside an archive on disk.
+type archiveRef struct {
+	fsPath  string      // archive file on disk
+	urlPath string      // URL path of the archive file itself
+	member  string      // sanitised member path; "" for the archive root
+	info    os.FileInfo // stat of the archive file
+}
+
+// resolveArchivePath checks whether urlPath names an archive file or a path
+// inside one. It walks up the URL one segment at a time until it reaches a
+// path that exists on disk; if that path is a regular file with a supported
+// archive extension, the remaining segments form the member path.
+func resolveArchivePath(r *http.Request, roots map[string]string, urlPath string) (*archiveRef, bool) {
+	segs := strings.Split(strings.Trim(urlPath, "/"), "/")
+	// segs[0] is the root name, which is always a directory.
+	for i := len(segs); i >= 2; i-- {
+		prefix := "/" + strings.Join(segs[:i], "/")
+		fsPath, err := resolvePath(r, roots, prefix)
+		if err != nil {
+			return nil, false
+		}
+		info, err := os.Stat(fsPath)
+		if err != nil {
+			continue
+		}
+		if !info.Mode().IsRegular() || archiveKind(fsPath) == "" {
+			return nil, false
+		}
+		return &archiveRef{
+			fsPath:  fsPath,
+			urlPath: prefix,
+			member:  strings.Join(segs[i:], "/"),
+			info:    info,
+		}, true
+	}
+	return nil, false
+}
+
+// sanitizeMemberName converts a raw archive member name into a clean,
+// relative, slash-separated path. It reports false for names that are
+// absolute, carry a drive letter, contain NUL bytes or climb out of the
+// archive with "..", so such members are never listed or served.
+func sanitizeMemberName(raw string) (string, bool) {
+	name := strings.ReplaceAll(raw, "\\", "/")
+	if name == "" || strings.ContainsRune(name, 0) || strings.HasPrefix(name, "/") {
+		return "", false
+	}
+	if len(name) >= 2 && name[1] == ':' {
+		return "", false
+	}
+	parts := make([]string, 0, strings.Count(name, "/")+1)
+	for _, p := range strings.Split(name, "/") {
+		switch p {
+		case "", ".":
+			continue
+		case "..":
+			return "", false
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	return strings.Join(parts, "/"), true
+}
+
+// ---------------------------------------------------------------------------
+// Archive index
+// ---------------------------------------------------------------------------
+
+// archiveMember is one file or directory inside an archive.
+type archiveMember struct {
+	name     string // sanitised path within the archive
+	isDir    bool
+	size     int64 // uncompressed size; aggregate of descendants for directories
+	modTime  time.Time
+	raw      string   // name exactly as stored in the archive
+	zipIndex int      // position in the zip central directory
+	offset   int64    // data offset in a plain tar, or -1 when not seekable
+	children []string // base names of direct children, for directories
+}
+
+// archiveIndex is the parsed member list of one archive.
+type archiveIndex struct {
+	kind    string
+	members map[string]*archiveMember // keyed by sanitised path; "" is the root
+}
+
+// archiveCacheEntry holds one index together with the file identity it was
+// built from. once ensures concurrent requests share a single build.
+type archiveCacheEntry struct {
+	modTime time.Time
+	size    int64
+	once    sync.Once
+	idx     *archiveIndex
+	err     error
+}
+
+// archiveCache caches archive indexes keyed by absolute filesystem path.
+// Entries are validated against the archive's mtime and size on every
+// lookup, so a replaced archive is re-indexed without watcher involvement.
+var archiveCache struct {
+	mu      sync.Mutex
+	entries map[string]*archiveCacheEntry
+}
+
+// archiveIndexFor returns the (possibly cached) index of the archive ref
+// points into.
+func archiveIndexFor(ref *archiveRef) (*archiveIndex, error) {
+	archiveCache.mu.Lock()
+	if archiveCache.entries == nil {
+		archiveCache.entries = make(map[string]*archiveCacheEntry)
+	}
+	e, ok := archiveCache.entries[ref.fsPath]
+	if !ok || !e.modTime.Equal(ref.info.ModTime()) || e.size != ref.info.Size() {
+		if !ok && len(archiveCache.entries) >= maxCachedArchives {
+			// Evict an arbitrary entry; re-indexing is cheap relative to the
+			// memory an unbounded cache could hold.
+			for k := range archiveCache.entries {
+				delete(archiveCache.entries, k)
+				break
+			}
+		}
+		e = &archiveCacheEntry{modTime: ref.info.ModTime(), size: ref.info.Size()}
+		archiveCache.entries[ref.fsPath] = e
+	}
+	archiveCache.mu.Unlock()
+
+	e.once.Do(func() {
+		start := time.Now()
+		e.idx, e.err = buildArchiveIndex(ref.fsPath, archiveKind(ref.fsPath), ref.info.ModTime())
+		if e.err != nil {
+			log.Printf("archive index  error  file=%s  err=%v", ref.fsPath, e.err)
+			return
+		}
+		log.Printf("archive index  built  members=%d  duration=%s  file=%s",
+			len(e.idx.members)-1, time.Since(start).Round(time.Millisecond), ref.fsPath)
+	})
+	return e.idx, e.err
+}
+
+// buildArchiveIndex reads the member list of the archive at fsPath.
+// archiveMTime is used for implicit parent directories that have no header
+// of their own.
+func buildArchiveIndex(fsPath, kind string, archiveMTime time.Time) (*archiveIndex, error) {
+	idx := &archiveIndex{
+		kind:    kind,
+		members: map[string]*archiveMember{"": {isDir: true, modTime: archiveMTime}},
+	}
+
+	switch kind {
+	case archiveZip:
+		zr, err := zip.OpenReader(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for i, f := range zr.File {
+			if len(idx.members) > maxArchiveMembers {
+				break
+			}
+			mode := f.Mode()
+			if mode&os.ModeSymlink != 0 {
+				continue
+			}
+			idx.add(&archiveMember{
+				raw:      f.Name,
+				isDir:    mode.IsDir(),
+				size:     int64(f.UncompressedSize64),
+				modTime:  f.Modified,
+				zipIndex: i,
+				offset:   -1,
+			}, archiveMTime)
+		}
+
+	case archiveTar, archiveTarGz, archiveTarZst:
+		f, err := os.Open(fsPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		cr := &countingReader{r: f}
+		src, closeSrc, err := decompressTar(kind, cr)
+		if err != nil {
+			return nil, err
+		}
+		defer closeSrc()
+		tr := tar.NewReader(src)
+		for len(idx.members) <= maxArchiveMembers {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			m := &archiveMember{raw: hdr.Name, modTime: hdr.ModTime, offset: -1}
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				m.isDir = true
+			case tar.TypeReg, tar.TypeRegA:
+				m.size = hdr.Size
+				// In an uncompressed tar the reader sits at the first data
+				// byte once Next returns, so the member can later be served
+				// as a seekable section. Sparse files store a map in their
+				// data and are always streamed.
+				if kind == archiveTar && !isSparseTarHeader(hdr) {
+					m.offset = cr.n
+				}
+			default:
+				// Links, devices and FIFOs are not exposed.
+				continue
+			}
+			idx.add(m, archiveMTime)
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported archive format")
+	}
+
+	idx.finish("")
+	return idx, nil
+}
+
+// add inserts m into the index under its sanitised name, creating any
+// implicit parent directories. Unsafe names, duplicates and members whose
+// parent path is occupied by a file are dropped.
+func (idx *archiveIndex) add(m *archiveMember, archiveMTime time.Time) {
+	name, ok := sanitizeMemberName(m.raw)
+	if !ok {
+		return
+	}
+	if existing, dup := idx.members[name]; dup {
+		// A directory may have been created implicitly before its own header
+		// was seen; adopt the header's mtime but keep the first entry.
+		if existing.isDir && m.isDir && !m.modTime.IsZero() {
+			existing.modTime = m.modTime
+		}
+		return
+	}
+	parent := path.Dir(name)
+	if parent == "." {
+		parent = ""
+	}
+	if !idx.ensureDir(parent, archiveMTime) {
+		return
+	}
+	m.name = name
+	idx.members[name] = m
+	p := idx.members[parent]
+	p.children = append(p.children, path.Base(name))
+}
+
+// ensureDir makes sure dir and all of its ancestors exist as directories.
+// It reports false when some component is already a file.
+func (idx *archiveIndex) ensureDir(dir string, archiveMTime time.Time) bool {
+	if m, ok := idx.members[dir]; ok {
+		return m.isDir
+	}
+	parent := path.Dir(dir)
+	if parent == "." {
+		parent = ""
+	}
+	if !idx.ensureDir(parent, archiveMTime) {
+		return false
+	}
+	idx.members[dir] = &archiveMember{name: dir, isDir: true, modTime: archiveMTime, offset: -1}
+	p := idx.members[parent]
+	p.children = append(p.children, path.Base(dir))
+	return true
+}
+
+// finish computes aggregate directory sizes below name and returns the size
+// of name itself.
+func (idx *archiveIndex) finish(name string) int64 {
+	m := idx.members[name]
+	if !m.isDir {
+		return m.size
+	}
+	var total int64
+	for _, c := range m.children {
+		total += idx.finish(path.Join(name, c))
+	}
+	m.size = total
+	return total
+}
+
+// isSparseTarHeader reports whether hdr describes a GNU or PAX sparse file.
+func isSparseTarHeader(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the body bytes written through it.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decompressTar returns the tar stream for an archive of the given kind read
+// from r, together with a function that releases any decoder resources.
+func decompressTar(kind string, r io.Reader) (io.Reader, func(), error) {
+	switch kind {
+	case archiveTarGz:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { gz.Close() }, nil
+	case archiveTarZst:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// ---------------------------------------------------------------------------
+// Listing and opening members
+// ---------------------------------------------------------------------------
+
+// archiveEntries returns the listing for the directory dir inside an
+// archive, in the same order and shape as buildEntries.
+func archiveEntries(idx *archiveIndex, ref *archiveRef, dir *archiveMember) []models.FileEntry {
+	dirURL := path.Join(ref.urlPath, dir.name)
+	entries := make([]models.FileEntry, 0, len(dir.children))
+	for _, c := range dir.children {
+		m := idx.members[path.Join(dir.name, c)]
+		fe := models.FileEntry{
+			Name:      c,
+			Path:      path.Join(dirURL, c),
+			IsDir:     m.isDir,
+			Size:      m.size,
+			ModTime:   m.modTime,
+			InArchive: true,
+		}
+		if !m.isDir {
+			mime := mimeForName(c)
+			fe.MIMEType = mime
+			fe.IsImage = isImage(mime)
+			fe.IsText = isText(mime)
+			fe.IsPreview = fe.IsImage || fe.IsText
+		}
+		entries = append(entries, fe)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	return entries
+}
+
+// memberReader is an open archive member. seeker is non-nil when the member
+// is stored uncompressed and supports random access (and thus HTTP ranges).
+type memberReader struct {
+	io.Reader
+	seeker io.ReadSeeker
+	close  func()
+}
+
+func (m *memberReader) Close() error {
+	m.close()
+	return nil
+}
+
+// openMember opens the file member m of the archive at fsPath for reading.
+func openMember(fsPath string, idx *archiveIndex, m *archiveMember) (*memberReader, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	switch idx.kind {
+	case archiveZip:
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if m.zipIndex >= len(zr.File) || zr.File[m.zipIndex].Name != m.raw {
+			f.Close()
+			return nil, errMemberNotFound
+		}
+		zf := zr.File[m.zipIndex]
+		if zf.Method == zip.Store && zf.Flags&0x1 == 0 {
+			if off, err := zf.DataOffset(); err == nil {
+				sr := io.NewSectionReader(f, off, int64(zf.CompressedSize64))
+				return &memberReader{Reader: sr, seeker: sr, close: func() { f.Close() }}, nil
+			}
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &memberReader{Reader: rc, close: func() { rc.Close(); f.Close() }}, nil
+
+	default:
+		if m.offset >= 0 {
+			sr := io.NewSectionReader(f, m.offset, m.size)
+			return &memberReader{Reader: sr, seeker: sr, close: func() { f.Close() }}, nil
+		}
+		src, closeSrc, err := decompressTar(idx.kind, f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		tr := tar.NewReader(src)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				closeSrc()
+				f.Close()
+				if err == io.EOF {
+					err = errMemberNotFound
+				}
+				return nil, err
+			}
+			if hdr.Name == m.raw && (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) {
+				return &memberReader{Reader: tr, close: func() { closeSrc(); f.Close() }}, nil
+			}
+		}
+	}
+}
+
+// lookupMember resolves ref to its index and member. A missing member is
+// reported as errMemberNotFound.
+func lookupMember(ref *archiveRef) (*archiveIndex, *archiveMember, error) {
+	idx, err := archiveIndexFor(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, ok := idx.members[ref.member]
+	if !ok {
+		return nil, nil, errMemberNotFound
+	}
+	return idx, m, nil
+}
+
+// serveArchiveMember writes a single archive member to w. When attachment
+// is true the response carries Content-Disposition: attachment, matching
+// FileHandler; otherwise it is served inline like ViewHandler. Seekable
+// members go through http.ServeContent for range support; compressed members
+// are streamed with the Content-Length their header gives, and never more. A
+// member that turns out shorter than that, or fails to decompress, before
+// the headers are sent gets an error response; later on, the connection is
+// cut so the client sees the response is incomplete. It returns the number
+// of body bytes sent, or -1 when an error response was written.
+func serveArchiveMember(w http.ResponseWriter, r *http.Request, ref *archiveRef, attachment bool) int64 {
+	idx, m, err := lookupMember(ref)
+	if err != nil || m.isDir {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return -1
+	}
+	mr, err := openMember(ref.fsPath, idx, m)
+	if err != nil {
+		http.Error(w, "Could not open file", http.StatusInternalServerError)
+		return -1
+	}
+	defer mr.Close()
+
+	name := path.Base(m.name)
+	w.Header().Set("Content-Type", mimeForName(name))
+	if attachment {
+		w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(name))
+	}
+
+	if mr.seeker != nil {
+		cw := &countingWriter{ResponseWriter: w}
+		http.ServeContent(cw, r, name, m.modTime, mr.seeker)
+		return cw.n
+	}
+
+	if !m.modTime.IsZero() {
+		w.Header().Set("Last-Modified", m.modTime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method == http.MethodHead {
+		w.Header().Set("Content-Length", strconv.FormatInt(m.size, 10))
+		return 0
+	}
+
+	// Read the start of the member while an error can still be reported.
+	body := io.LimitReader(mr, m.size)
+	head := make([]byte, min(m.size, copyBufferSize))
+	if _, err := io.ReadFull(body, head); err != nil {
+		log.Printf("archive: %s: %s: %v", ref.fsPath, m.name, err)
+		http.Error(w, "Could not read file", http.StatusInternalServerError)
+		return -1
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(m.size, 10))
+	src := &countingReader{r: io.MultiReader(bytes.NewReader(head), body)}
+	n, err := io.CopyBuffer(w, src, make([]byte, copyBufferSize))
+	// Everything read was written, so the member itself ran short; a write
+	// error (the client went away) leaves read bytes unwritten.
+	if n < m.size && src.n == n {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		log.Printf("archive: %s: %s: sent %d of %d bytes: %v", ref.fsPath, m.name, n, m.size, err)
+		panic(http.ErrAbortHandler)
+	}
+	return n
+}
+
+// readMemberText reads up to maxBytes from the start of an archive member.
+func readMemberText(ref *archiveRef, idx *archiveIndex, m *archiveMember, maxBytes int64) ([]byte, error) {
+	mr, err := openMember(ref.fsPath, idx, m)
+	if err != nil {
+		return nil, err
+	}
+	defer mr.Close()
+	return io.ReadAll(io.LimitReader(mr, maxBytes))
+}
@@ -0,0 +1,890 @@
+package handlers
+
+import (
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// renderAsciiDoc converts AsciiDoc to HTML.
+//
+// The renderer covers the constructs used by typical READMEs and Antora
+// pages: the document title and header, section titles, paragraphs and
+// literal paragraphs, admonition paragraphs and blocks, ordered, unordered,
+// checklist and description lists (with "+" list continuation), delimited
+// listing, literal, example, sidebar, quote, open and passthrough blocks,
+// tables, images, attribute entries and references, and the common inline
+// formatting and macros. Unknown block macros — include:: in particular,
+// which would read other files — are shown as visible literal blocks rather
+// than failing the render.
+//
+// All output is passed through sanitizeHTML before being placed in the page.
+func renderAsciiDoc(content, docURLDir string, previewImages bool) (template.HTML, error) {
+	p := &adocParser{attrs: make(map[string]string)}
+	out := p.blocks(splitMarkupLines(content, 4))
+	out = strings.ReplaceAll(out, adocTOCMarker, p.toc())
+	return template.HTML(sanitizeHTML(out, docURLDir, previewImages)), nil
+}
+
+// adocTOCMarker is substituted with the table of contents once every section
+// in the document is known.
+const adocTOCMarker = "\x00adoc-toc\x00"
+
+type adocParser struct {
+	attrs    map[string]string // document attributes set by ":name: value" entries
+	slugger  headingSlugger
+	headings []rstHeading
+	tocDone  bool
+}
+
+// adocBlockMeta holds the attribute list, title and anchor that apply to the
+// next block.
+type adocBlockMeta struct {
+	style   string            // first positional attribute, e.g. "source", "NOTE", "quote"
+	pos     []string          // all positional attributes
+	named   map[string]string // named attributes, e.g. cols, options
+	options map[string]bool   // %option shorthands and options="…"
+	id      string
+	title   string
+}
+
+var (
+	adocAttrEntryRe  = regexp.MustCompile(`^:(!?[\w-]+!?):(?:\s+(.*))?$`)
+	adocAnchorRe     = regexp.MustCompile(`^\[\[([^\],]+)(?:,[^\]]*)?\]\]$`)
+	adocBlockAttrRe  = regexp.MustCompile(`^\[([^\[\]]*)\]$`)
+	adocTitleRe      = regexp.MustCompile(`^\.([^.\s].*)$`)
+	adocSectionRe    = regexp.MustCompile(`^(={1,6}|#{1,6})\s+(.+?)(?:\s+=+)?$`)
+	adocAdmonitionRe = regexp.MustCompile(`^(NOTE|TIP|IMPORTANT|WARNING|CAUTION):\s+(.*)$`)
+	adocBlockMacroRe = regexp.MustCompile(`^([\w-]+)::(\S*?)\[(.*)\]$`)
+	adocListRe       = regexp.MustCompile(`^\s*(\*{1,5}|-|\.{1,5}|\d+\.)\s+(.*)$`)
+	adocDlistRe      = regexp.MustCompile(`^\s*(.*?[^:;])(::|:::|::::|;;)(?:\s+(.*))?$`)
+	adocShorthandRe  = regexp.MustCompile(`[#.%][^#.%]+`)
+	adocBreakRe      = regexp.MustCompile(`^'{3,}$`)
+)
+
+// adocDelimiter returns the delimited-block kind introduced by line, or "".
+func adocDelimiter(line string) string {
+	if line == "--" {
+		return "open"
+	}
+	if strings.HasPrefix(line, "```") {
+		return "fenced"
+	}
+	if line == "|===" || line == ",===" || line == ":===" {
+		return "table"
+	}
+	if len(line) < 4 {
+		return ""
+	}
+	c := line[0]
+	for i := 1; i < len(line); i++ {
+		if line[i] != c {
+			return ""
+		}
+	}
+	switch c {
+	case '-':
+		return "listing"
+	case '.':
+		return "literal"
+	case '=':
+		return "example"
+	case '*':
+		return "sidebar"
+	case '_':
+		return "quote"
+	case '+':
+		return "pass"
+	case '/':
+		return "comment"
+	}
+	return ""
+}
+
+// adocDelimitedEnd returns the index of the line closing the delimited block
+// opened at lines[i], or len(lines) if it is never closed.
+func adocDelimitedEnd(lines []string, i int) int {
+	closer := lines[i]
+	if strings.HasPrefix(closer, "```") {
+		closer = "```"
+	}
+	for k := i + 1; k < len(lines); k++ {
+		if lines[k] == closer {
+			return k
+		}
+	}
+	return len(lines)
+}
+
+// parseBlockAttrs parses the contents of a block attribute line such as
+// `source,python` or `#intro.lead%collapsible,cols="1,2",options=header`.
+func parseBlockAttrs(s string, meta *adocBlockMeta) {
+	meta.named = make(map[string]string)
+	meta.options = make(map[string]bool)
+	var parts []string
+	var cur strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	parts = append(parts, strings.TrimSpace(cur.String()))
+
+	for k, part := range parts {
+		if eq := strings.IndexByte(part, '='); eq > 0 {
+			name, val := strings.TrimSpace(part[:eq]), strings.TrimSpace(part[eq+1:])
+			meta.named[name] = val
+			if name == "options" || name == "opts" {
+				for _, o := range strings.Split(val, ",") {
+					meta.options[strings.TrimSpace(o)] = true
+				}
+			}
+			continue
+		}
+		if k == 0 {
+			// The first positional attribute may carry #id, .role and
+			// %option shorthands after the style name.
+			style := part
+			if cut := strings.IndexAny(style, "#.%"); cut >= 0 {
+				rest := style[cut:]
+				style = style[:cut]
+				for _, sh := range adocShorthandRe.FindAllString(rest, -1) {
+					switch sh[0] {
+					case '#':
+						meta.id = sh[1:]
+					case '%':
+						meta.options[sh[1:]] = true
+					}
+				}
+			}
+			meta.style = style
+		}
+		meta.pos = append(meta.pos, part)
+	}
+}
+
+// blocks renders a sequence of AsciiDoc blocks.
+func (p *adocParser) blocks(lines []string) string {
+	var b strings.Builder
+	var meta adocBlockMeta
+	reset := func() { meta = adocBlockMeta{} }
+
+	i := 0
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+			continue
+
+		case adocDelimiter(line) == "comment":
+			i = adocDelimitedEnd(lines, i) + 1
+			continue
+
+		case strings.HasPrefix(line, "//"):
+			i++
+			continue
+
+		case adocAttrEntryRe.MatchString(line):
+			m := adocAttrEntryRe.FindStringSubmatch(line)
+			name := m[1]
+			if strings.HasPrefix(name, "!") || strings.HasSuffix(name, "!") {
+				delete(p.attrs, strings.Trim(name, "!"))
+			} else {
+				p.attrs[name] = m[2]
+			}
+			i++
+			continue
+
+		case adocAnchorRe.MatchString(line):
+			meta.id = adocAnchorRe.FindStringSubmatch(line)[1]
+			i++
+			continue
+
+		case adocBlockAttrRe.MatchString(line):
+			id, title := meta.id, meta.title
+			parseBlockAttrs(adocBlockAttrRe.FindStringSubmatch(line)[1], &meta)
+			if meta.id == "" {
+				meta.id = id
+			}
+			meta.title = title
+			i++
+			continue
+
+		case adocTitleRe.MatchString(line) && !adocListRe.MatchString(line):
+			meta.title = adocTitleRe.FindStringSubmatch(line)[1]
+			i++
+			continue
+		}
+
+		if m := adocSectionRe.FindStringSubmatch(line); m != nil && meta.style == "" {
+			level := len(m[1])
+			text := m[2]
+			id := meta.id
+			if id == "" {
+				id = p.slugger.slug(p.subAttrs(text))
+			}
+			if level > 1 {
+				p.headings = append(p.headings, rstHeading{level: level, id: id, text: text})
+			}
+			b.WriteString(markupHeading(level, id, p.inline(text)))
+			i++
+			if level == 1 {
+				// The document header: author and revision lines directly
+				// below the title are metadata, not content.
+				for i < len(lines) && !isBlank(lines[i]) {
+					if am := adocAttrEntryRe.FindStringSubmatch(lines[i]); am != nil {
+						p.attrs[am[1]] = am[2]
+					}
+					i++
+				}
+				if _, ok := p.attrs["toc"]; ok && !p.tocDone {
+					p.tocDone = true
+					b.WriteString(adocTOCMarker)
+				}
+			}
+			reset()
+			continue
+		}
+
+		if kind := adocDelimiter(line); kind != "" {
+			end := adocDelimitedEnd(lines, i)
+			b.WriteString(p.delimited(kind, line, lines[i+1:end], &meta))
+			i = end + 1
+			reset()
+			continue
+		}
+
+		switch {
+		case adocBreakRe.MatchString(trimmed):
+			b.WriteString("<hr />\n")
+			i++
+
+		case trimmed == "<<<":
+			i++
+
+		case adocBlockMacroRe.MatchString(line):
+			b.WriteString(p.blockMacro(line, &meta))
+			i++
+
+		case adocListRe.MatchString(line) || (adocDlistRe.MatchString(line) && !strings.HasPrefix(line, " ")):
+			var html string
+			html, i = p.list(lines, i)
+			b.WriteString(p.titled(meta, html))
+
+		case strings.HasPrefix(line, " "):
+			// Literal paragraph: indented lines shown verbatim.
+			end := i
+			for end < len(lines) && !isBlank(lines[end]) {
+				end++
+			}
+			b.WriteString(markupLiteral(strings.Join(dedentLines(lines[i:end]), "\n"), "literal-block"))
+			i = end
+
+		default:
+			end := i
+			for end < len(lines) && !isBlank(lines[end]) && (end == i || adocDelimiter(lines[end]) == "") {
+				end++
+			}
+			b.WriteString(p.paragraph(lines[i:end], &meta))
+			i = end
+		}
+		reset()
+	}
+	return b.String()
+}
+
+// titled prefixes html with a block title, if one was given.
+func (p *adocParser) titled(meta adocBlockMeta, html string) string {
+	if meta.title == "" {
+		return html
+	}
+	return `<p class="block-title">` + p.inline(meta.title) + "</p>\n" + html
+}
+
+// paragraph renders a paragraph, honouring a style given in its attribute
+// list ([source], [quote], [NOTE], …) and admonition labels.
+func (p *adocParser) paragraph(lines []string, meta *adocBlockMeta) string {
+	text := strings.Join(lines, "\n")
+
+	if m := adocAdmonitionRe.FindStringSubmatch(lines[0]); m != nil && meta.style == "" {
+		body := append([]string{m[2]}, lines[1:]...)
+		return markupAdmonition(strings.ToLower(m[1]), p.inline(meta.title), "<p>"+p.inlineLines(body)+"</p>\n")
+	}
+
+	switch style := meta.style; {
+	case style == "source" || style == "listing":
+		return p.titled(*meta, highlightOrEscape(text, p.sourceLang(meta)))
+	case style == "literal":
+		return p.titled(*meta, markupLiteral(text, "literal-block"))
+	case admonitionTitles[strings.ToLower(style)] != "":
+		return markupAdmonition(strings.ToLower(style), p.inline(meta.title), "<p>"+p.inlineLines(lines)+"</p>\n")
+	case style == "quote" || style == "verse":
+		return p.quote(meta, "<p>"+p.inlineLines(lines)+"</p>\n")
+	}
+	return p.titled(*meta, "<p>"+p.inlineLines(lines)+"</p>\n")
+}
+
+// inlineLines renders paragraph lines, turning a trailing " +" into a hard
+// line break.
+func (p *adocParser) inlineLines(lines []string) string {
+	out := make([]string, len(lines))
+	for k, l := range lines {
+		if strings.HasSuffix(l, " +") {
+			out[k] = p.inline(strings.TrimSuffix(l, " +")) + "<br />"
+			continue
+		}
+		out[k] = p.inline(l)
+	}
+	return strings.Join(out, "\n")
+}
+
+// sourceLang returns the language of a source block: the second positional
+// attribute, falling back to the source-language document attribute.
+func (p *adocParser) sourceLang(meta *adocBlockMeta) string {
+	if len(meta.pos) > 1 {
+		return meta.pos[1]
+	}
+	if l := meta.named["language"]; l != "" {
+		return l
+	}
+	return p.attrs["source-language"]
+}
+
+// quote renders a blockquote with optional attribution.
+func (p *adocParser) quote(meta *adocBlockMeta, body string) string {
+	var b strings.Builder
+	b.WriteString("<blockquote>" + body)
+	var cite []string
+	if len(meta.pos) > 1 && meta.pos[1] != "" {
+		cite = append(cite, p.inline(strings.Trim(meta.pos[1], `"`)))
+	}
+	if len(meta.pos) > 2 && meta.pos[2] != "" {
+		cite = append(cite, "<cite>"+p.inline(strings.Trim(meta.pos[2], `"`))+"</cite>")
+	}
+	if len(cite) > 0 {
+		b.WriteString("<footer>— " + strings.Join(cite, ", ") + "</footer>")
+	}
+	b.WriteString("</blockquote>\n")
+	return p.titled(*meta, b.String())
+}
+
+// delimited renders a delimited block of the given kind.
+func (p *adocParser) delimited(kind, opener string, content []string, meta *adocBlockMeta) string {
+	text := strings.Join(content, "\n")
+	style := meta.style
+	if adm := strings.ToLower(style); admonitionTitles[adm] != "" && (kind == "example" || kind == "open") {
+		return markupAdmonition(adm, p.inline(meta.title), p.blocks(content))
+	}
+
+	switch kind {
+	case "listing", "fenced":
+		lang := p.sourceLang(meta)
+		if kind == "fenced" {
+			lang = strings.TrimSpace(strings.TrimPrefix(opener, "```"))
+		}
+		if style == "source" || kind == "fenced" || lang != "" {
+			return p.titled(*meta, highlightOrEscape(text, lang))
+		}
+		return p.titled(*meta, markupLiteral(text, "listing-block"))
+
+	case "literal":
+		return p.titled(*meta, markupLiteral(text, "literal-block"))
+
+	case "example":
+		return `<div class="example-block">` + p.titled(*meta, p.blocks(content)) + "</div>\n"
+
+	case "sidebar":
+		out := `<aside class="topic">`
+		if meta.title != "" {
+			out += `<p class="topic-title">` + p.inline(meta.title) + "</p>\n"
+		}
+		return out + p.blocks(content) + "</aside>\n"
+
+	case "quote":
+		if style == "verse" {
+			return p.quote(meta, markupLiteral(text, "verse"))
+		}
+		return p.quote(meta, p.blocks(content))
+
+	case "open":
+		if style == "source" {
+			return p.titled(*meta, highlightOrEscape(text, p.sourceLang(meta)))
+		}
+		return "<div>" + p.titled(*meta, p.blocks(content)) + "</div>\n"
+
+	case "pass":
+		// Raw HTML; sanitizeHTML applies the document policy to it.
+		return text + "\n"
+
+	case "table":
+		if opener != "|===" {
+			return markupUnsupported(opener + "\n" + text + "\n" + opener)
+		}
+		return p.table(content, meta)
+	}
+	return markupUnsupported(text)
+}
+
+// blockMacro renders a block macro line such as image::file.png[Alt].
+func (p *adocParser) blockMacro(line string, meta *adocBlockMeta) string {
+	m := adocBlockMacroRe.FindStringSubmatch(line)
+	name, target, args := m[1], m[2], m[3]
+	switch name {
+	case "image":
+		var am adocBlockMeta
+		parseBlockAttrs(args, &am)
+		alt := ""
+		if len(am.pos) > 0 {
+			alt = am.pos[0]
+		}
+		if alt == "" {
+			alt = am.named["alt"]
+		}
+		width := am.named["width"]
+		if width == "" && len(am.pos) > 1 {
+			width = am.pos[1]
+		}
+		img := markupImage(p.subAttrs(target), alt, width)
+		if meta.title != "" {
+			return "<figure>" + img + "<figcaption>" + p.inline(meta.title) + "</figcaption></figure>\n"
+		}
+		return "<p>" + img + "</p>\n"
+	case "toc":
+		if p.tocDone {
+			return ""
+		}
+		p.tocDone = true
+		return adocTOCMarker
+	}
+	return markupUnsupported(line)
+}
+
+// toc renders the table of contents for the :toc: attribute or toc::[].
+func (p *adocParser) toc() string {
+	if len(p.headings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="toc"><ul>`)
+	for _, h := range p.headings {
+		b.WriteString(`<li class="toc-level-` + strconv.Itoa(h.level-1) + `"><a href="#` + htmlAttrEscape(h.id) + `">` +
+			p.inline(h.text) + "</a></li>")
+	}
+	b.WriteString("</ul></nav>\n")
+	return b.String()
+}
+
+// adocItem is one list item gathered by list before nesting is resolved.
+type adocItem struct {
+	kind   string // "ul", "ol" or "dl"
+	marker string
+	term   string
+	lines  []string
+	blocks []string // attached via "+" list continuation
+}
+
+// list gathers the list starting at lines[i] and renders it, returning the
+// index of the first line after the list. Nesting follows AsciiDoc's rule:
+// a marker not used by an enclosing list starts a nested list.
+func (p *adocParser) list(lines []string, i int) (string, int) {
+	var items []adocItem
+	for i < len(lines) {
+		line := lines[i]
+		if m := adocListRe.FindStringSubmatch(line); m != nil {
+			kind := "ul"
+			marker := m[1]
+			if marker[0] == '.' || (marker[0] >= '0' && marker[0] <= '9') {
+				kind = "ol"
+				if marker[0] != '.' {
+					marker = "1."
+				}
+			}
+			items = append(items, adocItem{kind: kind, marker: marker, lines: []string{m[2]}})
+			i++
+		} else if m := adocDlistRe.FindStringSubmatch(line); m != nil && !strings.HasPrefix(line, " ") {
+			it := adocItem{kind: "dl", marker: m[2], term: m[1]}
+			if m[3] != "" {
+				it.lines = []string{m[3]}
+			}
+			items = append(items, it)
+			i++
+		} else if len(items) > 0 && line == "+" {
+			// List continuation: attach the next block to the current item.
+			i++
+			start := i
+			if i < len(lines) && adocDelimiter(lines[i]) != "" {
+				i = adocDelimitedEnd(lines, i) + 1
+			} else {
+				for i < len(lines) && !isBlank(lines[i]) && lines[i] != "+" && !adocListRe.MatchString(lines[i]) {
+					i++
+				}
+			}
+			if i > len(lines) {
+				i = len(lines)
+			}
+			last := &items[len(items)-1]
+			last.blocks = append(last.blocks, p.blocks(lines[start:i]))
+		} else if strings.HasPrefix(line, "//") {
+			// A line comment ends the list; it is the usual way to
+			// separate two adjacent lists.
+			break
+		} else if len(items) > 0 && !isBlank(line) && adocDelimiter(line) == "" && !adocBlockAttrRe.MatchString(line) {
+			// Continuation of the item's text.
+			last := &items[len(items)-1]
+			last.lines = append(last.lines, strings.TrimSpace(line))
+			i++
+		} else if isBlank(line) {
+			// Blank lines may separate items of the same list.
+			j := i
+			for j < len(lines) && isBlank(lines[j]) {
+				j++
+			}
+			if j < len(lines) && (adocListRe.MatchString(lines[j]) ||
+				(adocDlistRe.MatchString(lines[j]) && !strings.HasPrefix(lines[j], " "))) {
+				i = j
+				continue
+			}
+			if j < len(lines) && strings.HasPrefix(lines[j], " ") && len(items) > 0 && items[len(items)-1].kind == "dl" {
+				// An indented definition under a term.
+				end := j
+				for end < len(lines) && !isBlank(lines[end]) {
+					end++
+				}
+				last := &items[len(items)-1]
+				for _, l := range lines[j:end] {
+					last.lines = append(last.lines, strings.TrimSpace(l))
+				}
+				i = end
+				continue
+			}
+			break
+		} else {
+			break
+		}
+	}
+	html, _ := p.renderItems(items, 0, map[string]bool{})
+	return html, i
+}
+
+// renderItems renders items[k:] that share the marker of items[k], recursing
+// into nested lists, and returns the index of the first item not consumed.
+func (p *adocParser) renderItems(items []adocItem, k int, outer map[string]bool) (string, int) {
+	marker, kind := items[k].marker, items[k].kind
+	inner := make(map[string]bool, len(outer)+1)
+	for m := range outer {
+		inner[m] = true
+	}
+	inner[marker] = true
+
+	var b strings.Builder
+	checklist := kind == "ul" && strings.HasPrefix(items[k].lines[0], "[")
+	if checklist {
+		b.WriteString(`<ul class="contains-task-list">` + "\n")
+	} else {
+		b.WriteString("<" + kind + ">\n")
+	}
+	for k < len(items) && items[k].marker == marker {
+		it := items[k]
+		text := strings.Join(it.lines, "\n")
+		if kind == "dl" {
+			b.WriteString("<dt>" + p.inline(it.term) + "</dt>\n<dd>")
+		} else {
+			b.WriteString("<li>")
+		}
+		if kind == "ul" {
+			switch {
+			case strings.HasPrefix(text, "[x] ") || strings.HasPrefix(text, "[*] "):
+				text = "☑ " + text[4:]
+			case strings.HasPrefix(text, "[ ] "):
+				text = "☐ " + text[4:]
+			}
+		}
+		b.WriteString(p.inline(text))
+		for _, blk := range it.blocks {
+			b.WriteString("\n" + blk)
+		}
+		k++
+		for k < len(items) && !inner[items[k].marker] {
+			var nested string
+			nested, k = p.renderItems(items, k, inner)
+			b.WriteString("\n" + nested)
+		}
+		if kind == "dl" {
+			b.WriteString("</dd>\n")
+		} else {
+			b.WriteString("</li>\n")
+		}
+	}
+	b.WriteString("</" + kind + ">\n")
+	return b.String(), k
+}
+
+// adocCellSpecRe matches a cell specifier (span, alignment, style) that
+// precedes a cell separator, e.g. "2+" or "a" in "2+|" / "a|".
+var adocCellSpecRe = regexp.MustCompile(`(?:^|\s)(\d*(?:\.\d+)?[+*]?[<^>]?(?:\.[<^>])?[adehlmsv]?)$`)
+
+// table renders a |=== table. The column count comes from the cols
+// attribute or from the number of cells on the first line; a blank line
+// after a single-line first row marks it as the header, as does the header
+// option. Row and column spans are not reconstructed.
+func (p *adocParser) table(content []string, meta *adocBlockMeta) string {
+	var cells []string
+	var styles []string
+	firstLineCells := 0
+	implicitHeader := false
+	lineNo := 0
+	for k, l := range content {
+		if isBlank(l) {
+			continue
+		}
+		parts := strings.Split(l, "|")
+		if len(parts) == 1 || !strings.Contains(l, "|") {
+			// Continuation of the previous cell.
+			if len(cells) > 0 {
+				cells[len(cells)-1] += "\n" + l
+			}
+			continue
+		}
+		// Text before the first separator continues the previous cell,
+		// except for a cell spec like "2+" or "a".
+		lead := parts[0]
+		spec := ""
+		if sm := adocCellSpecRe.FindStringSubmatch(lead); sm != nil && sm[1] != "" {
+			spec = sm[1]
+			lead = strings.TrimSuffix(lead, sm[1])
+		}
+		if strings.TrimSpace(lead) != "" && len(cells) > 0 {
+			cells[len(cells)-1] += "\n" + lead
+		}
+		for n, c := range parts[1:] {
+			cellSpec := spec
+			if n+2 < len(parts) {
+				// This cell's text may end with the next cell's spec.
+				if sm := adocCellSpecRe.FindStringSubmatch(c); sm != nil && sm[1] != "" {
+					spec = sm[1]
+					c = strings.TrimSuffix(c, sm[1])
+				} else {
+					spec = ""
+				}
+			}
+			cells = append(cells, strings.TrimSpace(c))
+			styles = append(styles, cellSpec)
+		}
+		if lineNo == 0 {
+			firstLineCells = len(parts) - 1
+			implicitHeader = k+1 < len(content) && isBlank(content[k+1])
+		}
+		lineNo++
+	}
+
+	cols := firstLineCells
+	if c := meta.named["cols"]; c != "" {
+		c = strings.Trim(c, `"`)
+		if n, err := strconv.Atoi(strings.TrimSuffix(c, "*")); err == nil && !strings.Contains(c, ",") {
+			cols = n
+		} else {
+			cols = len(strings.Split(c, ","))
+		}
+	}
+	if cols <= 0 {
+		return markupUnsupported("|===\n" + strings.Join(content, "\n") + "\n|===")
+	}
+
+	var rows [][]string
+	for k := 0; k < len(cells); k += cols {
+		end := k + cols
+		if end > len(cells) {
+			end = len(cells)
+		}
+		row := make([]string, 0, cols)
+		for n := k; n < end; n++ {
+			if strings.HasSuffix(styles[n], "a") {
+				row = append(row, unwrapParagraph(p.blocks(strings.Split(cells[n], "\n"))))
+			} else {
+				row = append(row, p.inline(cells[n]))
+			}
+		}
+		for len(row) < cols {
+			row = append(row, "")
+		}
+		rows = append(rows, row)
+	}
+
+	headerRows := 0
+	if meta.options["header"] || (implicitHeader && !meta.options["noheader"]) {
+		headerRows = 1
+	}
+	return markupTable(p.inline(meta.title), rows, headerRows)
+}
+
+// ---------------------------------------------------------------------------
+// Inline markup
+// ---------------------------------------------------------------------------
+
+var adocInlineRe = regexp.MustCompile(
+	"`\\+(.+?)\\+`" + // 1: literal monospace
+		"|`([^`\\s](?:[^`]*[^`\\s])?)`" + // 2: monospace
+		"|pass:\\[(.*?)\\]" + // 3: passthrough
+		"|((?:https?|ftp|irc|mailto):[^\\s\\[\\]<>\"]+|link:[^\\s\\[]+)\\[([^\\]]*)\\]" + // 4, 5: link macro
+		"|(https?://[^\\s<>\"\\[\\]]*[^\\s<>\"\\[\\].,;:!?)'])" + // 6: bare URL
+		"|<<([^,>]+)(?:,\\s*([^>]+))?>>" + // 7, 8: cross reference
+		"|xref:([^\\[\\s]+)\\[([^\\]]*)\\]" + // 9, 10: xref macro
+		"|image:([^\\s:\\[][^\\s\\[]*)\\[([^\\]]*)\\]" + // 11, 12: inline image
+		"|footnote:\\[([^\\]]*)\\]" + // 13: footnote
+		"|kbd:\\[([^\\]]*)\\]" + // 14: keyboard
+		"|btn:\\[([^\\]]*)\\]" + // 15: button
+		"|\\*\\*(.+?)\\*\\*" + // 16: unconstrained strong
+		"|__(.+?)__" + // 17: unconstrained emphasis
+		"|##(.+?)##" + // 18: unconstrained mark
+		"|\\*([^*\\s](?:[^*]*[^*\\s])?)\\*" + // 19: strong
+		"|_([^_\\s](?:[^_]*[^_\\s])?)_" + // 20: emphasis
+		"|#([^#\\s](?:[^#]*[^#\\s])?)#" + // 21: mark
+		"|\\^(\\S+?)\\^" + // 22: superscript
+		"|~(\\S+?)~") // 23: subscript
+
+// adocAttrRefRe matches an attribute reference such as {project-name}.
+var adocAttrRefRe = regexp.MustCompile(`\{([\w-]+)\}`)
+
+// adocBuiltinAttrs are the character-replacement attributes predefined by
+// AsciiDoc.
+var adocBuiltinAttrs = map[string]string{
+	"empty": "", "sp": " ", "nbsp": "\u00a0", "zwsp": "\u200b", "wj": "\u2060",
+	"apos": "'", "quot": `"`, "lsquo": "‘", "rsquo": "’", "ldquo": "“", "rdquo": "”",
+	"deg": "°", "plus": "+", "brvbar": "¦", "vbar": "|", "amp": "&", "lt": "<", "gt": ">",
+	"startsb": "[", "endsb": "]", "caret": "^", "asterisk": "*", "tilde": "~",
+	"backslash": `\`, "backtick": "`", "two-colons": "::", "two-semicolons": ";;",
+}
+
+// subAttrs replaces attribute references with their values. Unknown
+// references are left untouched, as AsciiDoc does by default.
+func (p *adocParser) subAttrs(s string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return adocAttrRefRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := ref[1 : len(ref)-1]
+		if v, ok := p.attrs[name]; ok {
+			return v
+		}
+		if v, ok := adocBuiltinAttrs[name]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// isWordByte reports whether c is an ASCII letter, digit or underscore.
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// inline renders AsciiDoc inline markup in text, escaping everything else.
+func (p *adocParser) inline(text string) string {
+	text = p.subAttrs(text)
+	var b strings.Builder
+	last := 0
+	for _, m := range adocInlineRe.FindAllStringSubmatchIndex(text, -1) {
+		g := func(n int) string {
+			if m[2*n] < 0 {
+				return ""
+			}
+			return text[m[2*n]:m[2*n+1]]
+		}
+		has := func(n int) bool { return m[2*n] >= 0 }
+
+		// Constrained formatting only applies at word boundaries, so
+		// snake_case_names and a*b*c are left alone.
+		if has(19) || has(20) || has(21) {
+			if (m[0] > 0 && isWordByte(text[m[0]-1])) || (m[1] < len(text) && isWordByte(text[m[1]])) {
+				continue
+			}
+		}
+
+		b.WriteString(template.HTMLEscapeString(text[last:m[0]]))
+		last = m[1]
+
+		switch {
+		case has(1):
+			b.WriteString("<code>" + template.HTMLEscapeString(g(1)) + "</code>")
+		case has(2):
+			b.WriteString("<code>" + p.inline(g(2)) + "</code>")
+		case has(3):
+			b.WriteString(template.HTMLEscapeString(g(3)))
+		case has(4):
+			url := strings.TrimPrefix(g(4), "link:")
+			label := g(5)
+			if k := strings.Index(label, ","); k >= 0 && strings.Contains(label[k:], "=") {
+				label = label[:k] // drop window=_blank and similar
+			}
+			label = strings.Trim(label, `"`)
+			if label == "" {
+				label = template.HTMLEscapeString(strings.TrimPrefix(url, "mailto:"))
+			} else {
+				label = p.inline(label)
+			}
+			b.WriteString(`<a href="` + htmlAttrEscape(url) + `">` + label + "</a>")
+		case has(6):
+			b.WriteString(`<a href="` + htmlAttrEscape(g(6)) + `">` + template.HTMLEscapeString(g(6)) + "</a>")
+		case has(7):
+			label := g(8)
+			if label == "" {
+				label = g(7)
+			}
+			b.WriteString(`<a href="#` + htmlAttrEscape(g(7)) + `">` + p.inline(label) + "</a>")
+		case has(9):
+			target := g(9)
+			label := g(10)
+			if label == "" {
+				label = target
+			}
+			href := target
+			if !strings.Contains(target, "#") && !strings.Contains(target, ".") {
+				href = "#" + target
+			}
+			b.WriteString(`<a href="` + htmlAttrEscape(href) + `">` + p.inline(label) + "</a>")
+		case has(11):
+			var am adocBlockMeta
+			parseBlockAttrs(g(12), &am)
+			alt := ""
+			if len(am.pos) > 0 {
+				alt = am.pos[0]
+			}
+			b.WriteString(markupImage(g(11), alt, am.named["width"]))
+		case has(13):
+			b.WriteString(`<sup class="footnote">[` + p.inline(g(13)) + "]</sup>")
+		case has(14):
+			b.WriteString("<kbd>" + template.HTMLEscapeString(g(14)) + "</kbd>")
+		case has(15):
+			b.WriteString("<b>[" + template.HTMLEscapeString(g(15)) + "]</b>")
+		case has(16):
+			b.WriteString("<strong>" + p.inline(g(16)) + "</strong>")
+		case has(17):
+			b.WriteString("<em>" + p.inline(g(17)) + "</em>")
+		case has(18):
+			b.WriteString("<mark>" + p.inline(g(18)) + "</mark>")
+		case has(19):
+			b.WriteString("<strong>" + p.inline(g(19)) + "</strong>")
+		case has(20):
+			b.WriteString("<em>" + p.inline(g(20)) + "</em>")
+		case has(21):
+			b.WriteString("<mark>" + p.inline(g(21)) + "</mark>")
+		case has(22):
+			b.WriteString("<sup>" + template.HTMLEscapeString(g(22)) + "</sup>")
+		case has(23):
+			b.WriteString("<sub>" + template.HTMLEscapeString(g(23)) + "</sub>")
+		}
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return b.String()
+}
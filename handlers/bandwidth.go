@@ -7,8 +7,13 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"gileserver/models"
 
 	"golang.org/x/time/rate"
 )
@@ -24,80 +29,519 @@ import (
 // When an IP's last active transfer finishes the share is released and the
 // remaining IPs each receive a larger slice. Rebalancing is synchronous and
 // happens on every connect/disconnect event.
+//
+// Shares can be weighted. Each transfer has a weight derived from its route
+// (zip, download, view, tail), the root it reads from and the identity a
+// trusted proxy authenticated, and an IP's weight is the largest among its
+// active transfers. The cap is divided in proportion to those weights.
+//
+// Optional per-IP and per-connection ceilings apply on top of the fair share:
+// an IP never receives more than the per-IP cap even when it is the only
+// downloader, and each individual transfer is additionally held to the
+// per-connection cap. Share that a capped IP cannot use is redistributed
+// among the others by weight.
+//
+// The server-wide cap may follow a schedule. At each boundary the new cap is
+// applied to the existing limiters, so active transfers simply speed up or
+// slow down.
+//
+// The same fair-share model, with caps of its own, applies to request bodies
+// (uploads) through WrapBody.
 type BandwidthManager struct {
-	mu      sync.Mutex
-	limitBps float64            // total cap in bytes/sec (0 = unlimited)
-	peers   map[string]*ipState // keyed by remote IP
+	mu     sync.Mutex
+	limits BandwidthLimits
+	// egress holds response transfers and ingress request bodies; each has
+	// its own caps and peers.
+	egress, ingress *bandwidthPool
+	lastID          uint64 // of the most recent transfer; see transfers.go
+	following       bool   // a followSchedule goroutine is running
+
+	// quotaPage renders the 429 page for clients over quota; see SetQuotaPage.
+	quotaPage func(http.ResponseWriter, *models.QuotaPage) error
+}
+
+// BandwidthLimits are the caps enforced by a BandwidthManager, in bytes per
+// second. A zero field means that kind of limit is disabled.
+type BandwidthLimits struct {
+	Total   float64 // server-wide cap, split evenly across client IPs
+	PerIP   float64 // ceiling on any single IP's share
+	PerConn float64 // ceiling on any single transfer
+	// Schedule, when set, replaces Total with a cap that changes over time.
+	Schedule BandwidthSchedule
+	// Weights gives transfers a larger or smaller part of the cap. Keys are
+	// "route:<name>" (zip, download, view or tail), "root:<name>",
+	// "user:<name>" and "group:<name>"; unlisted classes weigh 1. A
+	// transfer's weight is its route weight times its root weight times
+	// the largest weight of its user and groups.
+	Weights map[string]float64
+	// DailyQuota and MonthlyQuota cap the bytes one client may download per
+	// calendar day and month; see quota.go. 0 disables the quota.
+	DailyQuota, MonthlyQuota int64
+	// MaxTransfers caps the simultaneous transfers of one client IP, other
+	// than streams on uncappedRoutes. Further requests are refused with 429
+	// until one finishes. 0 = no cap.
+	MaxTransfers int
+	// Ingress caps request bodies. It is independent of the caps above and
+	// of the schedule; Weights apply to both.
+	Ingress IngressLimits
+	// Track follows every transfer for the admin dashboard, even when no
+	// limit is set.
+	Track bool
+}
+
+// IngressLimits are the caps on request bodies, in bytes per second. A zero
+// field means that kind of limit is disabled.
+type IngressLimits struct {
+	Total   float64 // server-wide cap, split fairly across client IPs
+	PerIP   float64 // ceiling on any single IP's share
+	PerConn float64 // ceiling on any single request body
+}
+
+// BandwidthSchedule is a server-wide cap that changes with the time of day.
+// It is implemented by config.BandwidthSchedule.
+type BandwidthSchedule interface {
+	// Limit returns the cap at t in bytes per second (0 = unlimited).
+	Limit(t time.Time) float64
+	// Next returns when the cap next changes after t and the cap from then
+	// on. It returns the zero time when the cap never changes.
+	Next(t time.Time) (time.Time, float64)
+	// String returns the schedule as configured.
+	String() string
+}
+
+// enabled reports whether responses need to pass through the manager.
+func (l BandwidthLimits) enabled() bool {
+	return l.Total > 0 || l.PerIP > 0 || l.PerConn > 0 || l.Schedule != nil ||
+		l.DailyQuota > 0 || l.MonthlyQuota > 0 || l.MaxTransfers > 0 || l.Track
+}
+
+// enabled reports whether any limit on request bodies is set.
+func (l IngressLimits) enabled() bool {
+	return l.Total > 0 || l.PerIP > 0 || l.PerConn > 0
+}
+
+// bandwidthPool is the fair-share state of one direction of traffic: its caps
+// and the client IPs with transfers in progress. Its fields are guarded by
+// the owning BandwidthManager's mutex.
+type bandwidthPool struct {
+	kind                  string              // "download" or "upload", for the log
+	total, perIP, perConn float64             // bytes per second, 0 = unlimited
+	maxTransfers          int                 // per IP, 0 = no cap
+	peers                 map[string]*ipState // keyed by remote IP
+	throttles             map[string]throttle // temporary per-IP caps set by an admin
 }
 
 type ipState struct {
-	limiter *rate.Limiter
-	refs    int // number of active transfers from this IP
+	limiter   *rate.Limiter
+	transfers map[*transfer]struct{} // active transfers from this IP
+	alloc     float64                // current allocation, 0 = unlimited
+}
+
+// transfer is one rate-limited response or request body in progress.
+type transfer struct {
+	id     uint64
+	path   string
+	weight float64
+	start  time.Time
+	// uncapped marks a long-lived stream that does not count against
+	// MaxTransfers; see uncappedRoutes.
+	uncapped bool
+	// cancel aborts the transfer; aborted records that an admin did so.
+	cancel  context.CancelFunc
+	aborted atomic.Bool
+	// conn is the transfer's own limiter, nil when no per-connection cap was
+	// set when it started.
+	conn *rate.Limiter
+
+	// bytes counts what has been sent or received so far. The rate is
+	// sampled from it about once a second; see record.
+	bytes    atomic.Int64
+	sampleMu sync.Mutex
+	sampleAt time.Time
+	sampleN  int64
+	rate     float64
+}
+
+// weight returns the largest weight among the IP's active transfers.
+func (st *ipState) weight() float64 {
+	w := 0.0
+	for t := range st.transfers {
+		if t.weight > w {
+			w = t.weight
+		}
+	}
+	return w
+}
+
+// NewBandwidthManager creates a manager enforcing the given limits. Pass the
+// zero BandwidthLimits to disable rate limiting entirely.
+func NewBandwidthManager(limits BandwidthLimits) *BandwidthManager {
+	bm := &BandwidthManager{
+		egress: &bandwidthPool{
+			kind:      "download",
+			peers:     make(map[string]*ipState),
+			throttles: make(map[string]throttle),
+		},
+		ingress: &bandwidthPool{
+			kind:      "upload",
+			peers:     make(map[string]*ipState),
+			throttles: make(map[string]throttle),
+		},
+	}
+	bm.setLimitsLocked(limits)
+	return bm
+}
+
+// SetLimits replaces the manager's limits, for a configuration reload.
+// Transfers in progress carry on under the new caps; a per-connection cap
+// that was not set when a transfer started does not apply to it. Wrap and
+// WrapBody decide once whether to track a handler at all, so the routes must
+// be wrapped again after the call.
+func (bm *BandwidthManager) SetLimits(limits BandwidthLimits) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	bm.setLimitsLocked(limits)
+	for _, p := range []*bandwidthPool{bm.egress, bm.ingress} {
+		for _, st := range p.peers {
+			for t := range st.transfers {
+				if t.conn != nil {
+					lim := rate.Inf
+					if p.perConn > 0 {
+						lim = rate.Limit(p.perConn)
+					}
+					t.conn.SetLimit(lim)
+				}
+			}
+		}
+		p.rebalanceLocked()
+	}
+}
+
+// setLimitsLocked installs limits in the manager and its pools, and starts
+// following the schedule if there is one. Must be called with bm.mu held.
+func (bm *BandwidthManager) setLimitsLocked(limits BandwidthLimits) {
+	bm.limits = limits
+	e, in := bm.egress, bm.ingress
+	e.total, e.perIP, e.perConn, e.maxTransfers = limits.Total, limits.PerIP, limits.PerConn, limits.MaxTransfers
+	in.total, in.perIP, in.perConn = limits.Ingress.Total, limits.Ingress.PerIP, limits.Ingress.PerConn
+	if limits.Schedule != nil {
+		e.total = limits.Schedule.Limit(time.Now())
+		if !bm.following {
+			bm.following = true
+			go bm.followSchedule()
+		}
+	}
 }
 
-// NewBandwidthManager creates a manager with the given total cap in bytes per
-// second. Pass 0 to disable rate limiting entirely.
-func NewBandwidthManager(bytesPerSec float64) *BandwidthManager {
-	return &BandwidthManager{
-		limitBps: bytesPerSec,
-		peers:    make(map[string]*ipState),
+// currentLimits returns the limits in force.
+func (bm *BandwidthManager) currentLimits() BandwidthLimits {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	return bm.limits
+}
+
+// followSchedule applies the scheduled cap at each of its boundaries. It
+// never sleeps for more than a minute at a time, so a jump of the wall clock
+// (NTP correction, resume from suspend) is picked up promptly. It returns
+// once a reload has removed the schedule.
+func (bm *BandwidthManager) followSchedule() {
+	for {
+		bm.mu.Lock()
+		schedule := bm.limits.Schedule
+		if schedule == nil {
+			bm.following = false
+			bm.mu.Unlock()
+			return
+		}
+		bm.mu.Unlock()
+
+		wait := time.Minute
+		if next, _ := schedule.Next(time.Now()); !next.IsZero() {
+			if d := time.Until(next); d < wait {
+				wait = d
+			}
+		}
+		time.Sleep(wait)
+		bm.applySchedule(time.Now())
 	}
 }
 
-// join registers a new transfer for the given IP and returns its limiter.
-// It rebalances every existing IP's share to account for the new participant.
-func (bm *BandwidthManager) join(ip, file string) *rate.Limiter {
+// applySchedule sets the server-wide cap to the scheduled value at now and
+// rebalances the active peers when it has changed.
+func (bm *BandwidthManager) applySchedule(now time.Time) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	if bm.limits.Schedule == nil {
+		return
+	}
+	limit := bm.limits.Schedule.Limit(now)
+	if limit == bm.egress.total {
+		return
+	}
+	bm.egress.total = limit
+	log.Printf("bandwidth schedule  limit=%s", formatLimit(limit))
+	bm.egress.rebalanceLocked()
+}
+
+// Status reports the limits in force and the current allocation.
+func (bm *BandwidthManager) Status() models.BandwidthStatus {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	st, exists := bm.peers[ip]
+	st := bm.egress.statusLocked()
+	st.MaxTransfers = bm.limits.MaxTransfers
+	st.Rejections = GetRejections()
+	if bm.limits.Schedule != nil {
+		st.Schedule = bm.limits.Schedule.String()
+		if next, limit := bm.limits.Schedule.Next(time.Now()); !next.IsZero() {
+			st.NextChange = &next
+			st.NextLimit = formatLimit(limit)
+		}
+	}
+	if bm.limits.Ingress.enabled() {
+		in := bm.ingress.statusLocked()
+		st.Ingress = &in
+	}
+	return st
+}
+
+// statusLocked reports the pool's caps and current allocation. Must be called
+// with the manager's mutex held.
+func (p *bandwidthPool) statusLocked() models.BandwidthStatus {
+	st := models.BandwidthStatus{
+		Limit:     p.total,
+		LimitText: formatLimit(p.total),
+		PerIP:     p.perIP,
+		PerConn:   p.perConn,
+		ActiveIPs: len(p.peers),
+	}
+	for ip, peer := range p.peers {
+		st.Transfers += len(peer.transfers)
+		st.Peers = append(st.Peers, models.BandwidthPeer{
+			IP:        ip,
+			Transfers: len(peer.transfers),
+			Weight:    peer.weight(),
+			Alloc:     peer.alloc,
+			AllocText: formatLimit(peer.alloc),
+		})
+	}
+	sort.Slice(st.Peers, func(i, j int) bool { return st.Peers[i].IP < st.Peers[j].IP })
+	return st
+}
+
+// join registers a new transfer of the given weight for ip in pool p and
+// returns the IP's shared limiter along with a limiter of its own for this
+// transfer (nil when no per-connection cap is set). It rebalances every
+// existing IP's share to account for the new participant. cancel is called
+// when an admin aborts the transfer. When ip already has the maximum number
+// of simultaneous transfers, join registers nothing and returns nil, unless
+// the transfer is uncapped.
+func (bm *BandwidthManager) join(p *bandwidthPool, ip, file string, weight float64, uncapped bool, cancel context.CancelFunc) (*transfer, *rate.Limiter, *rate.Limiter) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	st, exists := p.peers[ip]
+	if exists && !uncapped && p.maxTransfers > 0 && st.cappedTransfers() >= p.maxTransfers {
+		return nil, nil, nil
+	}
 	if !exists {
 		// New IP — create a limiter with a placeholder rate; rebalance will set
 		// the real value right after.
-		st = &ipState{limiter: rate.NewLimiter(1, chunkSize)}
-		bm.peers[ip] = st
+		st = &ipState{
+			limiter:   rate.NewLimiter(1, chunkSize),
+			transfers: make(map[*transfer]struct{}),
+		}
+		p.peers[ip] = st
 	}
-	st.refs++
+	bm.lastID++
+	now := time.Now()
+	t := &transfer{id: bm.lastID, path: file, weight: weight, start: now, uncapped: uncapped, cancel: cancel, sampleAt: now}
+	st.transfers[t] = struct{}{}
 
-	log.Printf("download start  ip=%-15s  streams=%-2d  file=%s", ip, st.refs, file)
-	bm.rebalanceLocked()
-	return st.limiter
+	log.Printf("%-8s start  ip=%-15s  streams=%-2d  file=%s", p.kind, ip, len(st.transfers), file)
+	p.rebalanceLocked()
+
+	if p.perConn > 0 {
+		t.conn = rate.NewLimiter(rate.Limit(p.perConn), chunkSize)
+	}
+	return t, st.limiter, t.conn
+}
+
+// cappedTransfers counts the IP's transfers that count against
+// MaxTransfers.
+func (st *ipState) cappedTransfers() int {
+	n := 0
+	for t := range st.transfers {
+		if !t.uncapped {
+			n++
+		}
+	}
+	return n
 }
 
-// leave decrements the connection count for ip and removes the entry when it
-// reaches zero, then rebalances remaining peers.
-func (bm *BandwidthManager) leave(ip, file string) {
+// leave removes transfer t of ip from pool p, dropping the IP's entry along
+// with its last transfer, then rebalances remaining peers.
+func (bm *BandwidthManager) leave(p *bandwidthPool, ip string, t *transfer) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
 
-	st, ok := bm.peers[ip]
+	st, ok := p.peers[ip]
 	if !ok {
 		return
 	}
-	st.refs--
-	log.Printf("download end    ip=%-15s  streams=%-2d  file=%s", ip, st.refs, file)
-	if st.refs <= 0 {
-		delete(bm.peers, ip)
+	delete(st.transfers, t)
+	log.Printf("%-8s end    ip=%-15s  streams=%-2d  file=%s", p.kind, ip, len(st.transfers), t.path)
+	if len(st.transfers) == 0 {
+		delete(p.peers, ip)
 	}
-	bm.rebalanceLocked()
+	p.rebalanceLocked()
 }
 
-// rebalanceLocked recalculates the per-IP byte rate and applies it to every
-// active limiter. Must be called with bm.mu held.
-func (bm *BandwidthManager) rebalanceLocked() {
-	n := len(bm.peers)
-	if n == 0 || bm.limitBps == 0 {
+// rebalanceLocked recalculates the per-IP byte rates and applies them to
+// every active limiter. Must be called with the manager's mutex held.
+func (p *bandwidthPool) rebalanceLocked() {
+	n := len(p.peers)
+	if n == 0 {
 		return
 	}
-	perIP := bm.limitBps / float64(n)
-	lim := rate.Limit(perIP)
-	for ip, st := range bm.peers {
+	alloc := p.allocateLocked()
+	for ip, st := range p.peers {
+		st.alloc = alloc[ip]
+		lim := rate.Inf
+		if st.alloc > 0 {
+			lim = rate.Limit(st.alloc)
+		}
 		st.limiter.SetLimit(lim)
 		// Burst = one chunk so the limiter is always responsive but never
 		// allows more than ~one write-buffer worth of free data.
 		st.limiter.SetBurst(chunkSize)
-		log.Printf("rate rebalance  ip=%-15s  peers=%-2d  alloc=%s", ip, n, formatBits(perIP))
+		if st.alloc > 0 {
+			log.Printf("rate rebalance  %-8s  ip=%-15s  peers=%-2d  weight=%-4g  alloc=%s", p.kind, ip, n, st.weight(), formatBits(st.alloc))
+		}
+	}
+}
+
+// allocateLocked divides the pool's server-wide cap among the active IPs in
+// proportion to their weights. An IP whose ceiling (the per-IP cap, or the
+// per-connection cap times its transfers) is below its proportional share
+// gets its ceiling, and the remainder is divided again among the rest. An
+// admin throttle lowers an IP's ceiling for as long as it lasts.
+// Allocations are in bytes per second, 0 meaning unlimited. Must be called
+// with the manager's mutex held.
+func (p *bandwidthPool) allocateLocked() map[string]float64 {
+	alloc := make(map[string]float64, len(p.peers))
+	ceiling := func(ip string, st *ipState) float64 {
+		c := p.perIP
+		if p.perConn > 0 {
+			if pc := p.perConn * float64(len(st.transfers)); c == 0 || pc < c {
+				c = pc
+			}
+		}
+		if th, ok := p.throttles[ip]; ok && (c == 0 || th.limit < c) {
+			c = th.limit
+		}
+		return c
+	}
+	if p.total == 0 {
+		for ip, st := range p.peers {
+			alloc[ip] = ceiling(ip, st)
+		}
+		return alloc
 	}
+
+	open := make(map[string]*ipState, len(p.peers))
+	for ip, st := range p.peers {
+		open[ip] = st
+	}
+	remaining := p.total
+	for len(open) > 0 {
+		sum := 0.0
+		for _, st := range open {
+			sum += st.weight()
+		}
+		var capped []string
+		for ip, st := range open {
+			if c := ceiling(ip, st); c > 0 && remaining*st.weight()/sum > c {
+				capped = append(capped, ip)
+			}
+		}
+		if len(capped) == 0 {
+			for ip, st := range open {
+				alloc[ip] = remaining * st.weight() / sum
+			}
+			break
+		}
+		for _, ip := range capped {
+			alloc[ip] = ceiling(ip, open[ip])
+			remaining -= alloc[ip]
+			delete(open, ip)
+		}
+	}
+	return alloc
+}
+
+// transferWeight returns the weight of a request from the configured class
+// weights; see BandwidthLimits.Weights.
+func (bm *BandwidthManager) transferWeight(r *http.Request) float64 {
+	weights := bm.currentLimits().Weights
+	if len(weights) == 0 {
+		return 1
+	}
+	lookup := func(key string) (float64, bool) {
+		w, ok := weights[key]
+		return w, ok
+	}
+	route, root := transferClass(r.URL.Path)
+	w := 1.0
+	if rw, ok := lookup("route:" + route); ok {
+		w *= rw
+	}
+	if rw, ok := lookup("root:" + root); ok {
+		w *= rw
+	}
+	user, groups := proxyIdentity(r)
+	identity, found := 0.0, false
+	if user != "" {
+		if iw, ok := lookup("user:" + user); ok {
+			identity, found = iw, true
+		}
+	}
+	for _, g := range groups {
+		if iw, ok := lookup("group:" + g); ok && (!found || iw > identity) {
+			identity, found = iw, true
+		}
+	}
+	if found {
+		w *= identity
+	}
+	return w
+}
+
+// uncappedRoutes are the routes, as named by transferClass, whose responses
+// are long-lived streams rather than downloads. They share bandwidth like any
+// transfer but do not count against MaxTransfers, so that a few open follow
+// tabs cannot lock a client out of downloads.
+var uncappedRoutes = map[string]bool{"tail": true}
+
+// transferClass splits a rate-limited request path such as
+// /zip/releases/v1 or /api/tail/logs/app.log into its route ("zip", "tail")
+// and root ("releases", "logs").
+func transferClass(urlPath string) (route, root string) {
+	p := strings.TrimPrefix(urlPath, "/")
+	route, rest, _ := strings.Cut(p, "/")
+	if route == "api" {
+		route, rest, _ = strings.Cut(rest, "/")
+	}
+	root, _, _ = strings.Cut(rest, "/")
+	return route, root
+}
+
+// formatLimit is formatBits for a cap where 0 means unlimited.
+func formatLimit(bytesPerSec float64) string {
+	if bytesPerSec <= 0 {
+		return "unlimited"
+	}
+	return formatBits(bytesPerSec)
 }
 
 // formatBits formats a bytes-per-second value as a human-readable bits-per-second
@@ -116,24 +560,67 @@ func formatBits(bytesPerSec float64) string {
 	}
 }
 
-// Wrap returns an http.Handler that applies bandwidth limiting to h for
-// downloads (responses). When the manager has no cap set (0), h is returned
-// unchanged with zero overhead.
+// Wrap returns an http.Handler that applies bandwidth limiting and transfer
+// quotas to h for downloads (responses). When the manager has no limits set,
+// h is returned unchanged with zero overhead.
 func (bm *BandwidthManager) Wrap(h http.Handler) http.Handler {
-	if bm.limitBps == 0 {
+	if !bm.currentLimits().enabled() {
 		return h
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ip := clientIP(r)
-		file := r.URL.Path
-		limiter := bm.join(ip, file)
-		defer bm.leave(ip, file)
 
-		h.ServeHTTP(&limitedResponseWriter{
+		var meter *quotaMeter
+		if bm.quotaEnabled() {
+			key := quotaKey(r, ip)
+			now := time.Now()
+			if q := bm.checkQuota(key, now); q != nil {
+				log.Printf("quota exceeded  client=%s  period=%s  used=%s  file=%s", key, q.period, formatSize(q.used), r.URL.Path)
+				RecordRejection("quota")
+				bm.serveQuotaExceeded(w, q, now)
+				return
+			}
+			meter = &quotaMeter{bm: bm, key: key}
+			defer func() {
+				meter.flush()
+				saveQuotas()
+			}()
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		route, _ := transferClass(r.URL.Path)
+		t, limiter, conn := bm.join(bm.egress, ip, r.URL.Path, bm.transferWeight(r), uncappedRoutes[route], cancel)
+		if t == nil {
+			log.Printf("transfer cap    ip=%-15s  streams=%-2d  file=%s", ip, bm.currentLimits().MaxTransfers, r.URL.Path)
+			RecordRejection("transfers")
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Too many simultaneous downloads, please wait for one to finish", http.StatusTooManyRequests)
+			return
+		}
+		defer bm.leave(bm.egress, ip, t)
+
+		lw := &limitedResponseWriter{
 			ResponseWriter: w,
-			ctx:            r.Context(),
+			ctx:            ctx,
 			limiter:        limiter,
-		}, r)
+			conn:           conn,
+			quota:          meter,
+			transfer:       t,
+		}
+		h.ServeHTTP(lw, r)
+		if t.aborted.Load() {
+			log.Printf("download abort  ip=%-15s  file=%s", ip, r.URL.Path)
+			panic(http.ErrAbortHandler)
+		}
+		if lw.overQuota {
+			// The response has started, so the only way to tell the client
+			// it is incomplete is to cut the connection.
+			log.Printf("quota exceeded  client=%s  aborting transfer  file=%s", meter.key, r.URL.Path)
+			panic(http.ErrAbortHandler)
+		}
 	})
 }
 
@@ -143,11 +630,18 @@ func (bm *BandwidthManager) Wrap(h http.Handler) http.Handler {
 const chunkSize = 32 * 1024
 
 // limitedResponseWriter wraps http.ResponseWriter and throttles Write calls
-// through a token-bucket rate limiter.
+// through the token-bucket rate limiter shared by the client IP and, when a
+// per-connection cap is set, the transfer's own limiter.
 type limitedResponseWriter struct {
 	http.ResponseWriter
 	ctx     context.Context
 	limiter *rate.Limiter
+	conn    *rate.Limiter // nil when there is no per-connection cap
+
+	quota     *quotaMeter // nil when no transfer quota is set
+	overQuota bool        // a write was refused because the quota ran out
+
+	transfer *transfer // the transfer's entry in the manager, for the dashboard
 }
 
 func (lw *limitedResponseWriter) Write(p []byte) (int, error) {
@@ -160,18 +654,34 @@ func (lw *limitedResponseWriter) Write(p []byte) (int, error) {
 		default:
 		}
 
+		if lw.quota != nil && lw.quota.exhausted() {
+			lw.overQuota = true
+			return total, errQuotaExceeded
+		}
+
 		n := len(p)
 		if n > chunkSize {
 			n = chunkSize
 		}
 
-		// Block until the limiter grants tokens for this chunk.
+		// Block until the limiters grant tokens for this chunk. The
+		// connection's own cap is waited on first so that a capped transfer
+		// does not hold tokens its IP's other transfers could use.
+		if lw.conn != nil {
+			if err := lw.conn.WaitN(lw.ctx, n); err != nil {
+				return total, err
+			}
+		}
 		if err := lw.limiter.WaitN(lw.ctx, n); err != nil {
 			return total, err
 		}
 
 		written, err := lw.ResponseWriter.Write(p[:n])
 		total += written
+		lw.transfer.record(written, time.Now())
+		if lw.quota != nil {
+			lw.quota.charge(written)
+		}
 		if err != nil {
 			return total, err
 		}
@@ -215,17 +725,82 @@ func (lw *limitedResponseWriter) Unwrap() http.ResponseWriter {
 	return lw.ResponseWriter
 }
 
+// WrapBody returns h with request bodies throttled to the client IP's fair
+// share of the ingress cap. Requests without a body pass through untouched,
+// as does everything when no ingress limit is configured.
+func (bm *BandwidthManager) WrapBody(h http.Handler) http.Handler {
+	if limits := bm.currentLimits(); !limits.Ingress.enabled() && !limits.Track {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		t, limiter, conn := bm.join(bm.ingress, ip, r.URL.Path, bm.transferWeight(r), false, cancel)
+		defer bm.leave(bm.ingress, ip, t)
+
+		r.Body = &limitedBody{
+			ReadCloser: r.Body,
+			ctx:        ctx,
+			limiter:    limiter,
+			conn:       conn,
+			transfer:   t,
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitedBody wraps a request body and throttles Read calls through the
+// client IP's ingress limiter and, when a per-connection cap is set, the
+// request's own limiter. Tokens are taken after each read, so a client that
+// sends faster than its share is held back by TCP flow control while the
+// handler waits.
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+	conn    *rate.Limiter // nil when there is no per-connection cap
+
+	transfer *transfer
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+	n, err := lb.ReadCloser.Read(p)
+	if n > 0 {
+		lb.transfer.record(n, time.Now())
+		if lb.conn != nil {
+			if werr := lb.conn.WaitN(lb.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+		if werr := lb.limiter.WaitN(lb.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
 // trustedProxy is the parsed CIDR network of a configured reverse proxy.
 // When non-nil, clientIP will trust X-Real-IP / X-Forwarded-For headers from
 // requests whose direct TCP peer falls within this network.
-var trustedProxy *net.IPNet
+var trustedProxy atomic.Pointer[net.IPNet]
 
 // SetTrustedProxy configures the reverse-proxy IP or CIDR that is allowed to
 // supply X-Real-IP / X-Forwarded-For headers. Pass an empty string to disable
-// (default). Must be called before the server starts accepting requests.
+// (default). It is called before the server starts accepting requests and
+// again on every reload.
 func SetTrustedProxy(cidr string) {
 	if cidr == "" {
-		trustedProxy = nil
+		trustedProxy.Store(nil)
 		return
 	}
 	// Accept a bare IP address by converting it to a host CIDR.
@@ -239,9 +814,10 @@ func SetTrustedProxy(cidr string) {
 	_, network, err := net.ParseCIDR(cidr)
 	if err != nil {
 		log.Printf("trusted-proxy: invalid value %q, proxy IP forwarding disabled: %v", cidr, err)
+		trustedProxy.Store(nil)
 		return
 	}
-	trustedProxy = network
+	trustedProxy.Store(network)
 }
 
 // clientIP extracts the real client IP from the request.
@@ -260,9 +836,9 @@ func clientIP(r *http.Request) string {
 		host = r.RemoteAddr
 	}
 
-	if trustedProxy != nil {
+	if proxy := trustedProxy.Load(); proxy != nil {
 		peerIP := net.ParseIP(host)
-		if peerIP != nil && trustedProxy.Contains(peerIP) {
+		if peerIP != nil && proxy.Contains(peerIP) {
 			// X-Real-IP is set by nginx's proxy_set_header X-Real-IP directive.
 			if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
 				if ip := net.ParseIP(xri); ip != nil {
@@ -284,3 +860,30 @@ func clientIP(r *http.Request) string {
 
 	return host
 }
+
+// proxyIdentity returns the user and groups authenticated by the trusted
+// reverse proxy, from the Remote-User and Remote-Groups headers set by
+// forward-auth services such as Authelia and Authentik. Requests that did
+// not come through the trusted proxy have no identity, so the headers cannot
+// be spoofed by clients.
+func proxyIdentity(r *http.Request) (string, []string) {
+	proxy := trustedProxy.Load()
+	if proxy == nil {
+		return "", nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if peerIP := net.ParseIP(host); peerIP == nil || !proxy.Contains(peerIP) {
+		return "", nil
+	}
+	user := strings.TrimSpace(r.Header.Get("Remote-User"))
+	var groups []string
+	for _, g := range strings.Split(r.Header.Get("Remote-Groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return user, groups
+}
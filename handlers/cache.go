@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -78,7 +79,8 @@ func sizeCacheGC() {
 	}
 }
 
-// cachedDirSize returns the cached recursive byte-count for fsPath.
+// cachedDirSize returns the cached recursive byte-count for fsPath, whose
+// exclusion rules are scope.
 //
 //   - Fresh hit        → returned immediately with no I/O.
 //   - Stale hit        → the last known size is returned immediately while one
@@ -88,7 +90,7 @@ func sizeCacheGC() {
 //     the entry, i.e. effectively never for directories the index knows about).
 //   - Concurrent miss  → subsequent callers wait on the condvar rather than
 //     launching duplicate walks.
-func cachedDirSize(fsPath string) int64 {
+func cachedDirSize(scope *ignoreScope, fsPath string) int64 {
 	sizeCache.mu.Lock()
 	e, ok := sizeCache.entries[fsPath]
 
@@ -108,7 +110,7 @@ func cachedDirSize(fsPath string) int64 {
 		sizeCache.mu.Unlock()
 
 		go func() {
-			fresh := dirSize(fsPath)
+			fresh := dirSize(scope, fsPath)
 			sizeCache.mu.Lock()
 			e.size = fresh
 			e.expires = time.Now().Add(safetyTTL)
@@ -146,7 +148,7 @@ func cachedDirSize(fsPath string) int64 {
 	e.computing = true
 	sizeCache.mu.Unlock()
 
-	size := dirSize(fsPath)
+	size := dirSize(scope, fsPath)
 
 	sizeCache.mu.Lock()
 	e.size = size
@@ -170,6 +172,28 @@ func invalidateSizePath(fsPath string) {
 	sizeCache.mu.Unlock()
 }
 
+// invalidateSizes marks every path in the size cache as stale.
+func invalidateSizes() {
+	sizeCache.mu.Lock()
+	for _, e := range sizeCache.entries {
+		e.stale = true
+	}
+	sizeCache.mu.Unlock()
+}
+
+// invalidateSizeTree marks fsDir and every path below it as stale in the
+// size cache.
+func invalidateSizeTree(fsDir string) {
+	prefix := filepath.Clean(fsDir) + string(filepath.Separator)
+	sizeCache.mu.Lock()
+	for p, e := range sizeCache.entries {
+		if p == filepath.Clean(fsDir) || strings.HasPrefix(p, prefix) {
+			e.stale = true
+		}
+	}
+	sizeCache.mu.Unlock()
+}
+
 // evictSizePath removes a path from the size cache entirely. Use this when a
 // directory is known to have been deleted or renamed so the entry does not
 // linger in memory indefinitely.
@@ -193,13 +217,54 @@ func evictSizePath(fsPath string) {
 // during a rebuild is: slice + raw JSON (momentary) + gzip output (retained).
 // The retained blob is typically 5-10x smaller than the raw JSON it replaces,
 // directly reducing the steady-state memory footprint of the cache.
+//
+// Clients that may only use some of the roots get an index of just those, so
+// there is one cached index per set of roots, keyed by rootKey.
 var indexCache struct {
-	mu         sync.Mutex
+	mu   sync.Mutex
+	sets map[string]*indexSet
+}
+
+// indexSet is the cached search index of one set of roots.
+type indexSet struct {
 	gzJSON     []byte // gzip-compressed JSON; nil until first build
 	expires    time.Time
 	refreshing bool
 }
 
+// indexSetLocked returns the cache entry for roots, creating it if needed.
+// indexCache.mu must be held.
+func indexSetLocked(roots map[string]string) *indexSet {
+	key := rootKey(roots)
+	set, ok := indexCache.sets[key]
+	if !ok {
+		if indexCache.sets == nil {
+			indexCache.sets = make(map[string]*indexSet)
+		}
+		set = &indexSet{}
+		indexCache.sets[key] = set
+	}
+	return set
+}
+
+// store caches fresh as the set's index. A set dropped by clearIndex in the
+// meantime is no longer in indexCache.sets, so the stale build is discarded
+// with it.
+func (set *indexSet) store(fresh []byte) {
+	indexCache.mu.Lock()
+	set.gzJSON = fresh
+	set.expires = time.Now().Add(safetyTTL)
+	indexCache.mu.Unlock()
+}
+
+// storeIndex caches fresh as the index of roots.
+func storeIndex(roots map[string]string, fresh []byte) {
+	indexCache.mu.Lock()
+	set := indexSetLocked(roots)
+	indexCache.mu.Unlock()
+	set.store(fresh)
+}
+
 // serializeIndex JSON-encodes a FileIndex, gzip-compresses the result, and
 // returns the compressed bytes. The FileIndex itself is not retained after
 // this call. Using BestSpeed keeps the compression fast at build time while
@@ -231,52 +296,56 @@ func serializeIndex(idx *models.FileIndex) []byte {
 //     single background goroutine to refresh; callers never block on a walk.
 func cachedIndexGzip(roots map[string]string) []byte {
 	indexCache.mu.Lock()
-	data := indexCache.gzJSON
-	expired := time.Now().After(indexCache.expires)
-	refreshing := indexCache.refreshing
+	set := indexSetLocked(roots)
+	data := set.gzJSON
+	expired := time.Now().After(set.expires)
+	refreshing := set.refreshing
+	if data != nil && expired && !refreshing {
+		set.refreshing = true
+	}
 	indexCache.mu.Unlock()
 
 	if data == nil {
 		// First request ever: build synchronously so we never return nil.
 		fresh := serializeIndex(buildIndex(roots))
-		indexCache.mu.Lock()
-		indexCache.gzJSON = fresh
-		indexCache.expires = time.Now().Add(safetyTTL)
-		indexCache.mu.Unlock()
+		set.store(fresh)
 		return fresh
 	}
 
 	if expired && !refreshing {
-		indexCache.mu.Lock()
-		indexCache.refreshing = true
-		indexCache.mu.Unlock()
-
 		go func() {
 			defer func() {
 				if r := recover(); r != nil {
 					log.Printf("cache: index refresh panic: %v", r)
 				}
 				indexCache.mu.Lock()
-				indexCache.refreshing = false
+				set.refreshing = false
 				indexCache.mu.Unlock()
 			}()
 
-			fresh := serializeIndex(buildIndex(roots))
-			indexCache.mu.Lock()
-			indexCache.gzJSON = fresh
-			indexCache.expires = time.Now().Add(safetyTTL)
-			indexCache.mu.Unlock()
+			set.store(serializeIndex(buildIndex(roots)))
 		}()
 	}
 
 	return data
 }
 
-// invalidateIndex marks the index as expired so the next call to cachedIndexJSON
-// triggers a background rebuild.  Any in-flight refresh is left to complete.
+// invalidateIndex marks every cached index as expired so the next call to
+// cachedIndexGzip triggers a background rebuild.  Any in-flight refresh is
+// left to complete.
 func invalidateIndex() {
 	indexCache.mu.Lock()
-	indexCache.expires = time.Time{} // zero time is always in the past
+	for _, set := range indexCache.sets {
+		set.expires = time.Time{} // zero time is always in the past
+	}
+	indexCache.mu.Unlock()
+}
+
+// clearIndex drops every cached index, so the next request for each set of
+// roots builds it afresh.
+func clearIndex() {
+	indexCache.mu.Lock()
+	indexCache.sets = nil
 	indexCache.mu.Unlock()
 }
 
@@ -302,15 +371,25 @@ func invalidateIndex() {
 //     adding each directory's subtotal to its parent.
 //
 // Result: O(n) walk work, one goroutine, peak RAM proportional to the number
-// of directories (one int64 per directory in the local map).
-func buildSizeIndex(root string) map[string]int64 {
+// of directories (one int64 and one exclusion scope per directory in local
+// maps). Excluded files and directories, per scope, the scope of root, are
+// skipped.
+func buildSizeIndex(scope *ignoreScope, root string) map[string]int64 {
 	sizes := make(map[string]int64)
+	scopes := map[string]*ignoreScope{root: scope}
 
 	// Seed the root so it always appears in the map even if empty.
 	sizes[root] = 0
 
 	filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
-		if err != nil {
+		if err != nil || p == root {
+			return nil
+		}
+		parent := scopes[filepath.Dir(p)]
+		if parent.excluded(d.Name(), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
@@ -319,6 +398,7 @@ func buildSizeIndex(root string) map[string]int64 {
 			if _, ok := sizes[p]; !ok {
 				sizes[p] = 0
 			}
+			scopes[p] = parent.child(d.Name(), p)
 			return nil
 		}
 
@@ -366,19 +446,15 @@ func WarmCache(roots map[string]string) {
 
 		// Build the search index — the single most expensive walk.
 		// Serialise and compress immediately so the []IndexEntry slice can be GC'd.
-		fresh := serializeIndex(buildIndex(roots))
-		indexCache.mu.Lock()
-		indexCache.gzJSON = fresh
-		indexCache.expires = time.Now().Add(safetyTTL)
-		indexCache.mu.Unlock()
+		storeIndex(roots, serializeIndex(buildIndex(roots)))
 
 		// Pre-populate the size cache with a single bottom-up walk per root.
 		// buildSizeIndex is O(n) in the number of filesystem entries; all results
 		// are bulk-inserted into the cache under one lock acquisition per root,
 		// bypassing the cachedDirSize hot path entirely.
 		expiry := time.Now().Add(safetyTTL)
-		for _, fsRoot := range roots {
-			sizeIndex := buildSizeIndex(fsRoot)
+		for name, fsRoot := range roots {
+			sizeIndex := buildSizeIndex(newIgnoreScope(rootSettings(name), fsRoot), fsRoot)
 			sizeCache.mu.Lock()
 			for p, sz := range sizeIndex {
 				sizeCache.entries[p] = &sizeEntry{
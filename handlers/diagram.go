@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Graphviz DOT diagrams in fenced ```dot blocks (Markdown) and #+BEGIN_SRC
+// dot blocks (Org) are rendered to inline SVG by the local dot binary. When
+// dot is not installed, or fails on a particular graph, the block falls back
+// to ordinary syntax highlighting, so the feature needs no configuration.
+//
+// Mermaid blocks are not rendered: doing so server-side takes mermaid-cli and
+// a headless browser, far more than a preview should start. They are shown
+// as highlighted source like any other fenced code.
+
+const (
+	// maxDiagramSource caps the DOT source handed to the dot process.
+	maxDiagramSource = 256 * 1024
+
+	// maxDiagramOutput caps the SVG read back; larger graphs fall back to
+	// highlighted source rather than bloating the page.
+	maxDiagramOutput = 4 * 1024 * 1024
+
+	// diagramTimeout bounds a single dot invocation. Pathological graphs can
+	// make the layout engines run for a very long time.
+	diagramTimeout = 10 * time.Second
+)
+
+var (
+	dotOnce sync.Once
+	dotPath string // "" when Graphviz is not installed
+
+	// diagramSlots bounds concurrent dot processes so that a burst of
+	// previews cannot fork an unbounded number of layout jobs.
+	diagramSlots = make(chan struct{}, runtime.NumCPU())
+)
+
+var errDiagramTooLarge = errors.New("diagram output too large")
+
+// isDiagramLang reports whether a code block language names Graphviz DOT.
+func isDiagramLang(lang string) bool {
+	switch strings.ToLower(lang) {
+	case "dot", "graphviz", "gv":
+		return true
+	}
+	return false
+}
+
+// lookupDot resolves the dot binary once per process.
+func lookupDot() string {
+	dotOnce.Do(func() {
+		dotPath, _ = exec.LookPath("dot")
+	})
+	return dotPath
+}
+
+// renderDiagram runs source through dot and returns the SVG wrapped in a
+// <div class="diagram">. It returns an empty string when dot is unavailable
+// or fails, so callers can fall back to chromaHighlightBlock.
+//
+// The SVG is not sanitised here; like every other renderer output it is
+// passed through sanitizeHTML by the caller, whose policy admits only the
+// presentational SVG elements and attributes that dot emits.
+func renderDiagram(source string) string {
+	bin := lookupDot()
+	if bin == "" || len(source) > maxDiagramSource {
+		return ""
+	}
+
+	diagramSlots <- struct{}{}
+	defer func() { <-diagramSlots }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), diagramTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, bin, "-Tsvg")
+	cmd.Stdin = strings.NewReader(source)
+	// With SERVER_NAME set, Graphviz refuses to read image and shape files
+	// outside GV_FILE_PATH (which we leave unset), so a document cannot pull
+	// arbitrary files from the server into its diagram.
+	cmd.Env = append(os.Environ(), "SERVER_NAME=gileserver", "GV_FILE_PATH=")
+	out := &cappedBuffer{max: maxDiagramOutput}
+	cmd.Stdout = out
+	if err := cmd.Run(); err != nil {
+		return ""
+	}
+
+	svg := out.String()
+	start := strings.Index(svg, "<svg")
+	if start < 0 {
+		return ""
+	}
+	return `<div class="diagram">` + svg[start:] + "</div>"
+}
+
+// cappedBuffer is a bytes.Buffer that fails writes beyond max bytes, which
+// makes the writing process exit instead of filling memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.max {
+		return 0, errDiagramTooLarge
+	}
+	return b.Buffer.Write(p)
+}
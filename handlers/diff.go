@@ -0,0 +1,503 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+
+	"gileserver/models"
+)
+
+// The /diff page compares two text files line by line with Myers' O(ND)
+// algorithm in its linear-space form, and shows the result as unified or
+// side-by-side hunks with Chroma highlighting.
+
+const (
+	// diffContext is the number of unchanged lines shown around each change.
+	diffContext = 3
+
+	// diffTimeout bounds the search for a minimal diff. Two large, wholly
+	// unrelated files make Myers quadratic; past the deadline the remaining
+	// regions are reported as replaced, which is correct if not minimal.
+	diffTimeout = 2 * time.Second
+)
+
+// DiffHandler serves /diff?a=<url path>&b=<url path>[&mode=split]. With
+// either path missing it shows just the form for choosing the files.
+func DiffHandler(roots map[string]string, siteName, defaultTheme string, opts PreviewOptions, tmpl interface {
+	ExecuteDiff(http.ResponseWriter, *models.DiffPage) error
+}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !opts.Text {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		q := r.URL.Query()
+		a, b := diffPaths(q)
+		pd := &models.DiffPage{
+			Title:        "Compare files",
+			SiteName:     siteName,
+			DefaultTheme: defaultTheme,
+			PathA:        a,
+			PathB:        b,
+			Mode:         "unified",
+		}
+		if q.Get("mode") == "split" {
+			pd.Mode = "split"
+		}
+
+		if pd.PathA != "" && pd.PathB != "" {
+			pd.Title = path.Base(pd.PathA) + " ↔ " + path.Base(pd.PathB)
+			if err := fillDiff(r, pd, roots, opts); err != nil {
+				pd.Error = err.Error()
+			}
+		}
+
+		if err := tmpl.ExecuteDiff(w, pd); err != nil {
+			http.Error(w, "Template error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// diffPaths returns the cleaned a and b paths of a /diff query; a missing
+// path stays empty. RootAccess checks the roots of the same cleaned paths the
+// handler compares.
+func diffPaths(q url.Values) (a, b string) {
+	clean := func(p string) string {
+		if p == "" {
+			return ""
+		}
+		return path.Clean("/" + p)
+	}
+	return clean(q.Get("a")), clean(q.Get("b"))
+}
+
+// fillDiff reads both files of pd and stores their diff in it.
+func fillDiff(r *http.Request, pd *models.DiffPage, roots map[string]string, opts PreviewOptions) error {
+	a, err := readDiffSide(r, roots, pd.PathA, opts)
+	if err != nil {
+		return err
+	}
+	b, err := readDiffSide(r, roots, pd.PathB, opts)
+	if err != nil {
+		return err
+	}
+
+	linesA, linesB := splitLines(a), splitLines(b)
+	ops := diffLines(linesA, linesB)
+	for _, op := range ops {
+		switch op.kind {
+		case diffAdd:
+			pd.Added++
+		case diffDel:
+			pd.Removed++
+		}
+	}
+	if pd.Added == 0 && pd.Removed == 0 {
+		pd.Identical = true
+		return nil
+	}
+
+	htmlA := highlightedLines(a, path.Base(pd.PathA), len(linesA))
+	htmlB := highlightedLines(b, path.Base(pd.PathB), len(linesB))
+	pd.Hunks = buildHunks(ops, htmlA, htmlB, pd.Mode == "split")
+	return nil
+}
+
+// readDiffSide resolves and reads one side of a comparison, refusing
+// anything that is not a regular text file within the preview size cap, or
+// that lies in a root with text previews turned off.
+func readDiffSide(r *http.Request, roots map[string]string, urlPath string, opts PreviewOptions) (string, error) {
+	fsPath, err := resolvePath(r, roots, urlPath)
+	if err != nil || !opts.forRoot(urlPath).Text {
+		return "", fmt.Errorf("%s: not found", urlPath)
+	}
+	info, err := os.Stat(fsPath)
+	if err != nil {
+		return "", fmt.Errorf("%s: not found", urlPath)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s: not a file", urlPath)
+	}
+	if !isText(mimeForFile(fsPath)) {
+		return "", fmt.Errorf("%s: binary files cannot be compared", urlPath)
+	}
+	content, truncated, err := readTextFile(fsPath)
+	if err != nil {
+		return "", fmt.Errorf("%s: could not be read", urlPath)
+	}
+	if truncated {
+		return "", fmt.Errorf("%s: larger than %s, too large to compare", urlPath, formatSize(maxTextBytes))
+	}
+	return content, nil
+}
+
+// splitLines splits content into lines without their terminators. A final
+// newline does not start another line.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// ---------------------------------------------------------------------------
+// Myers diff
+// ---------------------------------------------------------------------------
+
+type diffKind int
+
+const (
+	diffEqual diffKind = iota
+	diffDel
+	diffAdd
+)
+
+// diffOp is one line of an edit script: an unchanged line (present in both
+// files), a line deleted from a, or a line added from b. Indexes are
+// 0-based; the one that does not apply is -1.
+type diffOp struct {
+	kind diffKind
+	a, b int
+}
+
+// differ holds the state of one comparison. Lines are interned to integers
+// so that the inner loops compare ints rather than strings.
+type differ struct {
+	a, b     []int
+	removed  []bool // removed[i]: line i of a is not in the common subsequence
+	added    []bool // added[j]: line j of b is not in the common subsequence
+	deadline time.Time
+}
+
+// diffLines returns the edit script turning a into b.
+func diffLines(a, b []string) []diffOp {
+	ids := make(map[string]int, len(a)+len(b))
+	intern := func(lines []string) []int {
+		out := make([]int, len(lines))
+		for i, l := range lines {
+			id, ok := ids[l]
+			if !ok {
+				id = len(ids)
+				ids[l] = id
+			}
+			out[i] = id
+		}
+		return out
+	}
+	d := &differ{
+		a:        intern(a),
+		b:        intern(b),
+		removed:  make([]bool, len(a)),
+		added:    make([]bool, len(b)),
+		deadline: time.Now().Add(diffTimeout),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.removed[i]:
+			ops = append(ops, diffOp{diffDel, i, -1})
+			i++
+		case j < len(b) && d.added[j]:
+			ops = append(ops, diffOp{diffAdd, -1, j})
+			j++
+		default:
+			ops = append(ops, diffOp{diffEqual, i, j})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+// compare marks the differences between a[aLo:aHi] and b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+	if aLo == aHi || bLo == bHi {
+		d.replace(aLo, aHi, bLo, bHi)
+		return
+	}
+	x, y, ok := d.bisect(aLo, aHi, bLo, bHi)
+	if !ok || (x == aLo && y == bLo) || (x == aHi && y == bHi) {
+		d.replace(aLo, aHi, bLo, bHi)
+		return
+	}
+	d.compare(aLo, x, bLo, y)
+	d.compare(x, aHi, y, bHi)
+}
+
+// replace marks a[aLo:aHi] as removed and b[bLo:bHi] as added.
+func (d *differ) replace(aLo, aHi, bLo, bHi int) {
+	for i := aLo; i < aHi; i++ {
+		d.removed[i] = true
+	}
+	for j := bLo; j < bHi; j++ {
+		d.added[j] = true
+	}
+}
+
+// bisect finds the middle snake of a[aLo:aHi] and b[bLo:bHi] by running the
+// greedy forward and reverse searches until their paths overlap, and returns
+// the point at which to split the problem. It reports false when the ranges
+// share nothing or the deadline passes.
+func (d *differ) bisect(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	n, m := aHi-aLo, bHi-bLo
+	maxD := (n + m + 1) / 2
+	off := maxD + 1
+	vLen := 2*maxD + 2
+	v1 := make([]int, vLen)
+	v2 := make([]int, vLen)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[off+1], v2[off+1] = 0, 0
+	delta := n - m
+	// With an odd delta the paths meet during a forward step, otherwise
+	// during a reverse step.
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for step := 0; step < maxD; step++ {
+		if time.Now().After(d.deadline) {
+			return 0, 0, false
+		}
+		for k1 := -step + k1start; k1 <= step-k1end; k1 += 2 {
+			k1off := off + k1
+			var x1 int
+			if k1 == -step || (k1 != step && v1[k1off-1] < v1[k1off+1]) {
+				x1 = v1[k1off+1]
+			} else {
+				x1 = v1[k1off-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && d.a[aLo+x1] == d.b[bLo+y1] {
+				x1++
+				y1++
+			}
+			v1[k1off] = x1
+			switch {
+			case x1 > n:
+				k1end += 2 // ran off the right of the grid
+			case y1 > m:
+				k1start += 2 // ran off the bottom of the grid
+			case front:
+				k2off := off + delta - k1
+				if k2off >= 0 && k2off < vLen && v2[k2off] != -1 && x1 >= n-v2[k2off] {
+					return aLo + x1, bLo + y1, true
+				}
+			}
+		}
+		for k2 := -step + k2start; k2 <= step-k2end; k2 += 2 {
+			k2off := off + k2
+			var x2 int
+			if k2 == -step || (k2 != step && v2[k2off-1] < v2[k2off+1]) {
+				x2 = v2[k2off+1]
+			} else {
+				x2 = v2[k2off-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && d.a[aHi-x2-1] == d.b[bHi-y2-1] {
+				x2++
+				y2++
+			}
+			v2[k2off] = x2
+			switch {
+			case x2 > n:
+				k2end += 2
+			case y2 > m:
+				k2start += 2
+			case !front:
+				k1off := off + delta - k2
+				if k1off >= 0 && k1off < vLen && v1[k1off] != -1 {
+					x1 := v1[k1off]
+					y1 := off + x1 - k1off
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// ---------------------------------------------------------------------------
+// Rendering
+// ---------------------------------------------------------------------------
+
+// highlightedLines tokenises content with the lexer for filename and returns
+// the HTML of each of its want lines. Token classes match the /highlight.css
+// stylesheet. If the lexer's idea of the lines disagrees with splitLines,
+// the lines are returned escaped but unhighlighted.
+func highlightedLines(content, filename string, want int) []template.HTML {
+	out := make([]template.HTML, 0, want)
+	lexer := lexers.Match(filename)
+	if lexer == nil {
+		lexer = lexers.Analyse(content)
+	}
+	if lexer != nil {
+		if it, err := chroma.Coalesce(lexer).Tokenise(nil, content); err == nil {
+			for _, line := range chroma.SplitTokensIntoLines(it.Tokens()) {
+				var b strings.Builder
+				for _, tok := range line {
+					text := strings.TrimRight(tok.Value, "\r\n")
+					if text == "" {
+						continue
+					}
+					if cls := tokenClass(tok.Type); cls != "" {
+						b.WriteString(`<span class="` + cls + `">` + template.HTMLEscapeString(text) + `</span>`)
+					} else {
+						b.WriteString(template.HTMLEscapeString(text))
+					}
+				}
+				out = append(out, template.HTML(b.String()))
+			}
+		}
+	}
+	if len(out) >= want && want > 0 {
+		return out[:want]
+	}
+	out = out[:0]
+	for _, l := range splitLines(content) {
+		out = append(out, template.HTML(template.HTMLEscapeString(strings.TrimRight(l, "\r"))))
+	}
+	return out
+}
+
+// tokenClass returns the short CSS class Chroma's HTML formatter uses for t,
+// falling back through parent token types.
+func tokenClass(t chroma.TokenType) string {
+	for ; t != 0; t = t.Parent() {
+		if cls, ok := chroma.StandardTypes[t]; ok {
+			return cls
+		}
+	}
+	return chroma.StandardTypes[t]
+}
+
+// buildHunks groups ops into hunks of changes with diffContext lines of
+// context, laid out as unified lines or, when split is set, as side-by-side
+// rows in which deletions are paired with the additions that replace them.
+func buildHunks(ops []diffOp, htmlA, htmlB []template.HTML, split bool) []models.DiffHunk {
+	var hunks []models.DiffHunk
+	for start := 0; start < len(ops); {
+		// Find the next change and the extent of its hunk.
+		first := start
+		for first < len(ops) && ops[first].kind == diffEqual {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		lo := first - diffContext
+		if lo < start {
+			lo = start
+		}
+		hi := first
+		for hi < len(ops) {
+			if ops[hi].kind != diffEqual {
+				hi++
+				continue
+			}
+			run := hi
+			for run < len(ops) && ops[run].kind == diffEqual {
+				run++
+			}
+			if run == len(ops) || run-hi > 2*diffContext {
+				hi += min(diffContext, run-hi)
+				break
+			}
+			hi = run
+		}
+
+		hunks = append(hunks, renderHunk(ops[lo:hi], htmlA, htmlB, split))
+		start = hi
+	}
+	return hunks
+}
+
+// renderHunk lays out one hunk's ops.
+func renderHunk(ops []diffOp, htmlA, htmlB []template.HTML, split bool) models.DiffHunk {
+	var h models.DiffHunk
+	oldStart, newStart, oldCount, newCount := 0, 0, 0, 0
+	for _, op := range ops {
+		if op.a >= 0 {
+			if oldCount == 0 {
+				oldStart = op.a + 1
+			}
+			oldCount++
+		}
+		if op.b >= 0 {
+			if newCount == 0 {
+				newStart = op.b + 1
+			}
+			newCount++
+		}
+	}
+	h.Header = fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)
+
+	cell := func(kind string, lines []template.HTML, i int) models.DiffCell {
+		return models.DiffCell{Kind: kind, Num: i + 1, HTML: lines[i]}
+	}
+	if !split {
+		for _, op := range ops {
+			switch op.kind {
+			case diffEqual:
+				h.Lines = append(h.Lines, models.DiffLine{Kind: "context", OldNum: op.a + 1, NewNum: op.b + 1, HTML: htmlA[op.a]})
+			case diffDel:
+				h.Lines = append(h.Lines, models.DiffLine{Kind: "del", OldNum: op.a + 1, HTML: htmlA[op.a]})
+			case diffAdd:
+				h.Lines = append(h.Lines, models.DiffLine{Kind: "add", NewNum: op.b + 1, HTML: htmlB[op.b]})
+			}
+		}
+		return h
+	}
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == diffEqual {
+			h.Rows = append(h.Rows, models.DiffRow{
+				Left:  cell("context", htmlA, ops[i].a),
+				Right: cell("context", htmlB, ops[i].b),
+			})
+			i++
+			continue
+		}
+		// A change block: deletions followed by additions.
+		var dels, adds []int
+		for ; i < len(ops) && ops[i].kind == diffDel; i++ {
+			dels = append(dels, ops[i].a)
+		}
+		for ; i < len(ops) && ops[i].kind == diffAdd; i++ {
+			adds = append(adds, ops[i].b)
+		}
+		for k := 0; k < len(dels) || k < len(adds); k++ {
+			var row models.DiffRow
+			if k < len(dels) {
+				row.Left = cell("del", htmlA, dels[k])
+			}
+			if k < len(adds) {
+				row.Right = cell("add", htmlB, adds[k])
+			}
+			h.Rows = append(h.Rows, row)
+		}
+	}
+	return h
+}
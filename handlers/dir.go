@@ -2,6 +2,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"path"
@@ -29,11 +30,20 @@ func entryIsDir(parent string, d os.DirEntry) bool {
 // It is best-effort: unreadable entries are silently skipped.
 // Symlinks are NOT followed — they contribute 0 to the size, which matches
 // user expectations for "how much space does this directory use" without
-// counting shared or external content multiple times.
-func dirSize(root string) int64 {
+// counting shared or external content multiple times. Excluded files and
+// directories, per scope, the scope of root, are not counted either.
+func dirSize(scope *ignoreScope, root string) int64 {
 	var total int64
+	scopes := map[string]*ignoreScope{root: scope}
 	_ = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
-		if err != nil {
+		if err != nil || p == root {
+			return nil
+		}
+		parent := scopes[filepath.Dir(p)]
+		if parent.excluded(d.Name(), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		// Skip symlinks entirely — they contribute 0 to directory size.
@@ -43,6 +53,7 @@ func dirSize(root string) int64 {
 		}
 		// Real directory — descend normally, don't count its inode size.
 		if d.IsDir() {
+			scopes[p] = parent.child(d.Name(), p)
 			return nil
 		}
 		// Regular file — count it.
@@ -55,12 +66,13 @@ func dirSize(root string) int64 {
 }
 
 // DirHandler handles directory listing requests.
-// roots maps the URL top-level name to the real filesystem path.
-func DirHandler(roots map[string]string, siteName, defaultTheme string, tmpl interface{ ExecuteDir(http.ResponseWriter, *models.DirListing) error }) http.HandlerFunc {
+// roots maps the URL top-level name to the real filesystem path. When
+// opts.Docs is set, a README in the directory is rendered beneath the listing.
+func DirHandler(roots map[string]string, siteName, defaultTheme string, opts PreviewOptions, tmpl interface{ ExecuteDir(http.ResponseWriter, *models.DirListing) error }) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		urlPath := path.Clean("/" + r.URL.Path)
 
-		fsPath, err := resolvePath(roots, urlPath)
+		fsPath, err := resolvePath(r, roots, urlPath)
 		if err != nil {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
@@ -68,6 +80,11 @@ func DirHandler(roots map[string]string, siteName, defaultTheme string, tmpl int
 
 		info, err := os.Stat(fsPath)
 		if err != nil || !info.IsDir() {
+			// Archives and directories inside them are listed virtually.
+			if ref, ok := resolveArchivePath(r, roots, urlPath); ok {
+				archiveDir(w, r, ref, urlPath, siteName, defaultTheme, tmpl)
+				return
+			}
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -85,9 +102,14 @@ func DirHandler(roots map[string]string, siteName, defaultTheme string, tmpl int
 			Breadcrumbs:  buildBreadcrumbs(siteName, urlPath),
 			Entries:      entries,
 			DownloadURL:  "/zip" + urlPath,
-			TotalSize:    cachedDirSize(fsPath),
+			TotalSize:    cachedDirSize(scopeFor(roots, urlPath), fsPath),
 			DefaultTheme: defaultTheme,
 		}
+		if fe, html := dirReadme(entries, fsPath, urlPath, opts.forRoot(urlPath)); fe != nil {
+			listing.ReadmeName = fe.Name
+			listing.ReadmePath = fe.Path
+			listing.Readme = html
+		}
 
 		if err := tmpl.ExecuteDir(w, listing); err != nil {
 			http.Error(w, "Template error", http.StatusInternalServerError)
@@ -95,6 +117,40 @@ func DirHandler(roots map[string]string, siteName, defaultTheme string, tmpl int
 	}
 }
 
+// archiveDir renders the listing of a directory inside an archive. Requests
+// for a file member are redirected to its preview page.
+func archiveDir(w http.ResponseWriter, r *http.Request, ref *archiveRef, urlPath, siteName, defaultTheme string, tmpl interface{ ExecuteDir(http.ResponseWriter, *models.DirListing) error }) {
+	idx, m, err := lookupMember(ref)
+	if err != nil {
+		if errors.Is(err, errMemberNotFound) {
+			http.Error(w, "Not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Error reading archive", http.StatusInternalServerError)
+		}
+		return
+	}
+	if !m.isDir {
+		http.Redirect(w, r, "/preview"+urlPath, http.StatusFound)
+		return
+	}
+
+	listing := &models.DirListing{
+		Title:        path.Base(urlPath),
+		SiteName:     siteName,
+		CurrentPath:  urlPath,
+		Breadcrumbs:  buildBreadcrumbs(siteName, urlPath),
+		Entries:      archiveEntries(idx, ref, m),
+		DownloadURL:  "/download" + ref.urlPath,
+		TotalSize:    ref.info.Size(),
+		DefaultTheme: defaultTheme,
+		InArchive:    true,
+	}
+
+	if err := tmpl.ExecuteDir(w, listing); err != nil {
+		http.Error(w, "Template error", http.StatusInternalServerError)
+	}
+}
+
 // RootHandler returns a handler for the "/" path that lists all configured roots.
 func RootHandler(roots map[string]string, siteName, defaultTheme string, tmpl interface{ ExecuteDir(http.ResponseWriter, *models.DirListing) error }) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -103,6 +159,7 @@ func RootHandler(roots map[string]string, siteName, defaultTheme string, tmpl in
 			return
 		}
 
+		roots := visibleRoots(r, roots)
 		var entries []models.FileEntry
 		names := make([]string, 0, len(roots))
 		for name := range roots {
@@ -113,7 +170,7 @@ func RootHandler(roots map[string]string, siteName, defaultTheme string, tmpl in
 		var totalSize int64
 		for _, name := range names {
 			fsDir := roots[name]
-			sz := cachedDirSize(fsDir)
+			sz := cachedDirSize(newIgnoreScope(rootSettings(name), fsDir), fsDir)
 			totalSize += sz
 			fe := models.FileEntry{
 				Name:  name,
@@ -121,6 +178,10 @@ func RootHandler(roots map[string]string, siteName, defaultTheme string, tmpl in
 				IsDir: true,
 				Size:  sz,
 			}
+			if rc := rootSettings(name); rc != nil {
+				fe.DisplayName = rc.DisplayName
+				fe.Description = rc.Description
+			}
 			if fi, err := os.Stat(fsDir); err == nil {
 				fe.ModTime = fi.ModTime()
 			}
@@ -155,10 +216,14 @@ func buildEntries(roots map[string]string, urlPath, fsPath string) ([]models.Fil
 	}
 
 	// Pre-allocate and populate the slice without sizes yet.
+	scope := scopeFor(roots, urlPath)
 	entries := make([]models.FileEntry, 0, len(rawEntries))
 	for _, e := range rawEntries {
 		fullPath := filepath.Join(fsPath, e.Name())
 		isDir := entryIsDir(fsPath, e)
+		if scope.omitted(e.Name(), isDir) {
+			continue
+		}
 
 		// Use os.Stat so that symlinks are followed for size and modtime.
 		fi, err := os.Stat(fullPath)
@@ -180,6 +245,7 @@ func buildEntries(roots map[string]string, urlPath, fsPath string) ([]models.Fil
 			fe.IsImage = isImage(mime)
 			fe.IsText = isText(mime)
 			fe.IsPreview = fe.IsImage || fe.IsText
+			fe.IsArchive = fi.Mode().IsRegular() && archiveKind(e.Name()) != ""
 		}
 
 		entries = append(entries, fe)
@@ -196,7 +262,7 @@ func buildEntries(roots map[string]string, urlPath, fsPath string) ([]models.Fil
 		go func(i int) {
 			defer wg.Done()
 			fsDir := filepath.Join(fsPath, entries[i].Name)
-			entries[i].Size = cachedDirSize(fsDir)
+			entries[i].Size = cachedDirSize(scope.child(entries[i].Name, fsDir), fsDir)
 		}(i)
 	}
 	wg.Wait()
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"github.com/niklasfasching/go-org/org"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Goldmark and go-org extensions for server-side math (TeX to MathML, see
+// mathml.go) and Graphviz diagrams (see diagram.go).
+//
+// Markdown syntax:
+//   - $…$ inline math and $$…$$ display math, inline or as a block whose
+//     opening and closing $$ sit on their own lines;
+//   - ```math fenced blocks, as used by GitHub and GitLab;
+//   - ```dot (or graphviz) fenced blocks, rendered to SVG. ```mermaid blocks
+//     are left to the syntax highlighter.
+//
+// Org syntax: the LaTeX fragments go-org already parses ($…$, \(…\), \[…\],
+// $$…$$ and \begin{…} environments), and #+BEGIN_SRC dot blocks.
+
+var (
+	kindMathInline = ast.NewNodeKind("MathInline")
+	kindMathBlock  = ast.NewNodeKind("MathBlock")
+)
+
+// mathInline is a $…$ (or single-line $$…$$) span.
+type mathInline struct {
+	ast.BaseInline
+	source  string
+	display bool
+}
+
+func (n *mathInline) Kind() ast.NodeKind { return kindMathInline }
+
+func (n *mathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Source": n.source}, nil)
+}
+
+// mathBlock is a $$ … $$ block.
+type mathBlock struct {
+	ast.BaseBlock
+	source strings.Builder
+	closed bool
+}
+
+func (n *mathBlock) Kind() ast.NodeKind { return kindMathBlock }
+
+func (n *mathBlock) IsRaw() bool { return true }
+
+func (n *mathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Source": n.source.String()}, nil)
+}
+
+type mathInlineParser struct{}
+
+func (mathInlineParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if len(line) < 3 || line[0] != '$' {
+		return nil
+	}
+	if line[1] == '$' {
+		end := bytes.Index(line[2:], []byte("$$"))
+		if end < 0 || len(bytes.TrimSpace(line[2:2+end])) == 0 {
+			return nil
+		}
+		block.Advance(end + 4)
+		return &mathInline{source: string(line[2 : 2+end]), display: true}
+	}
+
+	end := -1
+	for i := 1; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if line[i] == '$' {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil
+	}
+	src := string(line[1:end])
+	// A closing $ followed by a digit is a price, not math ("$5 to $10").
+	if !plausibleInlineMath(src) || (end+1 < len(line) && line[end+1] >= '0' && line[end+1] <= '9') {
+		return nil
+	}
+	block.Advance(end + 1)
+	return &mathInline{source: src}
+}
+
+type mathBlockParser struct{}
+
+func (mathBlockParser) Trigger() []byte { return []byte{'$'} }
+
+func (mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !bytes.HasPrefix(line[pos:], []byte("$$")) {
+		return nil, parser.NoChildren
+	}
+	node := &mathBlock{}
+	rest := bytes.TrimSpace(line[pos+2:])
+	if bytes.HasSuffix(rest, []byte("$$")) {
+		node.source.Write(rest[:len(rest)-2])
+		node.closed = true
+	} else {
+		node.source.Write(rest)
+	}
+	reader.Advance(segment.Len() - 1)
+	return node, parser.NoChildren
+}
+
+func (mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*mathBlock)
+	if n.closed {
+		return parser.Close
+	}
+	line, segment := reader.PeekLine()
+	if line == nil {
+		return parser.Close
+	}
+	trimmed := bytes.TrimSpace(line)
+	n.source.WriteByte('\n')
+	if bytes.HasSuffix(trimmed, []byte("$$")) {
+		n.source.Write(trimmed[:len(trimmed)-2])
+		n.closed = true
+	} else {
+		n.source.Write(trimmed)
+	}
+	reader.Advance(segment.Len() - 1)
+	return parser.Continue | parser.NoChildren
+}
+
+func (mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (mathBlockParser) CanInterruptParagraph() bool { return true }
+
+func (mathBlockParser) CanAcceptIndentedLine() bool { return false }
+
+// docRenderer renders math nodes and intercepts fenced code blocks in the
+// math and DOT languages. Every other fenced block is handed to the
+// goldmark-highlighting renderer it wraps.
+type docRenderer struct {
+	highlight renderer.NodeRenderer
+	fallback  renderer.NodeRendererFunc
+}
+
+// funcCapture records the render functions a NodeRenderer registers.
+type funcCapture map[ast.NodeKind]renderer.NodeRendererFunc
+
+func (c funcCapture) Register(k ast.NodeKind, f renderer.NodeRendererFunc) { c[k] = f }
+
+func (r *docRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	captured := funcCapture{}
+	r.highlight.RegisterFuncs(captured)
+	r.fallback = captured[ast.KindFencedCodeBlock]
+
+	reg.Register(kindMathInline, r.renderMathInline)
+	reg.Register(kindMathBlock, r.renderMathBlock)
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *docRenderer) renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*mathInline)
+		_, _ = w.WriteString(renderMath(n.source, n.display))
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *docRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString(renderMath(node.(*mathBlock).source.String(), true) + "\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *docRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.FencedCodeBlock)
+	lang := strings.ToLower(string(n.Language(source)))
+	if entering && (lang == "math" || isDiagramLang(lang)) {
+		var body strings.Builder
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			seg := lines.At(i)
+			body.Write(seg.Value(source))
+		}
+		out := ""
+		if lang == "math" {
+			out = renderMath(body.String(), true)
+		} else {
+			out = renderDiagram(body.String())
+		}
+		if out != "" {
+			_, _ = w.WriteString(out + "\n")
+			return ast.WalkSkipChildren, nil
+		}
+	}
+	if r.fallback == nil {
+		return ast.WalkContinue, nil
+	}
+	return r.fallback(w, source, node, entering)
+}
+
+// docExtension registers the math parsers and docRenderer with a goldmark
+// pipeline. highlight is the code block renderer used for everything that is
+// neither math nor a diagram.
+type docExtension struct {
+	highlight renderer.NodeRenderer
+}
+
+func (e *docExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(util.Prioritized(mathBlockParser{}, 750)),
+		parser.WithInlineParsers(util.Prioritized(mathInlineParser{}, 150)),
+	)
+	// A lower value than goldmark-highlighting's 200 makes these
+	// registrations win for fenced code blocks.
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&docRenderer{highlight: e.highlight}, 100),
+	))
+}
+
+// orgDocWriter extends go-org's HTML writer to render LaTeX fragments and
+// environments as MathML.
+type orgDocWriter struct {
+	*org.HTMLWriter
+}
+
+func (w *orgDocWriter) WriteLatexFragment(l org.LatexFragment) {
+	src := org.String(l.Content...)
+	switch l.OpeningPair {
+	case "$":
+		if !plausibleInlineMath(src) {
+			w.WriteString(template.HTMLEscapeString("$" + src + "$"))
+			return
+		}
+		w.WriteString(renderMath(src, false))
+	case `\(`:
+		w.WriteString(renderMath(src, false))
+	case "$$", `\[`:
+		w.WriteString(renderMath(src, true))
+	default:
+		// \begin{env}…\end{env}: the environment is part of the math.
+		w.WriteString(renderMath(l.OpeningPair+src+l.ClosingPair, true))
+	}
+}
+
+func (w *orgDocWriter) WriteLatexBlock(b org.LatexBlock) {
+	w.WriteString(renderMath(org.String(b.Content...), true) + "\n")
+}
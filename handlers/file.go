@@ -20,7 +20,7 @@ func FileHandler(roots map[string]string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		urlPath := path.Clean("/" + r.URL.Path)
 
-		fsPath, err := resolvePath(roots, urlPath)
+		fsPath, err := resolvePath(r, roots, urlPath)
 		if err != nil {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
@@ -28,6 +28,19 @@ func FileHandler(roots map[string]string) http.HandlerFunc {
 
 		info, err := os.Stat(fsPath)
 		if err != nil || info.IsDir() {
+			// Single members can be downloaded straight out of an archive.
+			if ref, ok := resolveArchivePath(r, roots, urlPath); ok && ref.member != "" {
+				ip := clientIP(r)
+				log.Printf("file download   ip=%-15s  file=%s", ip, urlPath)
+				start := time.Now()
+				n := serveArchiveMember(w, r, ref, true)
+				if n > 0 {
+					RecordDownload(n)
+					log.Printf("file complete   ip=%-15s  size=%-10s  duration=%s  file=%s",
+						ip, formatSize(n), time.Since(start).Round(time.Millisecond), urlPath)
+				}
+				return
+			}
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -64,7 +77,7 @@ func ViewHandler(roots map[string]string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		urlPath := path.Clean("/" + r.URL.Path)
 
-		fsPath, err := resolvePath(roots, urlPath)
+		fsPath, err := resolvePath(r, roots, urlPath)
 		if err != nil {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
@@ -72,6 +85,10 @@ func ViewHandler(roots map[string]string) http.HandlerFunc {
 
 		info, err := os.Stat(fsPath)
 		if err != nil || info.IsDir() {
+			if ref, ok := resolveArchivePath(r, roots, urlPath); ok && ref.member != "" {
+				serveArchiveMember(w, r, ref, false)
+				return
+			}
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -109,7 +126,7 @@ func formatSize(b int64) string {
 // intermediate buffer allocation is needed.
 func IndexHandler(roots map[string]string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		data := cachedIndexGzip(roots)
+		data := cachedIndexGzip(visibleRoots(r, roots))
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Encoding", "gzip")
 		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
@@ -123,12 +140,12 @@ func IndexHandler(roots map[string]string) http.HandlerFunc {
 func buildIndex(roots map[string]string) *models.FileIndex {
 	idx := &models.FileIndex{}
 	for rootName, fsRoot := range roots {
-		walkDir(rootName, fsRoot, fsRoot, idx)
+		walkDir(rootName, fsRoot, fsRoot, newIgnoreScope(rootSettings(rootName), fsRoot), idx)
 	}
 	return idx
 }
 
-func walkDir(rootName, fsRoot, dir string, idx *models.FileIndex) {
+func walkDir(rootName, fsRoot, dir string, scope *ignoreScope, idx *models.FileIndex) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return
@@ -136,12 +153,15 @@ func walkDir(rootName, fsRoot, dir string, idx *models.FileIndex) {
 	for _, e := range entries {
 		fullPath := filepath.Join(dir, e.Name())
 		isDir := entryIsDir(dir, e)
+		if scope.omitted(e.Name(), isDir) {
+			continue
+		}
 
 		if isDir {
 			// Recurse into subdirectories but do not add them to the index.
 			// Excluding directories shrinks the index and avoids the client
 			// having to filter them out on every search keystroke.
-			walkDir(rootName, fsRoot, fullPath, idx)
+			walkDir(rootName, fsRoot, fullPath, scope.child(e.Name(), fullPath), idx)
 			continue
 		}
 
@@ -0,0 +1,567 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/andybalholm/brotli"
+
+	"gileserver/models"
+)
+
+// Font previews load the file itself through @font-face from /view/; the
+// details around the specimen come from the font's own tables, read here:
+// the name table (family, style, version, license …), maxp (glyph count)
+// and cmap (which characters the font covers). TrueType and OpenType files
+// are read directly, WOFF tables are inflated with zlib, and WOFF2 is
+// Brotli-decompressed; the tables needed here are never transformed by
+// WOFF2, so they can be read straight out of the decompressed stream.
+
+const (
+	// maxFontBytes caps the font files that are parsed at all.
+	maxFontBytes = 64 * 1024 * 1024
+
+	// maxFontTable caps a single decompressed table.
+	maxFontTable = 16 * 1024 * 1024
+
+	// maxGlyphCells bounds the character grid. Large CJK fonts map tens of
+	// thousands of characters; past this the grid notes what was left out.
+	maxGlyphCells = 12000
+)
+
+var errBadFont = errors.New("malformed font")
+
+// fontSampleSizes are the pixel sizes of the specimen lines.
+var fontSampleSizes = []int{12, 18, 24, 36, 48, 72}
+
+// isFont reports whether the MIME type is a font file that can be previewed.
+func isFont(mimeType string) bool {
+	switch baseMIME(mimeType) {
+	case "font/ttf", "font/otf", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
+
+// readFontInfo parses the font at fsPath. It returns nil when the file is not
+// a font it understands, in which case the preview shows the binary card.
+func readFontInfo(fsPath string) *models.FontInfo {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxFontBytes+1))
+	if err != nil || len(data) > maxFontBytes {
+		return nil
+	}
+	tables, format, err := fontTables(data)
+	if err != nil {
+		return nil
+	}
+
+	info := &models.FontInfo{Format: format, SampleSizes: fontSampleSizes}
+	if name, ok := tables["name"]; ok {
+		names := parseNameTable(name)
+		info.Family = firstNonEmpty(names[16], names[1])
+		info.Style = firstNonEmpty(names[17], names[2])
+		info.FullName = names[4]
+		info.Version = strings.TrimPrefix(names[5], "Version ")
+		info.PostScriptName = names[6]
+		info.Designer = names[9]
+		info.Manufacturer = names[8]
+		info.Copyright = names[0]
+		info.Trademark = names[7]
+		info.License = names[13]
+		info.LicenseURL = names[14]
+	}
+	if maxp, ok := tables["maxp"]; ok && len(maxp) >= 6 {
+		info.Glyphs = int(binary.BigEndian.Uint16(maxp[4:6]))
+	}
+	if cmap, ok := tables["cmap"]; ok {
+		fillCoverage(info, parseCmap(cmap))
+	}
+	return info
+}
+
+// fontTables returns the tables needed for the preview, keyed by tag, and a
+// description of the container format.
+func fontTables(data []byte) (map[string][]byte, string, error) {
+	if len(data) < 12 {
+		return nil, "", errBadFont
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+		t, err := sfntTables(data)
+		return t, "TrueType", err
+	case "OTTO":
+		t, err := sfntTables(data)
+		return t, "OpenType (CFF outlines)", err
+	case "wOFF":
+		t, err := woffTables(data)
+		return t, "WOFF" + woffFlavor(data), err
+	case "wOF2":
+		t, err := woff2Tables(data)
+		return t, "WOFF2" + woffFlavor(data), err
+	}
+	return nil, "", errBadFont
+}
+
+// wantedFontTable reports whether a table is read by the preview.
+func wantedFontTable(tag string) bool {
+	return tag == "name" || tag == "cmap" || tag == "maxp"
+}
+
+// woffFlavor describes the outlines wrapped by a WOFF or WOFF2 file.
+func woffFlavor(data []byte) string {
+	if string(data[4:8]) == "OTTO" {
+		return " (CFF outlines)"
+	}
+	return " (TrueType outlines)"
+}
+
+// sfntTables reads the table directory of a plain TrueType/OpenType file.
+func sfntTables(data []byte) (map[string][]byte, error) {
+	n := int(binary.BigEndian.Uint16(data[4:6]))
+	if len(data) < 12+16*n {
+		return nil, errBadFont
+	}
+	tables := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		rec := data[12+16*i:]
+		tag := string(rec[:4])
+		if !wantedFontTable(tag) {
+			continue
+		}
+		off, length := int64(binary.BigEndian.Uint32(rec[8:12])), int64(binary.BigEndian.Uint32(rec[12:16]))
+		if off+length > int64(len(data)) {
+			return nil, errBadFont
+		}
+		tables[tag] = data[off : off+length]
+	}
+	return tables, nil
+}
+
+// woffTables reads a WOFF 1.0 file, inflating compressed tables.
+func woffTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 44 {
+		return nil, errBadFont
+	}
+	n := int(binary.BigEndian.Uint16(data[12:14]))
+	if len(data) < 44+20*n {
+		return nil, errBadFont
+	}
+	tables := make(map[string][]byte)
+	for i := 0; i < n; i++ {
+		rec := data[44+20*i:]
+		tag := string(rec[:4])
+		if !wantedFontTable(tag) {
+			continue
+		}
+		off := int64(binary.BigEndian.Uint32(rec[4:8]))
+		compLen := int64(binary.BigEndian.Uint32(rec[8:12]))
+		origLen := int64(binary.BigEndian.Uint32(rec[12:16]))
+		if off+compLen > int64(len(data)) || origLen > maxFontTable {
+			return nil, errBadFont
+		}
+		raw := data[off : off+compLen]
+		if compLen < origLen {
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				return nil, errBadFont
+			}
+			raw, err = io.ReadAll(io.LimitReader(zr, origLen))
+			zr.Close()
+			if err != nil {
+				return nil, errBadFont
+			}
+		}
+		tables[tag] = raw
+	}
+	return tables, nil
+}
+
+// woff2KnownTags are the table tags WOFF2 encodes as a 6-bit index.
+var woff2KnownTags = [...]string{
+	"cmap", "head", "hhea", "hmtx", "maxp", "name", "OS/2", "post",
+	"cvt ", "fpgm", "glyf", "loca", "prep", "CFF ", "VORG", "EBDT",
+	"EBLC", "gasp", "hdmx", "kern", "LTSH", "PCLT", "VDMX", "vhea",
+	"vmtx", "BASE", "GDEF", "GPOS", "GSUB", "EBSC", "JSTF", "MATH",
+	"CBDT", "CBLC", "COLR", "CPAL", "SVG ", "sbix", "acnt", "avar",
+	"bdat", "bloc", "bsln", "cvar", "fdsc", "feat", "fmtx", "fvar",
+	"gvar", "hsty", "just", "lcar", "mort", "morx", "opbd", "prop",
+	"trak", "Zapf", "Silf", "Glat", "Gloc", "Feat", "Sill",
+}
+
+// woff2Tables reads a WOFF2 file: a table directory followed by one Brotli
+// stream holding every table back to back.
+func woff2Tables(data []byte) (map[string][]byte, error) {
+	if len(data) < 48 || string(data[4:8]) == "ttcf" {
+		return nil, errBadFont
+	}
+	n := int(binary.BigEndian.Uint16(data[12:14]))
+	compressed := int64(binary.BigEndian.Uint32(data[20:24]))
+
+	type entry struct {
+		tag         string
+		offset, len int64 // position within the decompressed stream
+	}
+	var entries []entry
+	pos := 48
+	var streamOff int64
+	for i := 0; i < n; i++ {
+		if pos >= len(data) {
+			return nil, errBadFont
+		}
+		flags := data[pos]
+		pos++
+		var tag string
+		if idx := int(flags & 0x3f); idx == 0x3f {
+			if pos+4 > len(data) {
+				return nil, errBadFont
+			}
+			tag = string(data[pos : pos+4])
+			pos += 4
+		} else if idx < len(woff2KnownTags) {
+			tag = woff2KnownTags[idx]
+		} else {
+			return nil, errBadFont
+		}
+		origLen, ok := readUIntBase128(data, &pos)
+		if !ok {
+			return nil, errBadFont
+		}
+		length := origLen
+		version := flags >> 6
+		// glyf and loca are transformed unless version 3; every other table
+		// is transformed only with a non-zero version.
+		transformed := version != 0
+		if tag == "glyf" || tag == "loca" {
+			transformed = version != 3
+		}
+		if transformed {
+			if length, ok = readUIntBase128(data, &pos); !ok {
+				return nil, errBadFont
+			}
+		}
+		entries = append(entries, entry{tag, streamOff, length})
+		streamOff += length
+	}
+	if int64(pos)+compressed > int64(len(data)) || streamOff > maxFontBytes {
+		return nil, errBadFont
+	}
+
+	// Only decompress as far as the last table needed.
+	var need int64
+	for _, e := range entries {
+		if wantedFontTable(e.tag) && e.offset+e.len > need {
+			need = e.offset + e.len
+		}
+	}
+	stream, err := io.ReadAll(io.LimitReader(brotli.NewReader(bytes.NewReader(data[pos:int64(pos)+compressed])), need))
+	if err != nil || int64(len(stream)) < need {
+		return nil, errBadFont
+	}
+	tables := make(map[string][]byte)
+	for _, e := range entries {
+		if wantedFontTable(e.tag) {
+			tables[e.tag] = stream[e.offset : e.offset+e.len]
+		}
+	}
+	return tables, nil
+}
+
+// readUIntBase128 decodes WOFF2's variable-length integer at data[*pos].
+func readUIntBase128(data []byte, pos *int) (int64, bool) {
+	var v int64
+	for i := 0; i < 5; i++ {
+		if *pos >= len(data) {
+			return 0, false
+		}
+		b := data[*pos]
+		*pos++
+		if i == 0 && b == 0x80 {
+			return 0, false // leading zeros are not allowed
+		}
+		v = v<<7 | int64(b&0x7f)
+		if b&0x80 == 0 {
+			return v, v <= 0xffffffff
+		}
+	}
+	return 0, false
+}
+
+// ---------------------------------------------------------------------------
+// name
+// ---------------------------------------------------------------------------
+
+// parseNameTable returns the best string for each name ID, preferring
+// Windows US-English records, then other Unicode records, then Macintosh.
+func parseNameTable(t []byte) map[int]string {
+	names := make(map[int]string)
+	if len(t) < 6 {
+		return names
+	}
+	count := int(binary.BigEndian.Uint16(t[2:4]))
+	storage := int(binary.BigEndian.Uint16(t[4:6]))
+	best := make(map[int]int)
+	for i := 0; i < count && 6+12*(i+1) <= len(t); i++ {
+		rec := t[6+12*i:]
+		platform := binary.BigEndian.Uint16(rec[0:2])
+		encoding := binary.BigEndian.Uint16(rec[2:4])
+		lang := binary.BigEndian.Uint16(rec[4:6])
+		id := int(binary.BigEndian.Uint16(rec[6:8]))
+		length := int(binary.BigEndian.Uint16(rec[8:10]))
+		off := storage + int(binary.BigEndian.Uint16(rec[10:12]))
+		if off+length > len(t) {
+			continue
+		}
+		raw := t[off : off+length]
+
+		var score int
+		var s string
+		switch {
+		case platform == 3 && (encoding == 1 || encoding == 10):
+			score = 2
+			if lang == 0x409 {
+				score = 3
+			}
+			s = decodeUTF16BE(raw)
+		case platform == 0:
+			score = 2
+			s = decodeUTF16BE(raw)
+		case platform == 1 && encoding == 0 && lang == 0:
+			score = 1
+			s = decodeMacRoman(raw)
+		default:
+			continue
+		}
+		if s = strings.TrimSpace(s); s != "" && score > best[id] {
+			best[id] = score
+			names[id] = s
+		}
+	}
+	return names
+}
+
+func decodeUTF16BE(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// decodeMacRoman decodes Mac OS Roman text. Only the ASCII half matters in
+// practice; the few accented letters in older fonts' copyright strings are
+// shown as the replacement character.
+func decodeMacRoman(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c < 0x80:
+			sb.WriteByte(c)
+		case c == 0xa9:
+			sb.WriteRune('©')
+		default:
+			sb.WriteRune(unicode.ReplacementChar)
+		}
+	}
+	return sb.String()
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------
+// cmap
+// ---------------------------------------------------------------------------
+
+// runeRange is an inclusive range of code points.
+type runeRange struct{ lo, hi rune }
+
+// parseCmap returns the code points mapped to a glyph by the best Unicode
+// subtable: format 12 (full Unicode) when present, else format 4 (BMP).
+func parseCmap(t []byte) []runeRange {
+	if len(t) < 4 {
+		return nil
+	}
+	n := int(binary.BigEndian.Uint16(t[2:4]))
+	var fmt4, fmt12 []byte
+	for i := 0; i < n && 4+8*(i+1) <= len(t); i++ {
+		rec := t[4+8*i:]
+		platform := binary.BigEndian.Uint16(rec[0:2])
+		encoding := binary.BigEndian.Uint16(rec[2:4])
+		off := int(binary.BigEndian.Uint32(rec[4:8]))
+		if off+2 > len(t) || !(platform == 0 || (platform == 3 && (encoding == 0 || encoding == 1 || encoding == 10))) {
+			continue
+		}
+		switch binary.BigEndian.Uint16(t[off:]) {
+		case 4:
+			if fmt4 == nil {
+				fmt4 = t[off:]
+			}
+		case 12:
+			if fmt12 == nil {
+				fmt12 = t[off:]
+			}
+		}
+	}
+	if fmt12 != nil {
+		return cmapFormat12(fmt12)
+	}
+	if fmt4 != nil {
+		return cmapFormat4(fmt4)
+	}
+	return nil
+}
+
+func cmapFormat12(t []byte) []runeRange {
+	if len(t) < 16 {
+		return nil
+	}
+	groups := int(binary.BigEndian.Uint32(t[12:16]))
+	var out []runeRange
+	for i := 0; i < groups && 16+12*(i+1) <= len(t); i++ {
+		g := t[16+12*i:]
+		lo, hi := rune(binary.BigEndian.Uint32(g[0:4])), rune(binary.BigEndian.Uint32(g[4:8]))
+		if lo > hi || hi > unicode.MaxRune {
+			continue
+		}
+		out = append(out, runeRange{lo, hi})
+	}
+	return mergeRanges(out)
+}
+
+func cmapFormat4(t []byte) []runeRange {
+	if len(t) < 14 {
+		return nil
+	}
+	segs := int(binary.BigEndian.Uint16(t[6:8])) / 2
+	ends, starts := 14, 16+2*segs
+	deltas, rangeOffs := starts+2*segs, starts+4*segs
+	if rangeOffs+2*segs > len(t) {
+		return nil
+	}
+	u16 := func(off int) int { return int(binary.BigEndian.Uint16(t[off:])) }
+
+	var out []runeRange
+	for s := 0; s < segs; s++ {
+		start, end := u16(starts+2*s), u16(ends+2*s)
+		delta, ro := u16(deltas+2*s), u16(rangeOffs+2*s)
+		for c := start; c <= end && c != 0xffff; c++ {
+			glyph := (c + delta) & 0xffff
+			if ro != 0 {
+				addr := rangeOffs + 2*s + ro + 2*(c-start)
+				if addr+2 > len(t) {
+					break
+				}
+				if glyph = u16(addr); glyph != 0 {
+					glyph = (glyph + delta) & 0xffff
+				}
+			}
+			if glyph == 0 {
+				continue
+			}
+			if k := len(out); k > 0 && out[k-1].hi == rune(c)-1 {
+				out[k-1].hi++
+			} else {
+				out = append(out, runeRange{rune(c), rune(c)})
+			}
+		}
+	}
+	return mergeRanges(out)
+}
+
+// mergeRanges sorts ranges and joins overlapping or adjacent ones.
+func mergeRanges(r []runeRange) []runeRange {
+	sort.Slice(r, func(i, j int) bool { return r[i].lo < r[j].lo })
+	var out []runeRange
+	for _, x := range r {
+		if k := len(out); k > 0 && x.lo <= out[k-1].hi+1 {
+			if x.hi > out[k-1].hi {
+				out[k-1].hi = x.hi
+			}
+			continue
+		}
+		out = append(out, x)
+	}
+	return out
+}
+
+// fillCoverage records the number of mapped characters and lays the
+// printable ones out in blocks of 256 code points for the glyph grid.
+func fillCoverage(info *models.FontInfo, ranges []runeRange) {
+	cells := 0
+	var block *models.GlyphBlock
+	for _, r := range ranges {
+		for c := r.lo; c <= r.hi; c++ {
+			info.Characters++
+			if !unicode.IsPrint(c) && !unicode.Is(unicode.Co, c) {
+				continue // controls, format characters, unassigned
+			}
+			if cells >= maxGlyphCells {
+				info.GridTruncated = true
+				continue
+			}
+			page := c &^ 0xff
+			if block == nil || block.Start != page {
+				info.Blocks = append(info.Blocks, models.GlyphBlock{
+					Start: page,
+					Label: fmt.Sprintf("U+%04X–%04X", page, page+0xff),
+				})
+				block = &info.Blocks[len(info.Blocks)-1]
+			}
+			block.Chars = append(block.Chars, models.Glyph{Char: string(c), Code: fmt.Sprintf("U+%04X", c)})
+			cells++
+		}
+	}
+	info.SampleText = fontSample(ranges)
+}
+
+// fontSample picks specimen text the font can actually show: a pangram for
+// fonts covering basic Latin, otherwise a run of the first characters the
+// font maps.
+func fontSample(ranges []runeRange) string {
+	const pangram = "The quick brown fox jumps over the lazy dog"
+	covered := func(c rune) bool {
+		i := sort.Search(len(ranges), func(i int) bool { return ranges[i].hi >= c })
+		return i < len(ranges) && ranges[i].lo <= c
+	}
+	latin := true
+	for _, c := range pangram {
+		if c != ' ' && !covered(c) {
+			latin = false
+			break
+		}
+	}
+	if latin || len(ranges) == 0 {
+		return pangram
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range ranges {
+		for c := r.lo; c <= r.hi && n < 32; c++ {
+			if unicode.IsGraphic(c) && !unicode.IsSpace(c) {
+				sb.WriteRune(c)
+				n++
+			}
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gileserver/models"
+)
+
+const (
+	// hexBytesPerRow is the number of bytes on one line of the dump.
+	hexBytesPerRow = 16
+	// hexPageSize is the number of bytes shown per page. Only this window
+	// is read from disk, so paging through a multi-gigabyte file is cheap.
+	hexPageSize = 4096
+)
+
+// hexPreview builds one page of the hex dump of the file at fsPath, starting
+// at the offset given by the ?offset= query parameter. It returns nil if the
+// file cannot be read.
+func hexPreview(fsPath string, size int64, offsetParam string) *models.HexDump {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	hd, err := readHexDump(f, size, parseHexOffset(offsetParam, size))
+	if err != nil {
+		return nil
+	}
+	hd.Format = detectFormat(f, size)
+	return hd
+}
+
+// parseHexOffset parses an offset given in decimal or as 0x-prefixed hex,
+// aligns it down to a row boundary and clamps it to the file.
+func parseHexOffset(s string, size int64) int64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	off, err := strconv.ParseInt(s, 0, 64)
+	if err != nil || off < 0 {
+		return 0
+	}
+	if off >= size {
+		off = lastHexPage(size)
+	}
+	return off - off%hexBytesPerRow
+}
+
+// lastHexPage returns the page-aligned offset of the final page of a file.
+func lastHexPage(size int64) int64 {
+	if size <= 0 {
+		return 0
+	}
+	return (size - 1) / hexPageSize * hexPageSize
+}
+
+// readHexDump reads one page starting at offset via ReadAt and formats it.
+func readHexDump(r io.ReaderAt, size, offset int64) (*models.HexDump, error) {
+	buf := make([]byte, hexPageSize)
+	n, err := r.ReadAt(buf, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	buf = buf[:n]
+
+	hd := &models.HexDump{
+		Offset:     offset,
+		End:        offset + int64(n),
+		LastOffset: lastHexPage(size),
+		HasPrev:    offset > 0,
+		HasNext:    offset+int64(n) < size,
+	}
+	hd.PrevOffset = offset - hexPageSize
+	if hd.PrevOffset < 0 {
+		hd.PrevOffset = 0
+	}
+	hd.NextOffset = offset + int64(n)
+
+	// Offsets are at least 8 hex digits wide, more for files past 4 GiB.
+	width := len(strconv.FormatInt(size, 16))
+	if width < 8 {
+		width = 8
+	}
+
+	var hex, ascii strings.Builder
+	for row := 0; row < len(buf); row += hexBytesPerRow {
+		end := row + hexBytesPerRow
+		if end > len(buf) {
+			end = len(buf)
+		}
+		hex.Reset()
+		ascii.Reset()
+		for i := row; i < row+hexBytesPerRow; i++ {
+			if i > row {
+				hex.WriteByte(' ')
+				if i-row == hexBytesPerRow/2 {
+					hex.WriteByte(' ')
+				}
+			}
+			if i >= end {
+				hex.WriteString("  ")
+				continue
+			}
+			b := buf[i]
+			hex.WriteString(fmt.Sprintf("%02x", b))
+			if b >= 0x20 && b < 0x7f {
+				ascii.WriteByte(b)
+			} else {
+				ascii.WriteByte('.')
+			}
+		}
+		hd.Rows = append(hd.Rows, models.HexRow{
+			Offset: fmt.Sprintf("%0*x", width, offset+int64(row)),
+			Hex:    hex.String(),
+			ASCII:  ascii.String(),
+		})
+	}
+	return hd, nil
+}
+
+// ---------------------------------------------------------------------------
+// Magic numbers
+// ---------------------------------------------------------------------------
+
+// magicSignatures are formats recognised by their leading bytes alone.
+var magicSignatures = []struct {
+	magic []byte
+	name  string
+}{
+	{[]byte("\x28\xb5\x2f\xfd"), "Zstandard compressed data"},
+	{[]byte("\xfd7zXZ\x00"), "XZ compressed data"},
+	{[]byte("BZh"), "bzip2 compressed data"},
+	{[]byte("7z\xbc\xaf\x27\x1c"), "7-Zip archive"},
+	{[]byte("Rar!\x1a\x07"), "RAR archive"},
+	{[]byte("PK\x03\x04"), "Zip archive"},
+	{[]byte("\x00asm"), "WebAssembly binary module"},
+	{[]byte("\xcf\xfa\xed\xfe"), "Mach-O 64-bit executable"},
+	{[]byte("\xce\xfa\xed\xfe"), "Mach-O 32-bit executable"},
+	{[]byte("!<arch>\n"), "ar archive"},
+}
+
+// detectFormat identifies common binary formats from their magic numbers and
+// headers. It returns "" for anything it does not recognise.
+func detectFormat(r io.ReaderAt, size int64) string {
+	head := make([]byte, 512)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte("\x7fELF")):
+		return describeELF(r)
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return describePE(r)
+	case bytes.HasPrefix(head, []byte("SQLite format 3\x00")):
+		return describeSQLite(head)
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return describeGzip(r, head, size)
+	}
+	for _, sig := range magicSignatures {
+		if bytes.HasPrefix(head, sig.magic) {
+			return sig.name
+		}
+	}
+	return ""
+}
+
+// describeELF summarises an ELF header, e.g.
+// "ELF 64-bit LSB shared object, x86-64".
+func describeELF(r io.ReaderAt) string {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return "ELF file (malformed header)"
+	}
+	defer f.Close()
+
+	bits := "32-bit"
+	if f.Class == elf.ELFCLASS64 {
+		bits = "64-bit"
+	}
+	order := "LSB"
+	if f.Data == elf.ELFDATA2MSB {
+		order = "MSB"
+	}
+	kind := map[elf.Type]string{
+		elf.ET_REL:  "relocatable object",
+		elf.ET_EXEC: "executable",
+		elf.ET_DYN:  "shared object",
+		elf.ET_CORE: "core dump",
+	}[f.Type]
+	if kind == "" {
+		kind = f.Type.String()
+	}
+	if f.Type == elf.ET_DYN {
+		// Position-independent executables are ET_DYN with an interpreter.
+		for _, p := range f.Progs {
+			if p.Type == elf.PT_INTERP {
+				kind = "position-independent executable"
+				break
+			}
+		}
+	}
+	machine := map[elf.Machine]string{
+		elf.EM_386:       "Intel 80386",
+		elf.EM_X86_64:    "x86-64",
+		elf.EM_ARM:       "ARM",
+		elf.EM_AARCH64:   "AArch64",
+		elf.EM_RISCV:     "RISC-V",
+		elf.EM_MIPS:      "MIPS",
+		elf.EM_PPC:       "PowerPC",
+		elf.EM_PPC64:     "PowerPC64",
+		elf.EM_S390:      "IBM S/390",
+		elf.EM_LOONGARCH: "LoongArch",
+	}[f.Machine]
+	if machine == "" {
+		machine = f.Machine.String()
+	}
+	return fmt.Sprintf("ELF %s %s %s, %s", bits, order, kind, machine)
+}
+
+// describePE summarises a Windows PE header, e.g.
+// "PE32+ executable (console), x86-64". Plain DOS executables have an MZ
+// header but no PE signature.
+func describePE(r io.ReaderAt) string {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return "MS-DOS executable"
+	}
+	defer f.Close()
+
+	format := "PE32"
+	subsystem := uint16(0)
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		subsystem = oh.Subsystem
+	case *pe.OptionalHeader64:
+		format = "PE32+"
+		subsystem = oh.Subsystem
+	}
+	kind := "executable"
+	if f.Characteristics&pe.IMAGE_FILE_DLL != 0 {
+		kind = "DLL"
+	}
+	switch subsystem {
+	case pe.IMAGE_SUBSYSTEM_WINDOWS_GUI:
+		kind += " (GUI)"
+	case pe.IMAGE_SUBSYSTEM_WINDOWS_CUI:
+		kind += " (console)"
+	case pe.IMAGE_SUBSYSTEM_EFI_APPLICATION, pe.IMAGE_SUBSYSTEM_EFI_BOOT_SERVICE_DRIVER, pe.IMAGE_SUBSYSTEM_EFI_RUNTIME_DRIVER:
+		kind += " (EFI)"
+	}
+	machine := map[uint16]string{
+		pe.IMAGE_FILE_MACHINE_I386:  "Intel 80386",
+		pe.IMAGE_FILE_MACHINE_AMD64: "x86-64",
+		pe.IMAGE_FILE_MACHINE_ARM64: "AArch64",
+		pe.IMAGE_FILE_MACHINE_ARMNT: "ARM Thumb-2",
+	}[f.Machine]
+	if machine == "" {
+		machine = fmt.Sprintf("machine 0x%04x", f.Machine)
+	}
+	return fmt.Sprintf("%s %s, %s", format, kind, machine)
+}
+
+// describeSQLite summarises the 100-byte SQLite database header.
+func describeSQLite(head []byte) string {
+	if len(head) < 100 {
+		return "SQLite 3 database"
+	}
+	pageSize := int(binary.BigEndian.Uint16(head[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	pages := binary.BigEndian.Uint32(head[28:32])
+	encoding := map[uint32]string{1: "UTF-8", 2: "UTF-16le", 3: "UTF-16be"}[binary.BigEndian.Uint32(head[56:60])]
+	out := fmt.Sprintf("SQLite 3 database, page size %d", pageSize)
+	if pages > 0 {
+		out += ", " + countNoun(int(pages), "page")
+	}
+	if encoding != "" {
+		out += ", " + encoding
+	}
+	return out
+}
+
+// describeGzip summarises a gzip member header and the trailing ISIZE field.
+func describeGzip(r io.ReaderAt, head []byte, size int64) string {
+	out := "gzip compressed data"
+	if len(head) < 10 {
+		return out
+	}
+	if head[2] == 8 {
+		out += ", deflate"
+	}
+	flags := head[3]
+	pos := 10
+	if flags&0x04 != 0 && len(head) >= pos+2 { // FEXTRA
+		pos += 2 + int(binary.LittleEndian.Uint16(head[pos:pos+2]))
+	}
+	if flags&0x08 != 0 && pos < len(head) { // FNAME
+		if end := bytes.IndexByte(head[pos:], 0); end > 0 {
+			out += fmt.Sprintf(", original name %q", string(head[pos:pos+end]))
+		}
+	}
+	if mtime := binary.LittleEndian.Uint32(head[4:8]); mtime != 0 {
+		out += ", modified " + time.Unix(int64(mtime), 0).UTC().Format("2006-01-02 15:04")
+	}
+	if size >= 18 {
+		// ISIZE is the uncompressed size modulo 2^32 of the last member.
+		var tail [4]byte
+		if _, err := r.ReadAt(tail[:], size-4); err == nil {
+			out += ", uncompressed size " + formatSize(int64(binary.LittleEndian.Uint32(tail[:])))
+		}
+	}
+	return out
+}
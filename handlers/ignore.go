@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gileignoreName is the name of the per-directory exclusion file. Its
+// patterns apply to the directory it is in and everything below, and the
+// file itself is never served.
+const gileignoreName = ".gileignore"
+
+// ignorePattern is one compiled line of gitignore syntax.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool // the line began with "!": it re-includes what it matches
+	dirOnly bool // the line ended with "/": it matches directories only
+}
+
+// compileIgnore compiles gitignore-style lines. Blank lines and comments are
+// skipped.
+func compileIgnore(lines []string) []ignorePattern {
+	var patterns []ignorePattern
+	for _, line := range lines {
+		if p, ok := parseIgnoreLine(line); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// parseIgnoreLine compiles one line of gitignore syntax. A pattern with a
+// slash other than a trailing one is matched against the whole path relative
+// to the directory it belongs to; any other pattern is matched against the
+// name at any depth.
+func parseIgnoreLine(line string) (ignorePattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are dropped unless escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return ignorePattern{}, false
+	}
+
+	var p ignorePattern
+	if line[0] == '!' {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return ignorePattern{}, false
+	}
+
+	anchored := strings.Contains(line, "/")
+	expr := globRegexp(strings.TrimPrefix(line, "/"))
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return ignorePattern{}, false
+	}
+	p.re = re
+	return p, true
+}
+
+// globRegexp translates a gitignore glob to a regular expression. "*" and "?"
+// do not match "/"; "**/" at the start or between slashes matches any number
+// of directories, and a trailing "/**" everything inside.
+func globRegexp(glob string) string {
+	rs := []rune(glob)
+	var b strings.Builder
+	for i := 0; i < len(rs); i++ {
+		rest := string(rs[i:])
+		switch {
+		case strings.HasPrefix(rest, "**/") && (i == 0 || rs[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case rest == "**" && i > 0 && rs[i-1] == '/':
+			b.WriteString(".*")
+			i++
+		case rs[i] == '*':
+			b.WriteString("[^/]*")
+		case rs[i] == '?':
+			b.WriteString("[^/]")
+		case rs[i] == '[':
+			class, end := globClass(rs, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString(class)
+			i = end
+		case rs[i] == '\\' && i+1 < len(rs):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(rs[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(rs[i])))
+		}
+	}
+	return b.String()
+}
+
+// globClass translates the bracket expression starting at rs[i] and returns
+// it with the index of its closing bracket, or -1 when it is not closed, in
+// which case the "[" is literal. "!" or "^" negates the class; a range whose
+// ends are out of order matches nothing.
+func globClass(rs []rune, i int) (string, int) {
+	j := i + 1
+	negate := false
+	if j < len(rs) && (rs[j] == '!' || rs[j] == '^') {
+		negate = true
+		j++
+	}
+	start := j
+	for j < len(rs) && (rs[j] != ']' || j == start) {
+		j++
+	}
+	if j >= len(rs) {
+		return "", -1
+	}
+
+	body := rs[start:j]
+	var items strings.Builder
+	for k := 0; k < len(body); k++ {
+		if k+2 < len(body) && body[k+1] == '-' {
+			if body[k] <= body[k+2] {
+				fmt.Fprintf(&items, `\x{%x}-\x{%x}`, body[k], body[k+2])
+			}
+			k += 2
+			continue
+		}
+		fmt.Fprintf(&items, `\x{%x}`, body[k])
+	}
+	switch {
+	case negate:
+		return "[^/" + items.String() + "]", j
+	case items.Len() == 0:
+		return `[^\x{0}-\x{10ffff}]`, j
+	}
+	return "[" + items.String() + "]", j
+}
+
+// matchIgnore applies patterns to the path rel in order, the last match
+// deciding, and returns whether rel is ignored. ignored is the result of any
+// earlier patterns.
+func matchIgnore(patterns []ignorePattern, rel string, isDir, ignored bool) bool {
+	for _, p := range patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// gileignores caches parsed .gileignore files by directory.
+var gileignores struct {
+	mu    sync.Mutex
+	files map[string]*cachedIgnore
+}
+
+// cachedIgnore is a parsed .gileignore file and the state of the file it was
+// parsed from.
+type cachedIgnore struct {
+	modTime  time.Time
+	size     int64
+	patterns []ignorePattern
+}
+
+// loadGileignore returns the patterns of the .gileignore file in fsDir, or
+// nil when there is none. A parsed file is reused until it changes on disk.
+func loadGileignore(fsDir string) []ignorePattern {
+	p := filepath.Join(fsDir, gileignoreName)
+	fi, err := os.Stat(p)
+	if err != nil || fi.IsDir() {
+		gileignores.mu.Lock()
+		delete(gileignores.files, fsDir)
+		gileignores.mu.Unlock()
+		return nil
+	}
+
+	gileignores.mu.Lock()
+	c, ok := gileignores.files[fsDir]
+	gileignores.mu.Unlock()
+	if ok && c.modTime.Equal(fi.ModTime()) && c.size == fi.Size() {
+		return c.patterns
+	}
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil
+	}
+	c = &cachedIgnore{
+		modTime:  fi.ModTime(),
+		size:     fi.Size(),
+		patterns: compileIgnore(strings.Split(string(data), "\n")),
+	}
+	gileignores.mu.Lock()
+	if gileignores.files == nil {
+		gileignores.files = make(map[string]*cachedIgnore)
+	}
+	gileignores.files[fsDir] = c
+	gileignores.mu.Unlock()
+	return c.patterns
+}
+
+// ignoreScope holds the exclusion rules in force in one directory of a root:
+// the root's own patterns and the .gileignore files from the root down to
+// the directory. Walks carry one down the tree with child. A nil
+// *ignoreScope excludes nothing.
+type ignoreScope struct {
+	rc    *rootConfig
+	dir   string       // root-relative slash path of the directory, "" at the root
+	files []ignoreFile // .gileignore files in force, outermost first
+}
+
+// ignoreFile is a .gileignore file in force in a scope.
+type ignoreFile struct {
+	dir      string // root-relative directory of the file
+	patterns []ignorePattern
+}
+
+// newIgnoreScope returns the scope of the root directory fsRoot, whose
+// settings are rc.
+func newIgnoreScope(rc *rootConfig, fsRoot string) *ignoreScope {
+	s := &ignoreScope{rc: rc}
+	if patterns := loadGileignore(fsRoot); patterns != nil {
+		s.files = []ignoreFile{{patterns: patterns}}
+	}
+	return s
+}
+
+// scopeFor returns the scope of the directory at urlPath, or nil when its
+// root is unknown.
+func scopeFor(roots map[string]string, urlPath string) *ignoreScope {
+	name := rootOf(urlPath)
+	fsRoot, ok := roots[name]
+	if !ok {
+		return nil
+	}
+	s := newIgnoreScope(rootSettings(name), fsRoot)
+	fsDir := fsRoot
+	rel := strings.TrimPrefix(strings.TrimPrefix(urlPath, "/"+name), "/")
+	for _, elem := range strings.Split(rel, "/") {
+		if elem != "" {
+			fsDir = filepath.Join(fsDir, elem)
+			s = s.child(elem, fsDir)
+		}
+	}
+	return s
+}
+
+// child returns the scope of the subdirectory name, found on disk at fsDir.
+func (s *ignoreScope) child(name, fsDir string) *ignoreScope {
+	if s == nil {
+		return nil
+	}
+	c := &ignoreScope{rc: s.rc, dir: path.Join(s.dir, name), files: s.files}
+	if patterns := loadGileignore(fsDir); patterns != nil {
+		// Cap the slice so siblings never share the appended element.
+		c.files = append(c.files[:len(c.files):len(c.files)], ignoreFile{dir: c.dir, patterns: patterns})
+	}
+	return c
+}
+
+// excluded reports whether the entry name in the scope's directory is not
+// served. The root's Exclude patterns, which come from the server's
+// configuration, cannot be overridden; among the .gileignore files a deeper
+// one overrides those above it.
+func (s *ignoreScope) excluded(name string, isDir bool) bool {
+	if s == nil {
+		return false
+	}
+	if name == gileignoreName {
+		return true
+	}
+	rel := path.Join(s.dir, name)
+	if s.rc != nil && matchIgnore(s.rc.exclude, rel, isDir, false) {
+		return true
+	}
+	ignored := false
+	for _, f := range s.files {
+		sub := rel
+		if f.dir != "" {
+			sub = strings.TrimPrefix(rel, f.dir+"/")
+		}
+		ignored = matchIgnore(f.patterns, sub, isDir, ignored)
+	}
+	return ignored
+}
+
+// omitted reports whether the entry name in the scope's directory is left
+// out of listings, search and ZIP archives: it is hidden or excluded.
+func (s *ignoreScope) omitted(name string, isDir bool) bool {
+	if s == nil {
+		return false
+	}
+	if s.rc != nil && matchIgnore(s.rc.hidden, path.Join(s.dir, name), isDir, false) {
+		return true
+	}
+	return s.excluded(name, isDir)
+}
+
+// excludedPath reports whether the root-relative path rel, or any directory
+// on the way to it, is excluded from the root at fsRoot.
+func excludedPath(rc *rootConfig, fsRoot, rel string) bool {
+	if rel == "" || rel == "." {
+		return false
+	}
+	elems := strings.Split(rel, "/")
+	s := newIgnoreScope(rc, fsRoot)
+	fsDir := fsRoot
+	for i, elem := range elems {
+		fsDir = filepath.Join(fsDir, elem)
+		last := i == len(elems)-1
+		isDir := !last
+		if last {
+			fi, err := os.Stat(fsDir)
+			isDir = err == nil && fi.IsDir()
+		}
+		if s.excluded(elem, isDir) {
+			return true
+		}
+		if !last {
+			s = s.child(elem, fsDir)
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Helpers shared by the hand-written lightweight-markup renderers
+// (reStructuredText and AsciiDoc). Both build HTML directly and hand the
+// finished document to sanitizeHTML, exactly like the Markdown and Org paths.
+
+// headingSlugger assigns unique, URL-friendly id attributes to headings in a
+// single document, following the same lowercase-and-dash scheme that
+// goldmark's auto heading IDs use.
+type headingSlugger struct {
+	seen map[string]int
+}
+
+func (s *headingSlugger) slug(text string) string {
+	if s.seen == nil {
+		s.seen = make(map[string]int)
+	}
+	base := slugify(text)
+	if base == "" {
+		base = "section"
+	}
+	n := s.seen[base]
+	s.seen[base] = n + 1
+	if n == 0 {
+		return base
+	}
+	return base + "-" + strconv.Itoa(n)
+}
+
+// slugify lowercases text and replaces every run of non-alphanumeric
+// characters with a single dash.
+func slugify(text string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// markupHeading renders an <hN> element. level is clamped to 1–6.
+func markupHeading(level int, id, inner string) string {
+	if level < 1 {
+		level = 1
+	}
+	if level > 6 {
+		level = 6
+	}
+	n := strconv.Itoa(level)
+	return "<h" + n + ` id="` + htmlAttrEscape(id) + `">` + inner + "</h" + n + ">\n"
+}
+
+// admonitionTitles maps admonition kinds to their display titles.
+var admonitionTitles = map[string]string{
+	"note":      "Note",
+	"tip":       "Tip",
+	"hint":      "Hint",
+	"important": "Important",
+	"warning":   "Warning",
+	"caution":   "Caution",
+	"danger":    "Danger",
+	"attention": "Attention",
+	"error":     "Error",
+	"seealso":   "See also",
+}
+
+// markupAdmonition renders a callout box. kind selects the CSS variant and
+// title is already-rendered inline HTML; when empty the kind's default
+// title is used.
+func markupAdmonition(kind, title, body string) string {
+	if title == "" {
+		title = admonitionTitles[kind]
+	}
+	if title == "" {
+		title = template.HTMLEscapeString(kind)
+	}
+	return `<div class="admonition admonition-` + htmlAttrEscape(kind) + `"><p class="admonition-title">` +
+		title + "</p>\n" + body + "</div>\n"
+}
+
+// markupLiteral renders text verbatim in a <pre> block.
+func markupLiteral(text, class string) string {
+	open := "<pre>"
+	if class != "" {
+		open = `<pre class="` + class + `">`
+	}
+	return open + template.HTMLEscapeString(text) + "</pre>\n"
+}
+
+// markupUnsupported renders source that the renderer does not understand as
+// a visible literal block, so one unknown construct degrades locally instead
+// of failing the whole document.
+func markupUnsupported(source string) string {
+	return markupLiteral(source, "unsupported-directive")
+}
+
+// markupImage renders an <img> tag. Relative sources are later rewritten to
+// /view/ by rewriteImgSrcURLs inside sanitizeHTML.
+func markupImage(src, alt, width string) string {
+	out := `<img src="` + htmlAttrEscape(src) + `" alt="` + htmlAttrEscape(alt) + `"`
+	if width != "" {
+		out += ` width="` + htmlAttrEscape(strings.TrimSuffix(width, "px")) + `"`
+	}
+	return out + " />"
+}
+
+// markupTable renders a table from already-rendered cell HTML. The first
+// headerRows rows go into <thead>.
+func markupTable(caption string, rows [][]string, headerRows int) string {
+	var b strings.Builder
+	b.WriteString("<table>")
+	if caption != "" {
+		b.WriteString("<caption>" + caption + "</caption>")
+	}
+	if headerRows > len(rows) {
+		headerRows = len(rows)
+	}
+	if headerRows > 0 {
+		b.WriteString("<thead>")
+		for _, row := range rows[:headerRows] {
+			b.WriteString("<tr>")
+			for _, c := range row {
+				b.WriteString("<th>" + c + "</th>")
+			}
+			b.WriteString("</tr>")
+		}
+		b.WriteString("</thead>")
+	}
+	b.WriteString("<tbody>")
+	for _, row := range rows[headerRows:] {
+		b.WriteString("<tr>")
+		for _, c := range row {
+			b.WriteString("<td>" + c + "</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString("</tbody></table>\n")
+	return b.String()
+}
+
+// unwrapParagraph strips the <p> wrapper from HTML consisting of exactly
+// one paragraph, so tight list items and table cells do not gain margins.
+func unwrapParagraph(html string) string {
+	s := strings.TrimSpace(html)
+	if strings.HasPrefix(s, "<p>") && strings.HasSuffix(s, "</p>") && strings.Count(s, "<p>") == 1 {
+		return s[3 : len(s)-4]
+	}
+	return s
+}
+
+// indentOf returns the number of leading spaces in line.
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// isBlank reports whether line contains only whitespace.
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// dedentLines removes the smallest common indentation from the non-blank
+// lines and trims leading and trailing blank lines.
+func dedentLines(lines []string) []string {
+	for len(lines) > 0 && isBlank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && isBlank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	min := -1
+	for _, l := range lines {
+		if isBlank(l) {
+			continue
+		}
+		if n := indentOf(l); min < 0 || n < min {
+			min = n
+		}
+	}
+	out := make([]string, len(lines))
+	for i, l := range lines {
+		if len(l) >= min && min > 0 {
+			out[i] = l[min:]
+		} else {
+			out[i] = strings.TrimLeft(l, " ")
+		}
+	}
+	return out
+}
+
+// splitMarkupLines normalises line endings and expands tabs to the next
+// multiple of tabWidth so that indentation can be measured in columns.
+func splitMarkupLines(content string, tabWidth int) []string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.ReplaceAll(content, "\r", "\n")
+	lines := strings.Split(content, "\n")
+	for i, l := range lines {
+		if !strings.Contains(l, "\t") {
+			lines[i] = strings.TrimRight(l, " ")
+			continue
+		}
+		var b strings.Builder
+		col := 0
+		for _, r := range l {
+			if r == '\t' {
+				n := tabWidth - col%tabWidth
+				b.WriteString(strings.Repeat(" ", n))
+				col += n
+				continue
+			}
+			b.WriteRune(r)
+			col++
+		}
+		lines[i] = strings.TrimRight(b.String(), " ")
+	}
+	return lines
+}
@@ -0,0 +1,850 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A small TeX-to-MathML translator for the math found in design documents:
+// sub- and superscripts, fractions, roots, Greek letters and the usual
+// operator and relation symbols, big operators with limits, accents,
+// \left…\right fences, font commands, \text, and the matrix, cases and
+// align-style environments. The output is presentation MathML, which every
+// current browser renders natively, so no client-side script is needed.
+//
+// Commands the translator does not know are kept visible as <merror>
+// elements rather than failing the whole expression; only structural errors
+// (unbalanced braces, a missing \right) reject an expression.
+
+// maxMathSource caps the length of a single expression.
+const maxMathSource = 16 * 1024
+
+// maxMathDepth caps group nesting so hostile input cannot exhaust the stack.
+const maxMathDepth = 64
+
+var errMathSyntax = errors.New("malformed TeX expression")
+
+// renderMath converts a TeX expression to a <math> element. When the
+// expression cannot be translated the original source is returned in a
+// <code class="math-error"> element so the reader still sees it.
+func renderMath(tex string, display bool) string {
+	out, err := latexToMathML(tex, display)
+	if err != nil {
+		delim := "$"
+		if display {
+			delim = "$$"
+		}
+		return `<code class="math-error">` + template.HTMLEscapeString(delim+tex+delim) + `</code>`
+	}
+	if display {
+		return `<div class="math-display">` + out + `</div>`
+	}
+	return out
+}
+
+// latexToMathML translates tex to a MathML <math> element. The TeX source is
+// kept in an annotation so that copying the formula yields the original.
+func latexToMathML(tex string, display bool) (string, error) {
+	if len(tex) > maxMathSource {
+		return "", errMathSyntax
+	}
+	p := &texParser{src: tex, display: display}
+	body := p.list(func(t texToken) bool { return false })
+	if p.err != nil {
+		return "", p.err
+	}
+	if t := p.peek(); t.kind != texEOF {
+		return "", errMathSyntax
+	}
+	mode := ""
+	if display {
+		mode = ` display="block"`
+	}
+	return "<math" + mode + "><semantics>" + mrow(body) +
+		`<annotation encoding="application/x-tex">` + template.HTMLEscapeString(strings.TrimSpace(tex)) +
+		"</annotation></semantics></math>", nil
+}
+
+type texTokenKind int
+
+const (
+	texEOF texTokenKind = iota
+	texCommand
+	texChar
+	texNumber
+)
+
+type texToken struct {
+	kind texTokenKind
+	text string // command name without the backslash, the character, or the digits
+}
+
+type texParser struct {
+	src     string
+	pos     int
+	display bool
+	font    string // active \mathbf-style alphabet, "" for the default
+	depth   int
+	err     error
+}
+
+// fail records the first structural error.
+func (p *texParser) fail() {
+	if p.err == nil {
+		p.err = errMathSyntax
+	}
+}
+
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		case '%':
+			// A comment runs to the end of the line.
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// lex reads the token at p.pos without consuming it and returns it with the
+// position just past it.
+func (p *texParser) lex() (texToken, int) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return texToken{kind: texEOF}, p.pos
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '\\':
+		end := p.pos + 1
+		for end < len(p.src) && isASCIILetter(p.src[end]) {
+			end++
+		}
+		if end == p.pos+1 && end < len(p.src) {
+			// Control symbol such as \, \{ or \\.
+			_, size := utf8.DecodeRuneInString(p.src[end:])
+			end += size
+		}
+		return texToken{kind: texCommand, text: p.src[p.pos+1 : end]}, end
+	case c >= '0' && c <= '9':
+		end := p.pos
+		for end < len(p.src) && (p.src[end] >= '0' && p.src[end] <= '9' ||
+			p.src[end] == '.' && end+1 < len(p.src) && p.src[end+1] >= '0' && p.src[end+1] <= '9') {
+			end++
+		}
+		return texToken{kind: texNumber, text: p.src[p.pos:end]}, end
+	}
+	_, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	return texToken{kind: texChar, text: p.src[p.pos : p.pos+size]}, p.pos + size
+}
+
+func (p *texParser) peek() texToken {
+	t, _ := p.lex()
+	return t
+}
+
+func (p *texParser) next() texToken {
+	t, end := p.lex()
+	p.pos = end
+	return t
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// mrow wraps items in an <mrow> unless there is exactly one.
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+func mo(s string) string { return "<mo>" + template.HTMLEscapeString(s) + "</mo>" }
+func mi(s string) string { return "<mi>" + template.HTMLEscapeString(s) + "</mi>" }
+
+// mathAtom is one parsed element together with how scripts attach to it.
+type mathAtom struct {
+	html   string
+	limits bool // scripts go above and below (\sum, \lim in display mode)
+}
+
+// list parses atoms until stop reports true for the next token, the input
+// ends, or a closing brace is reached.
+func (p *texParser) list(stop func(texToken) bool) []string {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxMathDepth {
+		p.fail()
+		return nil
+	}
+	var out []string
+	for p.err == nil {
+		t := p.peek()
+		if t.kind == texEOF || (t.kind == texChar && t.text == "}") || stop(t) {
+			break
+		}
+		if t.kind == texCommand && (t.text == "limits" || t.text == "nolimits" || t.text == "displaystyle" || t.text == "textstyle") {
+			p.next()
+			continue
+		}
+		a, ok := p.atom()
+		if !ok {
+			continue
+		}
+		out = append(out, p.scripts(a))
+	}
+	return out
+}
+
+// scripts attaches any following ^, _ and ' scripts to a.
+func (p *texParser) scripts(a mathAtom) string {
+	var sub, sup string
+scan:
+	for p.err == nil {
+		t := p.peek()
+		if t.kind != texChar {
+			break
+		}
+		switch t.text {
+		case "_":
+			p.next()
+			if sub != "" {
+				p.fail()
+			}
+			sub = p.arg()
+		case "^":
+			p.next()
+			if sup != "" {
+				p.fail()
+			}
+			sup = p.arg()
+		case "'":
+			p.next()
+			primes := "′"
+			for p.peek().text == "'" {
+				p.next()
+				primes += "′"
+			}
+			sup = mo(primes)
+		default:
+			break scan
+		}
+	}
+	switch {
+	case sub == "" && sup == "":
+		return a.html
+	case a.limits && sub != "" && sup != "":
+		return "<munderover>" + a.html + sub + sup + "</munderover>"
+	case a.limits && sub != "":
+		return "<munder>" + a.html + sub + "</munder>"
+	case a.limits:
+		return "<mover>" + a.html + sup + "</mover>"
+	case sub != "" && sup != "":
+		return "<msubsup>" + a.html + sub + sup + "</msubsup>"
+	case sub != "":
+		return "<msub>" + a.html + sub + "</msub>"
+	}
+	return "<msup>" + a.html + sup + "</msup>"
+}
+
+// arg parses a single argument: a braced group or one token.
+func (p *texParser) arg() string {
+	t := p.peek()
+	switch {
+	case t.kind == texEOF:
+		p.fail()
+		return ""
+	case t.kind == texChar && t.text == "{":
+		p.next()
+		items := p.list(func(texToken) bool { return false })
+		p.expect("}")
+		return mrow(items)
+	case t.kind == texNumber && len(t.text) > 1:
+		// In x^23 only the 2 is the superscript.
+		p.pos = p.pos + 1
+		p.skipSpace()
+		return p.number(t.text[:1])
+	}
+	a, ok := p.atom()
+	if !ok {
+		return "<mrow></mrow>"
+	}
+	return a.html
+}
+
+// expect consumes the character s or records an error.
+func (p *texParser) expect(s string) {
+	if t := p.next(); t.kind != texChar || t.text != s {
+		p.fail()
+	}
+}
+
+// rawGroup returns the unparsed text of a braced group, used by \text and
+// environment names.
+func (p *texParser) rawGroup() string {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '{' {
+		t := p.next()
+		return t.text
+	}
+	depth := 0
+	start := p.pos + 1
+	for i := p.pos; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				p.pos = i + 1
+				return p.src[start:i]
+			}
+		}
+	}
+	p.fail()
+	return ""
+}
+
+// optional returns the contents of an optional [..] argument, if present.
+func (p *texParser) optional() (string, bool) {
+	if t := p.peek(); t.kind != texChar || t.text != "[" {
+		return "", false
+	}
+	p.next()
+	items := p.list(func(t texToken) bool { return t.kind == texChar && t.text == "]" })
+	p.expect("]")
+	return mrow(items), true
+}
+
+func (p *texParser) number(s string) string {
+	if p.font != "" {
+		return mi(mathAlphabet(s, p.font))
+	}
+	return "<mn>" + s + "</mn>"
+}
+
+// atom parses one element. ok is false when the token produced no output
+// (for example a stray \right, which is reported as an error).
+func (p *texParser) atom() (mathAtom, bool) {
+	t := p.next()
+	switch t.kind {
+	case texNumber:
+		return mathAtom{html: p.number(t.text)}, true
+	case texChar:
+		return p.char(t.text)
+	case texCommand:
+		return p.command(t.text)
+	}
+	p.fail()
+	return mathAtom{}, false
+}
+
+func (p *texParser) char(c string) (mathAtom, bool) {
+	switch c {
+	case "{":
+		items := p.list(func(texToken) bool { return false })
+		p.expect("}")
+		return mathAtom{html: mrow(items)}, true
+	case "}", "&", "^", "_":
+		p.fail()
+		return mathAtom{}, false
+	case "~":
+		return mathAtom{html: `<mspace width="0.33em"></mspace>`}, true
+	case "-":
+		return mathAtom{html: mo("−")}, true
+	case "*":
+		return mathAtom{html: mo("∗")}, true
+	case "'":
+		return mathAtom{html: mo("′")}, true
+	}
+	r, _ := utf8.DecodeRuneInString(c)
+	if unicode.IsLetter(r) {
+		switch p.font {
+		case "":
+			return mathAtom{html: mi(c)}, true
+		case "normal":
+			return mathAtom{html: `<mi mathvariant="normal">` + template.HTMLEscapeString(c) + "</mi>"}, true
+		}
+		return mathAtom{html: mi(mathAlphabet(c, p.font))}, true
+	}
+	return mathAtom{html: mo(c)}, true
+}
+
+func (p *texParser) command(name string) (mathAtom, bool) {
+	if s, ok := texIdentifiers[name]; ok {
+		if p.font == "normal" || p.font == "bold" {
+			// Upright or bold Greek: an explicit variant keeps the
+			// single-character <mi> from being italicised.
+			return mathAtom{html: `<mi mathvariant="` + p.font + `">` + s + "</mi>"}, true
+		}
+		return mathAtom{html: mi(s)}, true
+	}
+	if s, ok := texOperators[name]; ok {
+		return mathAtom{html: mo(s)}, true
+	}
+	if s, ok := texBigOperators[name]; ok {
+		limits := p.display && !strings.Contains(name, "int")
+		attrs := ` largeop="true"`
+		if limits {
+			attrs += ` movablelimits="true"`
+		}
+		return mathAtom{html: "<mo" + attrs + ">" + s + "</mo>", limits: limits}, true
+	}
+	if texFunctions[name] {
+		return mathAtom{html: mi(name) + mo("⁡")}, true
+	}
+	if texLimitFunctions[name] {
+		label := name
+		switch name {
+		case "liminf":
+			label = "lim inf"
+		case "limsup":
+			label = "lim sup"
+		}
+		return mathAtom{html: "<mo movablelimits=\"true\">" + label + "</mo>", limits: p.display}, true
+	}
+	if w, ok := texSpaces[name]; ok {
+		return mathAtom{html: `<mspace width="` + w + `"></mspace>`}, true
+	}
+	if a, ok := texAccents[name]; ok {
+		base := p.arg()
+		return mathAtom{html: `<mover accent="true">` + base + `<mo stretchy="` + a.stretchy + `">` + a.char + "</mo></mover>"}, true
+	}
+	if f, ok := texFonts[name]; ok {
+		saved := p.font
+		p.font = f
+		html := p.arg()
+		p.font = saved
+		return mathAtom{html: html}, true
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac", "cfrac":
+		num := p.arg()
+		den := p.arg()
+		return mathAtom{html: "<mfrac>" + num + den + "</mfrac>"}, true
+	case "binom", "dbinom", "tbinom":
+		top := p.arg()
+		bottom := p.arg()
+		return mathAtom{html: "<mrow>" + mo("(") + `<mfrac linethickness="0">` + top + bottom + "</mfrac>" + mo(")") + "</mrow>"}, true
+	case "sqrt":
+		if idx, ok := p.optional(); ok {
+			base := p.arg()
+			return mathAtom{html: "<mroot>" + base + idx + "</mroot>"}, true
+		}
+		return mathAtom{html: "<msqrt>" + p.arg() + "</msqrt>"}, true
+	case "text", "textrm", "textup", "textnormal", "mbox", "textit", "textbf", "texttt", "textsf":
+		s := strings.ReplaceAll(p.rawGroup(), `\ `, " ")
+		s = strings.NewReplacer(`\{`, "{", `\}`, "}", `\$`, "$", `\%`, "%", `\&`, "&", `\_`, "_", `\#`, "#").Replace(s)
+		return mathAtom{html: "<mtext>" + template.HTMLEscapeString(s) + "</mtext>"}, true
+	case "operatorname":
+		s := p.rawGroup()
+		return mathAtom{html: mi(strings.TrimSpace(s)) + mo("⁡")}, true
+	case "overline":
+		return mathAtom{html: `<mover accent="true">` + p.arg() + `<mo stretchy="true">‾</mo></mover>`}, true
+	case "underline":
+		return mathAtom{html: `<munder accentunder="true">` + p.arg() + `<mo stretchy="true">_</mo></munder>`}, true
+	case "overbrace":
+		return mathAtom{html: `<mover>` + p.arg() + `<mo stretchy="true">⏞</mo></mover>`, limits: true}, true
+	case "underbrace":
+		return mathAtom{html: `<munder>` + p.arg() + `<mo stretchy="true">⏟</mo></munder>`, limits: true}, true
+	case "stackrel", "overset":
+		over := p.arg()
+		base := p.arg()
+		return mathAtom{html: "<mover>" + base + over + "</mover>"}, true
+	case "underset":
+		under := p.arg()
+		base := p.arg()
+		return mathAtom{html: "<munder>" + base + under + "</munder>"}, true
+	case "left":
+		return p.fenced()
+	case "right", "middle", "end", "\\", "cr":
+		p.fail()
+		return mathAtom{}, false
+	case "big", "Big", "bigg", "Bigg", "bigl", "bigr", "Bigl", "Bigr", "biggl", "biggr", "Biggl", "Biggr":
+		d := p.delimiter()
+		return mathAtom{html: `<mo stretchy="false">` + template.HTMLEscapeString(d) + "</mo>"}, true
+	case "begin":
+		return p.environment(p.rawGroup())
+	case "not":
+		a, ok := p.atom()
+		if !ok {
+			return a, false
+		}
+		// Overlay a combining long solidus on the negated relation.
+		if strings.HasSuffix(a.html, "</mo>") {
+			a.html = strings.TrimSuffix(a.html, "</mo>") + "\u0338</mo>"
+		}
+		return a, true
+	case "mathop":
+		return mathAtom{html: p.arg(), limits: p.display}, true
+	case "color", "textcolor":
+		// Colour is presentation only; keep the content.
+		p.rawGroup()
+		if name == "textcolor" {
+			return mathAtom{html: p.arg()}, true
+		}
+		return mathAtom{}, false
+	case "tag", "label", "nonumber", "notag":
+		if name == "tag" || name == "label" {
+			p.rawGroup()
+		}
+		return mathAtom{}, false
+	}
+	if len(name) == 1 && !isASCIILetter(name[0]) {
+		// Escaped punctuation: \{ \} \$ \% \# \& \_ \|
+		switch name {
+		case "|":
+			return mathAtom{html: mo("‖")}, true
+		case "{", "}":
+			return mathAtom{html: mo(name)}, true
+		}
+		return mathAtom{html: mi(name)}, true
+	}
+	return mathAtom{html: "<merror><mtext>" + template.HTMLEscapeString(`\`+name) + "</mtext></merror>"}, true
+}
+
+// delimiter reads the delimiter following \left, \right or \big.
+func (p *texParser) delimiter() string {
+	t := p.next()
+	switch t.kind {
+	case texChar:
+		if t.text == "." {
+			return ""
+		}
+		return t.text
+	case texCommand:
+		if s, ok := texOperators[t.text]; ok {
+			return s
+		}
+		switch t.text {
+		case "{", "}":
+			return t.text
+		case "|":
+			return "‖"
+		}
+	}
+	p.fail()
+	return ""
+}
+
+// fenced parses \left<d> … \right<d>, with optional \middle delimiters.
+func (p *texParser) fenced() (mathAtom, bool) {
+	open := p.delimiter()
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if open != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + template.HTMLEscapeString(open) + "</mo>")
+	}
+	for p.err == nil {
+		items := p.list(func(t texToken) bool {
+			return t.kind == texCommand && (t.text == "right" || t.text == "middle")
+		})
+		b.WriteString(mrow(items))
+		t := p.next()
+		if t.kind != texCommand {
+			p.fail()
+			break
+		}
+		d := p.delimiter()
+		if t.text == "middle" {
+			b.WriteString(`<mo stretchy="true">` + template.HTMLEscapeString(d) + "</mo>")
+			continue
+		}
+		if d != "" {
+			b.WriteString(`<mo fence="true" stretchy="true">` + template.HTMLEscapeString(d) + "</mo>")
+		}
+		break
+	}
+	b.WriteString("</mrow>")
+	return mathAtom{html: b.String()}, true
+}
+
+// texMatrixFences maps matrix environments to their surrounding delimiters.
+var texMatrixFences = map[string][2]string{
+	"matrix":      {"", ""},
+	"smallmatrix": {"", ""},
+	"pmatrix":     {"(", ")"},
+	"bmatrix":     {"[", "]"},
+	"Bmatrix":     {"{", "}"},
+	"vmatrix":     {"|", "|"},
+	"Vmatrix":     {"‖", "‖"},
+	"cases":       {"{", ""},
+	"dcases":      {"{", ""},
+	"rcases":      {"", "}"},
+	"array":       {"", ""},
+	"aligned":     {"", ""},
+	"alignedat":   {"", ""},
+	"align":       {"", ""},
+	"align*":      {"", ""},
+	"alignat":     {"", ""},
+	"alignat*":    {"", ""},
+	"split":       {"", ""},
+	"gather":      {"", ""},
+	"gather*":     {"", ""},
+	"gathered":    {"", ""},
+	"eqnarray":    {"", ""},
+	"eqnarray*":   {"", ""},
+}
+
+// environment parses \begin{name} … \end{name}.
+func (p *texParser) environment(name string) (mathAtom, bool) {
+	switch name {
+	case "equation", "equation*", "displaymath", "math":
+		items := p.list(func(t texToken) bool { return t.kind == texCommand && t.text == "end" })
+		p.endEnvironment(name)
+		return mathAtom{html: mrow(items)}, true
+	}
+	fences, ok := texMatrixFences[name]
+	if !ok {
+		p.fail()
+		return mathAtom{}, false
+	}
+	if name == "array" || strings.HasPrefix(name, "alignat") {
+		p.rawGroup() // column specification or column count
+	}
+
+	isCell := func(t texToken) bool {
+		return (t.kind == texChar && t.text == "&") ||
+			(t.kind == texCommand && (t.text == "\\" || t.text == "cr" || t.text == "end"))
+	}
+	var rows [][]string
+	row := []string{}
+	for p.err == nil {
+		if t := p.peek(); t.kind == texCommand && t.text == "hline" {
+			p.next()
+			continue
+		}
+		row = append(row, "<mtd>"+mrow(p.list(isCell))+"</mtd>")
+		t := p.peek()
+		if t.kind == texChar && t.text == "&" {
+			p.next()
+			continue
+		}
+		rows = append(rows, row)
+		row = []string{}
+		if t.kind != texCommand || t.text == "end" {
+			break
+		}
+		p.next() // \\ or \cr
+		// Drop an optional [2pt] spacing argument after \\.
+		if t := p.peek(); t.kind == texChar && t.text == "[" {
+			p.optional()
+		}
+	}
+	p.endEnvironment(name)
+	// A trailing \\ leaves one empty row behind.
+	if n := len(rows); n > 1 && len(rows[n-1]) == 1 && rows[n-1][0] == "<mtd><mrow></mrow></mtd>" {
+		rows = rows[:n-1]
+	}
+
+	var b strings.Builder
+	b.WriteString("<mrow>")
+	if fences[0] != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + template.HTMLEscapeString(fences[0]) + "</mo>")
+	}
+	attrs := ""
+	switch {
+	case strings.Contains(name, "cases"):
+		attrs = ` columnalign="left"`
+	case strings.HasPrefix(name, "align") || name == "split" || strings.HasPrefix(name, "eqnarray"):
+		attrs = ` columnalign="right left right left right left"`
+	}
+	b.WriteString("<mtable" + attrs + ">")
+	for _, r := range rows {
+		b.WriteString("<mtr>" + strings.Join(r, "") + "</mtr>")
+	}
+	b.WriteString("</mtable>")
+	if fences[1] != "" {
+		b.WriteString(`<mo fence="true" stretchy="true">` + template.HTMLEscapeString(fences[1]) + "</mo>")
+	}
+	b.WriteString("</mrow>")
+	return mathAtom{html: b.String()}, true
+}
+
+// endEnvironment consumes \end{name}.
+func (p *texParser) endEnvironment(name string) {
+	if t := p.next(); t.kind != texCommand || t.text != "end" {
+		p.fail()
+		return
+	}
+	if got := p.rawGroup(); got != name {
+		p.fail()
+	}
+}
+
+// mathAlphabet maps ASCII letters and digits in s to the Unicode
+// mathematical alphanumeric symbols for font. Characters without a styled
+// form are returned unchanged.
+func mathAlphabet(s, font string) string {
+	a, ok := mathAlphabets[font]
+	if !ok {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if h, ok := a.holes[r]; ok {
+			b.WriteRune(h)
+			continue
+		}
+		switch {
+		case r >= 'A' && r <= 'Z' && a.upper != 0:
+			b.WriteRune(a.upper + r - 'A')
+		case r >= 'a' && r <= 'z' && a.lower != 0:
+			b.WriteRune(a.lower + r - 'a')
+		case r >= '0' && r <= '9' && a.digit != 0:
+			b.WriteRune(a.digit + r - '0')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+type mathAlphabetRange struct {
+	upper, lower, digit rune
+	holes               map[rune]rune // letters that predate the block and live in Letterlike Symbols
+}
+
+var mathAlphabets = map[string]mathAlphabetRange{
+	"bold":   {upper: 0x1D400, lower: 0x1D41A, digit: 0x1D7CE},
+	"italic": {upper: 0x1D434, lower: 0x1D44E, holes: map[rune]rune{'h': 'ℎ'}},
+	"bb": {upper: 0x1D538, lower: 0x1D552, digit: 0x1D7D8, holes: map[rune]rune{
+		'C': 'ℂ', 'H': 'ℍ', 'N': 'ℕ', 'P': 'ℙ', 'Q': 'ℚ', 'R': 'ℝ', 'Z': 'ℤ'}},
+	"cal": {upper: 0x1D49C, lower: 0x1D4B6, holes: map[rune]rune{
+		'B': 'ℬ', 'E': 'ℰ', 'F': 'ℱ', 'H': 'ℋ', 'I': 'ℐ', 'L': 'ℒ', 'M': 'ℳ', 'R': 'ℛ',
+		'e': 'ℯ', 'g': 'ℊ', 'o': 'ℴ'}},
+	"frak": {upper: 0x1D504, lower: 0x1D51E, holes: map[rune]rune{
+		'C': 'ℭ', 'H': 'ℌ', 'I': 'ℑ', 'R': 'ℜ', 'Z': 'ℨ'}},
+	"sf": {upper: 0x1D5A0, lower: 0x1D5BA, digit: 0x1D7E2},
+	"tt": {upper: 0x1D670, lower: 0x1D68A, digit: 0x1D7F6},
+}
+
+// texFonts maps font commands to mathAlphabets keys; "normal" is upright.
+var texFonts = map[string]string{
+	"mathrm": "normal", "mathup": "normal", "rm": "normal",
+	"mathbf": "bold", "bf": "bold", "boldsymbol": "bold", "bm": "bold",
+	"mathit": "italic", "it": "italic",
+	"mathbb": "bb", "Bbb": "bb",
+	"mathcal": "cal", "mathscr": "cal",
+	"mathfrak": "frak",
+	"mathsf":   "sf",
+	"mathtt":   "tt",
+}
+
+type texAccent struct {
+	char     string
+	stretchy string
+}
+
+var texAccents = map[string]texAccent{
+	"hat": {"^", "false"}, "widehat": {"^", "true"},
+	"tilde": {"~", "false"}, "widetilde": {"~", "true"},
+	"bar": {"‾", "false"}, "vec": {"→", "false"},
+	"overrightarrow": {"→", "true"}, "overleftarrow": {"←", "true"},
+	"dot": {"˙", "false"}, "ddot": {"¨", "false"},
+	"acute": {"´", "false"}, "grave": {"`", "false"},
+	"breve": {"˘", "false"}, "check": {"ˇ", "false"},
+}
+
+var texSpaces = map[string]string{
+	",": "0.167em", "thinspace": "0.167em",
+	":": "0.222em", ">": "0.222em", "medspace": "0.222em",
+	";": "0.278em", "thickspace": "0.278em",
+	"!": "-0.167em", "negthinspace": "-0.167em",
+	" ": "0.333em", "enspace": "0.5em",
+	"quad": "1em", "qquad": "2em",
+}
+
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true,
+	"sinh": true, "cosh": true, "tanh": true, "coth": true,
+	"log": true, "ln": true, "lg": true, "exp": true,
+	"dim": true, "ker": true, "deg": true, "arg": true, "hom": true,
+}
+
+// texLimitFunctions take their subscripts underneath in display mode.
+var texLimitFunctions = map[string]bool{
+	"lim": true, "liminf": true, "limsup": true,
+	"max": true, "min": true, "sup": true, "inf": true,
+	"det": true, "gcd": true, "Pr": true, "argmax": true, "argmin": true,
+}
+
+var texBigOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐",
+	"int": "∫", "iint": "∬", "iiint": "∭", "oint": "∮",
+	"bigcup": "⋃", "bigcap": "⋂", "bigsqcup": "⨆",
+	"bigoplus": "⨁", "bigotimes": "⨂", "bigodot": "⨀",
+	"bigvee": "⋁", "bigwedge": "⋀",
+}
+
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "omicron": "ο", "pi": "π", "varpi": "ϖ",
+	"rho": "ρ", "varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ",
+	"phi": "ϕ", "varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "emptyset": "∅", "varnothing": "∅", "aleph": "ℵ", "beth": "ℶ",
+	"hbar": "ℏ", "ell": "ℓ", "wp": "℘", "Re": "ℜ", "Im": "ℑ", "imath": "ı", "jmath": "ȷ",
+	"partial": "∂", "nabla": "∇",
+}
+
+var texOperators = map[string]string{
+	// Binary operators
+	"times": "×", "div": "÷", "cdot": "⋅", "pm": "±", "mp": "∓", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "ominus": "⊖", "otimes": "⊗", "odot": "⊙",
+	"cap": "∩", "cup": "∪", "sqcap": "⊓", "sqcup": "⊔", "wedge": "∧", "land": "∧",
+	"vee": "∨", "lor": "∨", "setminus": "∖", "smallsetminus": "∖", "wr": "≀", "amalg": "⨿",
+	"dagger": "†", "ddagger": "‡",
+	// Relations
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "leqslant": "⩽", "geqslant": "⩾",
+	"approx": "≈", "equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝",
+	"ll": "≪", "gg": "≫", "prec": "≺", "succ": "≻", "preceq": "⪯", "succeq": "⪰",
+	"subset": "⊂", "supset": "⊃", "subseteq": "⊆", "supseteq": "⊇", "subsetneq": "⊊", "supsetneq": "⊋",
+	"in": "∈", "notin": "∉", "ni": "∋", "perp": "⊥", "parallel": "∥", "mid": "∣", "nmid": "∤",
+	"vdash": "⊢", "dashv": "⊣", "models": "⊨", "doteq": "≐", "asymp": "≍", "coloneqq": "≔",
+	// Arrows
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "gets": "←", "leftrightarrow": "↔",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "iff": "⟺", "implies": "⟹", "impliedby": "⟸",
+	"mapsto": "↦", "longmapsto": "⟼", "longrightarrow": "⟶", "longleftarrow": "⟵",
+	"Longrightarrow": "⟹", "Longleftarrow": "⟸", "longleftrightarrow": "⟷", "Longleftrightarrow": "⟺",
+	"uparrow": "↑", "downarrow": "↓", "updownarrow": "↕", "Uparrow": "⇑", "Downarrow": "⇓",
+	"hookrightarrow": "↪", "hookleftarrow": "↩", "nearrow": "↗", "searrow": "↘",
+	"rightleftharpoons": "⇌", "leadsto": "⇝",
+	// Logic and misc
+	"forall": "∀", "exists": "∃", "nexists": "∄", "neg": "¬", "lnot": "¬",
+	"angle": "∠", "triangle": "△", "square": "□", "prime": "′", "top": "⊤", "bot": "⊥",
+	"therefore": "∴", "because": "∵", "checkmark": "✓", "surd": "√", "degree": "°",
+	"ldots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "dots": "…", "dotsc": "…", "dotsb": "⋯",
+	// Delimiters
+	"langle": "⟨", "rangle": "⟩", "lceil": "⌈", "rceil": "⌉", "lfloor": "⌊", "rfloor": "⌋",
+	"vert": "|", "Vert": "‖", "lvert": "|", "rvert": "|", "lVert": "‖", "rVert": "‖",
+	"lbrace": "{", "rbrace": "}", "lbrack": "[", "rbrack": "]", "backslash": "∖",
+}
+
+// plausibleInlineMath applies pandoc's heuristic for single-dollar math so
+// that prices such as "$5 and $10" are left as text: the content must not
+// start or end with whitespace.
+func plausibleInlineMath(s string) bool {
+	if s == "" {
+		return false
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	last, _ := utf8.DecodeLastRuneInString(s)
+	return !unicode.IsSpace(first) && !unicode.IsSpace(last)
+}
@@ -0,0 +1,951 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif"  // registers the GIF decoder for image.DecodeConfig
+	_ "image/jpeg" // registers the JPEG decoder for image.DecodeConfig
+	_ "image/png"  // registers the PNG decoder for image.DecodeConfig
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"gileserver/models"
+)
+
+// maxMetaRead caps how many bytes any single metadata structure may occupy
+// before it is ignored. EXIF segments are limited to 64 KB by the JPEG format
+// itself; ID3 tags and Vorbis comment blocks can legally be much larger when
+// they embed cover art, but the text frames we care about always come first.
+const maxMetaRead = 256 * 1024
+
+// extractMetadata reads embedded metadata from the file at fsPath and returns
+// it grouped for display in the collapsible panel on the preview page.
+//
+// Everything is parsed in pure Go from a bounded prefix (or, for ID3v1, a
+// bounded suffix) of the file, so even very large media files are cheap to
+// inspect. Parse failures are never fatal: a malformed or unrecognised file
+// simply yields fewer groups, or none at all.
+func extractMetadata(fsPath, mimeType string) []models.MetaGroup {
+	var groups []models.MetaGroup
+
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return groups
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return groups
+	}
+	size := info.Size()
+
+	switch base := baseMIME(mimeType); {
+	case isImage(base):
+		ex := readEXIF(f, size, base)
+
+		if w, h, ok := imageDimensions(f, size, base, ex); ok {
+			fields := []models.MetaField{{Label: "Dimensions", Value: fmt.Sprintf("%d × %d px", w, h)}}
+			// Orientations 5–8 swap the axes; report what the viewer sees,
+			// as the preview is rotated by image-orientation: from-image.
+			if ex.orientation >= 5 && ex.orientation <= 8 {
+				fields[0].Value = fmt.Sprintf("%d × %d px", h, w)
+				fields = append(fields, models.MetaField{Label: "Stored as", Value: fmt.Sprintf("%d × %d px", w, h)})
+			}
+			groups = append(groups, models.MetaGroup{Title: "Image", Fields: fields})
+		}
+		groups = append(groups, ex.groups()...)
+
+	case strings.HasPrefix(base, "audio/") || base == "application/ogg":
+		groups = append(groups, readAudioTags(f, size)...)
+	}
+
+	return groups
+}
+
+// ---------------------------------------------------------------------------
+// Image dimensions
+// ---------------------------------------------------------------------------
+
+// imageDimensions returns the stored pixel dimensions of an image. JPEG, PNG
+// and GIF are handled by the standard library decoders (header only — no
+// pixel data is decoded); WebP, BMP and TIFF headers are parsed directly.
+func imageDimensions(r io.ReaderAt, size int64, mimeType string, ex exifData) (int, int, bool) {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		cfg, _, err := image.DecodeConfig(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return 0, 0, false
+		}
+		return cfg.Width, cfg.Height, true
+
+	case "image/webp":
+		return webpDimensions(r)
+
+	case "image/bmp", "image/x-ms-bmp":
+		hdr := make([]byte, 26)
+		if _, err := r.ReadAt(hdr, 0); err != nil || string(hdr[:2]) != "BM" {
+			return 0, 0, false
+		}
+		w := int32(binary.LittleEndian.Uint32(hdr[18:22]))
+		h := int32(binary.LittleEndian.Uint32(hdr[22:26]))
+		if h < 0 {
+			h = -h // top-down bitmaps store a negative height
+		}
+		return int(w), int(h), w > 0 && h > 0
+
+	case "image/tiff":
+		if ex.width > 0 && ex.height > 0 {
+			return ex.width, ex.height, true
+		}
+	}
+	return 0, 0, false
+}
+
+// webpDimensions parses the canvas size from the first chunk of a RIFF/WebP
+// container. All three bitstream flavours (lossy, lossless, extended) are
+// supported.
+func webpDimensions(r io.ReaderAt) (int, int, bool) {
+	hdr := make([]byte, 30)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return 0, 0, false
+	}
+	if string(hdr[0:4]) != "RIFF" || string(hdr[8:12]) != "WEBP" {
+		return 0, 0, false
+	}
+	switch string(hdr[12:16]) {
+	case "VP8 ":
+		w := int(binary.LittleEndian.Uint16(hdr[26:28]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(hdr[28:30]) & 0x3fff)
+		return w, h, w > 0 && h > 0
+	case "VP8L":
+		b := binary.LittleEndian.Uint32(hdr[21:25])
+		return int(b&0x3fff) + 1, int((b>>14)&0x3fff) + 1, true
+	case "VP8X":
+		w := int(hdr[24]) | int(hdr[25])<<8 | int(hdr[26])<<16
+		h := int(hdr[27]) | int(hdr[28])<<8 | int(hdr[29])<<16
+		return w + 1, h + 1, true
+	}
+	return 0, 0, false
+}
+
+// ---------------------------------------------------------------------------
+// EXIF (JPEG APP1 segments and TIFF files)
+// ---------------------------------------------------------------------------
+
+// exifData holds the subset of EXIF tags shown on the preview page.
+type exifData struct {
+	make, model, lens, software, taken string
+	exposure, fNumber, focal, bias     float64
+	focal35, iso, flash                int
+	hasFlash                           bool
+	orientation                        int
+	width, height                      int // TIFF IFD0 only
+	lat, lon, alt                      float64
+	hasGPS, hasAlt                     bool
+}
+
+// EXIF / TIFF tag numbers used below.
+const (
+	tagImageWidth   = 0x0100
+	tagImageLength  = 0x0101
+	tagMake         = 0x010f
+	tagModel        = 0x0110
+	tagOrientation  = 0x0112
+	tagSoftware     = 0x0131
+	tagDateTime     = 0x0132
+	tagExifIFD      = 0x8769
+	tagGPSIFD       = 0x8825
+	tagExposureTime = 0x829a
+	tagFNumber      = 0x829d
+	tagISO          = 0x8827
+	tagDateOriginal = 0x9003
+	tagExposureBias = 0x9204
+	tagFlash        = 0x9209
+	tagFocalLength  = 0x920a
+	tagFocal35mm    = 0xa405
+	tagLensMake     = 0xa433
+	tagLensModel    = 0xa434
+
+	gpsLatRef = 0x0001
+	gpsLat    = 0x0002
+	gpsLonRef = 0x0003
+	gpsLon    = 0x0004
+	gpsAltRef = 0x0005
+	gpsAlt    = 0x0006
+)
+
+// readEXIF locates and parses the EXIF block of a JPEG or TIFF file.
+func readEXIF(r io.ReaderAt, size int64, mimeType string) exifData {
+	var ex exifData
+	var tr *tiffReader
+	switch mimeType {
+	case "image/jpeg":
+		base, length, ok := findJPEGExif(r, size)
+		if !ok {
+			return ex
+		}
+		tr = newTIFFReader(r, base, length)
+	case "image/tiff":
+		tr = newTIFFReader(r, 0, size)
+	default:
+		return ex
+	}
+	if tr == nil {
+		return ex
+	}
+
+	ifd0, ok := tr.readIFD(tr.first)
+	if !ok {
+		return ex
+	}
+	ex.make = tr.str(ifd0, tagMake)
+	ex.model = tr.str(ifd0, tagModel)
+	ex.software = tr.str(ifd0, tagSoftware)
+	ex.taken = tr.str(ifd0, tagDateTime)
+	if v, ok := tr.uint(ifd0, tagOrientation); ok && v >= 1 && v <= 8 {
+		ex.orientation = int(v)
+	}
+	if v, ok := tr.uint(ifd0, tagImageWidth); ok {
+		ex.width = int(v)
+	}
+	if v, ok := tr.uint(ifd0, tagImageLength); ok {
+		ex.height = int(v)
+	}
+
+	if off, ok := tr.uint(ifd0, tagExifIFD); ok {
+		if sub, ok := tr.readIFD(off); ok {
+			if s := tr.str(sub, tagDateOriginal); s != "" {
+				ex.taken = s
+			}
+			ex.exposure = tr.rational(sub, tagExposureTime, 0)
+			ex.fNumber = tr.rational(sub, tagFNumber, 0)
+			ex.focal = tr.rational(sub, tagFocalLength, 0)
+			ex.bias = tr.rational(sub, tagExposureBias, 0)
+			if v, ok := tr.uint(sub, tagISO); ok {
+				ex.iso = int(v)
+			}
+			if v, ok := tr.uint(sub, tagFocal35mm); ok {
+				ex.focal35 = int(v)
+			}
+			if v, ok := tr.uint(sub, tagFlash); ok {
+				ex.flash, ex.hasFlash = int(v), true
+			}
+			lensMake := tr.str(sub, tagLensMake)
+			ex.lens = tr.str(sub, tagLensModel)
+			if lensMake != "" && !strings.HasPrefix(ex.lens, lensMake) {
+				ex.lens = strings.TrimSpace(lensMake + " " + ex.lens)
+			}
+		}
+	}
+
+	if off, ok := tr.uint(ifd0, tagGPSIFD); ok {
+		if gps, ok := tr.readIFD(off); ok {
+			lat, latOK := tr.degrees(gps, gpsLat)
+			lon, lonOK := tr.degrees(gps, gpsLon)
+			if latOK && lonOK {
+				if strings.EqualFold(tr.str(gps, gpsLatRef), "S") {
+					lat = -lat
+				}
+				if strings.EqualFold(tr.str(gps, gpsLonRef), "W") {
+					lon = -lon
+				}
+				ex.lat, ex.lon, ex.hasGPS = lat, lon, true
+			}
+			if e, ok := gps[gpsAlt]; ok {
+				ex.alt, ex.hasAlt = tr.rationalAt(e, 0), true
+				if ref := tr.bytes(gps[gpsAltRef]); len(ref) > 0 && ref[0] == 1 {
+					ex.alt = -ex.alt // below sea level
+				}
+			}
+		}
+	}
+	return ex
+}
+
+// findJPEGExif walks the JPEG marker segments up to the start of scan and
+// returns the offset and length of the TIFF structure inside the APP1 "Exif"
+// segment.
+func findJPEGExif(r io.ReaderAt, size int64) (int64, int64, bool) {
+	soi := make([]byte, 2)
+	if _, err := r.ReadAt(soi, 0); err != nil || soi[0] != 0xff || soi[1] != 0xd8 {
+		return 0, 0, false
+	}
+	off := int64(2)
+	hdr := make([]byte, 10)
+	for i := 0; i < 64 && off+4 <= size; i++ {
+		if _, err := r.ReadAt(hdr[:4], off); err != nil || hdr[0] != 0xff {
+			return 0, 0, false
+		}
+		marker := hdr[1]
+		if marker == 0xda || marker == 0xd9 { // SOS / EOI — no metadata beyond here
+			return 0, 0, false
+		}
+		segLen := int64(binary.BigEndian.Uint16(hdr[2:4]))
+		if segLen < 2 {
+			return 0, 0, false
+		}
+		if marker == 0xe1 && segLen >= 16 {
+			if _, err := r.ReadAt(hdr[:6], off+4); err == nil && string(hdr[:6]) == "Exif\x00\x00" {
+				return off + 10, segLen - 8, true
+			}
+		}
+		off += 2 + segLen
+	}
+	return 0, 0, false
+}
+
+// tiffReader reads IFD structures from a TIFF header located at base within r.
+// All offsets inside the structure are relative to base and bounds-checked
+// against limit so a malicious file cannot make us read outside the block.
+type tiffReader struct {
+	r     io.ReaderAt
+	base  int64
+	limit int64
+	order binary.ByteOrder
+	first uint32 // offset of IFD0
+}
+
+// ifdEntry is one raw 12-byte IFD record.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	inl   [4]byte // inline value or offset
+}
+
+func newTIFFReader(r io.ReaderAt, base, limit int64) *tiffReader {
+	hdr := make([]byte, 8)
+	if limit < 8 {
+		return nil
+	}
+	if _, err := r.ReadAt(hdr, base); err != nil {
+		return nil
+	}
+	t := &tiffReader{r: r, base: base, limit: limit}
+	switch string(hdr[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil
+	}
+	t.first = t.order.Uint32(hdr[4:8])
+	return t
+}
+
+// read returns n bytes at the TIFF-relative offset off, or nil when the range
+// falls outside the block.
+func (t *tiffReader) read(off uint32, n int64) []byte {
+	if n <= 0 || n > maxMetaRead || int64(off)+n > t.limit {
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := t.r.ReadAt(buf, t.base+int64(off)); err != nil {
+		return nil
+	}
+	return buf
+}
+
+// readIFD parses the directory at off into a tag → entry map.
+func (t *tiffReader) readIFD(off uint32) (map[uint16]ifdEntry, bool) {
+	cnt := t.read(off, 2)
+	if cnt == nil {
+		return nil, false
+	}
+	n := int64(t.order.Uint16(cnt))
+	if n == 0 || n > 512 {
+		return nil, false
+	}
+	raw := t.read(off+2, n*12)
+	if raw == nil {
+		return nil, false
+	}
+	entries := make(map[uint16]ifdEntry, n)
+	for i := int64(0); i < n; i++ {
+		rec := raw[i*12 : i*12+12]
+		var e ifdEntry
+		e.typ = t.order.Uint16(rec[2:4])
+		e.count = t.order.Uint32(rec[4:8])
+		copy(e.inl[:], rec[8:12])
+		entries[t.order.Uint16(rec[0:2])] = e
+	}
+	return entries, true
+}
+
+// tiffTypeSize returns the byte width of one value of the given TIFF type.
+func tiffTypeSize(typ uint16) int64 {
+	switch typ {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
+
+// bytes returns the raw value bytes of e, following the offset when the value
+// does not fit inline.
+func (t *tiffReader) bytes(e ifdEntry) []byte {
+	n := tiffTypeSize(e.typ) * int64(e.count)
+	if n == 0 {
+		return nil
+	}
+	if n <= 4 {
+		return e.inl[:n]
+	}
+	return t.read(t.order.Uint32(e.inl[:]), n)
+}
+
+// str returns an ASCII tag value with trailing NULs and whitespace removed.
+func (t *tiffReader) str(ifd map[uint16]ifdEntry, tag uint16) string {
+	e, ok := ifd[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(t.bytes(e)), "\x00"))
+}
+
+// uint returns the first SHORT or LONG value of a tag.
+func (t *tiffReader) uint(ifd map[uint16]ifdEntry, tag uint16) (uint32, bool) {
+	e, ok := ifd[tag]
+	if !ok {
+		return 0, false
+	}
+	b := t.bytes(e)
+	switch {
+	case e.typ == 3 && len(b) >= 2:
+		return uint32(t.order.Uint16(b)), true
+	case e.typ == 4 && len(b) >= 4:
+		return t.order.Uint32(b), true
+	}
+	return 0, false
+}
+
+// rational returns the i-th RATIONAL or SRATIONAL value of a tag, or 0.
+func (t *tiffReader) rational(ifd map[uint16]ifdEntry, tag uint16, i int) float64 {
+	e, ok := ifd[tag]
+	if !ok {
+		return 0
+	}
+	return t.rationalAt(e, i)
+}
+
+func (t *tiffReader) rationalAt(e ifdEntry, i int) float64 {
+	b := t.bytes(e)
+	if (e.typ != 5 && e.typ != 10) || len(b) < (i+1)*8 {
+		return 0
+	}
+	b = b[i*8:]
+	if e.typ == 10 {
+		num, den := int32(t.order.Uint32(b[0:4])), int32(t.order.Uint32(b[4:8]))
+		if den == 0 {
+			return 0
+		}
+		return float64(num) / float64(den)
+	}
+	num, den := t.order.Uint32(b[0:4]), t.order.Uint32(b[4:8])
+	if den == 0 {
+		return 0
+	}
+	return float64(num) / float64(den)
+}
+
+// degrees converts a GPS degrees/minutes/seconds triple into decimal degrees.
+func (t *tiffReader) degrees(ifd map[uint16]ifdEntry, tag uint16) (float64, bool) {
+	e, ok := ifd[tag]
+	if !ok || e.count < 3 {
+		return 0, false
+	}
+	d, m, s := t.rationalAt(e, 0), t.rationalAt(e, 1), t.rationalAt(e, 2)
+	return d + m/60 + s/3600, true
+}
+
+// exifOrientationLabels maps EXIF orientation values to descriptions.
+var exifOrientationLabels = map[int]string{
+	1: "Normal",
+	2: "Mirrored horizontally",
+	3: "Rotated 180°",
+	4: "Mirrored vertically",
+	5: "Mirrored horizontally, rotated 90° CCW",
+	6: "Rotated 90° CW",
+	7: "Mirrored horizontally, rotated 90° CW",
+	8: "Rotated 90° CCW",
+}
+
+// groups formats the parsed EXIF data into display groups, omitting any
+// group that would be empty.
+func (ex exifData) groups() []models.MetaGroup {
+	var out []models.MetaGroup
+
+	var cam []models.MetaField
+	cam = appendField(cam, "Camera", strings.TrimSpace(cameraName(ex.make, ex.model)))
+	cam = appendField(cam, "Lens", ex.lens)
+	cam = appendField(cam, "Taken", formatEXIFTime(ex.taken))
+	cam = appendField(cam, "Software", ex.software)
+	if ex.orientation > 1 {
+		cam = appendField(cam, "Orientation", exifOrientationLabels[ex.orientation])
+	}
+	if len(cam) > 0 {
+		out = append(out, models.MetaGroup{Title: "Camera", Fields: cam})
+	}
+
+	var exp []models.MetaField
+	if ex.exposure > 0 {
+		if ex.exposure < 1 {
+			exp = appendField(exp, "Exposure", fmt.Sprintf("1/%d s", int(math.Round(1/ex.exposure))))
+		} else {
+			exp = appendField(exp, "Exposure", strconv.FormatFloat(ex.exposure, 'f', -1, 64)+" s")
+		}
+	}
+	if ex.fNumber > 0 {
+		exp = appendField(exp, "Aperture", fmt.Sprintf("f/%.1f", ex.fNumber))
+	}
+	if ex.iso > 0 {
+		exp = appendField(exp, "ISO", strconv.Itoa(ex.iso))
+	}
+	if ex.focal > 0 {
+		focal := fmt.Sprintf("%.0f mm", ex.focal)
+		if ex.focal35 > 0 && ex.focal35 != int(math.Round(ex.focal)) {
+			focal += fmt.Sprintf(" (%d mm equiv.)", ex.focal35)
+		}
+		exp = appendField(exp, "Focal length", focal)
+	}
+	if ex.bias != 0 {
+		exp = appendField(exp, "Exposure bias", fmt.Sprintf("%+.1f EV", ex.bias))
+	}
+	if ex.hasFlash {
+		flash := "Did not fire"
+		if ex.flash&1 == 1 {
+			flash = "Fired"
+		}
+		exp = appendField(exp, "Flash", flash)
+	}
+	if len(exp) > 0 {
+		out = append(out, models.MetaGroup{Title: "Exposure", Fields: exp})
+	}
+
+	if ex.hasGPS {
+		loc := []models.MetaField{{Label: "Coordinates", Value: fmt.Sprintf("%.6f, %.6f", ex.lat, ex.lon)}}
+		if ex.hasAlt {
+			loc = append(loc, models.MetaField{Label: "Altitude", Value: fmt.Sprintf("%.1f m", ex.alt)})
+		}
+		out = append(out, models.MetaGroup{Title: "Location", Fields: loc})
+	}
+	return out
+}
+
+// cameraName joins make and model, dropping the make when the model already
+// starts with it (e.g. "Canon" + "Canon EOS R5").
+func cameraName(mk, model string) string {
+	if mk != "" && strings.HasPrefix(strings.ToLower(model), strings.ToLower(mk)) {
+		return model
+	}
+	return mk + " " + model
+}
+
+// formatEXIFTime converts "2006:01:02 15:04:05" into "2006-01-02 15:04:05".
+func formatEXIFTime(s string) string {
+	if len(s) >= 10 && s[4] == ':' && s[7] == ':' {
+		return s[:4] + "-" + s[5:7] + "-" + s[8:]
+	}
+	return s
+}
+
+// appendField appends a field only when value is non-empty.
+func appendField(fields []models.MetaField, label, value string) []models.MetaField {
+	if value == "" {
+		return fields
+	}
+	return append(fields, models.MetaField{Label: label, Value: value})
+}
+
+// ---------------------------------------------------------------------------
+// Audio tags (ID3v2, ID3v1, FLAC and Ogg Vorbis/Opus comments)
+// ---------------------------------------------------------------------------
+
+// audioTags is the normalised set of tags shown for audio files.
+type audioTags struct {
+	title, artist, album, albumArtist, year, track, genre, composer string
+	sampleRate, channels                                            int
+	duration                                                        float64 // seconds
+}
+
+// readAudioTags detects the container format from its magic bytes and
+// dispatches to the matching tag parser.
+func readAudioTags(r io.ReaderAt, size int64) []models.MetaGroup {
+	var t audioTags
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil
+	}
+	switch {
+	case string(magic[:3]) == "ID3":
+		readID3v2(r, &t)
+	case string(magic) == "fLaC":
+		readFLAC(r, &t)
+	case string(magic) == "OggS":
+		readOgg(r, &t)
+	}
+	if t.title == "" && t.artist == "" {
+		readID3v1(r, size, &t)
+	}
+	return t.groups()
+}
+
+func (t audioTags) groups() []models.MetaGroup {
+	var out []models.MetaGroup
+	var tags []models.MetaField
+	tags = appendField(tags, "Title", t.title)
+	tags = appendField(tags, "Artist", t.artist)
+	tags = appendField(tags, "Album", t.album)
+	tags = appendField(tags, "Album artist", t.albumArtist)
+	tags = appendField(tags, "Composer", t.composer)
+	tags = appendField(tags, "Year", t.year)
+	tags = appendField(tags, "Track", t.track)
+	tags = appendField(tags, "Genre", t.genre)
+	if len(tags) > 0 {
+		out = append(out, models.MetaGroup{Title: "Tags", Fields: tags})
+	}
+
+	var stream []models.MetaField
+	if t.duration > 0 {
+		secs := int(math.Round(t.duration))
+		stream = appendField(stream, "Duration", fmt.Sprintf("%d:%02d", secs/60, secs%60))
+	}
+	if t.sampleRate > 0 {
+		stream = appendField(stream, "Sample rate", fmt.Sprintf("%d Hz", t.sampleRate))
+	}
+	if t.channels > 0 {
+		stream = appendField(stream, "Channels", strconv.Itoa(t.channels))
+	}
+	if len(stream) > 0 {
+		out = append(out, models.MetaGroup{Title: "Stream", Fields: stream})
+	}
+	return out
+}
+
+// readID3v2 parses the text frames of an ID3v2.2, v2.3 or v2.4 tag located at
+// the start of the file.
+func readID3v2(r io.ReaderAt, t *audioTags) {
+	hdr := make([]byte, 10)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return
+	}
+	major := hdr[3]
+	flags := hdr[5]
+	tagSize := int64(syncsafe(hdr[6:10]))
+	if major < 2 || major > 4 {
+		return
+	}
+	if tagSize > maxMetaRead {
+		tagSize = maxMetaRead
+	}
+	body := make([]byte, tagSize)
+	n, _ := r.ReadAt(body, 10)
+	body = body[:n]
+
+	// Skip the extended header when present (v2.3 and v2.4 only).
+	if flags&0x40 != 0 && major >= 3 && len(body) >= 4 {
+		ext := int(binary.BigEndian.Uint32(body[:4]))
+		if major == 4 {
+			ext = int(syncsafe(body[:4]))
+		} else {
+			ext += 4 // v2.3 size excludes the size field itself
+		}
+		if ext > len(body) {
+			return
+		}
+		body = body[ext:]
+	}
+
+	idLen, hdrLen := 4, 10
+	if major == 2 {
+		idLen, hdrLen = 3, 6
+	}
+	for len(body) >= hdrLen {
+		id := string(body[:idLen])
+		if id[0] == 0 {
+			break // padding
+		}
+		var frameLen int
+		switch major {
+		case 2:
+			frameLen = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameLen = int(binary.BigEndian.Uint32(body[4:8]))
+		default:
+			frameLen = int(syncsafe(body[4:8]))
+		}
+		if frameLen <= 0 || hdrLen+frameLen > len(body) {
+			break
+		}
+		data := body[hdrLen : hdrLen+frameLen]
+		body = body[hdrLen+frameLen:]
+		if id[0] != 'T' || len(data) < 2 {
+			continue
+		}
+		val := decodeID3Text(data[0], data[1:])
+		switch id {
+		case "TIT2", "TT2":
+			t.title = val
+		case "TPE1", "TP1":
+			t.artist = val
+		case "TALB", "TAL":
+			t.album = val
+		case "TPE2", "TP2":
+			t.albumArtist = val
+		case "TCOM", "TCM":
+			t.composer = val
+		case "TDRC", "TYER", "TYE":
+			t.year = val
+		case "TRCK", "TRK":
+			t.track = val
+		case "TCON", "TCO":
+			t.genre = val
+		}
+	}
+}
+
+// syncsafe decodes a 4-byte ID3 synchsafe integer (7 bits per byte).
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// decodeID3Text decodes an ID3 text frame payload. Multiple NUL-separated
+// values (allowed in v2.4) are joined with "; ".
+func decodeID3Text(enc byte, b []byte) string {
+	var s string
+	switch enc {
+	case 0: // ISO-8859-1
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	case 1, 2: // UTF-16 with BOM, UTF-16BE without
+		s = decodeUTF16(b, enc == 2)
+	default: // 3 = UTF-8
+		s = string(b)
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == 0 })
+	return strings.TrimSpace(strings.Join(parts, "; "))
+}
+
+// decodeUTF16 decodes UTF-16 text, honouring (and stripping) any byte-order
+// marks. When bigEndian is false and no BOM is present, little-endian is
+// assumed.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	var out []rune
+	for len(b) >= 2 {
+		order := binary.ByteOrder(binary.LittleEndian)
+		if bigEndian {
+			order = binary.BigEndian
+		}
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			order, b = binary.LittleEndian, b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			order, b = binary.BigEndian, b[2:]
+		}
+		var units []uint16
+		for len(b) >= 2 {
+			u := order.Uint16(b)
+			b = b[2:]
+			if u == 0 {
+				break
+			}
+			units = append(units, u)
+		}
+		if len(out) > 0 {
+			out = append(out, 0)
+		}
+		out = append(out, utf16.Decode(units)...)
+	}
+	return string(out)
+}
+
+// readID3v1 parses the fixed 128-byte ID3v1 trailer when present.
+func readID3v1(r io.ReaderAt, size int64, t *audioTags) {
+	if size < 128 {
+		return
+	}
+	b := make([]byte, 128)
+	if _, err := r.ReadAt(b, size-128); err != nil || string(b[:3]) != "TAG" {
+		return
+	}
+	field := func(p []byte) string {
+		return strings.TrimSpace(strings.TrimRight(string(bytes.ToValidUTF8(p, nil)), "\x00 "))
+	}
+	t.title = field(b[3:33])
+	t.artist = field(b[33:63])
+	t.album = field(b[63:93])
+	t.year = field(b[93:97])
+	if b[125] == 0 && b[126] != 0 { // ID3v1.1 track number
+		t.track = strconv.Itoa(int(b[126]))
+	}
+}
+
+// readFLAC walks the FLAC metadata blocks, reading STREAMINFO and the
+// VORBIS_COMMENT block.
+func readFLAC(r io.ReaderAt, t *audioTags) {
+	off := int64(4)
+	hdr := make([]byte, 4)
+	for i := 0; i < 64; i++ {
+		if _, err := r.ReadAt(hdr, off); err != nil {
+			return
+		}
+		last := hdr[0]&0x80 != 0
+		typ := hdr[0] & 0x7f
+		n := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		off += 4
+		switch typ {
+		case 0: // STREAMINFO
+			si := make([]byte, 18)
+			if n >= 18 {
+				if _, err := r.ReadAt(si, off); err == nil {
+					rate := int(si[10])<<12 | int(si[11])<<4 | int(si[12])>>4
+					t.sampleRate = rate
+					t.channels = int(si[12]>>1&0x07) + 1
+					total := int64(si[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(si[14:18]))
+					if rate > 0 {
+						t.duration = float64(total) / float64(rate)
+					}
+				}
+			}
+		case 4: // VORBIS_COMMENT
+			if n <= maxMetaRead {
+				buf := make([]byte, n)
+				if _, err := r.ReadAt(buf, off); err == nil {
+					parseVorbisComments(buf, t)
+				}
+			}
+		}
+		off += n
+		if last {
+			return
+		}
+	}
+}
+
+// readOgg reassembles the first two logical packets of an Ogg stream — the
+// identification header and the comment header — and parses them for Vorbis
+// or Opus streams.
+func readOgg(r io.ReaderAt, t *audioTags) {
+	var packets [][]byte
+	var cur []byte
+	off := int64(0)
+	hdr := make([]byte, 27)
+	for len(packets) < 2 && off < maxMetaRead {
+		if _, err := r.ReadAt(hdr, off); err != nil || string(hdr[:4]) != "OggS" {
+			return
+		}
+		nsegs := int(hdr[26])
+		segs := make([]byte, nsegs)
+		if _, err := r.ReadAt(segs, off+27); err != nil {
+			return
+		}
+		off += 27 + int64(nsegs)
+		for _, l := range segs {
+			chunk := make([]byte, l)
+			if _, err := r.ReadAt(chunk, off); err != nil {
+				return
+			}
+			off += int64(l)
+			cur = append(cur, chunk...)
+			if len(cur) > maxMetaRead {
+				return
+			}
+			if l < 255 { // a lacing value below 255 terminates the packet
+				packets = append(packets, cur)
+				cur = nil
+				if len(packets) == 2 {
+					break
+				}
+			}
+		}
+	}
+	if len(packets) < 2 {
+		return
+	}
+
+	id, comments := packets[0], packets[1]
+	switch {
+	case len(id) >= 16 && string(id[:7]) == "\x01vorbis":
+		t.channels = int(id[11])
+		t.sampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+	case len(id) >= 16 && string(id[:8]) == "OpusHead":
+		t.channels = int(id[9])
+		t.sampleRate = int(binary.LittleEndian.Uint32(id[12:16]))
+	}
+	switch {
+	case len(comments) > 7 && string(comments[:7]) == "\x03vorbis":
+		parseVorbisComments(comments[7:], t)
+	case len(comments) > 8 && string(comments[:8]) == "OpusTags":
+		parseVorbisComments(comments[8:], t)
+	}
+}
+
+// parseVorbisComments decodes a little-endian Vorbis comment block
+// (vendor string followed by KEY=value pairs).
+func parseVorbisComments(b []byte, t *audioTags) {
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint32(b[:4]))
+		if n < 0 || 4+n > len(b) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+	if _, ok := next(); !ok { // vendor
+		return
+	}
+	if len(b) < 4 {
+		return
+	}
+	count := int(binary.LittleEndian.Uint32(b[:4]))
+	b = b[4:]
+	for i := 0; i < count && i < 1024; i++ {
+		c, ok := next()
+		if !ok {
+			return
+		}
+		key, val, found := strings.Cut(c, "=")
+		if !found {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.ToUpper(key) {
+		case "TITLE":
+			t.title = val
+		case "ARTIST":
+			t.artist = val
+		case "ALBUM":
+			t.album = val
+		case "ALBUMARTIST":
+			t.albumArtist = val
+		case "COMPOSER":
+			t.composer = val
+		case "DATE", "YEAR":
+			t.year = val
+		case "TRACKNUMBER":
+			t.track = val
+		case "GENRE":
+			t.genre = val
+		}
+	}
+}
@@ -32,8 +32,32 @@ var ownExtensions = map[string]string{
 	".xslt":      "text/xml",
 	".svg":       "image/svg+xml",
 
+	// --- images / audio ---
+	// Listed explicitly because minimal container images ship without an OS
+	// MIME registry, and metadata extraction dispatches on these types.
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".bmp":  "image/bmp",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+
+	// --- fonts ---
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+
 	// --- data / config formats ---
 	".json":       "application/json",
+	".ipynb":      "application/x-ipynb+json", // Jupyter notebook
 	".jsonc":      "application/json",
 	".json5":      "application/json",
 	".yaml":       "text/yaml",
@@ -304,11 +328,19 @@ func sniffMIME(fsPath string) string {
 
 	buf := make([]byte, 512)
 	n, _ := f.Read(buf)
-	if n == 0 {
+	return sniffContent(buf[:n])
+}
+
+// sniffContent applies the sniffMIME heuristics to the leading bytes of a
+// file. It is shared with archive members, which have no filesystem path.
+func sniffContent(buf []byte) string {
+	if len(buf) == 0 {
 		// Empty file — treat as plain text so it can be previewed.
 		return "text/plain"
 	}
-	buf = buf[:n]
+	if len(buf) > 512 {
+		buf = buf[:512]
+	}
 
 	// Null bytes are a reliable indicator of binary content.
 	if bytes.IndexByte(buf, 0) != -1 {
@@ -344,7 +376,8 @@ func isText(mimeType string) bool {
 		return true
 	}
 	switch base {
-	case "application/json", "application/xml", "application/javascript":
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-ipynb+json":
 		return true
 	}
 	return false
@@ -376,7 +409,7 @@ func languageHint(mimeType, filename string) string {
 		return "xml"
 
 	// --- data / config ---
-	case ".json", ".jsonc", ".json5":
+	case ".json", ".jsonc", ".json5", ".ipynb":
 		return "json"
 	case ".yaml", ".yml":
 		return "yaml"
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// notebook is the subset of the Jupyter nbformat 4 schema that the renderer
+// uses. Unknown fields are ignored.
+type notebook struct {
+	NBFormat int            `json:"nbformat"`
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+type notebookCell struct {
+	CellType       string                       `json:"cell_type"`
+	Source         nbText                       `json:"source"`
+	ExecutionCount *int                         `json:"execution_count"`
+	Outputs        []notebookOutput             `json:"outputs"`
+	Attachments    map[string]map[string]nbText `json:"attachments"`
+}
+
+type notebookOutput struct {
+	OutputType     string            `json:"output_type"`
+	Name           string            `json:"name"` // stream name: stdout or stderr
+	Text           nbText            `json:"text"`
+	Data           map[string]nbText `json:"data"`
+	ExecutionCount *int              `json:"execution_count"`
+	EName          string            `json:"ename"`
+	EValue         string            `json:"evalue"`
+	Traceback      []string          `json:"traceback"`
+}
+
+// nbText is a notebook multiline string, which nbformat allows to be stored
+// either as a single string or as a list of lines.
+type nbText string
+
+func (t *nbText) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = nbText(s)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(b, &lines); err != nil {
+		return err
+	}
+	*t = nbText(strings.Join(lines, ""))
+	return nil
+}
+
+// ansiEscape matches the terminal colour sequences that IPython embeds in
+// tracebacks and some stream output.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// notebookImageTypes are the output image formats embedded as data URIs, in
+// order of preference. SVG is deliberately absent: the sanitiser never admits
+// SVG data URIs because SVG can carry script.
+var notebookImageTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+// renderNotebook renders a Jupyter notebook (.ipynb). Markdown cells go
+// through the shared goldmark pipeline, code cells through
+// chromaHighlightBlock in the kernel's language, and outputs are rendered
+// from their richest safe representation: HTML, an embedded raster image,
+// Markdown, or plain text. The assembled document is passed through
+// sanitizeHTML, so HTML outputs are subject to the same docPolicy as any
+// other rendered document.
+func renderNotebook(content, docURLDir string, previewImages bool) (template.HTML, error) {
+	var nb notebook
+	if err := json.Unmarshal([]byte(content), &nb); err != nil {
+		return "", fmt.Errorf("notebook parse: %w", err)
+	}
+	if nb.NBFormat < 4 {
+		return "", fmt.Errorf("unsupported nbformat %d", nb.NBFormat)
+	}
+
+	lang := nb.Metadata.LanguageInfo.Name
+	if lang == "" {
+		lang = nb.Metadata.KernelSpec.Language
+	}
+	if lang == "" {
+		lang = "python"
+	}
+
+	md := newMarkdown()
+	var b strings.Builder
+	b.WriteString(`<div class="notebook">`)
+	for _, cell := range nb.Cells {
+		switch cell.CellType {
+		case "markdown":
+			src := inlineAttachments(string(cell.Source), cell.Attachments)
+			var buf bytes.Buffer
+			if err := md.Convert([]byte(src), &buf); err != nil {
+				return "", fmt.Errorf("notebook markdown cell: %w", err)
+			}
+			b.WriteString(`<div class="nb-cell nb-markdown">`)
+			b.WriteString(buf.String())
+			b.WriteString(`</div>`)
+
+		case "code":
+			b.WriteString(`<div class="nb-cell nb-code"><div class="nb-input">`)
+			b.WriteString(`<span class="nb-prompt">In [`)
+			writeExecutionCount(&b, cell.ExecutionCount)
+			b.WriteString(`]:</span>`)
+			b.WriteString(highlightOrEscape(string(cell.Source), lang))
+			b.WriteString(`</div>`)
+			if len(cell.Outputs) > 0 {
+				b.WriteString(`<div class="nb-outputs">`)
+				for _, out := range cell.Outputs {
+					writeNotebookOutput(&b, md, out)
+				}
+				b.WriteString(`</div>`)
+			}
+			b.WriteString(`</div>`)
+
+		default:
+			// Raw cells (and any future cell type) are shown verbatim.
+			b.WriteString(`<div class="nb-cell nb-raw"><pre>`)
+			b.WriteString(template.HTMLEscapeString(string(cell.Source)))
+			b.WriteString(`</pre></div>`)
+		}
+	}
+	b.WriteString(`</div>`)
+
+	return template.HTML(sanitizeHTML(b.String(), docURLDir, previewImages)), nil
+}
+
+// writeNotebookOutput renders a single code cell output into b.
+func writeNotebookOutput(b *strings.Builder, md goldmark.Markdown, out notebookOutput) {
+	switch out.OutputType {
+	case "stream":
+		class := "nb-stream"
+		if out.Name == "stderr" {
+			class += " nb-stderr"
+		}
+		b.WriteString(`<div class="nb-output"><pre class="` + class + `">`)
+		b.WriteString(template.HTMLEscapeString(ansiEscape.ReplaceAllString(string(out.Text), "")))
+		b.WriteString(`</pre></div>`)
+
+	case "error":
+		b.WriteString(`<div class="nb-output"><pre class="nb-stream nb-stderr">`)
+		tb := strings.Join(out.Traceback, "\n")
+		if tb == "" {
+			tb = out.EName + ": " + out.EValue
+		}
+		b.WriteString(template.HTMLEscapeString(ansiEscape.ReplaceAllString(tb, "")))
+		b.WriteString(`</pre></div>`)
+
+	case "execute_result", "display_data":
+		b.WriteString(`<div class="nb-output">`)
+		if out.OutputType == "execute_result" {
+			b.WriteString(`<span class="nb-prompt">Out[`)
+			writeExecutionCount(b, out.ExecutionCount)
+			b.WriteString(`]:</span>`)
+		}
+		b.WriteString(`<div class="nb-result">`)
+		writeRichOutput(b, md, out.Data)
+		b.WriteString(`</div></div>`)
+	}
+}
+
+// writeRichOutput picks the richest representation in a MIME bundle.
+func writeRichOutput(b *strings.Builder, md goldmark.Markdown, data map[string]nbText) {
+	if h, ok := data["text/html"]; ok {
+		b.WriteString(balanceHTML(string(h)))
+		return
+	}
+	for _, t := range notebookImageTypes {
+		if img, ok := data[t]; ok {
+			b.WriteString(`<img src="data:` + t + `;base64,`)
+			b.WriteString(strings.Join(strings.Fields(string(img)), ""))
+			b.WriteString(`" alt="output" />`)
+			return
+		}
+	}
+	if m, ok := data["text/markdown"]; ok {
+		var buf bytes.Buffer
+		if err := md.Convert([]byte(m), &buf); err == nil {
+			b.WriteString(buf.String())
+			return
+		}
+	}
+	if txt, ok := data["text/plain"]; ok {
+		b.WriteString(`<pre>`)
+		b.WriteString(template.HTMLEscapeString(ansiEscape.ReplaceAllString(string(txt), "")))
+		b.WriteString(`</pre>`)
+	}
+}
+
+// balanceHTML re-serialises an HTML fragment through the HTML5 parser so
+// that stray closing tags in one output cannot close the notebook's own
+// wrapper elements.
+func balanceHTML(fragment string) string {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), body)
+	if err != nil {
+		return template.HTMLEscapeString(fragment)
+	}
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if err := html.Render(&buf, n); err != nil {
+			return template.HTMLEscapeString(fragment)
+		}
+	}
+	return buf.String()
+}
+
+// writeExecutionCount writes a cell's execution counter, or a space for
+// cells that have never been run.
+func writeExecutionCount(b *strings.Builder, n *int) {
+	if n == nil {
+		b.WriteString(" ")
+		return
+	}
+	fmt.Fprintf(b, "%d", *n)
+}
+
+// inlineAttachments replaces attachment:<name> references in a Markdown cell
+// with data URIs built from the cell's attachments, so pasted images render
+// without a separate file on disk.
+func inlineAttachments(src string, attachments map[string]map[string]nbText) string {
+	if len(attachments) == 0 {
+		return src
+	}
+	names := make([]string, 0, len(attachments))
+	for name := range attachments {
+		names = append(names, name)
+	}
+	// Longest first so "a.png" cannot clobber part of "data.png".
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	for _, name := range names {
+		bundle := attachments[name]
+		for _, t := range notebookImageTypes {
+			if img, ok := bundle[t]; ok {
+				uri := "data:" + t + ";base64," + strings.Join(strings.Fields(string(img)), "")
+				src = strings.ReplaceAll(src, "attachment:"+name, uri)
+				break
+			}
+		}
+	}
+	return src
+}
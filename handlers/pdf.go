@@ -0,0 +1,631 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+
+	"gileserver/models"
+)
+
+// maxPDFScan is the number of bytes of a PDF that readPDFInfo will inspect.
+// Files up to this size are read in full; larger files contribute their first
+// and last half of this budget, which is where writers place the document
+// catalog, page tree root and info dictionary (linearised files keep them at
+// the front, incrementally-updated files append them at the back).
+const maxPDFScan = 16 * 1024 * 1024
+
+// maxObjStmSize caps the decompressed size of a single object stream so a
+// crafted file cannot turn a small compressed stream into a huge allocation.
+const maxObjStmSize = 8 * 1024 * 1024
+
+// maxPDFDepth caps the nesting of arrays and dictionaries so hostile input
+// cannot exhaust the stack.
+const maxPDFDepth = 64
+
+// maxCachedPDFs bounds the PDF summary cache.
+const maxCachedPDFs = 256
+
+// pdfInfoEntry is one PDF summary, valid while the file's modification time
+// and size are unchanged.
+type pdfInfoEntry struct {
+	modTime time.Time
+	size    int64
+	info    *models.PDFInfo
+}
+
+// pdfInfoCache holds PDF summaries keyed by absolute filesystem path, so that
+// previewing a PDF does not scan up to maxPDFScan bytes on every request.
+var pdfInfoCache struct {
+	mu      sync.Mutex
+	entries map[string]*pdfInfoEntry
+}
+
+// isPDF reports whether the MIME type is a PDF document.
+func isPDF(mimeType string) bool {
+	return baseMIME(mimeType) == "application/pdf"
+}
+
+// readPDFInfo extracts the page count and the document information dictionary
+// (title, author, …) from a PDF without rendering it.
+//
+// This is deliberately not a full PDF parser: it indexes every "N G obj"
+// definition in the scanned bytes (later definitions win, matching incremental
+// updates), expands compressed object streams, and then follows the trailer's
+// /Root and /Info references. Anything it cannot resolve is left blank so the
+// card degrades gracefully rather than failing the preview.
+func readPDFInfo(fsPath string) *models.PDFInfo {
+	data, err := readPDFScanWindow(fsPath)
+	if err != nil || !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return nil
+	}
+
+	info := &models.PDFInfo{}
+	if end := bytes.IndexAny(data[5:], "\r\n"); end > 0 && end < 8 {
+		info.Version = string(data[5 : 5+end])
+	}
+
+	doc := indexPDFObjects(data)
+	trailer := doc.trailer(data)
+	if trailer == nil {
+		return info
+	}
+
+	if _, ok := trailer["Encrypt"]; ok {
+		// Strings in an encrypted document are ciphertext; showing them would
+		// only display garbage.
+		info.Encrypted = true
+	}
+
+	if root, ok := doc.resolve(trailer["Root"]).(map[string]any); ok {
+		if pages, ok := doc.resolve(root["Pages"]).(map[string]any); ok {
+			if n, ok := doc.resolve(pages["Count"]).(float64); ok {
+				info.Pages = int(n)
+			}
+		}
+	}
+	if info.Pages == 0 {
+		info.Pages = doc.maxPageCount()
+	}
+
+	if !info.Encrypted {
+		if dict, ok := doc.resolve(trailer["Info"]).(map[string]any); ok {
+			text := func(key string) string {
+				if s, ok := doc.resolve(dict[key]).(pdfString); ok {
+					return strings.TrimSpace(decodePDFText(s))
+				}
+				return ""
+			}
+			info.Title = text("Title")
+			info.Author = text("Author")
+			info.Subject = text("Subject")
+			info.Creator = text("Creator")
+			info.Producer = text("Producer")
+			info.Created = formatPDFDate(text("CreationDate"))
+		}
+	}
+	return info
+}
+
+// cachedPDFInfo returns readPDFInfo for the file at fsPath, reading it on a
+// cache miss or when the file has changed since it was cached.
+func cachedPDFInfo(fsPath string, modTime time.Time, size int64) *models.PDFInfo {
+	pdfInfoCache.mu.Lock()
+	if e, ok := pdfInfoCache.entries[fsPath]; ok && e.modTime.Equal(modTime) && e.size == size {
+		pdfInfoCache.mu.Unlock()
+		return e.info
+	}
+	pdfInfoCache.mu.Unlock()
+
+	info := readPDFInfo(fsPath)
+
+	pdfInfoCache.mu.Lock()
+	if pdfInfoCache.entries == nil {
+		pdfInfoCache.entries = make(map[string]*pdfInfoEntry)
+	}
+	if len(pdfInfoCache.entries) >= maxCachedPDFs {
+		// Drop an arbitrary entry; map iteration order is random enough
+		// for a cache this size.
+		for k := range pdfInfoCache.entries {
+			delete(pdfInfoCache.entries, k)
+			break
+		}
+	}
+	pdfInfoCache.entries[fsPath] = &pdfInfoEntry{modTime: modTime, size: size, info: info}
+	pdfInfoCache.mu.Unlock()
+	return info
+}
+
+// readPDFScanWindow returns the bytes readPDFInfo should inspect; see
+// maxPDFScan.
+func readPDFScanWindow(fsPath string) ([]byte, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() <= maxPDFScan {
+		return io.ReadAll(f)
+	}
+	half := int64(maxPDFScan / 2)
+	buf := make([]byte, 2*half)
+	if _, err := f.ReadAt(buf[:half], 0); err != nil {
+		return nil, err
+	}
+	if _, err := f.ReadAt(buf[half:], fi.Size()-half); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// pdfString is a decoded PDF string literal (raw bytes, before text decoding).
+type pdfString string
+
+// pdfName is a PDF name object without its leading slash.
+type pdfName string
+
+// pdfRef is an indirect object reference ("12 0 R").
+type pdfRef int
+
+// pdfDoc is the object index built by indexPDFObjects.
+type pdfDoc struct {
+	objects map[int]any
+}
+
+var pdfObjHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+
+// indexPDFObjects parses every top-level object definition in data, expanding
+// object streams (/Type /ObjStm) so objects stored inside them are reachable.
+func indexPDFObjects(data []byte) *pdfDoc {
+	doc := &pdfDoc{objects: make(map[int]any)}
+	var objStreams []map[string]any
+	var streamData [][]byte
+
+	headers := pdfObjHeader.FindAllSubmatchIndex(data, -1)
+	for k, m := range headers {
+		num, err := strconv.Atoi(string(data[m[2]:m[3]]))
+		if err != nil {
+			continue
+		}
+		// An object ends before the next one begins. Parsing stops there,
+		// so an unterminated string or stream is not scanned to the end of
+		// the window once for every object that follows it.
+		end := len(data)
+		if k+1 < len(headers) {
+			end = headers[k+1][0]
+		}
+		lx := &pdfLexer{b: data[:end], i: m[1]}
+		v, ok := lx.value()
+		if !ok {
+			continue
+		}
+		doc.objects[num] = v
+
+		if dict, ok := v.(map[string]any); ok && dict["Type"] == pdfName("ObjStm") {
+			if raw := lx.stream(); raw != nil {
+				objStreams = append(objStreams, dict)
+				streamData = append(streamData, raw)
+			}
+		}
+	}
+
+	for i, dict := range objStreams {
+		doc.expandObjStm(dict, streamData[i])
+	}
+	return doc
+}
+
+// expandObjStm decodes an object stream and adds its members to the index
+// unless a direct definition for the same object number already exists.
+func (d *pdfDoc) expandObjStm(dict map[string]any, raw []byte) {
+	if dict["Filter"] == pdfName("FlateDecode") {
+		zr, err := zlib.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return
+		}
+		dec, _ := io.ReadAll(io.LimitReader(zr, maxObjStmSize))
+		zr.Close()
+		raw = dec
+	} else if dict["Filter"] != nil {
+		return // other filters are vanishingly rare for object streams
+	}
+
+	n, _ := d.resolve(dict["N"]).(float64)
+	first, _ := d.resolve(dict["First"]).(float64)
+	// Both come from the file: check them against the stream before they
+	// size an allocation or a slice. Each member takes at least two numbers
+	// and a space in the header, so there are fewer than len(raw)/2.
+	if n <= 0 || first <= 0 || first > float64(len(raw)) {
+		return
+	}
+	n = min(n, float64(len(raw)/2))
+
+	hdr := &pdfLexer{b: raw[:int(first)]}
+	type member struct{ num, off int }
+	members := make([]member, 0, int(n))
+	for i := 0; i < int(n); i++ {
+		num, ok1 := hdr.value()
+		off, ok2 := hdr.value()
+		fnum, ok3 := num.(float64)
+		foff, ok4 := off.(float64)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			break
+		}
+		members = append(members, member{int(fnum), int(foff)})
+	}
+	for _, m := range members {
+		if _, exists := d.objects[m.num]; exists {
+			continue
+		}
+		start := int(first) + m.off
+		if start < 0 || start >= len(raw) {
+			continue
+		}
+		lx := &pdfLexer{b: raw, i: start}
+		if v, ok := lx.value(); ok {
+			d.objects[m.num] = v
+		}
+	}
+}
+
+// trailer returns the last trailer dictionary in data, falling back to the
+// dictionary of the last cross-reference stream for PDF 1.5+ files that have
+// no classic trailer.
+func (d *pdfDoc) trailer(data []byte) map[string]any {
+	if i := bytes.LastIndex(data, []byte("trailer")); i != -1 {
+		lx := &pdfLexer{b: data, i: i + len("trailer")}
+		if v, ok := lx.value(); ok {
+			if dict, ok := v.(map[string]any); ok && dict["Root"] != nil {
+				return dict
+			}
+		}
+	}
+	var best map[string]any
+	bestNum := -1
+	for num, v := range d.objects {
+		if dict, ok := v.(map[string]any); ok && dict["Type"] == pdfName("XRef") && dict["Root"] != nil && num > bestNum {
+			best, bestNum = dict, num
+		}
+	}
+	return best
+}
+
+// resolve follows indirect references (bounded, to survive reference loops).
+func (d *pdfDoc) resolve(v any) any {
+	for i := 0; i < 8; i++ {
+		ref, ok := v.(pdfRef)
+		if !ok {
+			return v
+		}
+		v = d.objects[int(ref)]
+	}
+	return nil
+}
+
+// maxPageCount is the fallback page count: the largest /Count of any page
+// tree node, which is the root's count in any well-formed file.
+func (d *pdfDoc) maxPageCount() int {
+	best := 0
+	for _, v := range d.objects {
+		if dict, ok := v.(map[string]any); ok && dict["Type"] == pdfName("Pages") {
+			if n, ok := d.resolve(dict["Count"]).(float64); ok && int(n) > best {
+				best = int(n)
+			}
+		}
+	}
+	return best
+}
+
+// pdfLexer is a minimal PDF object tokenizer covering the value types that
+// appear in catalogs, page-tree nodes and info dictionaries.
+type pdfLexer struct {
+	b     []byte
+	i     int
+	depth int // nesting of the arrays and dictionaries being parsed
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.i < len(l.b) {
+		c := l.b[l.i]
+		switch {
+		case c == '%':
+			for l.i < len(l.b) && l.b[l.i] != '\n' && l.b[l.i] != '\r' {
+				l.i++
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0:
+			l.i++
+		default:
+			return
+		}
+	}
+}
+
+func isPDFDelim(c byte) bool {
+	return strings.IndexByte("()<>[]{}/% \t\r\n\f\x00", c) != -1
+}
+
+// value parses the next object. Numbers followed by "G R" become pdfRef.
+func (l *pdfLexer) value() (any, bool) {
+	l.skipSpace()
+	if l.i >= len(l.b) {
+		return nil, false
+	}
+	switch c := l.b[l.i]; {
+	case c == '<' && l.i+1 < len(l.b) && l.b[l.i+1] == '<':
+		if !l.enter() {
+			return nil, false
+		}
+		defer l.leave()
+		return l.dict()
+	case c == '<':
+		return l.hexString()
+	case c == '(':
+		return l.literalString()
+	case c == '[':
+		if !l.enter() {
+			return nil, false
+		}
+		defer l.leave()
+		l.i++
+		var arr []any
+		for {
+			l.skipSpace()
+			if l.i >= len(l.b) {
+				return nil, false
+			}
+			if l.b[l.i] == ']' {
+				l.i++
+				return arr, true
+			}
+			v, ok := l.value()
+			if !ok {
+				return nil, false
+			}
+			arr = append(arr, v)
+		}
+	case c == '/':
+		l.i++
+		start := l.i
+		for l.i < len(l.b) && !isPDFDelim(l.b[l.i]) {
+			l.i++
+		}
+		return pdfName(l.b[start:l.i]), true
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.number()
+	default:
+		start := l.i
+		for l.i < len(l.b) && !isPDFDelim(l.b[l.i]) {
+			l.i++
+		}
+		switch string(l.b[start:l.i]) {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		case "null":
+			return nil, true
+		}
+		return nil, false
+	}
+}
+
+// enter records one more level of nesting and reports whether it is within
+// maxPDFDepth. A call that returns true must be paired with leave.
+func (l *pdfLexer) enter() bool {
+	l.depth++
+	if l.depth > maxPDFDepth {
+		l.depth--
+		return false
+	}
+	return true
+}
+
+// leave ends a level of nesting begun by enter.
+func (l *pdfLexer) leave() {
+	l.depth--
+}
+
+func (l *pdfLexer) number() (any, bool) {
+	start := l.i
+	for l.i < len(l.b) && strings.IndexByte("+-.0123456789", l.b[l.i]) != -1 {
+		l.i++
+	}
+	n, err := strconv.ParseFloat(string(l.b[start:l.i]), 64)
+	if err != nil {
+		return nil, false
+	}
+	// Look ahead for "<gen> R" to recognise an indirect reference.
+	save := l.i
+	l.skipSpace()
+	genStart := l.i
+	for l.i < len(l.b) && l.b[l.i] >= '0' && l.b[l.i] <= '9' {
+		l.i++
+	}
+	if l.i > genStart {
+		l.skipSpace()
+		if l.i < len(l.b) && l.b[l.i] == 'R' && (l.i+1 == len(l.b) || isPDFDelim(l.b[l.i+1])) {
+			l.i++
+			return pdfRef(int(n)), true
+		}
+	}
+	l.i = save
+	return n, true
+}
+
+func (l *pdfLexer) dict() (any, bool) {
+	l.i += 2
+	dict := make(map[string]any)
+	for {
+		l.skipSpace()
+		if l.i+1 >= len(l.b) {
+			return nil, false
+		}
+		if l.b[l.i] == '>' && l.b[l.i+1] == '>' {
+			l.i += 2
+			return dict, true
+		}
+		k, ok := l.value()
+		name, isName := k.(pdfName)
+		if !ok || !isName {
+			return nil, false
+		}
+		v, ok := l.value()
+		if !ok {
+			return nil, false
+		}
+		dict[string(name)] = v
+	}
+}
+
+func (l *pdfLexer) hexString() (any, bool) {
+	l.i++
+	var digits []byte
+	for l.i < len(l.b) && l.b[l.i] != '>' {
+		if c := l.b[l.i]; strings.IndexByte("0123456789abcdefABCDEF", c) != -1 {
+			digits = append(digits, c)
+		}
+		l.i++
+	}
+	if l.i >= len(l.b) {
+		return nil, false
+	}
+	l.i++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for j := range out {
+		v, _ := strconv.ParseUint(string(digits[2*j:2*j+2]), 16, 8)
+		out[j] = byte(v)
+	}
+	return pdfString(out), true
+}
+
+func (l *pdfLexer) literalString() (any, bool) {
+	l.i++
+	var out []byte
+	depth := 1
+	for l.i < len(l.b) {
+		c := l.b[l.i]
+		l.i++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return pdfString(out), true
+			}
+		case '\\':
+			if l.i >= len(l.b) {
+				return nil, false
+			}
+			e := l.b[l.i]
+			l.i++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r', '\n':
+				// Line continuation: swallow the newline (and a following \n).
+				if e == '\r' && l.i < len(l.b) && l.b[l.i] == '\n' {
+					l.i++
+				}
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && l.i < len(l.b) && l.b[l.i] >= '0' && l.b[l.i] <= '7'; k++ {
+						v = v*8 + int(l.b[l.i]-'0')
+						l.i++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		out = append(out, c)
+	}
+	return nil, false
+}
+
+// stream returns the raw bytes of the stream that follows a just-parsed
+// dictionary, using the "stream" / "endstream" keywords as delimiters.
+func (l *pdfLexer) stream() []byte {
+	l.skipSpace()
+	if !bytes.HasPrefix(l.b[l.i:], []byte("stream")) {
+		return nil
+	}
+	start := l.i + len("stream")
+	if start < len(l.b) && l.b[start] == '\r' {
+		start++
+	}
+	if start < len(l.b) && l.b[start] == '\n' {
+		start++
+	}
+	end := bytes.Index(l.b[start:], []byte("endstream"))
+	if end == -1 {
+		return nil
+	}
+	return l.b[start : start+end]
+}
+
+// decodePDFText converts a PDF text string to UTF-8. Strings starting with a
+// UTF-16BE or UTF-8 byte-order mark are decoded accordingly; everything else
+// is PDFDocEncoding, which matches Latin-1 for all printable characters.
+func decodePDFText(s pdfString) string {
+	b := []byte(s)
+	switch {
+	case len(b) >= 2 && b[0] == 0xfe && b[1] == 0xff:
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	case len(b) >= 3 && b[0] == 0xef && b[1] == 0xbb && b[2] == 0xbf:
+		return string(b[3:])
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// formatPDFDate turns a PDF date ("D:20240131094500+01'00'") into
+// "2024-01-31 09:45". Unrecognised values are returned unchanged.
+func formatPDFDate(s string) string {
+	d := strings.TrimPrefix(s, "D:")
+	if len(d) < 8 {
+		return s
+	}
+	for _, c := range d[:8] {
+		if c < '0' || c > '9' {
+			return s
+		}
+	}
+	out := d[0:4] + "-" + d[4:6] + "-" + d[6:8]
+	if len(d) >= 12 {
+		out += " " + d[8:10] + ":" + d[10:12]
+	}
+	return out
+}
@@ -24,6 +24,8 @@ type PreviewOptions struct {
 	Images bool // render image files inline
 	Text   bool // syntax-highlight text/code files
 	Docs   bool // render Markdown, Org-mode, and HTML as rich documents
+	PDF    bool // embed PDF documents in the browser's built-in viewer
+	Fonts  bool // show font files as type specimens
 }
 
 // PreviewHandler serves an inline preview page for any path — directory,
@@ -32,8 +34,9 @@ type PreviewOptions struct {
 func PreviewHandler(roots map[string]string, theme, siteName, defaultTheme string, opts PreviewOptions, tmpl interface{ ExecutePreview(http.ResponseWriter, *models.PreviewData) error }) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		urlPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/preview"))
+		opts := opts.forRoot(urlPath)
 
-		fsPath, err := resolvePath(roots, urlPath)
+		fsPath, err := resolvePath(r, roots, urlPath)
 		if err != nil {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
@@ -41,6 +44,18 @@ func PreviewHandler(roots map[string]string, theme, siteName, defaultTheme strin
 
 		info, err := os.Stat(fsPath)
 		if err != nil {
+			// The path may name a member inside an archive.
+			if ref, ok := resolveArchivePath(r, roots, urlPath); ok && ref.member != "" {
+				pd, err := archivePreview(ref, urlPath, theme, siteName, defaultTheme, opts)
+				if err != nil {
+					http.Error(w, "Not found", http.StatusNotFound)
+					return
+				}
+				if err := tmpl.ExecutePreview(w, pd); err != nil {
+					http.Error(w, "Template error", http.StatusInternalServerError)
+				}
+				return
+			}
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
 		}
@@ -59,18 +74,34 @@ func PreviewHandler(roots map[string]string, theme, siteName, defaultTheme strin
 		if info.IsDir() {
 			pd.IsDir = true
 			pd.DownloadURL = "/zip" + urlPath
-			// Count direct children.
+			// Count direct children, leaving out those a listing would.
+			scope := scopeFor(roots, urlPath)
 			if entries, err := os.ReadDir(fsPath); err == nil {
-				pd.EntryCount = len(entries)
+				for _, e := range entries {
+					if !scope.omitted(e.Name(), entryIsDir(fsPath, e)) {
+						pd.EntryCount++
+					}
+				}
 			}
 			// Directory size — served from cache to avoid blocking on a full walk.
-			pd.FileSize = cachedDirSize(fsPath)
+			pd.FileSize = cachedDirSize(scope, fsPath)
 		} else {
 			mime := mimeForFile(fsPath)
 			pd.MIMEType = mime
 			pd.DownloadURL = "/download" + urlPath
 			pd.ViewURL = "/view" + urlPath
 
+			// Embedded metadata is shown regardless of which preview branch is
+			// taken below, so e.g. audio tags still appear on the info card.
+			pd.Metadata = extractMetadata(fsPath, mime)
+
+			// Fonts are previewed only when their tables parse; anything
+			// else with a font extension gets the binary card.
+			var font *models.FontInfo
+			if isFont(mime) && opts.Fonts {
+				font = readFontInfo(fsPath)
+			}
+
 			switch {
 			case isImage(mime) && opts.Images:
 				// Inline image preview enabled.
@@ -78,32 +109,62 @@ func PreviewHandler(roots map[string]string, theme, siteName, defaultTheme strin
 
 			case isText(mime) && opts.Text:
 				// Syntax-highlighted (and optionally rendered) text preview enabled.
-				pd.IsText = true
-				content, err := readTextFile(fsPath)
+				// Delimited data is streamed straight into a table, so large
+				// files are not read in full just to be highlighted.
+				if opts.Docs && isTabular(mime) {
+					if table, err := renderTableFile(fsPath, mime); err == nil {
+						pd.IsText = true
+						pd.RenderedContent = table
+						pd.IsRendered = true
+						break
+					}
+				}
+				// Files over the read cap are shown a window of lines at a
+				// time rather than cut off; they are too large to render as
+				// documents anyway.
+				if info.Size() > maxTextBytes {
+					pager, err := newTextPager(fsPath, urlPath, info, r.URL.Query().Get("line"), theme)
+					if err != nil {
+						http.Error(w, "Could not read file", http.StatusInternalServerError)
+						return
+					}
+					pd.IsText = true
+					pd.TextPager = pager
+					pd.HighlightedContent = template.HTML(pager.HTML)
+					pd.TailURL = "/api/tail" + urlPath
+					break
+				}
+				content, _, err := readTextFile(fsPath)
 				if err != nil {
 					http.Error(w, "Could not read file", http.StatusInternalServerError)
 					return
 				}
-				// Always populate the highlighted fallback first.
-				highlighted, err := highlightContent(content, filepath.Base(fsPath), theme)
-				if err != nil {
-					highlighted = template.HTML("<pre class=\"chroma\"><code>" +
-						template.HTMLEscapeString(content) + "</code></pre>")
-				}
-				pd.HighlightedContent = highlighted
-				// Attempt a rich render only when document previews are also enabled.
-				if opts.Docs && isRenderable(mime) {
-					docURLDir := path.Dir(urlPath)
-					if rendered, err := renderContent(content, mime, docURLDir, opts.Images); err == nil {
-						pd.RenderedContent = rendered
-						pd.IsRendered = true
-					}
+				fillTextPreview(pd, content, mime, theme, opts)
+				if !pd.IsRendered {
+					pd.TailURL = "/api/tail" + urlPath
 				}
 
+			case isPDF(mime) && opts.PDF:
+				// Embedded viewer via /view/ plus a summary read from the file.
+				pd.IsPDF = true
+				pd.PDF = cachedPDFInfo(fsPath, info.ModTime(), info.Size())
+
+			case font != nil:
+				// Specimen rendered by the browser from /view/ via @font-face,
+				// with details read from the font's tables.
+				pd.IsFont = true
+				pd.Font = font
+
 			default:
 				// Either the file type has no preview, or the relevant preview
 				// type has been disabled by the admin — show the binary info-card.
 				pd.IsBinary = true
+				if archiveKind(fsPath) != "" {
+					pd.BrowseURL = urlPath
+				}
+				if baseMIME(mime) == "application/octet-stream" {
+					pd.Hex = hexPreview(fsPath, info.Size(), r.URL.Query().Get("offset"))
+				}
 			}
 		}
 
@@ -113,6 +174,97 @@ func PreviewHandler(roots map[string]string, theme, siteName, defaultTheme strin
 	}
 }
 
+// fillTextPreview populates the text fields of pd from content: the Chroma
+// highlighted view is always set, and a rich render is attempted when document
+// previews are enabled and the MIME type supports one.
+func fillTextPreview(pd *models.PreviewData, content, mime, theme string, opts PreviewOptions) {
+	pd.IsText = true
+	// Always populate the highlighted fallback first.
+	highlighted, err := highlightContent(content, pd.FileName, theme)
+	if err != nil {
+		highlighted = plainCodeBlock(content)
+	}
+	pd.HighlightedContent = highlighted
+	// Attempt a rich render only when document previews are also enabled.
+	if opts.Docs && isRenderable(mime) {
+		docURLDir := path.Dir(pd.FilePath)
+		if rendered, err := renderContent(content, mime, docURLDir, opts.Images); err == nil {
+			pd.RenderedContent = rendered
+			pd.IsRendered = true
+		}
+	}
+}
+
+// plainCodeBlock is the unhighlighted fallback used when Chroma fails.
+func plainCodeBlock(content string) template.HTML {
+	return template.HTML("<pre class=\"chroma\"><code>" +
+		template.HTMLEscapeString(content) + "</code></pre>")
+}
+
+// archivePreview builds the preview page for a member inside an archive.
+// Text members are read through the archive and highlighted like regular
+// files; images and PDFs are embedded via /view/, which serves members too.
+func archivePreview(ref *archiveRef, urlPath, theme, siteName, defaultTheme string, opts PreviewOptions) (*models.PreviewData, error) {
+	idx, m, err := lookupMember(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(urlPath)
+	pd := &models.PreviewData{
+		Title:        name,
+		SiteName:     siteName,
+		DefaultTheme: defaultTheme,
+		FilePath:     urlPath,
+		FileName:     name,
+		Breadcrumbs:  buildBreadcrumbs(siteName, path.Dir(urlPath)),
+		ModTime:      m.modTime,
+		FileSize:     m.size,
+		InArchive:    true,
+	}
+
+	if m.isDir {
+		pd.IsDir = true
+		pd.DownloadURL = "/download" + ref.urlPath
+		pd.EntryCount = len(m.children)
+		return pd, nil
+	}
+
+	pd.DownloadURL = "/download" + urlPath
+	pd.ViewURL = "/view" + urlPath
+
+	mime := mimeForName(name)
+	var head []byte
+	if mime == "application/octet-stream" || (isText(mime) && opts.Text) {
+		if head, err = readMemberText(ref, idx, m, maxTextBytes); err != nil {
+			return nil, err
+		}
+		if mime == "application/octet-stream" {
+			mime = sniffContent(head)
+		}
+		if m.size > maxTextBytes && isText(mime) {
+			// Members are read through a decompressor, so they cannot be
+			// paged like regular files; say that only the start is shown.
+			pd.TruncatedAt = int64(len(head))
+		}
+	}
+	pd.MIMEType = mime
+
+	switch {
+	case isImage(mime) && opts.Images:
+		pd.IsImage = true
+	case isText(mime) && opts.Text:
+		fillTextPreview(pd, string(head), mime, theme, opts)
+	case isPDF(mime) && opts.PDF:
+		// The summary parser needs random access to a file on disk, so
+		// members get the embedded viewer without it.
+		pd.IsPDF = true
+	default:
+		pd.IsBinary = true
+	}
+	return pd, nil
+}
+
 // HighlightCSSHandler serves the Chroma CSS stylesheet for the configured theme.
 // The CSS is generated once at startup and cached in memory.
 func HighlightCSSHandler(theme string) http.HandlerFunc {
@@ -135,6 +287,12 @@ func HighlightCSSHandler(theme string) http.HandlerFunc {
 
 // highlightContent runs Chroma over content, using filename for language detection.
 func highlightContent(content, filename, theme string) (template.HTML, error) {
+	return highlightLines(content, filename, theme, 1)
+}
+
+// highlightLines is highlightContent for a run of lines taken from the middle
+// of a file; the line-number gutter starts at firstLine.
+func highlightLines(content, filename, theme string, firstLine int) (template.HTML, error) {
 	lexer := lexers.Match(filename)
 	if lexer == nil {
 		lexer = lexers.Analyse(content)
@@ -153,6 +311,7 @@ func highlightContent(content, filename, theme string) (template.HTML, error) {
 		chromahtml.WithLineNumbers(true),
 		chromahtml.LineNumbersInTable(true),
 		chromahtml.TabWidth(4),
+		chromahtml.BaseLineNumber(firstLine),
 	)
 
 	iterator, err := lexer.Tokenise(nil, content)
@@ -168,18 +327,24 @@ func highlightContent(content, filename, theme string) (template.HTML, error) {
 	return template.HTML(buf.String()), nil
 }
 
+// maxTextBytes caps how much of a text file is read for previewing.
+const maxTextBytes = 2 * 1024 * 1024
+
 // readTextFile reads a file and returns its content as a string.
-// Reading is limited to 2 MB to avoid memory issues with large files.
-func readTextFile(fsPath string) (string, error) {
-	const maxBytes = 2 * 1024 * 1024
+// Reading is limited to 2 MB to avoid memory issues with large files; the
+// second result reports whether the file was longer than that.
+func readTextFile(fsPath string) (string, bool, error) {
 	f, err := os.Open(fsPath)
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	defer f.Close()
-	b, err := io.ReadAll(io.LimitReader(f, maxBytes))
+	b, err := io.ReadAll(io.LimitReader(f, maxTextBytes+1))
 	if err != nil {
-		return "", err
+		return "", false, err
+	}
+	if len(b) > maxTextBytes {
+		return string(b[:maxTextBytes]), true, nil
 	}
-	return string(b), nil
+	return string(b), false, nil
 }
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gileserver/models"
+)
+
+// Transfer quotas cap how many bytes one client may download per calendar
+// day and per calendar month (server local time). A client is the user
+// authenticated by the trusted proxy when there is one, otherwise the IP.
+// Usage is kept with the download statistics and persisted to gile.json, so
+// it survives restarts.
+//
+// BandwidthManager.Wrap refuses a transfer from a client already over quota
+// with 429 Too Many Requests, and aborts one that crosses its quota midway,
+// within quotaWindow bytes.
+
+// errQuotaExceeded is returned by limitedResponseWriter.Write once the
+// client's quota has been used up.
+var errQuotaExceeded = errors.New("transfer quota exceeded")
+
+// quotaUsage is one client's transfer volume in the current periods.
+type quotaUsage struct {
+	Day        string `json:"day"` // e.g. "2026-10-18"
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"` // e.g. "2026-10"
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// roll starts new periods for counters whose day or month has ended.
+func (u *quotaUsage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day, u.DayBytes = day, 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+	}
+}
+
+// quotaKey identifies the client a request is charged to.
+func quotaKey(r *http.Request, ip string) string {
+	if user, _ := proxyIdentity(r); user != "" {
+		return "user:" + user
+	}
+	return "ip:" + ip
+}
+
+// quotaUsed returns the bytes key has transferred today and this month.
+func quotaUsed(key string, now time.Time) (int64, int64) {
+	downloadStats.mu.Lock()
+	defer downloadStats.mu.Unlock()
+	u, ok := downloadStats.data.Quotas[key]
+	if !ok {
+		return 0, 0
+	}
+	u.roll(now)
+	return u.DayBytes, u.MonthBytes
+}
+
+// chargeQuota adds n transferred bytes to key's usage. The change is
+// persisted by saveQuotas after the transfer ends.
+func chargeQuota(key string, n int64, now time.Time) {
+	downloadStats.mu.Lock()
+	defer downloadStats.mu.Unlock()
+	for _, st := range []*persistedStats{&downloadStats.data, &downloadStats.unsaved} {
+		if st.Quotas == nil {
+			st.Quotas = make(map[string]*quotaUsage)
+		}
+		u, ok := st.Quotas[key]
+		if !ok {
+			u = &quotaUsage{}
+			st.Quotas[key] = u
+		}
+		u.roll(now)
+		u.DayBytes += n
+		u.MonthBytes += n
+	}
+}
+
+// saveQuotas schedules a write of the stats file, which also drops usage
+// from past months. Writes are batched like those of RecordRejection;
+// FlushStats writes whatever is pending when the server exits.
+func saveQuotas() {
+	downloadStats.mu.Lock()
+	queueSaveLocked()
+	downloadStats.mu.Unlock()
+}
+
+// quotaState describes a quota that has been used up.
+type quotaState struct {
+	period string // "daily" or "monthly"
+	used   int64
+	limit  int64
+	reset  time.Time
+}
+
+// checkQuota returns the exhausted quota of key, or nil when it may still
+// download. When both quotas are used up, the one that resets later is
+// reported.
+func (bm *BandwidthManager) checkQuota(key string, now time.Time) *quotaState {
+	day, month := quotaUsed(key, now)
+	limits := bm.currentLimits()
+	var q *quotaState
+	if limits.DailyQuota > 0 && day >= limits.DailyQuota {
+		y, m, d := now.Date()
+		q = &quotaState{"daily", day, limits.DailyQuota, time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())}
+	}
+	if limits.MonthlyQuota > 0 && month >= limits.MonthlyQuota {
+		y, m, _ := now.Date()
+		q = &quotaState{"monthly", month, limits.MonthlyQuota, time.Date(y, m+1, 1, 0, 0, 0, 0, now.Location())}
+	}
+	return q
+}
+
+// quotaEnabled reports whether any transfer quota is set.
+func (bm *BandwidthManager) quotaEnabled() bool {
+	limits := bm.currentLimits()
+	return limits.DailyQuota > 0 || limits.MonthlyQuota > 0
+}
+
+// quotaWindow is how many bytes a transfer sends between looks at its
+// client's usage, and so how far it may overshoot the quota.
+const quotaWindow = 1 << 20
+
+// quotaMeter charges a transfer's bytes to its client and reports when the
+// client's quota runs out. Bytes are charged and the quota checked once per
+// quotaWindow rather than on every write. It is used by one goroutine.
+type quotaMeter struct {
+	bm      *BandwidthManager
+	key     string
+	pending int64 // bytes sent since the last check, not yet charged
+	over    bool  // the last check found the quota used up
+}
+
+func (m *quotaMeter) exhausted() bool {
+	if m.pending >= quotaWindow {
+		m.flush()
+		m.over = m.bm.checkQuota(m.key, time.Now()) != nil
+	}
+	return m.over
+}
+
+func (m *quotaMeter) charge(n int) {
+	m.pending += int64(n)
+}
+
+// flush charges the bytes sent since the last check.
+func (m *quotaMeter) flush() {
+	if m.pending > 0 {
+		chargeQuota(m.key, m.pending, time.Now())
+		m.pending = 0
+	}
+}
+
+// SetQuotaPage configures the HTML page shown to clients over quota. Without
+// it they receive a plain-text reply. A reload calls it again with the new
+// site name and theme.
+func (bm *BandwidthManager) SetQuotaPage(siteName, defaultTheme string, tmpl interface {
+	ExecuteQuota(http.ResponseWriter, *models.QuotaPage) error
+}) {
+	page := func(w http.ResponseWriter, data *models.QuotaPage) error {
+		data.SiteName, data.DefaultTheme = siteName, defaultTheme
+		return tmpl.ExecuteQuota(w, data)
+	}
+	bm.mu.Lock()
+	bm.quotaPage = page
+	bm.mu.Unlock()
+}
+
+// serveQuotaExceeded replies 429 Too Many Requests with a Retry-After header
+// giving the seconds until the quota resets.
+func (bm *BandwidthManager) serveQuotaExceeded(w http.ResponseWriter, q *quotaState, now time.Time) {
+	wait := q.reset.Sub(now)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.Header().Set("Cache-Control", "no-store")
+	bm.mu.Lock()
+	page := bm.quotaPage
+	bm.mu.Unlock()
+	if page == nil {
+		http.Error(w, fmt.Sprintf("Download quota exceeded: %s of %s %s used. Try again after %s.",
+			formatSize(q.used), formatSize(q.limit), q.period, q.reset.Format("2006-01-02 15:04 MST")),
+			http.StatusTooManyRequests)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+	err := page(w, &models.QuotaPage{
+		Title:   "Download limit reached",
+		Period:  q.period,
+		Used:    q.used,
+		Limit:   q.limit,
+		ResetAt: q.reset,
+		ResetIn: formatWait(wait),
+	})
+	if err != nil {
+		log.Printf("quota page: %v", err)
+	}
+}
+
+// formatWait renders a duration as whole hours and minutes, e.g. "5 hours
+// and 12 minutes".
+func formatWait(d time.Duration) string {
+	mins := int(math.Ceil(d.Minutes()))
+	if mins < 60 {
+		return countNoun(mins, "minute")
+	}
+	h, m := mins/60, mins%60
+	if h >= 48 {
+		return countNoun((h+23)/24, "day")
+	}
+	if m == 0 {
+		return countNoun(h, "hour")
+	}
+	return countNoun(h, "hour") + " and " + countNoun(m, "minute")
+}
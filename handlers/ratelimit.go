@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RequestLimiter caps how often each client IP may make requests. Routes
+// that cost the server real work (ZIP streams, previews, the search index,
+// diffs, text windows) draw on a separate, smaller budget than everything
+// else, so a client hammering previews cannot crowd out its own browsing and
+// vice versa. Static assets are never limited.
+type RequestLimiter struct {
+	heavy, light RequestRate
+
+	mu      sync.Mutex
+	clients map[string]*clientBuckets
+	done    chan struct{} // closed by Close to stop sweep
+}
+
+// RequestRate is a request budget: Count requests per Per, with bursts of up
+// to Count. The zero value means unlimited.
+type RequestRate struct {
+	Count int
+	Per   time.Duration
+}
+
+// limit converts the budget to a token-bucket rate.
+func (r RequestRate) limit() rate.Limit {
+	return rate.Limit(float64(r.Count) / r.Per.Seconds())
+}
+
+// clientBuckets are the token buckets of one client IP.
+type clientBuckets struct {
+	heavy, light *rate.Limiter
+	lastSeen     time.Time
+}
+
+// requestClientIdle is how long a client's buckets are kept after its last
+// request. By then they have refilled, so forgetting them changes nothing.
+const requestClientIdle = 10 * time.Minute
+
+// NewRequestLimiter creates a limiter with the given budgets for heavy and
+// light routes. It returns nil when both are unlimited.
+func NewRequestLimiter(heavy, light RequestRate) *RequestLimiter {
+	if heavy.Count == 0 && light.Count == 0 {
+		return nil
+	}
+	rl := &RequestLimiter{heavy: heavy, light: light, clients: make(map[string]*clientBuckets), done: make(chan struct{})}
+	go rl.sweep()
+	return rl
+}
+
+// Close stops the limiter's background work, when a reload replaces it. A
+// nil RequestLimiter may be closed.
+func (rl *RequestLimiter) Close() {
+	if rl != nil {
+		close(rl.done)
+	}
+}
+
+// sweep periodically forgets clients that have been idle, until Close.
+func (rl *RequestLimiter) sweep() {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-tick.C:
+		}
+		cutoff := time.Now().Add(-requestClientIdle)
+		rl.mu.Lock()
+		for ip, c := range rl.clients {
+			if c.lastSeen.Before(cutoff) {
+				delete(rl.clients, ip)
+			}
+		}
+		rl.mu.Unlock()
+	}
+}
+
+// heavyRoute reports whether a request path is one of the expensive routes.
+func heavyRoute(urlPath string) bool {
+	for _, prefix := range []string{"/zip/", "/preview/", "/api/index", "/api/text/", "/diff"} {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// exemptRoute reports whether a request path is never rate limited.
+func exemptRoute(urlPath string) bool {
+	return strings.HasPrefix(urlPath, "/static/") || urlPath == "/favicon.ico" || urlPath == "/highlight.css"
+}
+
+// bucket returns the token bucket a heavy or light request from ip draws on, or
+// nil when that class of route is unlimited.
+func (rl *RequestLimiter) bucket(ip string, heavy bool) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	c, ok := rl.clients[ip]
+	if !ok {
+		c = &clientBuckets{}
+		if rl.heavy.Count > 0 {
+			c.heavy = rate.NewLimiter(rl.heavy.limit(), rl.heavy.Count)
+		}
+		if rl.light.Count > 0 {
+			c.light = rate.NewLimiter(rl.light.limit(), rl.light.Count)
+		}
+		rl.clients[ip] = c
+	}
+	c.lastSeen = time.Now()
+	if heavy {
+		return c.heavy
+	}
+	return c.light
+}
+
+// Wrap returns h behind the per-IP request budgets. Requests over budget are
+// refused with 429 Too Many Requests and a Retry-After header. A nil
+// RequestLimiter returns h unchanged.
+func (rl *RequestLimiter) Wrap(h http.Handler) http.Handler {
+	if rl == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exemptRoute(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		heavy := heavyRoute(r.URL.Path)
+		b := rl.bucket(ip, heavy)
+		if b == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		res := b.Reserve()
+		if delay := res.Delay(); delay > 0 {
+			// Give the token back: the request is refused, not queued.
+			res.Cancel()
+			kind := "light"
+			if heavy {
+				kind = "heavy"
+			}
+			log.Printf("rate limited    ip=%-15s  class=%s  path=%s", ip, kind, r.URL.Path)
+			RecordRejection("rate_" + kind)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			http.Error(w, "Too many requests, please slow down", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
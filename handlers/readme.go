@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gileserver/models"
+)
+
+// readmeNames lists the README file names shown beneath a directory
+// listing, most preferred first. Matching is case-insensitive.
+var readmeNames = []string{
+	"readme.md", "readme.markdown",
+	"readme.org",
+	"readme.rst",
+	"readme.adoc", "readme.asciidoc",
+	"readme.txt", "readme",
+}
+
+// maxCachedReadmes bounds the README render cache. Entries are small, but a
+// crawler walking every directory of a large tree should not grow it without
+// limit.
+const maxCachedReadmes = 512
+
+// readmeEntry is one rendered README, valid while the file's modification
+// time and size are unchanged.
+type readmeEntry struct {
+	modTime time.Time
+	size    int64
+	html    template.HTML
+}
+
+// readmeCache holds rendered READMEs keyed by absolute filesystem path, URL
+// directory and image setting, so that listing a directory does not re-render its README
+// on every request.
+var readmeCache struct {
+	mu      sync.Mutex
+	entries map[string]*readmeEntry
+}
+
+func init() {
+	readmeCache.entries = make(map[string]*readmeEntry)
+}
+
+// findReadme returns the preferred README among entries, or nil.
+func findReadme(entries []models.FileEntry) *models.FileEntry {
+	best, bestRank := -1, len(readmeNames)
+	for i := range entries {
+		if entries[i].IsDir {
+			continue
+		}
+		name := strings.ToLower(entries[i].Name)
+		for rank, candidate := range readmeNames {
+			if name == candidate && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	return &entries[best]
+}
+
+// dirReadme renders the README found among entries of the directory at
+// fsPath (URL path urlPath). It returns the entry and its HTML, or nil and
+// "" when there is no README, document rendering is disabled, or the README
+// cannot be rendered.
+func dirReadme(entries []models.FileEntry, fsPath, urlPath string, opts PreviewOptions) (*models.FileEntry, template.HTML) {
+	if !opts.Docs {
+		return nil, ""
+	}
+	fe := findReadme(entries)
+	if fe == nil {
+		return nil, ""
+	}
+	html := cachedReadme(filepath.Join(fsPath, fe.Name), fe, urlPath, opts.Images)
+	if html == "" {
+		return nil, ""
+	}
+	return fe, html
+}
+
+// cachedReadme returns the rendered README at fsPath, rendering it on a
+// cache miss or when the file has changed since it was cached.
+func cachedReadme(fsPath string, fe *models.FileEntry, docURLDir string, previewImages bool) template.HTML {
+	key := fsPath + "\x00" + docURLDir + "\x00" + strconv.FormatBool(previewImages)
+	readmeCache.mu.Lock()
+	if e, ok := readmeCache.entries[key]; ok && e.modTime.Equal(fe.ModTime) && e.size == fe.Size {
+		readmeCache.mu.Unlock()
+		return e.html
+	}
+	readmeCache.mu.Unlock()
+
+	html := renderReadme(fsPath, fe.MIMEType, docURLDir, previewImages)
+
+	readmeCache.mu.Lock()
+	if len(readmeCache.entries) >= maxCachedReadmes {
+		// Drop an arbitrary entry; map iteration order is random enough
+		// for a cache this size.
+		for k := range readmeCache.entries {
+			delete(readmeCache.entries, k)
+			break
+		}
+	}
+	readmeCache.entries[key] = &readmeEntry{modTime: fe.ModTime, size: fe.Size, html: html}
+	readmeCache.mu.Unlock()
+	return html
+}
+
+// clearReadmes drops every rendered README, so that each is rendered afresh
+// with the current render options.
+func clearReadmes() {
+	readmeCache.mu.Lock()
+	readmeCache.entries = make(map[string]*readmeEntry)
+	readmeCache.mu.Unlock()
+}
+
+// renderReadme renders a README through renderContent. Plain-text READMEs
+// (README, README.txt) are shown preformatted. Relative links and images
+// resolve against docURLDir, the directory being listed.
+func renderReadme(fsPath, mime, docURLDir string, previewImages bool) template.HTML {
+	content, truncated, err := readTextFile(fsPath)
+	if err != nil {
+		return ""
+	}
+	banner := template.HTML("")
+	if truncated {
+		banner = template.HTML(`<p class="text-truncated">This README is longer than ` +
+			formatSize(maxTextBytes) + `; only the beginning is shown.</p>`)
+	}
+	if baseMIME(mime) != "text/html" && isRenderable(mime) {
+		rendered, err := renderContent(content, mime, docURLDir, previewImages)
+		if err == nil {
+			return banner + rendered
+		}
+		log.Printf("readme: render %s: %v", fsPath, err)
+	}
+	if !isText(mime) {
+		return ""
+	}
+	return banner + template.HTML(`<pre class="readme-plain">`+template.HTMLEscapeString(content)+"</pre>")
+}
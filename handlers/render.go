@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"html/template"
 	"path"
+	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/alecthomas/chroma/v2"
 	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
@@ -20,9 +22,13 @@ import (
 	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
 )
 
+// renderMu guards renderTheme and docPolicy, which a reload may replace
+// while documents are being rendered.
+var renderMu sync.RWMutex
+
 // renderTheme is the Chroma style name used for code block highlighting inside
 // document renders (Markdown, Org-mode). Defaults to catppuccin-mocha; set
-// once at startup by InitRenderOptions.
+// by InitRenderOptions.
 var renderTheme = "catppuccin-mocha"
 
 // docPolicy is the bluemonday sanitization policy applied to all rendered
@@ -30,16 +36,27 @@ var renderTheme = "catppuccin-mocha"
 // back to a conservative default if called before initialisation.
 var docPolicy *bluemonday.Policy
 
-// InitRenderOptions configures the document renderer. It must be called once
-// at startup, before the server begins accepting requests.
+// InitRenderOptions configures the document renderer. It is called at
+// startup, before the server begins accepting requests, and again on every
+// reload, which drops the READMEs rendered with the previous options.
 //
 //   - theme:         Chroma style name for code block syntax highlighting.
 //   - previewImages: when true, data: URIs are permitted on <img> src so that
 //     base64-embedded images in Markdown/Org documents render inline,
 //     consistent with the server-wide --preview-images setting.
 func InitRenderOptions(theme string, previewImages bool) {
-	renderTheme = theme
-	docPolicy = buildDocPolicy(previewImages)
+	policy := buildDocPolicy(previewImages)
+	renderMu.Lock()
+	renderTheme, docPolicy = theme, policy
+	renderMu.Unlock()
+	clearReadmes()
+}
+
+// renderOptions returns the document renderer's theme and sanitization policy.
+func renderOptions() (string, *bluemonday.Policy) {
+	renderMu.RLock()
+	defer renderMu.RUnlock()
+	return renderTheme, docPolicy
 }
 
 // buildDocPolicy constructs the bluemonday allowlist policy used to sanitize
@@ -128,16 +145,54 @@ func buildDocPolicy(allowDataImages bool) *bluemonday.Policy {
 	// --- Quotation source ---
 	p.AllowAttrs("cite").OnElements("blockquote", "del", "q")
 
+	// --- MathML (TeX math rendered by latexToMathML) ---
+	// Only the presentation elements and attributes the translator emits.
+	// bluemonday drops attribute-less elements it does not know, so they
+	// are allowed without attributes explicitly.
+	p.AllowNoAttrs().OnElements(
+		"math", "semantics", "annotation",
+		"mrow", "mi", "mn", "mo", "mtext", "mspace", "merror",
+		"msub", "msup", "msubsup", "munder", "mover", "munderover",
+		"mfrac", "msqrt", "mroot",
+		"mtable", "mtr", "mtd",
+	)
+	p.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+	p.AllowAttrs("encoding").OnElements("annotation")
+	mathBool := regexp.MustCompile(`^(true|false)$`)
+	p.AllowAttrs("mathvariant").Matching(regexp.MustCompile(`^(normal|bold)$`)).OnElements("mi")
+	p.AllowAttrs("stretchy", "fence", "largeop", "movablelimits").Matching(mathBool).OnElements("mo")
+	p.AllowAttrs("width").Matching(regexp.MustCompile(`^-?[0-9.]+em$`)).OnElements("mspace")
+	p.AllowAttrs("linethickness").Matching(bluemonday.Integer).OnElements("mfrac")
+	p.AllowAttrs("accent").Matching(mathBool).OnElements("mover")
+	p.AllowAttrs("accentunder").Matching(mathBool).OnElements("munder")
+	p.AllowAttrs("columnalign").Matching(regexp.MustCompile(`^[a-z ]+$`)).OnElements("mtable")
+
+	// --- Inline SVG (Graphviz diagrams rendered by renderDiagram) ---
+	// Shapes, paths and text only: no <script>, <foreignObject>, <use>,
+	// <image> or event attributes, and no href on any SVG element.
+	p.AllowNoAttrs().OnElements("svg", "g", "path", "polygon", "polyline", "ellipse", "circle", "rect", "line", "text")
+	p.AllowAttrs("width", "height", "viewbox").OnElements("svg")
+	p.AllowAttrs("transform").OnElements("g")
+	p.AllowAttrs("fill", "fill-opacity", "stroke", "stroke-width", "stroke-opacity", "stroke-dasharray").
+		OnElements("path", "polygon", "polyline", "ellipse", "circle", "rect", "line", "text")
+	p.AllowAttrs("d").OnElements("path")
+	p.AllowAttrs("points").OnElements("polygon", "polyline")
+	p.AllowAttrs("cx", "cy", "rx", "ry", "r").OnElements("ellipse", "circle")
+	p.AllowAttrs("x", "y", "rx", "ry", "width", "height").OnElements("rect")
+	p.AllowAttrs("x1", "y1", "x2", "y2").OnElements("line")
+	p.AllowAttrs("x", "y", "text-anchor", "font-family", "font-size", "font-weight", "font-style").OnElements("text")
+
 	return p
 }
 
 // isRenderable reports whether a MIME type has a rich renderer available.
 func isRenderable(mimeType string) bool {
 	switch baseMIME(mimeType) {
-	case "text/markdown", "text/html", "text/x-org":
+	case "text/markdown", "text/html", "text/x-org", "application/x-ipynb+json",
+		"text/x-rst", "text/x-asciidoc":
 		return true
 	}
-	return false
+	return isTabular(mimeType)
 }
 
 // renderContent attempts a rich render for the given content and MIME type.
@@ -161,12 +216,21 @@ func renderContent(content, mimeType, docURLDir string, previewImages bool) (tem
 		return renderOrg(content, docURLDir, previewImages)
 	case "text/html":
 		return renderHTML(content)
+	case "text/x-rst":
+		return renderRST(content, docURLDir, previewImages)
+	case "text/x-asciidoc":
+		return renderAsciiDoc(content, docURLDir, previewImages)
+	case "application/x-ipynb+json":
+		return renderNotebook(content, docURLDir, previewImages)
+	case "text/csv", "text/tab-separated-values":
+		return renderTable(strings.NewReader(content), mimeType)
 	}
 	return "", fmt.Errorf("no renderer for %q", mimeType)
 }
 
 // renderMarkdown converts Markdown to HTML using goldmark with GitHub-flavoured
-// extensions and Chroma syntax highlighting on fenced code blocks.
+// extensions and Chroma syntax highlighting on fenced code blocks. TeX math
+// is rendered to MathML and ```dot blocks to SVG by docExtension.
 //
 // WithUnsafe allows raw HTML blocks in Markdown source to pass through the
 // renderer so authors can embed arbitrary HTML in their documents. All output
@@ -177,31 +241,40 @@ func renderContent(content, mimeType, docURLDir string, previewImages bool) (tem
 // Chroma with WithClasses(true) so highlighted blocks pick up their colours
 // from the same highlight.css that the rest of the preview system uses.
 func renderMarkdown(content, docURLDir string, previewImages bool) (template.HTML, error) {
-	md := goldmark.New(
+	var buf bytes.Buffer
+	if err := newMarkdown().Convert([]byte(content), &buf); err != nil {
+		return "", fmt.Errorf("markdown render: %w", err)
+	}
+	return template.HTML(sanitizeHTML(buf.String(), docURLDir, previewImages)), nil
+}
+
+// newMarkdown returns the goldmark pipeline shared by every Markdown render,
+// including Markdown cells inside notebooks. Its output is unsanitised; the
+// caller must pass the final document through sanitizeHTML.
+func newMarkdown() goldmark.Markdown {
+	theme, _ := renderOptions()
+	hl := []highlighting.Option{
+		highlighting.WithStyle(theme),
+		highlighting.WithFormatOptions(
+			chromahtml.WithClasses(true),
+		),
+	}
+	return goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM, // tables, strikethrough, linkify, task lists
 			extension.Footnote,
 			extension.DefinitionList,
 			extension.Typographer,
-			highlighting.NewHighlighting(
-				highlighting.WithStyle(renderTheme),
-				highlighting.WithFormatOptions(
-					chromahtml.WithClasses(true),
-				),
-			),
+			highlighting.NewHighlighting(hl...),
+			&docExtension{highlight: highlighting.NewHTMLRenderer(hl...)}, // math and DOT diagrams
 		),
 		goldmark.WithParserOptions(
 			parser.WithAutoHeadingID(),
 		),
 		goldmark.WithRendererOptions(
-			goldmarkhtml.WithUnsafe(), // raw HTML blocks pass through; sanitized below
+			goldmarkhtml.WithUnsafe(), // raw HTML blocks pass through; sanitized by the caller
 		),
 	)
-	var buf bytes.Buffer
-	if err := md.Convert([]byte(content), &buf); err != nil {
-		return "", fmt.Errorf("markdown render: %w", err)
-	}
-	return template.HTML(sanitizeHTML(buf.String(), docURLDir, previewImages)), nil
 }
 
 // renderOrg converts Emacs Org-mode content to HTML using go-org with Chroma
@@ -212,14 +285,22 @@ func renderMarkdown(content, docURLDir string, previewImages bool) (template.HTM
 // the hook returns a non-empty string, go-org uses that HTML verbatim (wrapped
 // in a <div class="highlight"> container); when it returns empty, go-org falls
 // back to its default plain-text <pre> rendering. This lets us slot Chroma in
-// without post-processing the rendered output.
+// without post-processing the rendered output. The same hook renders dot
+// source blocks as Graphviz diagrams, and orgDocWriter renders LaTeX
+// fragments as MathML.
 //
 // All rendered output is passed through sanitizeHTML before being placed in
 // the page.
 func renderOrg(content, docURLDir string, previewImages bool) (template.HTML, error) {
 	doc := org.New().Parse(strings.NewReader(content), "")
-	w := org.NewHTMLWriter()
+	w := &orgDocWriter{org.NewHTMLWriter()}
+	w.ExtendingWriter = w
 	w.HighlightCodeBlock = func(source, lang string, inline bool, _ map[string]string) string {
+		if !inline && isDiagramLang(lang) {
+			if svg := renderDiagram(source); svg != "" {
+				return svg
+			}
+		}
 		return chromaHighlightBlock(source, lang)
 	}
 	out, err := doc.Write(w)
@@ -243,7 +324,8 @@ func chromaHighlightBlock(source, lang string) string {
 	}
 	l = chroma.Coalesce(l)
 
-	style := styles.Get(renderTheme)
+	theme, _ := renderOptions()
+	style := styles.Get(theme)
 	if style == nil {
 		style = styles.Fallback
 	}
@@ -264,6 +346,15 @@ func chromaHighlightBlock(source, lang string) string {
 	return buf.String()
 }
 
+// highlightOrEscape highlights source with chromaHighlightBlock, falling
+// back to an escaped <pre> block if highlighting fails.
+func highlightOrEscape(source, lang string) string {
+	if h := chromaHighlightBlock(source, lang); h != "" {
+		return h
+	}
+	return "<pre><code>" + template.HTMLEscapeString(source) + "</code></pre>"
+}
+
 // renderHTML wraps the raw HTML in a sandboxed container.
 // We do NOT inject it directly into the page DOM to avoid XSS — instead we
 // wrap it in an <iframe srcdoc="…"> with a restrictive sandbox attribute so
@@ -300,7 +391,7 @@ func baseMIME(mimeType string) string {
 // conservative policy without data: image support is built on the fly.
 func sanitizeHTML(input, docURLDir string, previewImages bool) string {
 	input = rewriteImgSrcURLs(input, docURLDir)
-	p := docPolicy
+	_, p := renderOptions()
 	if p == nil {
 		p = buildDocPolicy(previewImages)
 	} else if !previewImages {
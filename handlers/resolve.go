@@ -2,13 +2,17 @@ package handlers
 
 import (
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"strings"
 )
 
 // resolvePath translates a URL path into an absolute filesystem path, using
-// the roots map.  It also validates against directory traversal attacks.
-func resolvePath(roots map[string]string, urlPath string) (string, error) {
+// the roots map.  It also validates against directory traversal attacks, and
+// refuses paths the root's exclusion rules or a .gileignore file exclude, and
+// roots whose allow list does not admit the client making r. urlPath must
+// already be cleaned, so that its first segment is the root it resolves in.
+func resolvePath(r *http.Request, roots map[string]string, urlPath string) (string, error) {
 	// URL path must start with /
 	if !strings.HasPrefix(urlPath, "/") {
 		return "", fmt.Errorf("invalid path")
@@ -19,7 +23,7 @@ func resolvePath(roots map[string]string, urlPath string) (string, error) {
 	rootName := parts[0]
 
 	rootFS, ok := roots[rootName]
-	if !ok {
+	if !ok || !rootSettings(rootName).allows(r) {
 		return "", fmt.Errorf("unknown root %q", rootName)
 	}
 
@@ -27,7 +31,6 @@ func resolvePath(roots map[string]string, urlPath string) (string, error) {
 	if len(parts) > 1 {
 		rel = parts[1]
 	}
-
 	fsPath := filepath.Join(rootFS, rel)
 
 	// Security: ensure resolved path is still under the declared root.
@@ -37,5 +40,9 @@ func resolvePath(roots map[string]string, urlPath string) (string, error) {
 		return "", fmt.Errorf("path traversal detected")
 	}
 
+	if rel, err := filepath.Rel(cleanRoot, cleanPath); err == nil && excludedPath(rootSettings(rootName), cleanRoot, filepath.ToSlash(rel)) {
+		return "", fmt.Errorf("excluded path")
+	}
+
 	return cleanPath, nil
 }
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// RootSettings are the per-root options from the config file. The zero value
+// leaves a root with the global behaviour.
+type RootSettings struct {
+	// DisplayName and Description are shown for the root on the front page.
+	DisplayName string
+	Description string
+	// Hidden lists patterns of paths left out of listings, search and ZIP
+	// archives; Exclude lists patterns of paths that are not served at all.
+	// Patterns use gitignore syntax and are relative to the root.
+	Hidden  []string
+	Exclude []string
+	// Preview overrides the global preview toggles; nil fields keep them.
+	Preview RootPreview
+	// Allow restricts the root to the listed clients: IP addresses, CIDR
+	// ranges, "user:<name>" and "group:<name>". Empty means everyone.
+	Allow []string
+}
+
+// RootPreview holds per-root overrides of the preview toggles.
+type RootPreview struct {
+	Images, Text, Docs, PDF, Fonts *bool
+}
+
+// rootConfig is the parsed form of a root's RootSettings.
+type rootConfig struct {
+	RootSettings
+	hidden  []ignorePattern
+	exclude []ignorePattern
+	nets    []*net.IPNet
+	users   map[string]bool
+	groups  map[string]bool
+}
+
+// rootConfigs holds the settings of every root that has any, keyed by root
+// name. It is replaced by ConfigureRoots, at startup and on every reload.
+var rootConfigs atomic.Pointer[map[string]*rootConfig]
+
+// ConfigureRoots installs the per-root settings, keyed by root name. Entries
+// of Allow that do not parse are ignored; config.Load has already rejected
+// them. It is safe to call while requests are being served, and drops the
+// cached search indexes and marks the directory sizes stale, as both depend
+// on the settings.
+func ConfigureRoots(settings map[string]RootSettings) {
+	configs := make(map[string]*rootConfig, len(settings))
+	for name, s := range settings {
+		rc := &rootConfig{
+			RootSettings: s,
+			hidden:       compileIgnore(s.Hidden),
+			exclude:      compileIgnore(s.Exclude),
+			users:        map[string]bool{},
+			groups:       map[string]bool{},
+		}
+		for _, rule := range s.Allow {
+			switch {
+			case strings.HasPrefix(rule, "user:"):
+				rc.users[strings.TrimPrefix(rule, "user:")] = true
+			case strings.HasPrefix(rule, "group:"):
+				rc.groups[strings.TrimPrefix(rule, "group:")] = true
+			default:
+				if n := parseNet(rule); n != nil {
+					rc.nets = append(rc.nets, n)
+				}
+			}
+		}
+		configs[name] = rc
+	}
+	rootConfigs.Store(&configs)
+	clearIndex()
+	invalidateSizes()
+}
+
+// parseNet parses an IP address or CIDR range into a network.
+func parseNet(s string) *net.IPNet {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil
+	}
+	return n
+}
+
+// rootOf returns the root name of a URL path such as /media/films/a.mkv.
+func rootOf(urlPath string) string {
+	name, _, _ := strings.Cut(strings.TrimPrefix(urlPath, "/"), "/")
+	return name
+}
+
+// rootSettings returns the parsed settings of the named root, or nil when it
+// has none.
+func rootSettings(name string) *rootConfig {
+	if configs := rootConfigs.Load(); configs != nil {
+		return (*configs)[name]
+	}
+	return nil
+}
+
+// allows reports whether the client making r may use the root.
+func (rc *rootConfig) allows(r *http.Request) bool {
+	if rc == nil || len(rc.Allow) == 0 {
+		return true
+	}
+	if ip := net.ParseIP(clientIP(r)); ip != nil {
+		for _, n := range rc.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	user, groups := proxyIdentity(r)
+	if user != "" && rc.users[user] {
+		return true
+	}
+	for _, g := range groups {
+		if rc.groups[g] {
+			return true
+		}
+	}
+	return false
+}
+
+// forRoot returns the preview options for a file under the root of urlPath,
+// with that root's overrides applied.
+func (o PreviewOptions) forRoot(urlPath string) PreviewOptions {
+	rc := rootSettings(rootOf(urlPath))
+	if rc == nil {
+		return o
+	}
+	set := func(dst *bool, v *bool) {
+		if v != nil {
+			*dst = *v
+		}
+	}
+	set(&o.Images, rc.Preview.Images)
+	set(&o.Text, rc.Preview.Text)
+	set(&o.Docs, rc.Preview.Docs)
+	set(&o.PDF, rc.Preview.PDF)
+	set(&o.Fonts, rc.Preview.Fonts)
+	return o
+}
+
+// visibleRoots returns the roots the client making r may use. It returns
+// roots itself when no root restricts access.
+func visibleRoots(r *http.Request, roots map[string]string) map[string]string {
+	restricted := false
+	for name := range roots {
+		if rc := rootSettings(name); rc != nil && len(rc.Allow) > 0 {
+			restricted = true
+			break
+		}
+	}
+	if !restricted {
+		return roots
+	}
+	visible := make(map[string]string, len(roots))
+	for name, dir := range roots {
+		if rootSettings(name).allows(r) {
+			visible[name] = dir
+		}
+	}
+	return visible
+}
+
+// rootKey identifies a set of roots, for caching per set.
+func rootKey(roots map[string]string) string {
+	names := make([]string, 0, len(roots))
+	for name := range roots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "/")
+}
+
+// routePrefixes are the routes whose path, after the prefix, begins with a
+// root name.
+var routePrefixes = []string{"/download/", "/view/", "/zip/", "/preview/", "/api/tail/", "/api/text/"}
+
+// RootAccess returns h behind the roots' allow lists. A client asking for a
+// root it may not use gets 404 Not Found, as if the root did not exist.
+// Routes that span roots (the front page, search, ZIP of everything) filter
+// their content themselves.
+func RootAccess(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range requestRoots(r) {
+			if !rootSettings(name).allows(r) {
+				http.Error(w, "Not found", http.StatusNotFound)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestRoots returns the names of the roots a request reads from. Paths are
+// cleaned first, as the handlers clean them, so that a ".." segment cannot
+// name one root here and resolve in another; resolvePath checks the allow
+// list again on the path it actually serves.
+func requestRoots(r *http.Request) []string {
+	p := r.URL.Path
+	if p == "/diff" {
+		a, b := diffPaths(r.URL.Query())
+		return []string{rootOf(a), rootOf(b)}
+	}
+	for _, prefix := range routePrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return []string{cleanRootOf(strings.TrimPrefix(p, prefix))}
+		}
+	}
+	if strings.HasPrefix(p, "/static/") || strings.HasPrefix(p, "/api/") || strings.HasPrefix(p, "/admin/") {
+		return nil
+	}
+	// Directory listings: /<root>/...
+	return []string{cleanRootOf(p)}
+}
+
+// cleanRootOf returns the root name of a URL path after cleaning it.
+func cleanRootOf(urlPath string) string {
+	return rootOf(path.Clean("/" + urlPath))
+}
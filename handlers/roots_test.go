@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gileserver/models"
+)
+
+// fakeTemplates records the pages handlers render.
+type fakeTemplates struct {
+	diff *models.DiffPage
+}
+
+func (t *fakeTemplates) ExecutePreview(w http.ResponseWriter, pd *models.PreviewData) error {
+	_, err := w.Write([]byte(pd.Title))
+	return err
+}
+
+func (t *fakeTemplates) ExecuteDiff(w http.ResponseWriter, pd *models.DiffPage) error {
+	t.diff = pd
+	return nil
+}
+
+// TestRootAccessDotDot checks that a ".." segment, encoded or not, cannot
+// reach a restricted root through a root the client may use.
+func TestRootAccessDotDot(t *testing.T) {
+	base := t.TempDir()
+	roots := map[string]string{
+		"public": filepath.Join(base, "public"),
+		"secret": filepath.Join(base, "secret"),
+	}
+	for name, dir := range roots {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".txt"), []byte(name+" contents\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ConfigureRoots(map[string]RootSettings{"secret": {Allow: []string{"10.9.9.9"}}})
+	t.Cleanup(func() { ConfigureRoots(nil) })
+
+	opts := PreviewOptions{Text: true}
+	tmpl := &fakeTemplates{}
+	mux := http.NewServeMux()
+	mux.Handle("/download/", http.StripPrefix("/download", FileHandler(roots)))
+	mux.HandleFunc("/preview/", PreviewHandler(roots, "", "", "", opts, tmpl))
+	mux.HandleFunc("/api/text/", TextWindowHandler(roots, "", opts))
+	mux.HandleFunc("/diff", DiffHandler(roots, "", "", opts, tmpl))
+
+	targets := []string{
+		"/download/public/..%2fsecret/secret.txt",
+		"/preview/public/..%2fsecret/secret.txt",
+		"/preview/public/%2e%2e/secret/secret.txt",
+		"/api/text/public/..%2fsecret/secret.txt",
+		"/diff?a=/public/../secret/secret.txt&b=/public/public.txt",
+		"/diff?a=/public/public.txt&b=/public/%2e%2e%2fsecret%2fsecret.txt",
+	}
+	handlers := map[string]http.Handler{
+		"middleware": RootAccess(mux),
+		"resolver":   mux,
+	}
+	for name, h := range handlers {
+		for _, target := range targets {
+			tmpl.diff = nil
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if tmpl.diff != nil {
+				if tmpl.diff.Error == "" {
+					t.Errorf("%s: %s compared a restricted file", name, target)
+				}
+				continue
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s: %s returned %d, want %d", name, target, rec.Code, http.StatusNotFound)
+			}
+		}
+	}
+
+	// The allowed client still reaches the root the same way.
+	req := httptest.NewRequest(http.MethodGet, "/download/public/..%2fsecret/secret.txt", nil)
+	req.RemoteAddr = "10.9.9.9:1234"
+	rec := httptest.NewRecorder()
+	RootAccess(mux).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("allowed client got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,859 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// renderRST converts reStructuredText to HTML.
+//
+// The renderer covers the constructs that make up the bulk of real-world
+// README and Sphinx sources: section titles, paragraphs, bullet, enumerated,
+// definition and field lists, literal and doctest blocks, block quotes, grid
+// and simple tables, and the common directives (code blocks, admonitions,
+// images, figures, list-table, csv-table, contents). Any other directive is
+// shown as a visible literal block rather than failing the render, and no
+// directive ever reads another file from disk.
+//
+// All output is passed through sanitizeHTML before being placed in the page.
+func renderRST(content, docURLDir string, previewImages bool) (template.HTML, error) {
+	p := &rstParser{
+		targets: make(map[string]string),
+		subs:    make(map[string]string),
+	}
+	lines := splitMarkupLines(content, 8)
+	p.collectDefinitions(lines)
+	out := p.blocks(lines)
+	out = strings.ReplaceAll(out, rstContentsMarker, p.contents())
+	return template.HTML(sanitizeHTML(out, docURLDir, previewImages)), nil
+}
+
+// rstContentsMarker is substituted with the generated table of contents once
+// every heading in the document is known.
+const rstContentsMarker = "\x00rst-contents\x00"
+
+type rstParser struct {
+	styles   []string          // heading adornment styles in order of first use
+	targets  map[string]string // normalised target name -> URL
+	subs     map[string]string // substitution name -> rendered HTML
+	slugger  headingSlugger
+	headings []rstHeading
+	codeLang string // default language set by the highlight directive
+}
+
+type rstHeading struct {
+	level int
+	id    string
+	text  string
+}
+
+var (
+	rstTargetRe       = regexp.MustCompile("^\\s*\\.\\.\\s+_(`[^`]+`|[^:]+):\\s*(.*)$")
+	rstSubstRe        = regexp.MustCompile(`^\s*\.\.\s+\|([^|]+)\|\s+([\w-]+)::\s*(.*)$`)
+	rstDirectiveRe    = regexp.MustCompile(`^\.\.\s+([\w:.-]+)::(?:\s+(.*))?$`)
+	rstOptionRe       = regexp.MustCompile(`^:([\w -]+):(?:\s+(.*))?$`)
+	rstBulletRe       = regexp.MustCompile(`^([*+\-•‣⁃])( +|$)`)
+	rstEnumRe         = regexp.MustCompile(`^(?:(\d+|#|[a-zA-Z])[.)]|\((\d+|#|[a-zA-Z])\))( +|$)`)
+	rstFieldRe        = regexp.MustCompile(`^:([^:\s][^:]*):(?: +(.*))?$`)
+	rstSimpleBorderRe = regexp.MustCompile(`^=+( +=+)+$`)
+	rstFootnoteRe     = regexp.MustCompile(`^\.\.\s+\[([^\]]+)\]\s*(.*)$`)
+)
+
+// collectDefinitions records hyperlink targets and substitution definitions
+// up front, since references may appear before their definitions.
+func (p *rstParser) collectDefinitions(lines []string) {
+	for i, l := range lines {
+		if m := rstTargetRe.FindStringSubmatch(l); m != nil {
+			name := strings.Trim(m[1], "`")
+			if url := strings.TrimSpace(m[2]); url != "" {
+				p.targets[rstNormName(name)] = url
+			}
+			continue
+		}
+		m := rstSubstRe.FindStringSubmatch(l)
+		if m == nil {
+			continue
+		}
+		name, kind, arg := m[1], m[2], strings.TrimSpace(m[3])
+		switch kind {
+		case "image":
+			alt, width := name, ""
+			for _, opt := range lines[i+1:] {
+				if !strings.HasPrefix(opt, " ") || isBlank(opt) {
+					break
+				}
+				if om := rstOptionRe.FindStringSubmatch(strings.TrimSpace(opt)); om != nil {
+					switch om[1] {
+					case "alt":
+						alt = om[2]
+					case "width":
+						width = om[2]
+					}
+				}
+			}
+			p.subs[name] = markupImage(arg, alt, width)
+		case "replace":
+			p.subs[name] = p.inline(arg)
+		}
+	}
+}
+
+// rstNormName normalises a reference or target name for lookup.
+func rstNormName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// isRSTAdornment reports whether line is a section adornment or transition:
+// three or more repetitions of a single punctuation character.
+func isRSTAdornment(line string) bool {
+	if utf8.RuneCountInString(line) < 3 {
+		return false
+	}
+	c := line[0]
+	if !strings.ContainsRune("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", rune(c)) {
+		return false
+	}
+	for i := 1; i < len(line); i++ {
+		if line[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// blocks renders a sequence of body elements.
+func (p *rstParser) blocks(lines []string) string {
+	var b strings.Builder
+	i := 0
+	for i < len(lines) {
+		line := lines[i]
+		if isBlank(line) {
+			i++
+			continue
+		}
+
+		// Indented text with no introducing construct is a block quote.
+		if indentOf(line) > 0 {
+			end := rstIndentedEnd(lines, i, 1)
+			b.WriteString("<blockquote>\n" + p.blocks(dedentLines(lines[i:end])) + "</blockquote>\n")
+			i = end
+			continue
+		}
+
+		// Section title with overline.
+		if isRSTAdornment(line) && i+2 < len(lines) && !isBlank(lines[i+1]) &&
+			strings.TrimSpace(lines[i+2]) == line {
+			p.heading(&b, strings.TrimSpace(lines[i+1]), "o"+line[:1])
+			i += 3
+			continue
+		}
+
+		// Section title with underline only.
+		if i+1 < len(lines) && isRSTAdornment(lines[i+1]) && !isRSTAdornment(line) &&
+			utf8.RuneCountInString(lines[i+1]) >= utf8.RuneCountInString(strings.TrimSpace(line)) {
+			p.heading(&b, strings.TrimSpace(line), lines[i+1][:1])
+			i += 2
+			continue
+		}
+
+		// Transition.
+		if isRSTAdornment(line) && utf8.RuneCountInString(line) >= 4 {
+			b.WriteString("<hr />\n")
+			i++
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, ".. ") || line == "..":
+			end := rstIndentedEnd(lines, i+1, 1)
+			b.WriteString(p.explicit(lines[i:end]))
+			i = end
+
+		case strings.HasPrefix(line, "+-") || strings.HasPrefix(line, "+="):
+			end := i
+			for end < len(lines) && (strings.HasPrefix(lines[end], "+") || strings.HasPrefix(lines[end], "|")) {
+				end++
+			}
+			b.WriteString(p.gridTable(lines[i:end]))
+			i = end
+
+		case rstSimpleBorderRe.MatchString(line):
+			end := rstSimpleTableEnd(lines, i)
+			b.WriteString(p.simpleTable(lines[i:end]))
+			i = end
+
+		case rstBulletRe.MatchString(line):
+			i = p.list(&b, lines, i, rstBulletRe, "ul")
+
+		case rstEnumRe.MatchString(line) && (i+1 >= len(lines) || isBlank(lines[i+1]) ||
+			indentOf(lines[i+1]) > 0 || rstEnumRe.MatchString(lines[i+1])):
+			i = p.list(&b, lines, i, rstEnumRe, "ol")
+
+		case rstFieldRe.MatchString(line):
+			i = p.fieldList(&b, lines, i)
+
+		case strings.HasPrefix(line, ">>>"):
+			end := i
+			for end < len(lines) && !isBlank(lines[end]) {
+				end++
+			}
+			b.WriteString(highlightOrEscape(strings.Join(lines[i:end], "\n"), "pycon"))
+			i = end
+
+		default:
+			i = p.paragraph(&b, lines, i)
+		}
+	}
+	return b.String()
+}
+
+// rstIndentedEnd returns the index just past the indented block starting at
+// lines[from]: every following line that is blank or indented by at least
+// minIndent columns. Trailing blank lines are left out.
+func rstIndentedEnd(lines []string, from, minIndent int) int {
+	end := from
+	last := from
+	for end < len(lines) {
+		l := lines[end]
+		if !isBlank(l) && indentOf(l) < minIndent {
+			break
+		}
+		end++
+		if !isBlank(l) {
+			last = end
+		}
+	}
+	return last
+}
+
+// heading renders a section title; its level comes from the order in which
+// adornment styles first appear in the document.
+func (p *rstParser) heading(b *strings.Builder, text, style string) {
+	level := 0
+	for k, s := range p.styles {
+		if s == style {
+			level = k + 1
+			break
+		}
+	}
+	if level == 0 {
+		p.styles = append(p.styles, style)
+		level = len(p.styles)
+	}
+	id := p.slugger.slug(text)
+	p.headings = append(p.headings, rstHeading{level: level, id: id, text: text})
+	b.WriteString(markupHeading(level, id, p.inline(text)))
+}
+
+// paragraph renders a paragraph starting at lines[i], together with a
+// following literal block ("::") or definition list, and returns the index
+// of the next unconsumed line.
+func (p *rstParser) paragraph(b *strings.Builder, lines []string, i int) int {
+	// Definition list: a single-line term directly followed by an indented
+	// definition.
+	if i+1 < len(lines) && !isBlank(lines[i+1]) && indentOf(lines[i+1]) > 0 {
+		b.WriteString("<dl>\n")
+		for i < len(lines) && !isBlank(lines[i]) && indentOf(lines[i]) == 0 &&
+			i+1 < len(lines) && !isBlank(lines[i+1]) && indentOf(lines[i+1]) > 0 {
+			term := lines[i]
+			classifier := ""
+			if k := strings.Index(term, " : "); k > 0 {
+				term, classifier = term[:k], term[k+3:]
+			}
+			end := rstIndentedEnd(lines, i+1, 1)
+			b.WriteString("<dt>" + p.inline(term))
+			if classifier != "" {
+				b.WriteString(` <span class="classifier">` + p.inline(classifier) + "</span>")
+			}
+			b.WriteString("</dt>\n<dd>" + p.blocks(dedentLines(lines[i+1:end])) + "</dd>\n")
+			i = end
+			for i < len(lines) && isBlank(lines[i]) {
+				i++
+			}
+		}
+		b.WriteString("</dl>\n")
+		return i
+	}
+
+	start := i
+	for i < len(lines) && !isBlank(lines[i]) && (i == start || indentOf(lines[i]) == 0) {
+		i++
+	}
+	text := strings.Join(lines[start:i], "\n")
+
+	literal := strings.HasSuffix(text, "::")
+	if literal {
+		switch {
+		case strings.TrimSpace(text) == "::":
+			text = ""
+		case strings.HasSuffix(text, " ::"):
+			text = strings.TrimSuffix(text, " ::")
+		default:
+			text = strings.TrimSuffix(text, ":")
+		}
+	}
+	if text != "" {
+		b.WriteString("<p>" + p.inline(text) + "</p>\n")
+	}
+	if !literal {
+		return i
+	}
+
+	j := i
+	for j < len(lines) && isBlank(lines[j]) {
+		j++
+	}
+	if j < len(lines) && indentOf(lines[j]) > 0 {
+		end := rstIndentedEnd(lines, j, 1)
+		b.WriteString(markupLiteral(strings.Join(dedentLines(lines[j:end]), "\n"), "literal-block"))
+		return end
+	}
+	return i
+}
+
+// list renders a bullet or enumerated list starting at lines[i].
+func (p *rstParser) list(b *strings.Builder, lines []string, i int, marker *regexp.Regexp, tag string) int {
+	open := "<" + tag + ">\n"
+	if tag == "ol" {
+		if m := rstEnumRe.FindStringSubmatch(lines[i]); m != nil {
+			label := m[1] + m[2]
+			switch {
+			case label >= "a" && label <= "z":
+				open = `<ol type="a">` + "\n"
+			case label >= "A" && label <= "Z":
+				open = `<ol type="A">` + "\n"
+			default:
+				if n, err := strconv.Atoi(label); err == nil && n > 1 {
+					open = `<ol start="` + strconv.Itoa(n) + `">` + "\n"
+				}
+			}
+		}
+	}
+	b.WriteString(open)
+	for i < len(lines) {
+		loc := marker.FindStringIndex(lines[i])
+		if loc == nil || indentOf(lines[i]) > 0 {
+			break
+		}
+		width := loc[1]
+		if width == len(lines[i]) && i+1 < len(lines) && indentOf(lines[i+1]) > 0 {
+			// Marker alone on the line; the body starts on the next line.
+			width = indentOf(lines[i+1])
+		}
+		item := []string{strings.TrimSpace(lines[i][loc[1]:])}
+		end := rstIndentedEnd(lines, i+1, width)
+		for _, l := range lines[i+1 : end] {
+			if len(l) >= width {
+				item = append(item, l[width:])
+			} else {
+				item = append(item, strings.TrimLeft(l, " "))
+			}
+		}
+		b.WriteString("<li>" + unwrapParagraph(p.blocks(item)) + "</li>\n")
+		i = end
+		// A blank line between items keeps the list going.
+		j := i
+		for j < len(lines) && isBlank(lines[j]) {
+			j++
+		}
+		if j < len(lines) && marker.MatchString(lines[j]) && indentOf(lines[j]) == 0 {
+			i = j
+		}
+	}
+	b.WriteString("</" + tag + ">\n")
+	return i
+}
+
+// fieldList renders a field list (":name: body") starting at lines[i].
+func (p *rstParser) fieldList(b *strings.Builder, lines []string, i int) int {
+	b.WriteString(`<dl class="field-list">` + "\n")
+	for i < len(lines) {
+		m := rstFieldRe.FindStringSubmatch(lines[i])
+		if m == nil {
+			break
+		}
+		end := rstIndentedEnd(lines, i+1, 1)
+		body := append([]string{m[2]}, dedentLines(lines[i+1:end])...)
+		b.WriteString("<dt>" + p.inline(m[1]) + "</dt>\n<dd>" + unwrapParagraph(p.blocks(body)) + "</dd>\n")
+		i = end
+		for i < len(lines) && isBlank(lines[i]) && i+1 < len(lines) && rstFieldRe.MatchString(lines[i+1]) {
+			i++
+		}
+	}
+	b.WriteString("</dl>\n")
+	return i
+}
+
+// explicit renders an explicit markup block (".. "): a directive, footnote,
+// hyperlink target, substitution definition or comment.
+func (p *rstParser) explicit(block []string) string {
+	first := block[0]
+	body := dedentLines(block[1:])
+
+	if rstTargetRe.MatchString(first) {
+		m := rstTargetRe.FindStringSubmatch(first)
+		if strings.TrimSpace(m[2]) == "" {
+			// Internal target: an anchor other documents can link to.
+			return `<span id="` + htmlAttrEscape(slugify(strings.Trim(m[1], "`"))) + `"></span>` + "\n"
+		}
+		return ""
+	}
+	if rstSubstRe.MatchString(first) {
+		return "" // collected up front
+	}
+	if m := rstFootnoteRe.FindStringSubmatch(first); m != nil {
+		text := append([]string{m[2]}, body...)
+		return `<div class="footnote"><span class="footnote-label">[` + template.HTMLEscapeString(m[1]) + "]</span> " +
+			unwrapParagraph(p.blocks(text)) + "</div>\n"
+	}
+	m := rstDirectiveRe.FindStringSubmatch(first)
+	if m == nil {
+		return "" // comment
+	}
+	name := strings.ToLower(m[1])
+	name = strings.TrimPrefix(name, "rst:")
+	arg := strings.TrimSpace(m[2])
+
+	// Split directive options from content.
+	opts := make(map[string]string)
+	k := 0
+	for k < len(body) {
+		om := rstOptionRe.FindStringSubmatch(body[k])
+		if om == nil {
+			break
+		}
+		opts[om[1]] = strings.TrimSpace(om[2])
+		k++
+	}
+	content := dedentLines(body[k:])
+
+	switch name {
+	case "code", "code-block", "sourcecode":
+		lang := arg
+		if lang == "" {
+			lang = p.codeLang
+		}
+		return highlightOrEscape(strings.Join(content, "\n"), lang)
+
+	case "highlight":
+		p.codeLang = arg
+		return ""
+
+	case "note", "tip", "hint", "important", "warning", "caution", "danger", "attention", "error", "seealso":
+		// The directive argument, if any, is the first line of content.
+		if arg != "" {
+			content = append([]string{arg}, content...)
+		}
+		return markupAdmonition(name, "", p.blocks(content))
+
+	case "admonition":
+		return markupAdmonition("note", p.inline(arg), p.blocks(content))
+
+	case "versionadded", "versionchanged", "deprecated":
+		title := map[string]string{
+			"versionadded":   "New in version ",
+			"versionchanged": "Changed in version ",
+			"deprecated":     "Deprecated since version ",
+		}[name] + template.HTMLEscapeString(arg)
+		return markupAdmonition("note", title, p.blocks(content))
+
+	case "image":
+		return "<p>" + markupImage(arg, opts["alt"], opts["width"]) + "</p>\n"
+
+	case "figure":
+		return "<figure>" + markupImage(arg, opts["alt"], opts["width"]) +
+			"<figcaption>" + unwrapParagraph(p.blocks(content)) + "</figcaption></figure>\n"
+
+	case "topic", "sidebar":
+		return `<aside class="topic"><p class="topic-title">` + p.inline(arg) + "</p>\n" + p.blocks(content) + "</aside>\n"
+
+	case "rubric":
+		return `<p class="rubric"><strong>` + p.inline(arg) + "</strong></p>\n"
+
+	case "container", "only", "class":
+		return "<div>" + p.blocks(content) + "</div>\n"
+
+	case "contents":
+		return rstContentsMarker
+
+	case "table":
+		return strings.Replace(p.blocks(content), "<table>", "<table><caption>"+p.inline(arg)+"</caption>", 1)
+
+	case "list-table":
+		return p.listTable(arg, opts, content)
+
+	case "csv-table":
+		if out, ok := p.csvTable(arg, opts, content); ok {
+			return out
+		}
+	}
+	return markupUnsupported(strings.Join(dedentLines(block), "\n"))
+}
+
+// contents renders the table of contents for the contents directive.
+func (p *rstParser) contents() string {
+	if len(p.headings) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`<nav class="toc"><ul>`)
+	for _, h := range p.headings {
+		b.WriteString(`<li class="toc-level-` + strconv.Itoa(h.level) + `"><a href="#` + htmlAttrEscape(h.id) + `">` +
+			p.inline(h.text) + "</a></li>")
+	}
+	b.WriteString("</ul></nav>\n")
+	return b.String()
+}
+
+// cell renders the content of one table cell.
+func (p *rstParser) cell(lines []string) string {
+	return unwrapParagraph(p.blocks(dedentLines(lines)))
+}
+
+// gridTable renders a grid table. Column boundaries are taken from the '+'
+// positions in the top border; row and column spans are not reconstructed.
+func (p *rstParser) gridTable(lines []string) string {
+	border := []rune(lines[0])
+	var cols []int
+	for k, r := range border {
+		if r == '+' {
+			cols = append(cols, k)
+		}
+	}
+	if len(cols) < 2 {
+		return markupUnsupported(strings.Join(lines, "\n"))
+	}
+
+	var rows [][]string
+	headerRows := 0
+	var cur [][]string // per-column line buffers for the row being read
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		row := make([]string, len(cur))
+		for c, ls := range cur {
+			row[c] = p.cell(ls)
+		}
+		rows = append(rows, row)
+		cur = nil
+	}
+	for _, l := range lines[1:] {
+		r := []rune(l)
+		if strings.HasPrefix(l, "+") {
+			flush()
+			if strings.Contains(l, "=") {
+				headerRows = len(rows)
+			}
+			continue
+		}
+		if cur == nil {
+			cur = make([][]string, len(cols)-1)
+		}
+		for c := 0; c < len(cols)-1; c++ {
+			from, to := cols[c]+1, cols[c+1]
+			if from >= len(r) {
+				cur[c] = append(cur[c], "")
+				continue
+			}
+			if to > len(r) {
+				to = len(r)
+			}
+			cur[c] = append(cur[c], strings.TrimRight(string(r[from:to]), " |"))
+		}
+	}
+	flush()
+	return markupTable("", rows, headerRows)
+}
+
+// rstSimpleTableEnd returns the index just past a simple table that starts
+// with the border at lines[i]. The table ends at a border line that is
+// followed by a blank line or the end of input.
+func rstSimpleTableEnd(lines []string, i int) int {
+	for k := i + 1; k < len(lines); k++ {
+		if rstSimpleBorderRe.MatchString(lines[k]) && (k+1 >= len(lines) || isBlank(lines[k+1])) {
+			return k + 1
+		}
+	}
+	return len(lines)
+}
+
+// simpleTable renders a simple table, whose columns are defined by the runs
+// of '=' in its border lines.
+func (p *rstParser) simpleTable(lines []string) string {
+	border := []rune(lines[0])
+	var starts []int
+	for k, r := range border {
+		if r == '=' && (k == 0 || border[k-1] == ' ') {
+			starts = append(starts, k)
+		}
+	}
+
+	var rows [][]string
+	headerRows := 0
+	borders := 0
+	var raw [][]string
+	for _, l := range lines[1:] {
+		if rstSimpleBorderRe.MatchString(l) {
+			borders++
+			if borders == 1 {
+				headerRows = len(raw)
+			}
+			continue
+		}
+		if isBlank(l) {
+			continue
+		}
+		r := []rune(l)
+		cells := make([]string, len(starts))
+		for c, s := range starts {
+			if s >= len(r) {
+				continue
+			}
+			end := len(r)
+			if c+1 < len(starts) && starts[c+1] < end {
+				end = starts[c+1]
+			}
+			cells[c] = strings.TrimSpace(string(r[s:end]))
+		}
+		// A blank first column continues the previous row.
+		if cells[0] == "" && len(raw) > 0 {
+			prev := raw[len(raw)-1]
+			for c := range cells {
+				if cells[c] != "" {
+					prev[c] = strings.TrimSpace(prev[c] + "\n" + cells[c])
+				}
+			}
+			continue
+		}
+		raw = append(raw, cells)
+	}
+	if borders < 2 {
+		// Only a closing border: no header section.
+		headerRows = 0
+	}
+	for _, cells := range raw {
+		row := make([]string, len(cells))
+		for c, t := range cells {
+			row[c] = p.inline(t)
+		}
+		rows = append(rows, row)
+	}
+	return markupTable("", rows, headerRows)
+}
+
+// listTable renders the list-table directive: a bullet list of rows, each a
+// bullet list of cells.
+func (p *rstParser) listTable(title string, opts map[string]string, content []string) string {
+	rowItems := rstSplitItems(content)
+	if len(rowItems) == 0 {
+		return markupUnsupported(".. list-table:: " + title + "\n" + strings.Join(content, "\n"))
+	}
+	var rows [][]string
+	for _, item := range rowItems {
+		var row []string
+		for _, c := range rstSplitItems(item) {
+			row = append(row, p.cell(c))
+		}
+		rows = append(rows, row)
+	}
+	headerRows, _ := strconv.Atoi(opts["header-rows"])
+	return markupTable(p.inline(title), rows, headerRows)
+}
+
+// rstSplitItems splits a bullet list into the dedented bodies of its items.
+func rstSplitItems(lines []string) [][]string {
+	var items [][]string
+	for _, l := range lines {
+		if loc := rstBulletRe.FindStringIndex(l); loc != nil && indentOf(l) == 0 {
+			items = append(items, []string{l[loc[1]:]})
+			continue
+		}
+		if len(items) == 0 {
+			continue
+		}
+		last := &items[len(items)-1]
+		*last = append(*last, l)
+	}
+	for k := range items {
+		first := items[k][0]
+		items[k] = append([]string{first}, dedentLines(items[k][1:])...)
+	}
+	return items
+}
+
+// csvTable renders the csv-table directive from inline content. External
+// data sources (:file: and :url:) are not supported.
+func (p *rstParser) csvTable(title string, opts map[string]string, content []string) (string, bool) {
+	if opts["file"] != "" || opts["url"] != "" {
+		return "", false
+	}
+	parse := func(s string) ([][]string, bool) {
+		r := csv.NewReader(strings.NewReader(s))
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+		r.TrimLeadingSpace = true
+		recs, err := r.ReadAll()
+		return recs, err == nil
+	}
+	var rows [][]string
+	headerRows := 0
+	if h := opts["header"]; h != "" {
+		recs, ok := parse(h)
+		if !ok {
+			return "", false
+		}
+		rows = append(rows, recs...)
+		headerRows = len(recs)
+	}
+	recs, ok := parse(strings.Join(content, "\n"))
+	if !ok {
+		return "", false
+	}
+	rows = append(rows, recs...)
+	if n, err := strconv.Atoi(opts["header-rows"]); err == nil {
+		headerRows += n
+	}
+	for _, row := range rows {
+		for c := range row {
+			row[c] = p.inline(row[c])
+		}
+	}
+	return markupTable(p.inline(title), rows, headerRows), true
+}
+
+// ---------------------------------------------------------------------------
+// Inline markup
+// ---------------------------------------------------------------------------
+
+var rstInlineRe = regexp.MustCompile(
+	"``(.+?)``" + // 1: inline literal
+		"|:([A-Za-z][\\w:+.-]*):`([^`]+)`" + // 2, 3: role
+		"|`([^`<]*?)\\s*<([^`>]+)>`__?" + // 4, 5: hyperlink with embedded URI
+		"|`([^`]+)`__?" + // 6: named reference
+		"|`([^`]+)`" + // 7: interpreted text
+		"|\\*\\*(\\S(?:.*?\\S)?)\\*\\*" + // 8: strong
+		"|\\*(\\S(?:[^*]*?\\S)?)\\*" + // 9: emphasis
+		"|\\|([^|\\s](?:[^|]*[^|\\s])?)\\|(?:__?)?" + // 10: substitution
+		"|\\[([0-9]+|#[\\w-]*|\\*)\\]_" + // 11: footnote reference
+		"|(https?://[^\\s<>\"`]*[^\\s<>\"`.,;:!?)\\]'])" + // 12: standalone URL
+		"|\\b([A-Za-z][\\w-]*)_\\b") // 13: simple named reference
+
+// rstEscapeRe matches backslash escapes in plain text.
+var rstEscapeRe = regexp.MustCompile(`\\(.)`)
+
+// rstCodeRoles are roles whose text is rendered as code.
+var rstCodeRoles = map[string]bool{
+	"code": true, "literal": true, "file": true, "command": true, "program": true, "samp": true,
+	"envvar": true, "option": true, "math": true, "func": true, "meth": true, "class": true,
+	"mod": true, "attr": true, "obj": true, "exc": true, "data": true, "const": true,
+	"type": true, "var": true, "macro": true, "member": true,
+}
+
+// inline renders RST inline markup in text, escaping everything else.
+func (p *rstParser) inline(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range rstInlineRe.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(rstText(text[last:m[0]]))
+		last = m[1]
+		g := func(n int) string {
+			if m[2*n] < 0 {
+				return ""
+			}
+			return text[m[2*n]:m[2*n+1]]
+		}
+		has := func(n int) bool { return m[2*n] >= 0 }
+
+		switch {
+		case has(1):
+			b.WriteString("<code>" + template.HTMLEscapeString(g(1)) + "</code>")
+		case has(2):
+			b.WriteString(p.role(g(2), g(3)))
+		case has(4):
+			label, uri := strings.TrimSpace(g(4)), strings.TrimSpace(g(5))
+			if strings.HasSuffix(uri, "_") {
+				// `text <name_>`_ refers to another target.
+				uri = p.resolveRef(strings.TrimSuffix(uri, "_"))
+			}
+			if label == "" {
+				label = g(5)
+			}
+			b.WriteString(`<a href="` + htmlAttrEscape(uri) + `">` + template.HTMLEscapeString(label) + "</a>")
+		case has(6):
+			b.WriteString(`<a href="` + htmlAttrEscape(p.resolveRef(g(6))) + `">` + template.HTMLEscapeString(g(6)) + "</a>")
+		case has(7):
+			b.WriteString("<cite>" + template.HTMLEscapeString(g(7)) + "</cite>")
+		case has(8):
+			b.WriteString("<strong>" + rstText(g(8)) + "</strong>")
+		case has(9):
+			b.WriteString("<em>" + rstText(g(9)) + "</em>")
+		case has(10):
+			if sub, ok := p.subs[g(10)]; ok {
+				b.WriteString(sub)
+			} else {
+				b.WriteString(template.HTMLEscapeString(text[m[0]:m[1]]))
+			}
+		case has(11):
+			b.WriteString("<sup>[" + template.HTMLEscapeString(g(11)) + "]</sup>")
+		case has(12):
+			b.WriteString(`<a href="` + htmlAttrEscape(g(12)) + `">` + template.HTMLEscapeString(g(12)) + "</a>")
+		case has(13):
+			// Only known targets are links; otherwise "word_" is just text.
+			if url, ok := p.targets[rstNormName(g(13))]; ok {
+				b.WriteString(`<a href="` + htmlAttrEscape(url) + `">` + template.HTMLEscapeString(g(13)) + "</a>")
+			} else {
+				b.WriteString(template.HTMLEscapeString(text[m[0]:m[1]]))
+			}
+		}
+	}
+	b.WriteString(rstText(text[last:]))
+	return b.String()
+}
+
+// rstText escapes plain text and removes backslash escapes.
+func rstText(s string) string {
+	return template.HTMLEscapeString(rstEscapeRe.ReplaceAllString(s, "$1"))
+}
+
+// resolveRef returns the URL for a named reference: an external target if
+// one was defined, otherwise an anchor to the section of that name.
+func (p *rstParser) resolveRef(name string) string {
+	if url, ok := p.targets[rstNormName(name)]; ok {
+		return url
+	}
+	return "#" + slugify(name)
+}
+
+// role renders interpreted text with an explicit role.
+func (p *rstParser) role(role, text string) string {
+	role = role[strings.LastIndex(role, ":")+1:] // py:func -> func
+	// Cross-reference roles accept "title <target>"; show the title.
+	if k := strings.LastIndex(text, " <"); k > 0 && strings.HasSuffix(text, ">") {
+		text = text[:k]
+	}
+	text = strings.TrimPrefix(text, "~")
+	esc := template.HTMLEscapeString(text)
+	switch {
+	case rstCodeRoles[role]:
+		return "<code>" + esc + "</code>"
+	case role == "kbd":
+		return "<kbd>" + esc + "</kbd>"
+	case role == "sub" || role == "subscript":
+		return "<sub>" + esc + "</sub>"
+	case role == "sup" || role == "superscript":
+		return "<sup>" + esc + "</sup>"
+	case role == "strong":
+		return "<strong>" + esc + "</strong>"
+	case role == "emphasis" || role == "title-reference" || role == "title" || role == "t":
+		return "<em>" + esc + "</em>"
+	case role == "abbr":
+		return "<abbr>" + esc + "</abbr>"
+	}
+	// ref, doc, term, pep, rfc and any unknown role: plain text.
+	return esc
+}
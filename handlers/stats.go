@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 // statsSnapshot is the public view of the current download counters,
@@ -20,12 +21,78 @@ type StatsSnapshot struct {
 type persistedStats struct {
 	TotalDownloads int64 `json:"total_downloads"`
 	TotalBytes     int64 `json:"total_bytes"`
+	// Quotas is the transfer volume of each client in the current day and
+	// month, keyed by quotaKey. Present only when quotas are enabled.
+	Quotas map[string]*quotaUsage `json:"quotas,omitempty"`
+	// Rejections counts requests refused by the rate limiter, the
+	// concurrent-transfer cap and the quotas, keyed by reason.
+	Rejections map[string]int64 `json:"rejections,omitempty"`
+}
+
+// snapshotLocked returns a copy of the stats that is safe to encode after
+// the mutex is released. Must be called with downloadStats.mu held.
+func snapshotLocked() persistedStats {
+	snap := downloadStats.data
+	if snap.Quotas != nil {
+		snap.Quotas = make(map[string]*quotaUsage, len(downloadStats.data.Quotas))
+		for k, u := range downloadStats.data.Quotas {
+			c := *u
+			snap.Quotas[k] = &c
+		}
+	}
+	if snap.Rejections != nil {
+		snap.Rejections = make(map[string]int64, len(downloadStats.data.Rejections))
+		for k, n := range downloadStats.data.Rejections {
+			snap.Rejections[k] = n
+		}
+	}
+	return snap
 }
 
 var downloadStats struct {
 	mu   sync.Mutex
 	data persistedStats
-	path string
+	// unsaved is what this process has counted since it last wrote the stats
+	// file. A write adds it to the file's current contents rather than
+	// replacing them, so that two processes sharing the file while one hands
+	// its socket to the other do not lose each other's counts.
+	unsaved persistedStats
+	path    string
+	// saveQueued is set while a deferred write of the stats file is pending.
+	saveQueued bool
+}
+
+// statsWriteMu serialises writes of the stats file.
+var statsWriteMu sync.Mutex
+
+// add adds the counts in d to s. Quota usage is added only where d and s
+// are in the same day or month, after s has been rolled forward to now.
+func (s *persistedStats) add(d persistedStats, now time.Time) {
+	s.TotalDownloads += d.TotalDownloads
+	s.TotalBytes += d.TotalBytes
+	for reason, n := range d.Rejections {
+		if s.Rejections == nil {
+			s.Rejections = make(map[string]int64)
+		}
+		s.Rejections[reason] += n
+	}
+	for key, du := range d.Quotas {
+		if s.Quotas == nil {
+			s.Quotas = make(map[string]*quotaUsage)
+		}
+		u, ok := s.Quotas[key]
+		if !ok {
+			u = &quotaUsage{}
+			s.Quotas[key] = u
+		}
+		u.roll(now)
+		if du.Day == u.Day {
+			u.DayBytes += du.DayBytes
+		}
+		if du.Month == u.Month {
+			u.MonthBytes += du.MonthBytes
+		}
+	}
 }
 
 // InitStats resolves the stats file path from the given directory, loads any
@@ -66,14 +133,55 @@ func InitStats(statsDir string) {
 // and persists the updated totals to disk.
 func RecordDownload(bytesSent int64) {
 	downloadStats.mu.Lock()
-	downloadStats.data.TotalDownloads++
-	downloadStats.data.TotalBytes += bytesSent
-	snap := downloadStats.data
-	path := downloadStats.path
+	for _, st := range []*persistedStats{&downloadStats.data, &downloadStats.unsaved} {
+		st.TotalDownloads++
+		st.TotalBytes += bytesSent
+	}
 	downloadStats.mu.Unlock()
 
 	// Write asynchronously so the response is never delayed by disk I/O.
-	go persistStats(path, snap)
+	go saveStats()
+}
+
+// statsSaveDelay is how long RecordRejection and saveQuotas wait before
+// writing the stats file, so that a flood of requests costs one write rather
+// than one per request.
+const statsSaveDelay = 5 * time.Second
+
+// RecordRejection counts one refused request under reason, e.g. "rate_heavy"
+// or "transfers". The stats file is written shortly afterwards.
+func RecordRejection(reason string) {
+	downloadStats.mu.Lock()
+	defer downloadStats.mu.Unlock()
+	for _, st := range []*persistedStats{&downloadStats.data, &downloadStats.unsaved} {
+		if st.Rejections == nil {
+			st.Rejections = make(map[string]int64)
+		}
+		st.Rejections[reason]++
+	}
+	queueSaveLocked()
+}
+
+// queueSaveLocked writes the stats file after statsSaveDelay, unless a write
+// is already pending. The caller must hold downloadStats.mu.
+func queueSaveLocked() {
+	if downloadStats.saveQueued {
+		return
+	}
+	downloadStats.saveQueued = true
+	time.AfterFunc(statsSaveDelay, func() {
+		downloadStats.mu.Lock()
+		downloadStats.saveQueued = false
+		downloadStats.mu.Unlock()
+		saveStats()
+	})
+}
+
+// GetRejections returns a copy of the rejection counters.
+func GetRejections() map[string]int64 {
+	downloadStats.mu.Lock()
+	defer downloadStats.mu.Unlock()
+	return snapshotLocked().Rejections
 }
 
 // GetStats returns a point-in-time snapshot of the download counters.
@@ -86,16 +194,72 @@ func GetStats() StatsSnapshot {
 	}
 }
 
-// persistStats logs any error and is safe to call from a goroutine.
-func persistStats(filePath string, data persistedStats) {
-	if err := persistStatsLocked(filePath, data); err != nil {
+// saveStats adds the counts not yet written to the stats file. The file is
+// read again first, so counts another process wrote in the meantime are kept,
+// and the in-memory totals are refreshed from the result. Quota usage from
+// past months is dropped.
+func saveStats() {
+	statsWriteMu.Lock()
+	defer statsWriteMu.Unlock()
+
+	downloadStats.mu.Lock()
+	path := downloadStats.path
+	downloadStats.mu.Unlock()
+	disk, readErr := readStats(path)
+
+	now := time.Now()
+	downloadStats.mu.Lock()
+	unsaved := downloadStats.unsaved
+	downloadStats.unsaved = persistedStats{}
+	var merged persistedStats
+	if readErr != nil {
+		// Missing or unreadable: this process's own totals are the best
+		// there is.
+		merged = snapshotLocked()
+	} else {
+		merged = disk
+		merged.add(unsaved, now)
+	}
+	month := now.Format("2006-01")
+	for k, u := range merged.Quotas {
+		if u.Month != month {
+			delete(merged.Quotas, k)
+		}
+	}
+	downloadStats.data = merged
+	snap := snapshotLocked()
+	downloadStats.mu.Unlock()
+
+	if err := persistStatsLocked(path, snap); err != nil {
 		log.Printf("stats: %v", err)
+		// Keep the counts for the next write.
+		downloadStats.mu.Lock()
+		downloadStats.unsaved.add(unsaved, now)
+		downloadStats.mu.Unlock()
 	}
 }
 
+// FlushStats writes any counts not yet in the stats file, waiting for writes
+// already under way. The server calls it before it exits.
+func FlushStats() {
+	saveStats()
+}
+
+// readStats reads the stats file at filePath.
+func readStats(filePath string) (persistedStats, error) {
+	var data persistedStats
+	f, err := os.Open(filePath)
+	if err != nil {
+		return data, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&data)
+	return data, err
+}
+
 // persistStatsLocked does the actual atomic write and returns any error.
 // It does not acquire any mutex and may be called from InitStats (which
-// already holds the lock) or from the async goroutine in RecordDownload.
+// already holds the lock) or from saveStats.
 func persistStatsLocked(filePath string, data persistedStats) error {
 	dir := filepath.Dir(filePath)
 	tmp, err := os.CreateTemp(dir, ".gilebrowser-stats-*.tmp")
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxTableRows is the number of data rows parsed from a delimited file
+	// for the table view. Anything beyond it is never read; the table
+	// carries a notice that the preview is incomplete.
+	maxTableRows = 5000
+
+	// maxTableBytes bounds how much input is consumed while reading those
+	// rows, so a file with enormous fields cannot stall the preview.
+	maxTableBytes = 32 * 1024 * 1024
+
+	// tablePageSize is the number of rows shown per page by the client-side
+	// pager in main.js.
+	tablePageSize = 100
+)
+
+// tableDelimiters are the candidate field separators tried by
+// sniffDelimiter, with a display name for the table summary.
+var tableDelimiters = []struct {
+	r    rune
+	name string
+}{
+	{',', "comma"},
+	{'\t', "tab"},
+	{';', "semicolon"},
+	{'|', "pipe"},
+}
+
+// isTabular reports whether the MIME type is a delimited data format that
+// renderTable can display.
+func isTabular(mimeType string) bool {
+	switch baseMIME(mimeType) {
+	case "text/csv", "text/tab-separated-values":
+		return true
+	}
+	return false
+}
+
+// renderTableFile renders the delimited file at fsPath as an HTML table,
+// reading only as much of the file as the first maxTableRows rows need.
+func renderTableFile(fsPath, mimeType string) (template.HTML, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return renderTable(f, mimeType)
+}
+
+// renderTable parses delimited data from r and renders it as a table whose
+// first row is the header. The delimiter is sniffed from the leading bytes.
+// Every cell is HTML-escaped here, so the output needs no further
+// sanitisation; sorting and pagination are applied client-side.
+func renderTable(r io.Reader, mimeType string) (template.HTML, error) {
+	counter := &countingReader{r: io.LimitReader(r, maxTableBytes)}
+	br := bufio.NewReaderSize(counter, 64*1024)
+	sample, _ := br.Peek(16 * 1024)
+	delim, delimName := sniffDelimiter(sample, mimeType)
+
+	cr := csv.NewReader(br)
+	cr.Comma = delim
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var rows [][]string
+	truncated := false
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if len(rows) == 0 {
+				return "", err
+			}
+			// Keep what parsed cleanly rather than discarding the table.
+			truncated = true
+			break
+		}
+		if len(rows) > maxTableRows {
+			truncated = true
+			break
+		}
+		rows = append(rows, rec)
+	}
+	if counter.n >= maxTableBytes {
+		// The byte cap may have cut the final record short.
+		truncated = true
+		if len(rows) > 1 {
+			rows = rows[:len(rows)-1]
+		}
+	}
+	if len(rows) == 0 {
+		return "", errors.New("no rows")
+	}
+
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(`<div class="table-preview" data-page-size="`)
+	b.WriteString(strconv.Itoa(tablePageSize))
+	b.WriteString(`">`)
+
+	b.WriteString(`<p class="table-summary">`)
+	b.WriteString(countNoun(len(rows)-1, "row"))
+	b.WriteString(" × ")
+	b.WriteString(countNoun(cols, "column"))
+	b.WriteString(" · ")
+	b.WriteString(delimName)
+	b.WriteString("-separated</p>")
+	if truncated {
+		b.WriteString(`<p class="table-truncated">Only the first `)
+		b.WriteString(groupDigits(len(rows) - 1))
+		b.WriteString(" rows are shown. Download the file to see all of it.</p>")
+	}
+
+	b.WriteString(`<div class="table-scroll"><table class="data-table"><thead><tr>`)
+	for i := 0; i < cols; i++ {
+		b.WriteString(`<th scope="col">`)
+		if i < len(rows[0]) {
+			b.WriteString(template.HTMLEscapeString(rows[0][i]))
+		}
+		b.WriteString("</th>")
+	}
+	b.WriteString("</tr></thead><tbody>")
+	for _, row := range rows[1:] {
+		b.WriteString("<tr>")
+		for i := 0; i < cols; i++ {
+			b.WriteString("<td>")
+			if i < len(row) {
+				b.WriteString(template.HTMLEscapeString(row[i]))
+			}
+			b.WriteString("</td>")
+		}
+		b.WriteString("</tr>")
+	}
+	b.WriteString(`</tbody></table></div><nav class="table-pager" hidden></nav></div>`)
+
+	return template.HTML(b.String()), nil
+}
+
+// sniffDelimiter picks the field separator for sample. Each candidate is
+// counted (outside double quotes) on the first lines of the sample; the one
+// that appears on the header line and most consistently on the following
+// lines wins. Ties go to the default for the MIME type: tab for TSV, comma
+// otherwise.
+func sniffDelimiter(sample []byte, mimeType string) (rune, string) {
+	def := 0
+	if baseMIME(mimeType) == "text/tab-separated-values" {
+		def = 1
+	}
+
+	lines := bytes.Split(sample, []byte("\n"))
+	if len(lines) > 1 {
+		// The last line may be cut off mid-record.
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > 20 {
+		lines = lines[:20]
+	}
+
+	best, bestScore := def, -1
+	for ci, c := range tableDelimiters {
+		first := -1
+		consistent := 0
+		for _, line := range lines {
+			n := countUnquoted(line, byte(c.r))
+			if first < 0 {
+				first = n
+			}
+			if n == first {
+				consistent++
+			}
+		}
+		if first <= 0 {
+			continue
+		}
+		score := consistent*1000 + first
+		if score > bestScore || (score == bestScore && ci == def) {
+			best, bestScore = ci, score
+		}
+	}
+	return tableDelimiters[best].r, tableDelimiters[best].name
+}
+
+// countUnquoted counts occurrences of c in line that are not inside a
+// double-quoted field.
+func countUnquoted(line []byte, c byte) int {
+	n := 0
+	quoted := false
+	for _, b := range line {
+		switch {
+		case b == '"':
+			quoted = !quoted
+		case b == c && !quoted:
+			n++
+		}
+	}
+	return n
+}
+
+// groupDigits formats n with thousands separators, e.g. 12345 → "12,345".
+func groupDigits(n int) string {
+	s := strconv.Itoa(n)
+	if len(s) <= 3 {
+		return s
+	}
+	var b strings.Builder
+	pre := len(s) % 3
+	if pre > 0 {
+		b.WriteString(s[:pre])
+	}
+	for i := pre; i < len(s); i += 3 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(s[i : i+3])
+	}
+	return b.String()
+}
+
+// countNoun formats a count with its noun, pluralised with a trailing "s".
+func countNoun(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return groupDigits(n) + " " + noun + "s"
+}
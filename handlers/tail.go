@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Follow mode streams bytes appended to a text file as Server-Sent Events.
+// A stream wakes when the filesystem watcher reports a change to the file
+// and, as a fallback, on a short poll interval: inotify does not see writes
+// made by other hosts on network mounts, nor files in directories beyond the
+// watch limit.
+//
+// Events sent on /api/tail/<path>?offset=N:
+//
+//	event: append   data: {"text": "..."}     id: byte offset after the text
+//	event: reset    data: {"reason": "..."}   the file was truncated or replaced;
+//	                                          following restarts from its start
+//
+// The id lets EventSource resume from the right place after a reconnect.
+
+const (
+	// tailPollInterval is how often a stream re-checks its file when no
+	// watcher event has arrived.
+	tailPollInterval = 2 * time.Second
+
+	// tailHeartbeat is the interval between keep-alive comments, which also
+	// detect clients that went away without closing the connection.
+	tailHeartbeat = 15 * time.Second
+
+	// maxTailChunk caps the text sent in a single event.
+	maxTailChunk = 64 * 1024
+
+	// maxTailBacklog is how far behind the end of the file a stream may
+	// start. Older bytes are skipped rather than replayed.
+	maxTailBacklog = 256 * 1024
+
+	// maxTailStreams bounds concurrent follow streams; each holds an open
+	// file and a goroutine for as long as the page stays open.
+	maxTailStreams = 64
+)
+
+// tailSubs maps cleaned absolute file paths to the wake-up channels of the
+// streams following them.
+var tailSubs struct {
+	mu      sync.Mutex
+	streams int
+	files   map[string]map[chan struct{}]struct{}
+}
+
+func init() {
+	tailSubs.files = make(map[string]map[chan struct{}]struct{})
+}
+
+// tailStop is closed by StopTailStreams to end every follow stream.
+var (
+	tailStop     = make(chan struct{})
+	tailStopOnce sync.Once
+)
+
+// StopTailStreams ends every follow stream and any started later. The server
+// calls it when it shuts down: a stream never finishes on its own, so it
+// would otherwise hold the drain until the shutdown timeout. EventSource
+// clients reconnect, after a handoff to the new process.
+func StopTailStreams() {
+	tailStopOnce.Do(func() { close(tailStop) })
+}
+
+// subscribeTail registers a follower of fsPath. It returns nil when the
+// stream limit has been reached.
+func subscribeTail(fsPath string) (<-chan struct{}, func()) {
+	key := filepath.Clean(fsPath)
+	tailSubs.mu.Lock()
+	defer tailSubs.mu.Unlock()
+	if tailSubs.streams >= maxTailStreams {
+		return nil, nil
+	}
+	tailSubs.streams++
+	ch := make(chan struct{}, 1)
+	if tailSubs.files[key] == nil {
+		tailSubs.files[key] = make(map[chan struct{}]struct{})
+	}
+	tailSubs.files[key][ch] = struct{}{}
+
+	return ch, func() {
+		tailSubs.mu.Lock()
+		defer tailSubs.mu.Unlock()
+		tailSubs.streams--
+		delete(tailSubs.files[key], ch)
+		if len(tailSubs.files[key]) == 0 {
+			delete(tailSubs.files, key)
+		}
+	}
+}
+
+// notifyTail wakes every stream following fsPath. It never blocks: a stream
+// that has not yet consumed its previous wake-up will still re-read the file.
+func notifyTail(fsPath string) {
+	tailSubs.mu.Lock()
+	defer tailSubs.mu.Unlock()
+	for ch := range tailSubs.files[filepath.Clean(fsPath)] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// TailHandler serves /api/tail/<path> as an SSE stream of text appended to
+// a file after ?offset= (or the Last-Event-ID sent on reconnect). It is
+// mounted behind BandwidthManager.Wrap, so the stream shares the same
+// per-IP budget as downloads.
+func TailHandler(roots map[string]string, opts PreviewOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urlPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/api/tail"))
+		if !opts.forRoot(urlPath).Text {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		fsPath, err := resolvePath(r, roots, urlPath)
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		f, err := os.Open(fsPath)
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		// catchUp replaces t.f when the file is rotated; close whichever is
+		// open at the end.
+		t := &tailer{w: w, f: f, fsPath: fsPath}
+		defer func() { t.f.Close() }()
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() || !isText(mimeForFile(fsPath)) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		wake, unsubscribe := subscribeTail(fsPath)
+		if wake == nil {
+			http.Error(w, "Too many followers", http.StatusServiceUnavailable)
+			return
+		}
+		defer unsubscribe()
+
+		offset := tailStartOffset(r, info.Size())
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Ask reverse proxies such as NGINX not to buffer the stream.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		// Tell EventSource how long to wait before reconnecting.
+		fmt.Fprint(w, "retry: 3000\n\n")
+		if rc.Flush() != nil {
+			return
+		}
+
+		t.info, t.offset = info, offset
+		poll := time.NewTicker(tailPollInterval)
+		defer poll.Stop()
+		heartbeat := time.NewTicker(tailHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			if err := t.catchUp(); err != nil {
+				return
+			}
+			if rc.Flush() != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-tailStop:
+				return
+			case <-wake:
+			case <-poll.C:
+			case <-heartbeat.C:
+				if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// tailStartOffset picks where a stream starts: the Last-Event-ID of a
+// reconnecting EventSource, else ?offset=, else the end of the file. It
+// never starts more than maxTailBacklog bytes before the end.
+func tailStartOffset(r *http.Request, size int64) int64 {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("offset")
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 || offset > size {
+		offset = size
+	}
+	if size-offset > maxTailBacklog {
+		offset = size - maxTailBacklog
+	}
+	return offset
+}
+
+// tailer is the state of one follow stream.
+type tailer struct {
+	w      io.Writer
+	f      *os.File
+	info   os.FileInfo // of the open file, for rotation detection
+	fsPath string
+	offset int64 // next byte to send
+}
+
+// catchUp sends everything appended since the last call. A file that has
+// shrunk was truncated; a path that now names a different file was rotated
+// (renamed away and recreated). Both restart the stream from byte 0.
+func (t *tailer) catchUp() error {
+	cur, err := os.Stat(t.fsPath)
+	if err != nil {
+		// Mid-rotation the path may briefly not exist; try again later.
+		return nil
+	}
+	switch {
+	case !os.SameFile(t.info, cur):
+		f, err := os.Open(t.fsPath)
+		if err != nil {
+			return nil
+		}
+		t.f.Close()
+		t.f, t.info, t.offset = f, cur, 0
+		if err := t.event("reset", "", map[string]string{"reason": "rotated"}); err != nil {
+			return err
+		}
+	case cur.Size() < t.offset:
+		t.offset = 0
+		if err := t.event("reset", "", map[string]string{"reason": "truncated"}); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, maxTailChunk)
+	for {
+		n, err := t.f.ReadAt(buf, t.offset)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return err
+			}
+			return nil
+		}
+		// Hold back a multi-byte character split across reads, whether at
+		// the end of the file or at the end of a full chunk; the offset
+		// stops before it, so the next read starts with the whole
+		// character.
+		chunk := trimPartialRune(buf[:n])
+		if len(chunk) == 0 {
+			return nil
+		}
+		t.offset += int64(len(chunk))
+		id := strconv.FormatInt(t.offset, 10)
+		if err := t.event("append", id, map[string]string{"text": string(chunk)}); err != nil {
+			return err
+		}
+		if n < len(buf) {
+			return nil
+		}
+	}
+}
+
+// event writes one SSE event with a JSON payload.
+func (t *tailer) event(name, id string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	msg := "event: " + name + "\n"
+	if id != "" {
+		msg += "id: " + id + "\n"
+	}
+	_, err = io.WriteString(t.w, msg+"data: "+string(data)+"\n\n")
+	return err
+}
+
+// trimPartialRune drops an incomplete UTF-8 sequence from the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i <= utf8.UTFMax-1 && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if c < utf8.RuneSelf {
+			return b
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			return b
+		}
+	}
+	return b
+}
@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gileserver/models"
+)
+
+// Text files larger than maxTextBytes are previewed a window of lines at a
+// time instead of being cut off. A sparse line index (the byte offset of
+// every lineIndexStride-th line) is built with one sequential pass over the
+// file and cached, so jumping to an arbitrary line only has to scan forward
+// from the nearest indexed line.
+
+const (
+	// textWindowLines is the number of lines in one window.
+	textWindowLines = 500
+
+	// maxWindowBytes ends a window early when its lines are very long, so a
+	// window never becomes a multi-megabyte highlighting job.
+	maxWindowBytes = 512 * 1024
+
+	// maxLineBytes is the longest line shown; the rest of a longer line is
+	// skipped and the window is marked Clipped.
+	maxLineBytes = 64 * 1024
+
+	// lineIndexStride is the distance in lines between indexed offsets.
+	lineIndexStride = 1024
+
+	// maxCachedLineIndexes bounds the line index cache.
+	maxCachedLineIndexes = 64
+
+	// lineScanBuffer is the read size used while scanning for newlines.
+	lineScanBuffer = 256 * 1024
+)
+
+// lineIndex records where every lineIndexStride-th line of a file starts.
+type lineIndex struct {
+	modTime time.Time
+	size    int64
+	lines   int     // total number of lines
+	offsets []int64 // offsets[i] is the start of line i*lineIndexStride (0-based)
+}
+
+// lineIndexCache holds line indexes keyed by absolute filesystem path, valid
+// while the file's modification time and size are unchanged.
+var lineIndexCache struct {
+	mu      sync.Mutex
+	entries map[string]*lineIndex
+}
+
+func init() {
+	lineIndexCache.entries = make(map[string]*lineIndex)
+}
+
+// cachedLineIndex returns the line index of the open file f at fsPath,
+// building it on a cache miss or when the file has changed.
+func cachedLineIndex(f *os.File, fsPath string, info os.FileInfo) (*lineIndex, error) {
+	lineIndexCache.mu.Lock()
+	if li, ok := lineIndexCache.entries[fsPath]; ok && li.modTime.Equal(info.ModTime()) && li.size == info.Size() {
+		lineIndexCache.mu.Unlock()
+		return li, nil
+	}
+	lineIndexCache.mu.Unlock()
+
+	li, err := buildLineIndex(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	li.modTime = info.ModTime()
+
+	lineIndexCache.mu.Lock()
+	if len(lineIndexCache.entries) >= maxCachedLineIndexes {
+		for k := range lineIndexCache.entries {
+			delete(lineIndexCache.entries, k)
+			break
+		}
+	}
+	lineIndexCache.entries[fsPath] = li
+	lineIndexCache.mu.Unlock()
+	return li, nil
+}
+
+// buildLineIndex scans the first size bytes of r once, counting lines and
+// recording the offset of every lineIndexStride-th line.
+func buildLineIndex(r io.ReaderAt, size int64) (*lineIndex, error) {
+	li := &lineIndex{size: size, offsets: []int64{0}}
+	buf := make([]byte, lineScanBuffer)
+	newlines := 0
+	var pos int64
+	for pos < size {
+		n, err := r.ReadAt(buf, pos)
+		if n == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		chunk := buf[:n]
+		for i := 0; ; {
+			j := bytes.IndexByte(chunk[i:], '\n')
+			if j < 0 {
+				break
+			}
+			i += j + 1
+			newlines++
+			if newlines%lineIndexStride == 0 {
+				li.offsets = append(li.offsets, pos+int64(i))
+			}
+		}
+		pos += int64(n)
+	}
+	li.lines = newlines
+	if size > 0 && !endsWithNewline(r, size) {
+		// The final line has no terminating newline but is still a line.
+		li.lines++
+	}
+	return li, nil
+}
+
+// endsWithNewline reports whether the byte at size-1 is '\n'.
+func endsWithNewline(r io.ReaderAt, size int64) bool {
+	var b [1]byte
+	_, err := r.ReadAt(b[:], size-1)
+	return err == nil && b[0] == '\n'
+}
+
+// seekLine returns the byte offset of 0-based line target, scanning forward
+// from the nearest indexed line.
+func (li *lineIndex) seekLine(r io.ReaderAt, target int) (int64, error) {
+	k := target / lineIndexStride
+	if k >= len(li.offsets) {
+		k = len(li.offsets) - 1
+	}
+	line, pos := k*lineIndexStride, li.offsets[k]
+	_, pos, err := scanLines(r, li.size, line, pos, func(line int, _ int64) bool { return line >= target })
+	return pos, err
+}
+
+// lineAt returns the 0-based number and offset of the first line starting
+// at or after offset. Offsets inside a line therefore snap to the next one.
+func (li *lineIndex) lineAt(r io.ReaderAt, offset int64) (int, int64, error) {
+	k := sort.Search(len(li.offsets), func(i int) bool { return li.offsets[i] > offset }) - 1
+	if k < 0 {
+		k = 0
+	}
+	return scanLines(r, li.size, k*lineIndexStride, li.offsets[k], func(_ int, pos int64) bool { return pos >= offset })
+}
+
+// scanLines walks line starts forward from line (which starts at pos) until
+// stop reports true or the end of the file is reached, and returns the line
+// number and offset it stopped at.
+func scanLines(r io.ReaderAt, size int64, line int, pos int64, stop func(line int, pos int64) bool) (int, int64, error) {
+	buf := make([]byte, lineScanBuffer)
+	for !stop(line, pos) && pos < size {
+		n, err := r.ReadAt(buf, pos)
+		if n == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, 0, err
+		}
+		chunk := buf[:n]
+		consumed := 0
+		for {
+			j := bytes.IndexByte(chunk[consumed:], '\n')
+			if j < 0 {
+				break
+			}
+			consumed += j + 1
+			line++
+			if stop(line, pos+int64(consumed)) {
+				return line, pos + int64(consumed), nil
+			}
+		}
+		pos += int64(n)
+	}
+	if pos > size {
+		pos = size
+	}
+	return line, pos, nil
+}
+
+// readWindow reads up to textWindowLines whole lines starting at offset.
+// It returns the text, the offset just past it, the number of lines read and
+// whether any line was longer than maxLineBytes and had to be cut short.
+func readWindow(r io.ReaderAt, size, offset int64) (string, int64, int, bool, error) {
+	var out bytes.Buffer
+	buf := make([]byte, lineScanBuffer)
+	pos, lines, clipped := offset, 0, false
+	lineLen := 0 // bytes of the current line written so far
+
+	for pos < size && lines < textWindowLines && out.Len() < maxWindowBytes {
+		n, err := r.ReadAt(buf, pos)
+		if n == 0 && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", 0, 0, false, err
+		}
+		chunk := buf[:n]
+		for len(chunk) > 0 && lines < textWindowLines {
+			j := bytes.IndexByte(chunk, '\n')
+			seg := chunk
+			if j >= 0 {
+				seg = chunk[:j+1]
+			}
+			if keep := maxLineBytes - lineLen; len(seg) > keep {
+				if keep > 0 {
+					out.Write(seg[:keep])
+					lineLen += keep
+				}
+				clipped = true
+			} else {
+				out.Write(seg)
+				lineLen += len(seg)
+			}
+			pos += int64(len(seg))
+			chunk = chunk[len(seg):]
+			if j >= 0 {
+				if lineLen >= maxLineBytes && seg[len(seg)-1] == '\n' && out.Bytes()[out.Len()-1] != '\n' {
+					out.WriteByte('\n')
+				}
+				lines++
+				lineLen = 0
+				if out.Len() >= maxWindowBytes {
+					break
+				}
+			}
+		}
+	}
+	if lineLen > 0 {
+		// The file ends without a trailing newline.
+		lines++
+	}
+	return out.String(), pos, lines, clipped, nil
+}
+
+// textWindow reads and highlights the window of the file at fsPath that
+// starts at 1-based line (when line > 0) or at the first line starting at or
+// after byte offset.
+func textWindow(fsPath string, info os.FileInfo, line int, offset int64, theme string) (*models.TextWindow, error) {
+	f, err := os.Open(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	li, err := cachedLineIndex(f, fsPath, info)
+	if err != nil {
+		return nil, err
+	}
+
+	var first int
+	if line > 0 {
+		if line > li.lines {
+			line = li.lines
+		}
+		first = line - 1
+		if first < 0 {
+			first = 0
+		}
+		if offset, err = li.seekLine(f, first); err != nil {
+			return nil, err
+		}
+	} else if first, offset, err = li.lineAt(f, offset); err != nil {
+		return nil, err
+	}
+
+	content, next, n, clipped, err := readWindow(f, li.size, offset)
+	if err != nil {
+		return nil, err
+	}
+	highlighted, err := highlightLines(content, filepath.Base(fsPath), theme, first+1)
+	if err != nil {
+		highlighted = plainCodeBlock(content)
+	}
+	return &models.TextWindow{
+		Offset:     offset,
+		Next:       next,
+		StartLine:  first + 1,
+		EndLine:    first + n,
+		TotalLines: li.lines,
+		Size:       li.size,
+		EOF:        next >= li.size,
+		Clipped:    clipped,
+		HTML:       string(highlighted),
+	}, nil
+}
+
+// newTextPager builds the paged preview of a large text file, starting at
+// the 1-based line requested with ?line= (or the first line).
+func newTextPager(fsPath, urlPath string, info os.FileInfo, lineParam, theme string) (*models.TextPager, error) {
+	line, _ := strconv.Atoi(lineParam)
+	if line < 1 {
+		line = 1
+	}
+	win, err := textWindow(fsPath, info, line, 0, theme)
+	if err != nil {
+		return nil, err
+	}
+	p := &models.TextPager{
+		TextWindow:  *win,
+		API:         "/api/text" + urlPath,
+		WindowLines: textWindowLines,
+		Summary:     countNoun(win.TotalLines, "line"),
+		PrevLine:    win.StartLine - textWindowLines,
+		LastLine:    win.TotalLines - textWindowLines + 1,
+	}
+	if p.PrevLine < 1 {
+		p.PrevLine = 1
+	}
+	if p.LastLine < 1 {
+		p.LastLine = 1
+	}
+	return p, nil
+}
+
+// TextWindowHandler serves /api/text/<path>: a JSON models.TextWindow of
+// highlighted lines from a text file, selected with ?line=N (1-based) or
+// ?offset=B (the Next offset of a previous window).
+func TextWindowHandler(roots map[string]string, theme string, opts PreviewOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urlPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/api/text"))
+		if !opts.forRoot(urlPath).Text {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		fsPath, err := resolvePath(r, roots, urlPath)
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		info, err := os.Stat(fsPath)
+		if err != nil || !info.Mode().IsRegular() || !isText(mimeForFile(fsPath)) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		q := r.URL.Query()
+		line, _ := strconv.Atoi(q.Get("line"))
+		offset, _ := strconv.ParseInt(q.Get("offset"), 10, 64)
+		if offset < 0 {
+			offset = 0
+		}
+		win, err := textWindow(fsPath, info, line, offset, theme)
+		if err != nil {
+			http.Error(w, "Could not read file", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(win)
+	}
+}
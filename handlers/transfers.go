@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"gileserver/models"
+)
+
+// rateStale is how long a transfer may go without moving data before its
+// sampled rate is reported as zero.
+const rateStale = 3 * time.Second
+
+// record counts n more bytes for the transfer and refreshes its sampled rate
+// when the last sample is at least a second old.
+func (t *transfer) record(n int, now time.Time) {
+	total := t.bytes.Add(int64(n))
+	t.sampleMu.Lock()
+	defer t.sampleMu.Unlock()
+	if d := now.Sub(t.sampleAt); d >= time.Second {
+		t.rate = float64(total-t.sampleN) / d.Seconds()
+		t.sampleAt, t.sampleN = now, total
+	}
+}
+
+// currentRate returns the transfer's most recently sampled rate in bytes per
+// second.
+func (t *transfer) currentRate(now time.Time) float64 {
+	t.sampleMu.Lock()
+	defer t.sampleMu.Unlock()
+	if now.Sub(t.sampleAt) > rateStale {
+		return 0
+	}
+	return t.rate
+}
+
+// throttle is a temporary cap an admin placed on one client IP.
+type throttle struct {
+	limit float64 // bytes per second
+	until time.Time
+}
+
+// Transfers lists every transfer in progress and the throttles in force, for
+// the admin dashboard.
+func (bm *BandwidthManager) Transfers() models.TransferList {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	now := time.Now()
+	list := models.TransferList{Transfers: []models.TransferStatus{}, Throttles: []models.ThrottleStatus{}}
+	for _, p := range []*bandwidthPool{bm.egress, bm.ingress} {
+		for ip, st := range p.peers {
+			for t := range st.transfers {
+				r := t.currentRate(now)
+				list.Transfers = append(list.Transfers, models.TransferStatus{
+					ID:        t.id,
+					Kind:      p.kind,
+					IP:        ip,
+					Path:      t.path,
+					Bytes:     t.bytes.Load(),
+					Rate:      r,
+					RateText:  formatBits(r),
+					Started:   t.start,
+					Elapsed:   now.Sub(t.start).Round(time.Second).String(),
+					Weight:    t.weight,
+					Alloc:     st.alloc,
+					AllocText: formatLimit(st.alloc),
+				})
+			}
+		}
+	}
+	sort.Slice(list.Transfers, func(i, j int) bool { return list.Transfers[i].ID < list.Transfers[j].ID })
+
+	// Throttles are set on both pools together, so the egress pool has them
+	// all.
+	for ip, th := range bm.egress.throttles {
+		list.Throttles = append(list.Throttles, models.ThrottleStatus{
+			IP:        ip,
+			Limit:     th.limit,
+			LimitText: formatBits(th.limit),
+			Until:     th.until,
+		})
+	}
+	sort.Slice(list.Throttles, func(i, j int) bool { return list.Throttles[i].IP < list.Throttles[j].IP })
+	return list
+}
+
+// Abort cuts off the transfer with the given ID. It reports false when no
+// such transfer is in progress.
+func (bm *BandwidthManager) Abort(id uint64) bool {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	for _, p := range []*bandwidthPool{bm.egress, bm.ingress} {
+		for ip, st := range p.peers {
+			for t := range st.transfers {
+				if t.id == id {
+					log.Printf("admin: abort  ip=%s  file=%s", ip, t.path)
+					t.aborted.Store(true)
+					t.cancel()
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Throttle caps ip at limit bytes per second, in both directions, for the
+// duration d. The cap lowers the IP's share but never raises it. A limit of 0
+// lifts an existing throttle.
+func (bm *BandwidthManager) Throttle(ip string, limit float64, d time.Duration) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if limit <= 0 {
+		log.Printf("admin: unthrottle  ip=%s", ip)
+		bm.setThrottleLocked(ip, nil)
+		return
+	}
+	th := throttle{limit: limit, until: time.Now().Add(d)}
+	log.Printf("admin: throttle  ip=%s  limit=%s  for=%s", ip, formatBits(limit), d)
+	bm.setThrottleLocked(ip, &th)
+
+	time.AfterFunc(d, func() {
+		bm.mu.Lock()
+		defer bm.mu.Unlock()
+		// A later call may have replaced or lifted this throttle.
+		if cur, ok := bm.egress.throttles[ip]; ok && cur == th {
+			log.Printf("admin: throttle expired  ip=%s", ip)
+			bm.setThrottleLocked(ip, nil)
+		}
+	})
+}
+
+// setThrottleLocked installs th for ip, or removes the IP's throttle when th
+// is nil, and rebalances. Must be called with bm.mu held.
+func (bm *BandwidthManager) setThrottleLocked(ip string, th *throttle) {
+	for _, p := range []*bandwidthPool{bm.egress, bm.ingress} {
+		if th == nil {
+			delete(p.throttles, ip)
+		} else {
+			p.throttles[ip] = *th
+		}
+		p.rebalanceLocked()
+	}
+}
@@ -5,22 +5,32 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sync"
 	"syscall"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+// Watcher keeps the caches of the served roots in step with the filesystem.
+type Watcher struct {
+	w *fsnotify.Watcher
+
+	mu    sync.Mutex
+	roots map[string]string // guarded by mu; replaced by SetRoots
+}
+
 // StartWatcher sets up recursive filesystem watches on all configured roots.
 // On any change it invalidates only the affected cache entries so the next
 // request is served fresh without a full re-walk.
 //
 // It returns immediately; all watch processing runs in a background goroutine.
-// The returned stop function closes the watcher and terminates the goroutine.
-func StartWatcher(roots map[string]string) (stop func(), err error) {
+// Stop closes the watcher and terminates the goroutine.
+func StartWatcher(roots map[string]string) (*Watcher, error) {
 	w, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
+	fw := &Watcher{w: w, roots: roots}
 
 	// Watch every existing directory under every root recursively.
 	for _, fsRoot := range roots {
@@ -37,6 +47,9 @@ func StartWatcher(roots map[string]string) (stop func(), err error) {
 				if !ok {
 					return
 				}
+				fw.mu.Lock()
+				roots := fw.roots
+				fw.mu.Unlock()
 				handleEvent(w, roots, event)
 
 			case err, ok := <-w.Errors:
@@ -48,7 +61,66 @@ func StartWatcher(roots map[string]string) (stop func(), err error) {
 		}
 	}()
 
-	return func() { _ = w.Close() }, nil
+	return fw, nil
+}
+
+// Stop closes the watcher.
+func (fw *Watcher) Stop() {
+	_ = fw.w.Close()
+}
+
+// SetRoots switches the watcher to a new set of roots, after a reload. Roots
+// that are gone stop being watched and new ones start; roots present in both
+// sets keep their watches.
+func (fw *Watcher) SetRoots(roots map[string]string) {
+	fw.mu.Lock()
+	old := fw.roots
+	fw.roots = roots
+	fw.mu.Unlock()
+
+	was := make(map[string]bool, len(old))
+	for _, dir := range old {
+		was[filepath.Clean(dir)] = true
+	}
+	now := make(map[string]bool, len(roots))
+	for _, dir := range roots {
+		now[filepath.Clean(dir)] = true
+	}
+
+	// Drop the watches of removed roots, except where another root that is
+	// still served contains them.
+	for _, path := range fw.w.WatchList() {
+		if underAny(path, was) && !underAny(path, now) {
+			_ = fw.w.Remove(path)
+		}
+	}
+	for dir := range now {
+		if !was[dir] {
+			log.Printf("watcher: watching new root %s", dir)
+			if err := watchRecursive(fw.w, dir); err != nil {
+				log.Printf("watcher: could not watch %s: %v", dir, err)
+			}
+		}
+	}
+	for dir := range was {
+		if !now[dir] {
+			log.Printf("watcher: no longer watching %s", dir)
+		}
+	}
+}
+
+// underAny reports whether path is one of dirs or lies beneath one of them.
+func underAny(path string, dirs map[string]bool) bool {
+	for cur := filepath.Clean(path); ; {
+		if dirs[cur] {
+			return true
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			return false
+		}
+		cur = parent
+	}
 }
 
 // watchRecursive adds a watch for dir and every subdirectory beneath it.
@@ -104,6 +176,10 @@ func handleEvent(w *fsnotify.Watcher, roots map[string]string, event fsnotify.Ev
 		evictSizePath(event.Name)
 	}
 
+	// Wake any follow-mode streams tailing the file. Rotation shows up as a
+	// Rename or Remove followed by a Create, so every operation counts.
+	notifyTail(event.Name)
+
 	// Invalidate (mark stale) the size for the parent directory and every
 	// ancestor up to the root. A file change at any depth affects the
 	// cumulative size of all directories above it.
@@ -114,6 +190,14 @@ func handleEvent(w *fsnotify.Watcher, roots map[string]string, event fsnotify.Ev
 	if event.Has(fsnotify.Create) || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
 		invalidateIndex()
 	}
+
+	// A .gileignore changes what is excluded below its directory, so any
+	// operation on one, including an edit in place, makes the index and the
+	// sizes of the whole subtree stale.
+	if filepath.Base(event.Name) == gileignoreName {
+		invalidateIndex()
+		invalidateSizeTree(filepath.Dir(event.Name))
+	}
 }
 
 // invalidateSizeChain removes fsPath and every ancestor up to the root from
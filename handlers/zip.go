@@ -79,7 +79,7 @@ func ZipHandler(roots map[string]string, siteName string) http.HandlerFunc {
 		if urlPath == "/" {
 			log.Printf("zip  download   ip=%-15s  dir=/ (all roots)", ip)
 			start := time.Now()
-			n := zipAll(w, roots, siteName)
+			n := zipAll(w, visibleRoots(r, roots), siteName)
 			if n > 0 {
 				RecordDownload(n)
 			}
@@ -88,7 +88,7 @@ func ZipHandler(roots map[string]string, siteName string) http.HandlerFunc {
 			return
 		}
 
-		fsPath, err := resolvePath(roots, urlPath)
+		fsPath, err := resolvePath(r, roots, urlPath)
 		if err != nil {
 			http.Error(w, "Not found", http.StatusNotFound)
 			return
@@ -104,7 +104,7 @@ func ZipHandler(roots map[string]string, siteName string) http.HandlerFunc {
 		log.Printf("zip  download   ip=%-15s  dir=%s", ip, urlPath)
 		start := time.Now()
 
-		entries, err := collectEntries(fsPath, dirName)
+		entries, err := collectEntries(fsPath, dirName, scopeFor(roots, urlPath))
 		if err != nil {
 			http.Error(w, "Failed to read directory", http.StatusInternalServerError)
 			return
@@ -128,7 +128,7 @@ func ZipHandler(roots map[string]string, siteName string) http.HandlerFunc {
 func zipAll(w http.ResponseWriter, roots map[string]string, siteName string) int64 {
 	var allEntries []zipEntry
 	for name, fsPath := range roots {
-		entries, err := collectEntries(fsPath, name)
+		entries, err := collectEntries(fsPath, name, newIgnoreScope(rootSettings(name), fsPath))
 		if err == nil {
 			allEntries = append(allEntries, entries...)
 		}
@@ -147,8 +147,9 @@ type zipEntry struct {
 // collectEntries walks fsPath and returns all files with their archive names
 // rooted at prefix. It follows symlinks (including symlinks to directories)
 // and prevents infinite recursion by tracking every resolved real path that
-// has been visited.
-func collectEntries(fsPath, prefix string) ([]zipEntry, error) {
+// has been visited. Files and directories that scope, the scope of fsPath,
+// omits are left out.
+func collectEntries(fsPath, prefix string, scope *ignoreScope) ([]zipEntry, error) {
 	// Resolve the root itself so it is in the visited set from the start,
 	// preventing a symlink inside the tree from looping back to the root.
 	realRoot, err := filepath.EvalSymlinks(fsPath)
@@ -160,7 +161,7 @@ func collectEntries(fsPath, prefix string) ([]zipEntry, error) {
 	visited[realRoot] = struct{}{}
 
 	var entries []zipEntry
-	err = walkEntries(realRoot, prefix, visited, &entries)
+	err = walkEntries(realRoot, prefix, scope, visited, &entries)
 	return entries, err
 }
 
@@ -172,7 +173,7 @@ func collectEntries(fsPath, prefix string) ([]zipEntry, error) {
 // symlinks into directories — it calls the walk function with the symlink's
 // own FileInfo and never descends. We use os.ReadDir + os.Lstat instead so
 // we can detect symlinks ourselves and recurse into their targets explicitly.
-func walkEntries(fsPath, zipPrefix string, visited map[string]struct{}, entries *[]zipEntry) error {
+func walkEntries(fsPath, zipPrefix string, scope *ignoreScope, visited map[string]struct{}, entries *[]zipEntry) error {
 	dirEntries, err := os.ReadDir(fsPath)
 	if err != nil {
 		log.Printf("zip  warning    cannot-read-dir=%s  err=%v", fsPath, err)
@@ -180,6 +181,9 @@ func walkEntries(fsPath, zipPrefix string, visited map[string]struct{}, entries
 	}
 
 	for _, de := range dirEntries {
+		if scope.omitted(de.Name(), entryIsDir(fsPath, de)) {
+			continue
+		}
 		filePath := filepath.Join(fsPath, de.Name())
 		zipName := zipPrefix + "/" + de.Name()
 
@@ -216,7 +220,7 @@ func walkEntries(fsPath, zipPrefix string, visited map[string]struct{}, entries
 				// so that further os.ReadDir calls work correctly, but keep
 				// zipName derived from the original (logical) path so the
 				// archive structure mirrors what the user sees on disk.
-				if err := walkEntries(realPath, zipName, visited, entries); err != nil {
+				if err := walkEntries(realPath, zipName, scope.child(de.Name(), realPath), visited, entries); err != nil {
 					log.Printf("zip  warning    walk-symdir=%s  err=%v", realPath, err)
 				}
 			} else {
@@ -243,7 +247,7 @@ func walkEntries(fsPath, zipPrefix string, visited map[string]struct{}, entries
 			}
 			visited[realPath] = struct{}{}
 
-			if err := walkEntries(filePath, zipName, visited, entries); err != nil {
+			if err := walkEntries(filePath, zipName, scope.child(de.Name(), filePath), visited, entries); err != nil {
 				log.Printf("zip  warning    walk-dir=%s  err=%v", filePath, err)
 			}
 			continue
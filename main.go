@@ -4,6 +4,9 @@ package main
 import (
 	"embed"
 	"log"
+	// Embedded zone database, so TZ works for bandwidth schedules in images
+	// without tzdata installed.
+	_ "time/tzdata"
 
 	"gileserver/config"
 	"gileserver/server"
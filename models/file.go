@@ -17,6 +17,12 @@ type FileEntry struct {
 	IsPreview   bool // true if the file can be previewed (image or text)
 	IsImage     bool // true if the file is an image
 	IsText      bool // true if the file is a plain-text type
+	IsArchive   bool // true if the file is an archive that can be browsed
+	InArchive   bool // true if the entry is a member inside an archive
+	// DisplayName and Description are set for roots that have them in the
+	// config file; DisplayName replaces Name in the listing.
+	DisplayName string
+	Description string
 }
 
 // DirListing holds everything a directory template needs.
@@ -35,6 +41,134 @@ type DirListing struct {
 	IsRoot bool
 	// DefaultTheme is the server-configured theme ("dark" or "light").
 	DefaultTheme string
+	// InArchive is true when the listing is a directory inside an archive;
+	// DownloadURL then downloads the whole archive file.
+	InArchive bool
+	// ReadmeName and ReadmePath identify the README rendered beneath the
+	// listing; Readme is its rendered HTML, empty when there is none.
+	ReadmeName string
+	ReadmePath string
+	Readme     template.HTML
+}
+
+// PDFInfo is the server-side summary of a PDF document, read from its page
+// tree and document information dictionary.
+type PDFInfo struct {
+	Version   string // header version, e.g. "1.7"
+	Pages     int
+	Title     string
+	Author    string
+	Subject   string
+	Creator   string // application that created the original document
+	Producer  string // application that converted it to PDF
+	Created   string // creation date, formatted for display
+	Encrypted bool
+}
+
+// FontInfo is the server-side summary of a font file, read from its name,
+// maxp and cmap tables. Name fields are empty when the font omits them.
+type FontInfo struct {
+	Format         string // container and outline type, e.g. "WOFF2 (TrueType outlines)"
+	Family         string
+	Style          string // e.g. "Bold Italic"
+	FullName       string
+	Version        string
+	PostScriptName string
+	Designer       string
+	Manufacturer   string
+	Copyright      string
+	Trademark      string
+	License        string
+	LicenseURL     string
+
+	Glyphs     int // glyphs in the font
+	Characters int // code points mapped to a glyph
+	// SampleText is specimen text the font covers: a pangram for Latin
+	// fonts, otherwise a run of characters from its character map.
+	SampleText  string
+	SampleSizes []int // pixel sizes of the specimen lines
+	// Blocks lays the printable mapped characters out in rows of 256 code
+	// points. GridTruncated is set when the grid was capped.
+	Blocks        []GlyphBlock
+	GridTruncated bool
+}
+
+// GlyphBlock is the mapped characters within one 256-code-point range.
+type GlyphBlock struct {
+	Start rune
+	Label string // e.g. "U+0100–01FF"
+	Chars []Glyph
+}
+
+// Glyph is one cell of the character grid.
+type Glyph struct {
+	Char string
+	Code string // e.g. "U+00E9"
+}
+
+// HexDump is one page of a hex/ASCII dump of a binary file.
+type HexDump struct {
+	// Format describes the file when a known magic number was recognised,
+	// e.g. "ELF 64-bit LSB shared object, x86-64". Empty otherwise.
+	Format string
+	Offset int64 // offset of the first byte shown
+	End    int64 // offset just past the last byte shown
+	Rows   []HexRow
+	// PrevOffset and NextOffset are the offsets of the neighbouring pages;
+	// HasPrev / HasNext report whether those pages exist.
+	PrevOffset int64
+	NextOffset int64
+	LastOffset int64 // offset of the final page
+	HasPrev    bool
+	HasNext    bool
+}
+
+// HexRow is one line of a hex dump: the offset, up to 16 bytes in hex, and
+// the same bytes as printable ASCII.
+type HexRow struct {
+	Offset string
+	Hex    string
+	ASCII  string
+}
+
+// TextWindow is a run of whole lines from a text file, highlighted on its
+// own. It is both the JSON body of /api/text/ and the first window embedded
+// in a paged preview page.
+type TextWindow struct {
+	Offset     int64  `json:"offset"`     // byte offset of the first line
+	Next       int64  `json:"next"`       // byte offset just past the last line
+	StartLine  int    `json:"startLine"`  // 1-based number of the first line
+	EndLine    int    `json:"endLine"`    // number of the last line
+	TotalLines int    `json:"totalLines"` // lines in the whole file
+	Size       int64  `json:"size"`       // size of the whole file
+	EOF        bool   `json:"eof"`        // the window reaches the end of the file
+	Clipped    bool   `json:"clipped"`    // an over-long line was cut short
+	HTML       string `json:"html"`       // Chroma-highlighted lines
+}
+
+// TextPager describes the paged view of a text file too large to preview in
+// one piece. Further windows are loaded from API; the navigation links use
+// ?line= so that paging also works without JavaScript.
+type TextPager struct {
+	TextWindow
+	API         string // URL of the window API for this file
+	WindowLines int    // lines per window
+	Summary     string // e.g. "1,204,331 lines"
+	PrevLine    int    // first line of the previous window
+	LastLine    int    // first line of the final window
+}
+
+// MetaGroup is one titled section of the metadata panel on a preview page
+// (e.g. "Camera", "Location", "Tags").
+type MetaGroup struct {
+	Title  string
+	Fields []MetaField
+}
+
+// MetaField is a single label/value pair within a MetaGroup.
+type MetaField struct {
+	Label string
+	Value string
 }
 
 // Breadcrumb is one segment of the path shown in the navigation bar.
@@ -58,7 +192,7 @@ type IndexEntry struct {
 }
 
 // PreviewData holds the information needed to render a file preview page.
-// Exactly one of IsImage / IsText / IsDir / IsBinary will be true.
+// Exactly one of IsImage / IsText / IsPDF / IsFont / IsDir / IsBinary will be true.
 type PreviewData struct {
 	Title        string
 	SiteName     string // branding name shown in the header and page title
@@ -73,12 +207,28 @@ type PreviewData struct {
 	IsImage  bool
 	IsText   bool
 	IsBinary bool // not image, not text — generic info card
+	// IsPDF is true when the file is a PDF shown in the embedded viewer.
+	IsPDF bool
+	// PDF holds the document summary shown above the embedded viewer.
+	// Nil when the file could not be parsed.
+	PDF *PDFInfo
+	// IsFont is true when the file is a font shown as a type specimen, in
+	// which case Font holds the details read from its tables.
+	IsFont bool
+	Font   *FontInfo
+	// Hex is the hex/ASCII dump shown beneath the info card of unrecognised
+	// binary files. Nil for every other kind of file.
+	Hex *HexDump
 
 	// DownloadURL is the download (or ZIP) href for explicit user-initiated downloads.
 	DownloadURL string
 	// ViewURL is the inline-serving href used for image previews.
 	// It does not set Content-Disposition: attachment and is not counted in stats.
 	ViewURL string
+	// BrowseURL is set for archive files and links to their contents listing.
+	BrowseURL string
+	// InArchive is true when the previewed path is a member inside an archive.
+	InArchive bool
 
 	// FileSize, MIMEType and ModTime are shown on the generic info card.
 	FileSize int64
@@ -88,8 +238,21 @@ type PreviewData struct {
 	// EntryCount is the number of direct children; populated for directories.
 	EntryCount int
 
+	// Metadata holds embedded file metadata (EXIF, audio tags, image
+	// dimensions) shown in the collapsible panel. Empty when none was found.
+	Metadata []MetaGroup
+
 	// HighlightedContent is the Chroma-highlighted HTML for text files.
 	HighlightedContent template.HTML
+	// TailURL is the follow-mode event stream for a plain text file on disk;
+	// empty for rendered documents and archive members.
+	TailURL string
+	// TextPager is set when a text file is too large to preview whole; its
+	// first window is in HighlightedContent.
+	TextPager *TextPager
+	// TruncatedAt is the number of bytes shown when text content was cut
+	// short (archive members, which cannot be paged). Zero when complete.
+	TruncatedAt int64
 
 	// IsRendered is true when RenderedContent should be shown instead of
 	// HighlightedContent (e.g. Markdown, Org-mode, HTML).
@@ -100,3 +263,140 @@ type PreviewData struct {
 
 	Breadcrumbs []Breadcrumb
 }
+
+// DiffPage holds the data for the /diff page comparing two text files.
+type DiffPage struct {
+	Title        string
+	SiteName     string
+	DefaultTheme string
+
+	PathA string // URL path of the original file
+	PathB string // URL path of the changed file
+	Mode  string // "unified" or "split"
+
+	// Error explains why the files could not be compared (not found, binary,
+	// too large). Empty on success or when no files were chosen yet.
+	Error string
+	// Identical is true when the files have the same lines.
+	Identical bool
+	// Added and Removed count changed lines across all hunks.
+	Added   int
+	Removed int
+	Hunks   []DiffHunk
+}
+
+// DiffHunk is one run of changes with surrounding context. Lines is set in
+// unified mode and Rows in side-by-side mode.
+type DiffHunk struct {
+	Header string // e.g. "@@ -12,7 +12,9 @@"
+	Lines  []DiffLine
+	Rows   []DiffRow
+}
+
+// DiffLine is one line of a unified diff. OldNum / NewNum are 1-based line
+// numbers, zero on the side the line does not exist in.
+type DiffLine struct {
+	Kind   string // "context", "add" or "del"
+	OldNum int
+	NewNum int
+	HTML   template.HTML
+}
+
+// DiffRow is one row of a side-by-side diff.
+type DiffRow struct {
+	Left  DiffCell
+	Right DiffCell
+}
+
+// DiffCell is one side of a DiffRow. Kind is empty for the blank cell
+// opposite a pure addition or deletion.
+type DiffCell struct {
+	Kind string // "", "context", "add" or "del"
+	Num  int
+	HTML template.HTML
+}
+
+// BandwidthStatus is the JSON reply of /admin/bandwidth: the limits in force
+// right now and, for a scheduled cap, when it next changes. Rates are in
+// bytes per second, with 0 meaning unlimited, alongside readable forms.
+type BandwidthStatus struct {
+	Limit      float64         `json:"limit"`
+	LimitText  string          `json:"limitText"` // e.g. "20.00 Mbps" or "unlimited"
+	Schedule   string          `json:"schedule,omitempty"`
+	NextChange *time.Time      `json:"nextChange,omitempty"`
+	NextLimit  string          `json:"nextLimit,omitempty"` // readable cap from NextChange on
+	PerIP      float64         `json:"perIP"`
+	PerConn    float64         `json:"perConn"`
+	ActiveIPs  int             `json:"activeIPs"`
+	Transfers  int             `json:"transfers"`
+	Peers      []BandwidthPeer `json:"peers"`
+	// MaxTransfers is the cap on simultaneous transfers per IP, 0 = none.
+	MaxTransfers int `json:"maxTransfers"`
+	// Rejections counts refused requests by reason since stats began.
+	Rejections map[string]int64 `json:"rejections,omitempty"`
+	// Ingress is the same report for request bodies, present when they are
+	// limited.
+	Ingress *BandwidthStatus `json:"ingress,omitempty"`
+}
+
+// TransferList is the admin dashboard's view of the transfers in progress.
+type TransferList struct {
+	Transfers []TransferStatus `json:"transfers"`
+	Throttles []ThrottleStatus `json:"throttles"`
+}
+
+// TransferStatus is one download or upload in progress.
+type TransferStatus struct {
+	ID        uint64    `json:"id"`
+	Kind      string    `json:"kind"` // "download" or "upload"
+	IP        string    `json:"ip"`
+	Path      string    `json:"path"`
+	Bytes     int64     `json:"bytes"`    // sent or received so far
+	Rate      float64   `json:"rate"`     // bytes per second over the last second or so
+	RateText  string    `json:"rateText"` // e.g. "4.20 Mbps"
+	Started   time.Time `json:"started"`
+	Elapsed   string    `json:"elapsed"` // e.g. "2m13s"
+	Weight    float64   `json:"weight"`
+	Alloc     float64   `json:"alloc"`     // the IP's share in bytes per second, 0 = unlimited
+	AllocText string    `json:"allocText"` // e.g. "5.00 Mbps"
+}
+
+// ThrottleStatus is a temporary cap an admin placed on a client IP.
+type ThrottleStatus struct {
+	IP        string    `json:"ip"`
+	Limit     float64   `json:"limit"` // bytes per second
+	LimitText string    `json:"limitText"`
+	Until     time.Time `json:"until"`
+}
+
+// AdminPage holds the information needed to render the admin transfer
+// dashboard. The table itself is filled in by the browser from
+// /admin/api/transfers.
+type AdminPage struct {
+	Title        string
+	SiteName     string
+	DefaultTheme string
+}
+
+// BandwidthPeer is one client IP with active rate-limited transfers.
+type BandwidthPeer struct {
+	IP        string  `json:"ip"`
+	Transfers int     `json:"transfers"`
+	Weight    float64 `json:"weight"`
+	Alloc     float64 `json:"alloc"`     // bytes per second, 0 = unlimited
+	AllocText string  `json:"allocText"` // e.g. "5.00 Mbps"
+}
+
+// QuotaPage is the page shown with a 429 reply when a client has used up its
+// daily or monthly transfer quota.
+type QuotaPage struct {
+	Title        string
+	SiteName     string
+	DefaultTheme string
+
+	Period  string // "daily" or "monthly"
+	Used    int64  // bytes transferred in the period
+	Limit   int64  // the quota in bytes
+	ResetAt time.Time
+	ResetIn string // e.g. "5 hours and 12 minutes"
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// handoffSignal is nil where there is no SIGUSR2; the socket cannot be handed
+// off there.
+var handoffSignal os.Signal
+
+// handoff is not supported without SIGUSR2.
+func handoff(ln net.Listener) error {
+	return fmt.Errorf("handoff is not supported on this platform")
+}
@@ -0,0 +1,74 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// handoffSignal asks the server to hand its socket to a new process.
+var handoffSignal os.Signal = syscall.SIGUSR2
+
+// handoff starts a new server process from the current executable, with the
+// same arguments, and passes it the listening socket. Once serving, the new
+// process sends this one SIGTERM, and this one drains as on any shutdown;
+// until then this one keeps serving, so a new process that fails to start
+// costs nothing.
+func handoff(ln net.Listener) error {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("the listener cannot be handed off")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, listenFDEnv+"=") && !strings.HasPrefix(kv, handoffParentEnv+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, listenFDEnv+"=3", fmt.Sprintf("%s=%d", handoffParentEnv, os.Getpid()))
+
+	// The socket's descriptor is passed as is rather than as an *os.File:
+	// os/exec would switch it to blocking mode, which the two processes
+	// share, and leave this one's accept loop unable to stop.
+	var pid int
+	var startErr error
+	err = raw.Control(func(fd uintptr) {
+		pid, startErr = syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{
+			Env:   env,
+			Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), fd},
+		})
+	})
+	if err == nil {
+		err = startErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("handoff: started %s (pid %d)", exe, pid)
+	go func() {
+		p, err := os.FindProcess(pid)
+		if err != nil {
+			return
+		}
+		st, err := p.Wait()
+		if err == nil {
+			err = fmt.Errorf("%s", st)
+		}
+		log.Printf("handoff: pid %d exited: %v", pid, err)
+	}()
+	return nil
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"gileserver/handlers"
+)
+
+// listenFDEnv names the environment variable through which a process handing
+// off its socket tells the new process which file descriptor to serve on, and
+// handoffParentEnv the one carrying its PID, to be signalled once the new
+// process is serving.
+const (
+	listenFDEnv      = "GILE_LISTEN_FD"
+	handoffParentEnv = "GILE_HANDOFF_PARENT"
+)
+
+// listen returns the server's listening socket: the one inherited from the
+// previous process after a handoff, or a new one on addr.
+func listen(addr string) (net.Listener, error) {
+	fdStr := os.Getenv(listenFDEnv)
+	if fdStr == "" {
+		return net.Listen("tcp", addr)
+	}
+	os.Unsetenv(listenFDEnv)
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", listenFDEnv, fdStr)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherited listener: %w", err)
+	}
+	log.Printf("handoff: serving on the inherited socket %s", ln.Addr())
+	return ln, nil
+}
+
+// serve serves on ln until a signal ends it. SIGHUP reloads the
+// configuration, SIGINT and SIGTERM shut the server down gracefully, and
+// SIGUSR2, where there is one, hands the socket to a new process.
+func (a *app) serve(srv *http.Server, ln net.Listener) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	if handoffSignal != nil {
+		signal.Notify(sigs, handoffSignal)
+	}
+
+	// Follow streams never finish on their own; end them as soon as a
+	// shutdown begins so that they do not hold up the drain.
+	srv.RegisterOnShutdown(handlers.StopTailStreams)
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+	notifyParent()
+
+	for {
+		select {
+		case err := <-served:
+			return err
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGHUP:
+				log.Println("reload: SIGHUP received")
+				a.reload()
+			case handoffSignal:
+				log.Printf("handoff: %s received", sig)
+				if err := handoff(ln); err != nil {
+					log.Printf("handoff: %v; carrying on", err)
+				}
+			default:
+				log.Printf("shutdown: %s received", sig)
+				return a.shutdown(srv, sigs)
+			}
+		}
+	}
+}
+
+// shutdown stops accepting connections and waits for the requests in
+// progress to finish, for at most the configured shutdown timeout, then cuts
+// off whatever remains. A second SIGINT or SIGTERM cuts them off at once.
+// Last, it stops the filesystem watcher and writes out the download stats.
+func (a *app) shutdown(srv *http.Server, sigs <-chan os.Signal) error {
+	a.mu.Lock()
+	timeout := a.cfg.ShutdownTimeout
+	a.mu.Unlock()
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		log.Printf("shutdown: waiting up to %s for transfers to finish", timeout)
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		log.Println("shutdown: waiting for transfers to finish")
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case sig := <-sigs:
+				if sig == syscall.SIGINT || sig == syscall.SIGTERM {
+					log.Printf("shutdown: %s received again", sig)
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("shutdown: transfers still running after %s; closing them", timeout)
+		} else {
+			log.Println("shutdown: closing the remaining transfers")
+		}
+		srv.Close()
+	}
+
+	a.mu.Lock()
+	if a.watcher != nil {
+		a.watcher.Stop()
+	}
+	a.limiter.Close()
+	a.mu.Unlock()
+	handlers.FlushStats()
+	log.Println("shutdown: done")
+	return nil
+}
+
+// notifyParent tells the process that handed over its socket, if any, that
+// this one is serving, so that it can drain and exit.
+func notifyParent() {
+	s := os.Getenv(handoffParentEnv)
+	if s == "" {
+		return
+	}
+	os.Unsetenv(handoffParentEnv)
+
+	pid, err := strconv.Atoi(s)
+	if err != nil {
+		return
+	}
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Signal(syscall.SIGTERM)
+	}
+	if err != nil {
+		log.Printf("handoff: could not signal pid %d: %v", pid, err)
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"gileserver/config"
+	"gileserver/handlers"
+)
+
+// app is the running server. Its routes are built from the configuration at
+// startup and again on every reload, and swapped in atomically: a request
+// already being served finishes on the routes it started with, so a reload
+// never interrupts a download. The bandwidth manager, and with it the fair
+// share of the transfers in progress, carries over from one to the next.
+type app struct {
+	tmpl *Templates
+
+	// mu serialises reloads and guards the fields below.
+	mu      sync.Mutex
+	cfg     *config.Config
+	bw      *handlers.BandwidthManager
+	limiter *handlers.RequestLimiter
+	watcher *handlers.Watcher
+
+	handler atomic.Pointer[http.Handler]
+}
+
+// ServeHTTP serves r with the current routes.
+func (a *app) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*a.handler.Load()).ServeHTTP(w, r)
+}
+
+// reload re-reads the configuration and applies it. When it does not load,
+// the running configuration is kept. The port and the stats directory are
+// bound at startup, so changes to them are logged and ignored.
+func (a *app) reload() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("reload: %v; keeping the running configuration", err)
+		return err
+	}
+	if cfg.Port != a.cfg.Port {
+		log.Printf("reload: the port cannot change without a restart; staying on %d", a.cfg.Port)
+		cfg.Port = a.cfg.Port
+	}
+	if cfg.StatsDir != a.cfg.StatsDir {
+		log.Printf("reload: the stats directory cannot change without a restart; keeping %s", a.cfg.StatsDir)
+		cfg.StatsDir = a.cfg.StatsDir
+	}
+	log.Println("reload: applying the new configuration")
+	a.applyLocked(cfg)
+	return nil
+}
+
+// applyLocked puts cfg into effect: it installs the per-root settings, the
+// limits and the render options, swaps in routes built from cfg, and points
+// the caches and the filesystem watcher at its roots. Must be called with
+// a.mu held.
+func (a *app) applyLocked(cfg *config.Config) {
+	// Build root map: name -> filesystem path, and the per-root settings.
+	roots := make(map[string]string, len(cfg.Roots))
+	settings := make(map[string]handlers.RootSettings, len(cfg.Roots))
+	weights := make(map[string]float64, len(cfg.BandwidthWeights))
+	for class, w := range cfg.BandwidthWeights {
+		weights[class] = w
+	}
+	for _, root := range cfg.Roots {
+		roots[root.Name] = root.Path
+		// The global exclusions come first, so a root's own patterns can
+		// re-include what they exclude.
+		exclude := append(append([]string{}, cfg.Exclude...), root.Exclude...)
+		settings[root.Name] = handlers.RootSettings{
+			DisplayName: root.DisplayName,
+			Description: root.Description,
+			Hidden:      root.Hidden,
+			Exclude:     exclude,
+			Preview:     handlers.RootPreview(root.Preview),
+			Allow:       root.Allow,
+		}
+		// --bandwidth-weights takes precedence over a root's own weight.
+		if _, set := weights["root:"+root.Name]; !set && root.BandwidthWeight > 0 {
+			weights["root:"+root.Name] = root.BandwidthWeight
+		}
+	}
+	handlers.ConfigureRoots(settings)
+
+	// Configure reverse-proxy IP forwarding before any request is served.
+	handlers.SetTrustedProxy(cfg.TrustedProxy)
+
+	// Configure the document renderer (Markdown/Org-mode) with the active
+	// Chroma theme and the preview-images setting. Must be called before
+	// any preview request is served.
+	handlers.InitRenderOptions(cfg.Theme, cfg.PreviewImages)
+
+	limits := handlers.BandwidthLimits{
+		Total:   cfg.Bandwidth.Default,
+		PerIP:   cfg.BandwidthPerIP,
+		PerConn: cfg.BandwidthPerConn,
+		Weights: weights,
+
+		DailyQuota:   cfg.DailyQuota,
+		MonthlyQuota: cfg.MonthlyQuota,
+		MaxTransfers: cfg.MaxTransfers,
+		// The admin dashboard lists transfers even when nothing is capped.
+		Track: cfg.AdminPassword != "",
+
+		Ingress: handlers.IngressLimits{
+			Total:   cfg.IngressBandwidth,
+			PerIP:   cfg.IngressPerIP,
+			PerConn: cfg.IngressPerConn,
+		},
+	}
+	if cfg.Bandwidth.Scheduled() && cfg.Bandwidth.Enabled() {
+		limits.Schedule = cfg.Bandwidth
+	}
+	if a.bw == nil {
+		a.bw = handlers.NewBandwidthManager(limits)
+	} else {
+		a.bw.SetLimits(limits)
+	}
+	a.bw.SetQuotaPage(cfg.Title, cfg.DefaultTheme, a.tmpl)
+
+	// Request budgets start afresh only when they have changed.
+	if a.cfg == nil || cfg.RateLimitHeavy != a.cfg.RateLimitHeavy || cfg.RateLimitLight != a.cfg.RateLimitLight {
+		a.limiter.Close()
+		a.limiter = handlers.NewRequestLimiter(
+			handlers.RequestRate(cfg.RateLimitHeavy),
+			handlers.RequestRate(cfg.RateLimitLight),
+		)
+	}
+
+	previewOpts := handlers.PreviewOptions{
+		Images: cfg.PreviewImages,
+		Text:   cfg.PreviewText,
+		Docs:   cfg.PreviewDocs,
+		PDF:    cfg.PreviewPDF,
+		Fonts:  cfg.PreviewFonts,
+	}
+
+	mux := http.NewServeMux()
+	registerRoutes(mux, roots, cfg.Theme, cfg.Title, cfg.FaviconPath, cfg.DefaultTheme, a.bw, previewOpts, a.tmpl)
+	if cfg.AdminPassword != "" {
+		registerAdminRoutes(mux, cfg.AdminPassword, cfg.Title, cfg.DefaultTheme, a.bw, a.tmpl, a.reload)
+	}
+	// Per-IP request budgets sit in front of every route, so a client over
+	// budget is turned away before any handler does work for it.
+	var h http.Handler = securityHeaders(a.limiter.Wrap(a.bw.WrapBody(handlers.RootAccess(mux))), cfg.PreviewImages, cfg.PreviewPDF)
+	a.handler.Store(&h)
+	a.cfg = cfg
+
+	logStartup(cfg, weights, fmt.Sprintf("0.0.0.0:%d", cfg.Port))
+
+	// Warm the directory-size and search-index caches in the background so
+	// that the first real page load is never a cold cache miss.
+	handlers.WarmCache(roots)
+
+	// Watch all managed directories for filesystem changes and invalidate
+	// only the affected cache entries when they occur.
+	if a.watcher != nil {
+		a.watcher.SetRoots(roots)
+	} else if w, err := handlers.StartWatcher(roots); err != nil {
+		log.Printf("watcher: could not start filesystem watcher: %v", err)
+	} else {
+		a.watcher = w
+	}
+}
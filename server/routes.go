@@ -4,6 +4,8 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"path"
+	"strings"
 
 	"gileserver/handlers"
 )
@@ -21,6 +23,13 @@ import (
 //     same-origin and data: URIs are permitted, which matches the policy that
 //     the sanitizer enforces in rendered document HTML.
 //
+//     object-src is 'none' everywhere except on /view/ responses for PDF
+//     files when previewPDF is true. Browsers implement their built-in PDF
+//     viewer as a plugin, so a PDF delivered with object-src 'none' is
+//     blocked rather than rendered inside the preview page's iframe. The
+//     exception is scoped to that single route and file type; every HTML page
+//     keeps the strict policy.
+//
 //   - X-Content-Type-Options: tells browsers not to MIME-sniff response bodies.
 //     Without this a browser might execute a file whose declared Content-Type
 //     is benign (e.g. text/plain) if its content looks like JavaScript.
@@ -32,7 +41,7 @@ import (
 //   - Referrer-Policy: suppresses the Referer header on outbound navigations
 //     so internal file paths are not leaked to external sites linked from
 //     previewed documents.
-func securityHeaders(h http.Handler, previewImages bool) http.Handler {
+func securityHeaders(h http.Handler, previewImages, previewPDF bool) http.Handler {
 	imgSrc := "img-src 'self' data:;"
 	if previewImages {
 		imgSrc = "img-src 'self' data: https:;"
@@ -44,9 +53,14 @@ func securityHeaders(h http.Handler, previewImages bool) http.Handler {
 		"font-src 'self'; " +
 		"frame-src 'self'; " +
 		"object-src 'none';"
+	pdfCSP := strings.Replace(csp, "object-src 'none';", "object-src 'self';", 1)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Security-Policy", csp)
+		if previewPDF && strings.HasPrefix(r.URL.Path, "/view/") && strings.EqualFold(path.Ext(r.URL.Path), ".pdf") {
+			w.Header().Set("Content-Security-Policy", pdfCSP)
+		} else {
+			w.Header().Set("Content-Security-Policy", csp)
+		}
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
 		w.Header().Set("Referrer-Policy", "same-origin")
@@ -71,6 +85,13 @@ func registerRoutes(mux *http.ServeMux, roots map[string]string, theme, title, f
 	// Search index (JSON)
 	mux.HandleFunc("/api/index", handlers.IndexHandler(roots))
 
+	// Windows of lines from large text files (JSON), for the paged preview
+	mux.HandleFunc("/api/text/", handlers.TextWindowHandler(roots, theme, previewOpts))
+
+	// Follow mode for text files: appended bytes as Server-Sent Events
+	// (bandwidth-limited, like any other transfer)
+	mux.Handle("/api/tail/", bw.Wrap(handlers.TailHandler(roots, previewOpts)))
+
 	// ZIP download for directories (bandwidth-limited)
 	mux.Handle("/zip/", bw.Wrap(handlers.ZipHandler(roots, title)))
 
@@ -86,14 +107,34 @@ func registerRoutes(mux *http.ServeMux, roots map[string]string, theme, title, f
 	// File previews
 	mux.HandleFunc("/preview/", handlers.PreviewHandler(roots, theme, title, defaultTheme, previewOpts, tmpl))
 
+	// Line-by-line comparison of two text files
+	mux.HandleFunc("/diff", handlers.DiffHandler(roots, title, defaultTheme, previewOpts, tmpl))
+
 	// Directory / root listing (catch-all)
-	mux.HandleFunc("/", routeRoot(roots, title, defaultTheme, tmpl))
+	mux.HandleFunc("/", routeRoot(roots, title, defaultTheme, previewOpts, tmpl))
+}
+
+// registerAdminRoutes attaches the administrator endpoints, all behind HTTP
+// Basic authentication with the configured admin password. reload reloads
+// the configuration.
+func registerAdminRoutes(mux *http.ServeMux, password, title, defaultTheme string, bw *handlers.BandwidthManager, tmpl *Templates, reload func() error) {
+	// Bandwidth limits in force and the next scheduled change (JSON)
+	mux.Handle("/admin/bandwidth", handlers.AdminAuth(password, handlers.BandwidthStatusHandler(bw)))
+
+	// Live transfer dashboard, its data (JSON) and its actions
+	mux.Handle("/admin/transfers", handlers.AdminAuth(password, handlers.AdminPageHandler(title, defaultTheme, tmpl)))
+	mux.Handle("/admin/api/transfers", handlers.AdminAuth(password, handlers.TransfersHandler(bw)))
+	mux.Handle("/admin/api/abort", handlers.AdminAuth(password, handlers.AbortHandler(bw)))
+	mux.Handle("/admin/api/throttle", handlers.AdminAuth(password, handlers.ThrottleHandler(bw)))
+
+	// Configuration reload, as on SIGHUP
+	mux.Handle("/admin/api/reload", handlers.AdminAuth(password, handlers.ReloadHandler(reload)))
 }
 
 // routeRoot dispatches between the root listing and subdirectory listings.
-func routeRoot(roots map[string]string, title, defaultTheme string, tmpl *Templates) http.HandlerFunc {
+func routeRoot(roots map[string]string, title, defaultTheme string, previewOpts handlers.PreviewOptions, tmpl *Templates) http.HandlerFunc {
 	rootHandler := handlers.RootHandler(roots, title, defaultTheme, tmpl)
-	dirHandler := handlers.DirHandler(roots, title, defaultTheme, tmpl)
+	dirHandler := handlers.DirHandler(roots, title, defaultTheme, previewOpts, tmpl)
 
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == "/" || r.URL.Path == "" {
@@ -6,6 +6,8 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
 	"gileserver/config"
@@ -29,59 +31,31 @@ func staticHandler() http.Handler {
 	return http.FileServer(http.FS(sub))
 }
 
-// Run starts the HTTP server with the given configuration.
+// Run starts the HTTP server with the given configuration. The server
+// reloads its configuration on SIGHUP (see reload.go) and shuts down
+// gracefully on SIGINT or SIGTERM (see lifecycle.go).
 func Run(cfg *config.Config, templateFS embed.FS) error {
-	// Build root map: name -> filesystem path
-	roots := make(map[string]string, len(cfg.Dirs))
-	for _, d := range cfg.Dirs {
-		name := rootName(d)
-		roots[name] = d
-	}
-
 	tmpl, err := LoadTemplates(templateFS)
 	if err != nil {
 		return fmt.Errorf("loading templates: %w", err)
 	}
 
-	bwManager := handlers.NewBandwidthManager(cfg.BandwidthLimit)
-
-	previewOpts := handlers.PreviewOptions{
-		Images: cfg.PreviewImages,
-		Text:   cfg.PreviewText,
-		Docs:   cfg.PreviewDocs,
-	}
-
-	mux := http.NewServeMux()
-	registerRoutes(mux, roots, cfg.Theme, cfg.Title, cfg.FaviconPath, cfg.DefaultTheme, bwManager, previewOpts, tmpl)
-	wrappedMux := securityHeaders(mux, cfg.PreviewImages)
-
 	// Load persisted download statistics before any handler runs.
 	handlers.InitStats(cfg.StatsDir)
 
-	// Configure reverse-proxy IP forwarding before any request is served.
-	handlers.SetTrustedProxy(cfg.TrustedProxy)
-
-	// Configure the document renderer (Markdown/Org-mode) with the active
-	// Chroma theme and the preview-images setting. Must be called before
-	// any preview request is served.
-	handlers.InitRenderOptions(cfg.Theme, cfg.PreviewImages)
+	a := &app{tmpl: tmpl}
+	a.mu.Lock()
+	a.applyLocked(cfg)
+	a.mu.Unlock()
 
 	addr := fmt.Sprintf("0.0.0.0:%d", cfg.Port)
-	logStartup(cfg, roots, addr)
-
-	// Warm the directory-size and search-index caches in the background so
-	// that the first real page load is never a cold cache miss.
-	handlers.WarmCache(roots)
-
-	// Watch all managed directories for filesystem changes and invalidate
-	// only the affected cache entries when they occur.
-	if _, err := handlers.StartWatcher(roots); err != nil {
-		log.Printf("watcher: could not start filesystem watcher: %v", err)
+	ln, err := listen(addr)
+	if err != nil {
+		return err
 	}
-
 	srv := &http.Server{
 		Addr:    addr,
-		Handler: wrappedMux,
+		Handler: a,
 
 		// ReadHeaderTimeout caps how long the server waits for a client to
 		// finish sending HTTP headers. This is the primary Slowloris defence:
@@ -100,11 +74,12 @@ func Run(cfg *config.Config, templateFS embed.FS) error {
 		// ensures slow readers do not hold unlimited server resources, and
 		// IdleTimeout handles truly dead connections.
 	}
-	return srv.ListenAndServe()
+	return a.serve(srv, ln)
 }
 
 // logStartup prints a structured summary of the active configuration.
-func logStartup(cfg *config.Config, roots map[string]string, addr string) {
+// weights are the bandwidth weights in effect, including the roots' own.
+func logStartup(cfg *config.Config, weights map[string]float64, addr string) {
 	sep := "-------------------------------------------"
 	log.Println(sep)
 	log.Printf("  %s", cfg.Title)
@@ -113,6 +88,9 @@ func logStartup(cfg *config.Config, roots map[string]string, addr string) {
 	log.Printf("  %-18s %d", "Port:", cfg.Port)
 	log.Printf("  %-18s %s", "Highlight theme:", cfg.Theme)
 	log.Printf("  %-18s %s", "Default UI theme:", cfg.DefaultTheme)
+	if cfg.ConfigFile != "" {
+		log.Printf("  %-18s %s", "Config file:", cfg.ConfigFile)
+	}
 
 	if cfg.FaviconPath != "" {
 		log.Printf("  %-18s %s", "Favicon:", cfg.FaviconPath)
@@ -120,22 +98,76 @@ func logStartup(cfg *config.Config, roots map[string]string, addr string) {
 		log.Printf("  %-18s %s", "Favicon:", "(embedded default)")
 	}
 
-	if cfg.BandwidthLimit > 0 {
-		log.Printf("  %-18s %s/s", "Bandwidth limit:", formatBandwidth(cfg.BandwidthLimit))
-	} else {
-		log.Printf("  %-18s %s", "Bandwidth limit:", "unlimited")
+	now := time.Now()
+	log.Printf("  %-18s %s", "Bandwidth limit:", formatLimit(cfg.Bandwidth.Limit(now)))
+	if cfg.Bandwidth.Scheduled() {
+		log.Printf("  %-18s %s", "Schedule:", cfg.Bandwidth.Spec)
+		if next, limit := cfg.Bandwidth.Next(now); !next.IsZero() {
+			log.Printf("  %-18s %s at %s", "Next change:", formatLimit(limit), next.Format("Mon 2006-01-02 15:04 MST"))
+		}
+	}
+	if cfg.BandwidthPerIP > 0 {
+		log.Printf("  %-18s %s", "Per-IP limit:", formatBandwidth(cfg.BandwidthPerIP))
+	}
+	if cfg.BandwidthPerConn > 0 {
+		log.Printf("  %-18s %s", "Per-conn limit:", formatBandwidth(cfg.BandwidthPerConn))
+	}
+
+	if cfg.IngressBandwidth > 0 || cfg.IngressPerIP > 0 || cfg.IngressPerConn > 0 {
+		log.Printf("  %-18s total=%s  per-ip=%s  per-conn=%s", "Ingress limit:",
+			formatLimit(cfg.IngressBandwidth), formatLimit(cfg.IngressPerIP), formatLimit(cfg.IngressPerConn))
 	}
 
-	log.Printf("  %-18s images=%s  text=%s  docs=%s",
+	if len(weights) > 0 {
+		classes := make([]string, 0, len(weights))
+		for class, w := range weights {
+			classes = append(classes, fmt.Sprintf("%s=%g", class, w))
+		}
+		sort.Strings(classes)
+		log.Printf("  %-18s %s", "Bandwidth weights:", strings.Join(classes, "  "))
+	}
+
+	if cfg.DailyQuota > 0 || cfg.MonthlyQuota > 0 {
+		log.Printf("  %-18s daily=%s  monthly=%s", "Quotas:", formatQuota(cfg.DailyQuota), formatQuota(cfg.MonthlyQuota))
+	}
+
+	if cfg.RateLimitHeavy.Count > 0 || cfg.RateLimitLight.Count > 0 {
+		log.Printf("  %-18s heavy=%s  light=%s", "Request limits:", cfg.RateLimitHeavy, cfg.RateLimitLight)
+	}
+	if cfg.MaxTransfers > 0 {
+		log.Printf("  %-18s %d", "Transfers per IP:", cfg.MaxTransfers)
+	}
+
+	if cfg.AdminPassword != "" {
+		log.Printf("  %-18s %s", "Admin:", "enabled at /admin/transfers (user \"admin\")")
+	}
+
+	log.Printf("  %-18s images=%s  text=%s  docs=%s  pdf=%s  fonts=%s",
 		"Previews:",
 		enabledStr(cfg.PreviewImages),
 		enabledStr(cfg.PreviewText),
 		enabledStr(cfg.PreviewDocs),
+		enabledStr(cfg.PreviewPDF),
+		enabledStr(cfg.PreviewFonts),
 	)
 
-	log.Printf("  %-18s %d director%s", "Serving:", len(roots), map[bool]string{true: "y", false: "ies"}[len(roots) == 1])
-	for name, fsPath := range roots {
-		log.Printf("    /%-16s %s", name, fsPath)
+	if len(cfg.Exclude) > 0 {
+		log.Printf("  %-18s %s", "Excluded:", strings.Join(cfg.Exclude, "  "))
+	}
+
+	log.Printf("  %-18s %d director%s", "Serving:", len(cfg.Roots), map[bool]string{true: "y", false: "ies"}[len(cfg.Roots) == 1])
+	for _, root := range cfg.Roots {
+		line := root.Path
+		if root.DisplayName != "" {
+			line += fmt.Sprintf("  (%s)", root.DisplayName)
+		}
+		if len(root.Allow) > 0 {
+			line += "  [restricted]"
+		}
+		if root.Writable {
+			line += "  [writable: no write operations yet]"
+		}
+		log.Printf("    /%-16s %s", root.Name, line)
 	}
 	log.Println(sep)
 }
@@ -148,6 +180,22 @@ func enabledStr(b bool) string {
 	return "off"
 }
 
+// formatLimit formats a bandwidth cap in bytes/sec, where 0 means unlimited.
+func formatLimit(bps float64) string {
+	if bps <= 0 {
+		return "unlimited"
+	}
+	return formatBandwidth(bps)
+}
+
+// formatQuota formats a transfer quota in bytes, where 0 means unlimited.
+func formatQuota(n int64) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return humanSize(n)
+}
+
 // formatBandwidth converts a bytes/sec value to a human-readable bits/sec string.
 func formatBandwidth(bps float64) string {
 	bits := bps * 8
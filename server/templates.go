@@ -16,6 +16,9 @@ import (
 type Templates struct {
 	dir     *template.Template
 	preview *template.Template
+	diff    *template.Template
+	quota   *template.Template
+	admin   *template.Template
 }
 
 var tmplFuncs = template.FuncMap{
@@ -49,7 +52,22 @@ func LoadTemplates(tfs embed.FS) (*Templates, error) {
 		return nil, fmt.Errorf("parse preview template: %w", err)
 	}
 
-	return &Templates{dir: dir, preview: prev}, nil
+	diff, err := cloneAndParse(base, sub, "diff.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse diff template: %w", err)
+	}
+
+	quota, err := cloneAndParse(base, sub, "quota.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse quota template: %w", err)
+	}
+
+	admin, err := cloneAndParse(base, sub, "admin.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse admin template: %w", err)
+	}
+
+	return &Templates{dir: dir, preview: prev, diff: diff, quota: quota, admin: admin}, nil
 }
 
 // loadTemplatesFromDisk loads templates directly from the filesystem.
@@ -70,7 +88,22 @@ func loadTemplatesFromDisk(dir string) (*Templates, error) {
 		return nil, fmt.Errorf("parse preview template: %w", err)
 	}
 
-	return &Templates{dir: dirTmpl, preview: prevTmpl}, nil
+	diffTmpl, err := cloneAndParseFiles(base, dir+"/diff.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse diff template: %w", err)
+	}
+
+	quotaTmpl, err := cloneAndParseFiles(base, dir+"/quota.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse quota template: %w", err)
+	}
+
+	adminTmpl, err := cloneAndParseFiles(base, dir+"/admin.html")
+	if err != nil {
+		return nil, fmt.Errorf("parse admin template: %w", err)
+	}
+
+	return &Templates{dir: dirTmpl, preview: prevTmpl, diff: diffTmpl, quota: quotaTmpl, admin: adminTmpl}, nil
 }
 
 // cloneAndParse clones a base template set and adds one more file from an fs.FS.
@@ -103,6 +136,24 @@ func (t *Templates) ExecutePreview(w http.ResponseWriter, data *models.PreviewDa
 	return t.preview.ExecuteTemplate(w, "base", data)
 }
 
+// ExecuteDiff renders the file comparison template.
+func (t *Templates) ExecuteDiff(w http.ResponseWriter, data *models.DiffPage) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return t.diff.ExecuteTemplate(w, "base", data)
+}
+
+// ExecuteQuota renders the page shown to clients over their transfer quota.
+func (t *Templates) ExecuteQuota(w http.ResponseWriter, data *models.QuotaPage) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return t.quota.ExecuteTemplate(w, "base", data)
+}
+
+// ExecuteAdmin renders the admin transfer dashboard.
+func (t *Templates) ExecuteAdmin(w http.ResponseWriter, data *models.AdminPage) error {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return t.admin.ExecuteTemplate(w, "base", data)
+}
+
 // humanSize formats a byte count into a human-readable string using SI
 // (decimal) units where 1 KB = 1000 B, 1 MB = 1000 KB, 1 GB = 1000 MB, etc.
 func humanSize(n int64) string {
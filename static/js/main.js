@@ -750,3 +750,443 @@
     makeImagesClickable();
   }
 })();
+
+// ------------------------------------------------------------------ //
+// Delimited data table: sorting and pagination                       //
+// ------------------------------------------------------------------ //
+
+(function () {
+  "use strict";
+
+  function cellValue(row, col) {
+    var cell = row.cells[col];
+    return cell ? cell.textContent : "";
+  }
+
+  // Numbers compare numerically; everything else uses a natural,
+  // case-insensitive string order.
+  function compareValues(a, b) {
+    var na = Number(a.replace(/,/g, ""));
+    var nb = Number(b.replace(/,/g, ""));
+    if (!isNaN(na) && !isNaN(nb)) return na - nb;
+    return a.localeCompare(b, undefined, { numeric: true, sensitivity: "base" });
+  }
+
+  function initTable(wrap) {
+    var table = wrap.querySelector(".data-table");
+    var pager = wrap.querySelector(".table-pager");
+    if (!table || !pager) return;
+
+    var tbody = table.tBodies[0];
+    var rows = Array.prototype.slice.call(tbody.rows);
+    var pageSize = parseInt(wrap.getAttribute("data-page-size"), 10) || 100;
+    var pages = Math.max(1, Math.ceil(rows.length / pageSize));
+    var page = 0;
+
+    var prev = document.createElement("button");
+    prev.className = "btn btn-sm btn-secondary";
+    prev.textContent = "Prev";
+    var label = document.createElement("span");
+    var next = document.createElement("button");
+    next.className = "btn btn-sm btn-secondary";
+    next.textContent = "Next";
+    pager.appendChild(prev);
+    pager.appendChild(label);
+    pager.appendChild(next);
+
+    function show() {
+      var start = page * pageSize;
+      var end = start + pageSize;
+      rows.forEach(function (row, i) {
+        row.style.display = i >= start && i < end ? "" : "none";
+      });
+      label.textContent = "Page " + (page + 1) + " of " + pages;
+      prev.disabled = page === 0;
+      next.disabled = page >= pages - 1;
+    }
+
+    prev.addEventListener("click", function () {
+      if (page > 0) { page--; show(); }
+    });
+    next.addEventListener("click", function () {
+      if (page < pages - 1) { page++; show(); }
+    });
+
+    var headers = table.tHead ? table.tHead.rows[0].cells : [];
+    Array.prototype.forEach.call(headers, function (th, col) {
+      th.addEventListener("click", function () {
+        var asc = th.getAttribute("aria-sort") !== "ascending";
+        Array.prototype.forEach.call(headers, function (h) { h.removeAttribute("aria-sort"); });
+        th.setAttribute("aria-sort", asc ? "ascending" : "descending");
+        rows.sort(function (a, b) {
+          var va = cellValue(a, col);
+          var vb = cellValue(b, col);
+          // Empty cells sort last in either direction.
+          if (va === "" || vb === "") return (va === "") - (vb === "");
+          var c = compareValues(va, vb);
+          return asc ? c : -c;
+        });
+        rows.forEach(function (row) { tbody.appendChild(row); });
+        page = 0;
+        show();
+      });
+    });
+
+    if (pages > 1) pager.hidden = false;
+    show();
+  }
+
+  function init() {
+    document.querySelectorAll(".table-preview").forEach(initTable);
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", init);
+  } else {
+    init();
+  }
+})();
+
+// ------------------------------------------------------------------ //
+// Paged text preview: load windows of lines without a page reload   //
+// ------------------------------------------------------------------ //
+
+(function () {
+  "use strict";
+
+  function initPager(pager) {
+    var api = pager.getAttribute("data-api");
+    var size = parseInt(pager.getAttribute("data-window"), 10) || 500;
+    var total = parseInt(pager.getAttribute("data-total"), 10) || 0;
+    var view = document.querySelector(".text-preview");
+    var nav = pager.querySelector(".text-pager-nav");
+    var links = nav.querySelectorAll("a");
+    var first = links[0], prev = links[1], next = links[2], last = links[3];
+    var range = pager.querySelector(".text-pager-range");
+    var clipped = pager.querySelector(".text-truncated");
+    var input = pager.querySelector(".text-pager-goto input");
+    var busy = false;
+
+    function setLink(a, attr, value, line, hidden) {
+      a.removeAttribute("data-line");
+      a.removeAttribute("data-offset");
+      a.setAttribute(attr, value);
+      a.setAttribute("href", "?line=" + line);
+      a.hidden = hidden;
+    }
+
+    function show(win) {
+      view.innerHTML = win.html;
+      var atStart = win.startLine <= 1;
+      setLink(first, "data-line", 1, 1, atStart);
+      var p = Math.max(1, win.startLine - size);
+      setLink(prev, "data-line", p, p, atStart);
+      setLink(next, "data-offset", win.next, win.endLine + 1, win.eof);
+      var l = Math.max(1, total - size + 1);
+      setLink(last, "data-line", l, l, win.eof);
+      range.textContent = "Lines " + win.startLine + "–" + win.endLine + " of " + total;
+      clipped.hidden = !win.clipped;
+      history.replaceState(null, "", "?line=" + win.startLine);
+    }
+
+    function load(query) {
+      if (busy) return;
+      busy = true;
+      fetch(api + "?" + query)
+        .then(function (r) {
+          if (!r.ok) throw new Error(r.status);
+          return r.json();
+        })
+        .then(function (win) {
+          show(win);
+          pager.scrollIntoView({ block: "start" });
+        })
+        .catch(function (err) {
+          console.warn("GileBrowser: failed to load lines:", err);
+        })
+        .finally(function () { busy = false; });
+    }
+
+    nav.addEventListener("click", function (e) {
+      var a = e.target.closest("a");
+      if (!a) return;
+      e.preventDefault();
+      if (a.hasAttribute("data-offset")) {
+        load("offset=" + encodeURIComponent(a.getAttribute("data-offset")));
+      } else {
+        load("line=" + encodeURIComponent(a.getAttribute("data-line")));
+      }
+    });
+
+    nav.querySelector("form").addEventListener("submit", function (e) {
+      var line = parseInt(input.value, 10);
+      if (!line) return;
+      e.preventDefault();
+      load("line=" + Math.min(Math.max(1, line), total));
+    });
+  }
+
+  function init() {
+    var pager = document.querySelector(".text-pager");
+    if (pager) initPager(pager);
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", init);
+  } else {
+    init();
+  }
+})();
+
+// ------------------------------------------------------------------ //
+// Follow mode: stream lines appended to a text file                  //
+// ------------------------------------------------------------------ //
+
+(function () {
+  "use strict";
+
+  // Keep the page responsive when following a very chatty log.
+  var MAX_TAIL_CHARS = 1024 * 1024;
+
+  function initTail(btn) {
+    var view = document.querySelector(".tail-view");
+    if (!view) return;
+    var status = view.querySelector(".tail-status");
+    var out = view.querySelector(".tail-output");
+    var source = null;
+
+    function nearBottom() {
+      return window.innerHeight + window.scrollY >= document.body.scrollHeight - 40;
+    }
+
+    function append(text) {
+      var stick = nearBottom();
+      out.appendChild(document.createTextNode(text));
+      if (out.textContent.length > MAX_TAIL_CHARS) {
+        out.textContent = out.textContent.slice(-MAX_TAIL_CHARS / 2);
+      }
+      if (stick) window.scrollTo(0, document.body.scrollHeight);
+    }
+
+    function start() {
+      // In the paged view, show the last page before following it.
+      var end = document.querySelector(".text-pager-nav a[data-line]:not([hidden]):nth-of-type(4)");
+      if (end) end.click();
+
+      view.hidden = false;
+      status.textContent = "Following — new lines appear below as they are written.";
+      source = new EventSource(btn.getAttribute("data-tail") + "?offset=" +
+        encodeURIComponent(btn.getAttribute("data-offset")));
+      source.addEventListener("append", function (e) {
+        append(JSON.parse(e.data).text);
+      });
+      source.addEventListener("reset", function (e) {
+        var reason = JSON.parse(e.data).reason;
+        out.textContent = "";
+        status.textContent = "The file was " + (reason === "rotated" ? "replaced" : "truncated") +
+          "; following it again from the start.";
+      });
+      source.addEventListener("error", function () {
+        if (source && source.readyState === EventSource.CLOSED) {
+          status.textContent = "Follow stopped: the connection was closed.";
+          stop();
+        }
+      });
+      btn.textContent = "Stop following";
+      btn.setAttribute("aria-pressed", "true");
+      window.scrollTo(0, document.body.scrollHeight);
+    }
+
+    function stop() {
+      if (source) source.close();
+      source = null;
+      btn.textContent = "Follow";
+      btn.setAttribute("aria-pressed", "false");
+    }
+
+    btn.addEventListener("click", function () {
+      if (source) stop();
+      else start();
+    });
+  }
+
+  function init() {
+    var btn = document.querySelector(".tail-toggle");
+    if (btn) initTail(btn);
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", init);
+  } else {
+    init();
+  }
+})();
+
+// ------------------------------------------------------------------ //
+// Font specimen: the sample box retypes every specimen line          //
+// ------------------------------------------------------------------ //
+
+(function () {
+  "use strict";
+
+  function init() {
+    var input = document.querySelector(".font-sample-input");
+    if (!input) return;
+    var lines = document.querySelectorAll(".font-sample-text");
+    var fallback = input.value;
+    input.addEventListener("input", function () {
+      var text = input.value || fallback;
+      for (var i = 0; i < lines.length; i++) lines[i].textContent = text;
+    });
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", init);
+  } else {
+    init();
+  }
+})();
+
+// ------------------------------------------------------------------ //
+// Admin transfer dashboard: poll, abort and throttle                 //
+// ------------------------------------------------------------------ //
+
+(function () {
+  "use strict";
+
+  var POLL_MS = 2000;
+
+  function humanSize(bytes) {
+    var units = ["B", "KB", "MB", "GB", "TB"];
+    var i = 0;
+    while (bytes >= 1000 && i < units.length - 1) {
+      bytes /= 1000;
+      i++;
+    }
+    return (i === 0 ? bytes : bytes.toFixed(1)) + " " + units[i];
+  }
+
+  function cell(row, text, cls) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (cls) td.className = cls;
+    row.appendChild(td);
+    return td;
+  }
+
+  function button(label, title, onClick) {
+    var b = document.createElement("button");
+    b.type = "button";
+    b.className = "btn btn-sm btn-secondary";
+    b.textContent = label;
+    b.title = title;
+    b.addEventListener("click", onClick);
+    return b;
+  }
+
+  function init() {
+    var root = document.getElementById("admin-transfers");
+    if (!root) return;
+    var api = root.dataset.api;
+    var summary = root.querySelector(".admin-summary");
+    var transfers = root.querySelector(".admin-table tbody");
+    var throttles = root.querySelector(".admin-throttles tbody");
+    var errorBox = root.querySelector(".admin-error");
+    var form = root.querySelector(".admin-throttle-form");
+
+    function showError(msg) {
+      errorBox.textContent = msg;
+      errorBox.hidden = !msg;
+    }
+
+    // Actions are POSTs carrying X-Gile-Admin, which the server requires
+    // so that other sites cannot trigger them with the browser's
+    // remembered credentials.
+    function act(path, params) {
+      return fetch(path + "?" + new URLSearchParams(params), {
+        method: "POST",
+        headers: { "X-Gile-Admin": "1" },
+        credentials: "same-origin",
+      }).then(function (resp) {
+        if (!resp.ok) {
+          return resp.text().then(function (t) { throw new Error(t.trim() || resp.statusText); });
+        }
+        showError("");
+        refresh();
+      }).catch(function (err) { showError(err.message); });
+    }
+
+    function throttleIP(ip) {
+      form.elements.ip.value = ip;
+      form.elements.mbps.focus();
+    }
+
+    function render(data) {
+      var n = data.transfers.length;
+      summary.textContent = n === 0 ? "No transfers in progress." :
+        n + " transfer" + (n === 1 ? "" : "s") + " in progress.";
+
+      transfers.textContent = "";
+      data.transfers.forEach(function (t) {
+        var tr = document.createElement("tr");
+        cell(tr, t.ip + (t.kind === "upload" ? " ↑" : ""));
+        cell(tr, t.path, "admin-path");
+        cell(tr, humanSize(t.bytes), "col-size");
+        cell(tr, t.rateText, "col-size");
+        cell(tr, t.elapsed, "col-size");
+        cell(tr, t.allocText, "col-size");
+        var actions = cell(tr, "", "col-actions");
+        actions.appendChild(button("Throttle", "Cap this client IP", function () { throttleIP(t.ip); }));
+        actions.appendChild(button("Abort", "Cut this transfer off", function () {
+          if (confirm("Abort the transfer of " + t.path + " to " + t.ip + "?")) act("/admin/api/abort", { id: t.id });
+        }));
+        transfers.appendChild(tr);
+      });
+
+      throttles.textContent = "";
+      data.throttles.forEach(function (th) {
+        var tr = document.createElement("tr");
+        cell(tr, th.ip);
+        cell(tr, th.limitText, "col-size");
+        cell(tr, new Date(th.until).toLocaleTimeString(), "col-size");
+        var actions = cell(tr, "", "col-actions");
+        actions.appendChild(button("Lift", "Remove this throttle", function () {
+          act("/admin/api/throttle", { ip: th.ip, rate: 0 });
+        }));
+        throttles.appendChild(tr);
+      });
+    }
+
+    function refresh() {
+      return fetch(api, { credentials: "same-origin", cache: "no-store" })
+        .then(function (resp) {
+          if (!resp.ok) throw new Error("Could not load transfers: " + resp.status);
+          return resp.json();
+        })
+        .then(render)
+        .catch(function (err) { showError(err.message); });
+    }
+
+    form.addEventListener("submit", function (e) {
+      e.preventDefault();
+      // The API takes bytes per second; the form asks for megabits.
+      var rate = parseFloat(form.elements.mbps.value) * 1e6 / 8;
+      act("/admin/api/throttle", {
+        ip: form.elements.ip.value.trim(),
+        rate: rate,
+        minutes: form.elements.minutes.value,
+      });
+    });
+
+    refresh();
+    setInterval(function () {
+      if (!document.hidden) refresh();
+    }, POLL_MS);
+  }
+
+  if (document.readyState === "loading") {
+    document.addEventListener("DOMContentLoaded", init);
+  } else {
+    init();
+  }
+})();